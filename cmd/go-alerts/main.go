@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"financial-data-backend-2/internal/alerts"
+	"financial-data-backend-2/internal/config"
+	"financial-data-backend-2/internal/kafka"
+	"financial-data-backend-2/internal/logging"
+	"financial-data-backend-2/internal/models"
+	mongoGo "financial-data-backend-2/internal/mongo"
+	"financial-data-backend-2/internal/processor"
+	"log"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+)
+
+const configPath = "config/config.yml"
+
+func main() {
+	// - Load Configuration
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	logging.Apply(cfg.Logging.Level)
+	alertsCfg := cfg.Alerts.WithDefaults()
+
+	// - Wait for Kafka to be ready and the topic to exist.
+	if err := kafka.EnsureTopicWithRetry(context.Background(), cfg.Kafka); err != nil {
+		log.Fatalf("Could not ensure Kafka topic exists: %v", err)
+	}
+
+	// - Setup Kafka Reader, in a consumer group of its own so that it
+	// sees every trade, independently of the processor.
+	dialer, err := kafka.NewDialer(cfg.Kafka)
+	if err != nil {
+		log.Fatalf("Invalid Kafka security settings: %v", err)
+	}
+	r := kafkaGo.NewReader(kafkaGo.ReaderConfig{
+		Brokers: cfg.Kafka.BrokerList(),
+		Topic:   cfg.Kafka.Topic,
+		Dialer:  dialer,
+		GroupID: alertsCfg.GroupID,
+		// A new group starts with the latest trades, rather than alerting
+		// on the topic's history.
+		StartOffset: kafkaGo.LastOffset,
+	})
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Fatal("failed to close Kafka Reader:", err)
+		}
+		log.Println("Kafka Reader closed.")
+	}()
+	log.Printf("Kafka reader configured successfully. Consumer Group ID: %s", alertsCfg.GroupID)
+
+	// - Setup MongoDB database
+	DB, err := mongoGo.ConnectDB(cfg.MongoDB.URL, cfg.Timeouts.BackgroundOperation)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+		defer cancel()
+		if err := DB.Disconnect(ctx); err != nil {
+			log.Fatalf("Error during MongoDB disconnect: %v", err)
+		}
+		log.Println("MongoDB client disconnected.")
+	}()
+	store := alerts.NewStore(
+		mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName, cfg.MongoDB.AlertRulesCollection()),
+		mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName, cfg.MongoDB.AlertDeliveriesCollection()))
+
+	// Graceful shutdown setup
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// - Load the rules, and reload them as they are edited through the API
+	evaluator := alerts.NewEvaluator(alertsCfg.Cooldown)
+	loadRules := func() {
+		loadCtx, cancel := context.WithTimeout(ctx, cfg.Timeouts.BackgroundOperation)
+		defer cancel()
+		rules, err := store.EnabledRules(loadCtx)
+		if err != nil {
+			log.Printf("Failed to load alert rules: %v", err)
+			return
+		}
+		evaluator.SetRules(rules)
+		logging.Debugf("Loaded %d enabled alert rule(s).", len(rules))
+	}
+	loadRules()
+	go func() {
+		ticker := time.NewTicker(alertsCfg.RulesRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				loadRules()
+			}
+		}
+	}()
+
+	// - Deliver alerts in the background, so that slow webhooks do not
+	// hold up evaluation
+	dispatcher := alerts.NewDispatcher(alerts.NewWebhookClient(alertsCfg.WebhookTimeout, alertsCfg.WebhookHosts), store,
+		alertsCfg.MaxAttempts, alertsCfg.RetryBackoff, alertsCfg.QueueSize)
+	// It stops once the read loop has, so that every alert enqueued is
+	// delivered, or recorded as undelivered.
+	dispatchCtx, stopDispatch := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopDispatch()
+	delivered := make(chan struct{})
+	go func() {
+		dispatcher.Run(dispatchCtx, alertsCfg.Workers, cfg.Timeouts.Shutdown)
+		close(delivered)
+	}()
+
+	// - Bad ticks must not trigger alerts
+	validators := processor.NewTickValidators(cfg.Validation)
+
+	// - The Read Loop
+	log.Println("Waiting for messages...")
+	for {
+		m, err := r.ReadMessage(ctx)
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				log.Println("Context cancelled, shutting down alert evaluator.")
+				break
+			}
+
+			log.Printf("Error reading message: %v", err)
+			continue
+		}
+
+		data, err := processor.TransformMessage(m, validators...)
+		if err != nil {
+			log.Printf("Failed to transform message: %v. Raw value: %s", err, string(m.Value))
+			continue
+		}
+		if data == nil {
+			continue
+		}
+		for _, record := range data.TradeRecords {
+			trade, ok := record.(models.TradeRecord)
+			if !ok || len(trade.Flags) > 0 || excluded(trade, cfg.Aggregate.ExcludeConditions) {
+				continue
+			}
+			for _, alert := range evaluator.Evaluate(trade) {
+				log.Printf("Alert %s: %s", alert.Event.Id, alert.Event.Message)
+				if !dispatcher.Enqueue(alert) {
+					log.Printf("WARNING: delivery queue full, dropping alert %s of rule %s",
+						alert.Event.Id, alert.Event.RuleId)
+				}
+			}
+		}
+	}
+	stopDispatch()
+	<-delivered
+	log.Println("Cleanup finished. Alert evaluator exiting.")
+}
+
+// excluded reports whether a trade has any of the conditions left out of
+// derived figures, e.g. odd lots, which can print away from the market.
+func excluded(trade models.TradeRecord, conditions []string) bool {
+	return slices.ContainsFunc(trade.Conditions, func(c string) bool {
+		return slices.Contains(conditions, c)
+	})
+}
@@ -7,22 +7,34 @@ import (
 	"financial-data-backend-2/internal/api/repo"
 	"financial-data-backend-2/internal/api/usecase"
 	"financial-data-backend-2/internal/config"
+	"financial-data-backend-2/internal/logging"
 	mongoGo "financial-data-backend-2/internal/mongo"
+	"financial-data-backend-2/internal/postgres"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+const configPath = "config/config.yml"
+
 func main() {
 	// - Load Configuration
-	cfg, err := config.LoadConfig("config/config.yml")
+	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
+	logging.Apply(cfg.Logging.Level)
+
+	// - Watch the configuration for changes that can be applied live
+	watcher := config.NewWatcher(configPath, cfg, config.DefaultReloadInterval)
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	go watcher.Run(watchCtx)
 
 	// - Setup MongoDB database
 	DB, err := mongoGo.ConnectDB(cfg.MongoDB.URL, cfg.Timeouts.BackgroundOperation)
@@ -30,7 +42,7 @@ func main() {
 		log.Fatalf("Failed to connect to MongoDB: %v", err)
 	}
 	defer func() {
-		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+		ctx, cancel := context.WithTimeout(context.Background(), watcher.Current().Timeouts.BackgroundOperation)
 		defer cancel()
 		if err := DB.Disconnect(ctx); err != nil {
 			log.Fatalf("Error during MongoDB disconnect: %v", err)
@@ -43,16 +55,56 @@ func main() {
 		cfg.MongoDB.SymbolsCollectionName)
 	tc := mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName,
 		cfg.MongoDB.CollectionName)
+	cc := mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName,
+		cfg.MongoDB.CandlesCollection())
+	ac := mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName,
+		cfg.MongoDB.AlertRulesCollection())
+	dc := mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName,
+		cfg.MongoDB.AlertDeliveriesCollection())
+	gc := mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName,
+		cfg.MongoDB.DataGapsCollection())
 
 	// Setup server and middlewares
 	r := gin.New()
 	r.Use(gin.Logger())
 	r.Use(middleware.Error())
-	r.Use(middleware.Timeout(cfg.Timeouts.APIRequest))
+	r.Use(middleware.TimeoutFunc(func() time.Duration {
+		return watcher.Current().Timeouts.APIRequest
+	}))
+	limiter := middleware.NewRateLimiter(cfg.RateLimit.RequestsPerSecond, cfg.RateLimit.Burst)
+	r.Use(middleware.RateLimit(limiter))
+
+	watcher.OnReload(func(old, new *config.Config) {
+		logging.Apply(new.Logging.Level)
+		if new.RateLimit != old.RateLimit {
+			limiter.SetLimit(new.RateLimit.RequestsPerSecond, new.RateLimit.Burst)
+			log.Printf("Rate limit changed to %v requests/s (burst %d)",
+				new.RateLimit.RequestsPerSecond, new.RateLimit.Burst)
+		}
+	})
 
-	// Setup apps
-	rp := repo.NewRepo(sc, tc)
+	// Setup apps. With Postgres, market data is read from there, while
+	// alert rules and data gaps stay in MongoDB.
+	if err := cfg.Storage.Validate(); err != nil {
+		log.Fatalf("Invalid storage configuration: %v", err)
+	}
+	var rp repo.RepoItf = repo.NewRepo(sc, tc, cc, ac, dc, gc)
+	if cfg.Storage.WithDefaults().Backend == config.StoragePostgres {
+		pg, err := postgres.ConnectDB(cfg.Storage.PostgresURL, cfg.Timeouts.BackgroundOperation)
+		if err != nil {
+			log.Fatalf("Failed to connect to Postgres: %v", err)
+		}
+		defer pg.Close()
+		rp = repo.NewPostgresRepo(pg, repo.NewRepo(sc, tc, cc, ac, dc, gc))
+		log.Println("Reading market data from Postgres")
+	}
 	uc := usecase.NewUsecase(rp)
+	if refresh := cfg.Snapshot.Refresh(); refresh > 0 {
+		// Serve snapshots from memory; watchCtx ends when main returns.
+		snapshotCache := usecase.NewSnapshotCache(rp, refresh)
+		go snapshotCache.Run(watchCtx)
+		uc.UseSnapshotCache(snapshotCache)
+	}
 	hd := handler.NewHandler(uc)
 
 	// Endpoints:
@@ -60,8 +112,35 @@ func main() {
 	{
 		// 1. Get metadata for all tracked symbols.
 		v1.GET("/symbols", hd.GetSymbols)
+		v1.GET("/symbols/:symbol", hd.GetSymbol)
+		// Latest quotes for many symbols at once.
+		v1.GET("/snapshot", hd.GetSnapshot)
 		// 2. Get the 50 most recent trades for one symbol.
 		v1.GET("/trades/:symbol", hd.GetTradesPerSymbol)
+		v1.GET("/candles/:symbol", hd.GetCandlesPerSymbol)
+		// VWAP, TWAP and other statistics over a time window.
+		v1.GET("/stats/:symbol", hd.GetStatsPerSymbol)
+		// Technical indicators (SMA, EMA, RSI, MACD, Bollinger Bands, ATR).
+		v1.GET("/indicators/:symbol", hd.GetIndicator)
+	}
+	adminAuth := middleware.AdminAuth(func() string {
+		return watcher.Current().Admin.Token
+	})
+	alertRules := v1.Group("/alerts", adminAuth)
+	{
+		// Alert rules, evaluated by go-alerts, and their webhook deliveries.
+		// The rules hold webhook URLs and secrets, so only admins see them.
+		alertRules.GET("", hd.GetAlertRules)
+		alertRules.POST("", hd.CreateAlertRule)
+		alertRules.GET("/:id", hd.GetAlertRule)
+		alertRules.PUT("/:id", hd.UpdateAlertRule)
+		alertRules.DELETE("/:id", hd.DeleteAlertRule)
+		alertRules.GET("/:id/deliveries", hd.GetAlertDeliveries)
+	}
+	admin := v1.Group("/admin", adminAuth)
+	{
+		// Gaps in the trades found by go-gap-detector, to target backfills.
+		admin.GET("/gaps", hd.GetGaps)
 	}
 
 	// Run server
@@ -81,13 +160,14 @@ func main() {
 	<-quit
 
 	log.Println("Shutdown Server ...")
-	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Shutdown)
+	shutdownTimeout := watcher.Current().Timeouts.Shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		log.Println("Server Shutdown Error:", err)
 	}
 
 	<-ctx.Done()
-	log.Printf("timeout of %d seconds.\n", int(cfg.Timeouts.Shutdown)/1000_000_000)
+	log.Printf("timeout of %d seconds.\n", int(shutdownTimeout)/1000_000_000)
 	log.Println("Server exiting")
 }
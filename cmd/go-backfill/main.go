@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"financial-data-backend-2/internal/backfill"
+	"financial-data-backend-2/internal/config"
+	"financial-data-backend-2/internal/gaps"
+	"financial-data-backend-2/internal/logging"
+	mongoGo "financial-data-backend-2/internal/mongo"
+	"financial-data-backend-2/internal/postgres"
+	"financial-data-backend-2/internal/processor"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const configPath = "config/config.yml"
+
+func main() {
+	symbolList := flag.String("symbols", "", "comma-separated symbols to backfill (default subscribed_symbols)")
+	fromFlag := flag.String("from", "", "start of the range, RFC 3339 or YYYY-MM-DD (required)")
+	toFlag := flag.String("to", "", "end of the range, excluded, RFC 3339 or YYYY-MM-DD (default now)")
+	minGap := flag.Duration("min-gap", time.Minute, "fill only gaps in the stored trades at least this long")
+	baseURL := flag.String("base-url", backfill.DefaultFinnhubURL, "base URL of the Finnhub REST API")
+	flag.Parse()
+
+	// - Load Configuration
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	logging.Apply(cfg.Logging.Level)
+	if err := cfg.Storage.Validate(); err != nil {
+		log.Fatalf("Invalid storage configuration: %v", err)
+	}
+	if err := cfg.Dedup.Validate(); err != nil {
+		log.Fatalf("Invalid dedup configuration: %v", err)
+	}
+
+	// - Parse the range and the symbols
+	from, err := parseTime(*fromFlag)
+	if err != nil || from.IsZero() {
+		fmt.Fprintln(os.Stderr, "-from is required, as RFC 3339 or YYYY-MM-DD")
+		flag.Usage()
+		os.Exit(2)
+	}
+	to := time.Now().UTC()
+	if *toFlag != "" {
+		if to, err = parseTime(*toFlag); err != nil {
+			log.Fatalf("Invalid -to: %v", err)
+		}
+	}
+	if !from.Before(to) {
+		log.Fatalf("Invalid range: %s is not before %s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	symbols := cfg.Symbols
+	if *symbolList != "" {
+		symbols = strings.Split(*symbolList, ",")
+	}
+
+	// - Setup the store and where the stored trades are read from
+	store, times, closeStore := openStore(cfg)
+	defer closeStore()
+
+	// - Key trades as the processor does, so that a trade backfilled twice
+	// is stored once, and flag bad ticks. Old trades are expected here, so
+	// they are not flagged as stale.
+	validation := cfg.Validation
+	validation.MaxPast = -1
+	transformer := processor.Transformer{Validators: processor.NewTickValidators(validation)}
+	if cfg.Dedup.WithDefaults().Key == config.DedupByContent {
+		transformer.Key = processor.ContentKey
+	}
+	source := backfill.NewFinnhubSource(&http.Client{Timeout: 30 * time.Second}, *baseURL, cfg.Finnhub.Token)
+	backfiller := backfill.NewBackfiller(source, store, times, transformer, *minGap, cfg.Retention.Trades)
+
+	// Graceful shutdown setup
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Backfilling %d symbol(s) from %s to %s, in gaps of %v or more", len(symbols),
+		from.Format(time.RFC3339), to.Format(time.RFC3339), *minGap)
+	failed := 0
+	for _, symbol := range symbols {
+		report, err := backfiller.Run(ctx, strings.TrimSpace(symbol), from, to)
+		log.Println(report)
+		if err != nil {
+			log.Printf("Backfill of %s failed: %v", symbol, err)
+			failed++
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if failed > 0 {
+		closeStore()
+		log.Fatalf("%d symbol(s) failed; run the backfill again to resume", failed)
+	}
+}
+
+// parseTime parses an RFC 3339 time, or a date as midnight UTC.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// openStore connects to the configured backend and returns the store, its
+// trade times and a function that disconnects it.
+func openStore(cfg *config.Config) (processor.Store, gaps.TradeTimes, func()) {
+	if cfg.Storage.WithDefaults().Backend == config.StoragePostgres {
+		db, err := postgres.ConnectDB(cfg.Storage.PostgresURL, cfg.Timeouts.BackgroundOperation)
+		if err != nil {
+			log.Fatalf("Failed to connect to Postgres: %v", err)
+		}
+		return processor.NewPostgresStore(db), gaps.NewPostgresTradeTimes(db), func() {
+			if err := db.Close(); err != nil {
+				log.Printf("Error during Postgres disconnect: %v", err)
+			}
+		}
+	}
+
+	DB, err := mongoGo.ConnectDB(cfg.MongoDB.URL, cfg.Timeouts.BackgroundOperation)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	db := DB.Database(cfg.MongoDB.DatabaseName)
+	trades := db.Collection(cfg.MongoDB.CollectionName)
+	store := processor.NewMongoStore(db.Collection(cfg.MongoDB.KeysCollection()), trades,
+		db.Collection(cfg.MongoDB.LateTradesCollection()), db.Collection(cfg.MongoDB.SymbolsCollectionName))
+	return store, gaps.NewMongoTradeTimes(trades), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+		defer cancel()
+		if err := DB.Disconnect(ctx); err != nil {
+			log.Printf("Error during MongoDB disconnect: %v", err)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"financial-data-backend-2/internal/config"
+	"financial-data-backend-2/internal/gaps"
+	"financial-data-backend-2/internal/logging"
+	mongoGo "financial-data-backend-2/internal/mongo"
+	"financial-data-backend-2/internal/postgres"
+	"flag"
+	"log"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	// Market hours are in local time; the image has no zoneinfo
+	_ "time/tzdata"
+)
+
+const configPath = "config/config.yml"
+
+func main() {
+	once := flag.Bool("once", false, "scan once and exit, e.g. from cron")
+	rescanFrom := flag.String("rescan-from", "", "first scan from this time, RFC 3339 or YYYY-MM-DD, e.g. after a backfill")
+	flag.Parse()
+
+	// - Load Configuration
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	logging.Apply(cfg.Logging.Level)
+	if err := cfg.Storage.Validate(); err != nil {
+		log.Fatalf("Invalid storage configuration: %v", err)
+	}
+	if err := cfg.Gaps.Validate(); err != nil {
+		log.Fatalf("Invalid gaps configuration: %v", err)
+	}
+	gapsCfg := cfg.Gaps.WithDefaults()
+	calendar, err := gaps.NewCalendar(gapsCfg)
+	if err != nil {
+		log.Fatalf("Invalid market hours: %v", err)
+	}
+	var rescan time.Time
+	if *rescanFrom != "" {
+		if rescan, err = parseTime(*rescanFrom); err != nil {
+			log.Fatalf("Invalid -rescan-from: %v", err)
+		}
+	}
+
+	// - Gaps are kept in MongoDB, whichever backend stores the trades
+	DB, err := mongoGo.ConnectDB(cfg.MongoDB.URL, cfg.Timeouts.BackgroundOperation)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+		defer cancel()
+		if err := DB.Disconnect(ctx); err != nil {
+			log.Printf("Error during MongoDB disconnect: %v", err)
+		}
+		log.Println("MongoDB client disconnected.")
+	}()
+	db := DB.Database(cfg.MongoDB.DatabaseName)
+	store := gaps.NewMongoStore(db.Collection(cfg.MongoDB.DataGapsCollection()),
+		db.Collection(cfg.MongoDB.GapScansCollection()))
+
+	var times gaps.TradeTimes = gaps.NewMongoTradeTimes(db.Collection(cfg.MongoDB.CollectionName))
+	if cfg.Storage.WithDefaults().Backend == config.StoragePostgres {
+		pg, err := postgres.ConnectDB(cfg.Storage.PostgresURL, cfg.Timeouts.BackgroundOperation)
+		if err != nil {
+			log.Fatalf("Failed to connect to Postgres: %v", err)
+		}
+		defer pg.Close()
+		times = gaps.NewPostgresTradeTimes(pg)
+	}
+	detector := gaps.NewDetector(times, store, calendar, gapsCfg.Threshold, gapsCfg.Lookback)
+
+	// Graceful shutdown setup
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Scanning %d symbol(s) every %v for gaps of %v or more", len(cfg.Symbols),
+		gapsCfg.Interval, gapsCfg.Threshold)
+	for {
+		run(ctx, detector, cfg.Symbols, rescan)
+		rescan = time.Time{}
+		if *once {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Println("Context cancelled, shutting down gap detector.")
+			return
+		case <-time.After(gapsCfg.Interval):
+		}
+	}
+}
+
+// run scans each symbol, from rescan if it is set. A symbol that fails is
+// scanned again from the same point next time.
+func run(ctx context.Context, detector *gaps.Detector, symbols []string, rescan time.Time) {
+	now := time.Now().UTC()
+	total, changed := 0, 0
+	for _, symbol := range symbols {
+		symbol = strings.TrimSpace(symbol)
+		var found []gaps.Found
+		var err error
+		if rescan.IsZero() {
+			found, err = detector.Scan(ctx, symbol, now)
+		} else {
+			found, err = detector.Rescan(ctx, symbol, rescan, now)
+		}
+		if err != nil {
+			log.Printf("Gap scan of %s failed: %v", symbol, err)
+		}
+		// Gaps seen by earlier scans are only logged again once they end,
+		// or otherwise change, rather than on every scan while ongoing.
+		for _, g := range found {
+			if !g.New && !g.Changed {
+				continue
+			}
+			changed++
+			kind, state := "New gap", ""
+			if g.Changed {
+				kind = "Gap changed"
+			}
+			if g.Ongoing {
+				state = ", ongoing"
+			}
+			log.Printf("%s in %s: %s - %s (%v%s)", kind, symbol, g.From.Format(time.RFC3339),
+				g.To.Format(time.RFC3339), g.To.Sub(g.From), state)
+		}
+		total += len(found)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	log.Printf("Gap scan done, %d gap(s) found, %d of them new or changed.", total, changed)
+}
+
+// parseTime parses an RFC 3339 time, or a date as midnight UTC.
+func parseTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -3,41 +3,211 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/url"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
 	"time"
 
 	"financial-data-backend-2/internal/config"
+	"financial-data-backend-2/internal/events"
+	"financial-data-backend-2/internal/ingestor"
 	"financial-data-backend-2/internal/kafka"
+	"financial-data-backend-2/internal/leader"
+	"financial-data-backend-2/internal/logging"
+	"financial-data-backend-2/internal/models"
+	mongoGo "financial-data-backend-2/internal/mongo"
 
 	"github.com/gorilla/websocket"
-	kafkaGo "github.com/segmentio/kafka-go"
 )
 
+const configPath = "config/config.yml"
+
+// Name of the ingestors' lease
+const leaseName = "go-ingestor"
+
+// How long a replica waits after losing its Finnhub connection before
+// campaigning again, so that a standby may take over first.
+const rejoinDelay = 2 * time.Second
+
+// errPublish ends the ingestor: trades can no longer be published.
+var errPublish = errors.New("failed to publish")
+
+// sendSubscription asks Finnhub to start ("subscribe") or stop
+// ("unsubscribe") streaming trades for a symbol.
+func sendSubscription(conn *websocket.Conn, msgType, symbol string) error {
+	msg, _ := json.Marshal(map[string]interface{}{"type": msgType, "symbol": symbol})
+	return conn.WriteMessage(websocket.TextMessage, msg)
+}
+
+// feed is the current Finnhub connection, if any, and the symbols it is
+// subscribed to. Writes to the connection are serialised by mu.
+type feed struct {
+	mu      sync.Mutex
+	conn    *websocket.Conn
+	symbols []string
+}
+
+// subscribe brings the connection's subscriptions in line with symbols.
+func (f *feed) subscribe(symbols []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conn != nil {
+		for _, symbol := range f.symbols {
+			if !slices.Contains(symbols, symbol) {
+				log.Printf("Unsubscribing from %s", symbol)
+				if err := sendSubscription(f.conn, "unsubscribe", symbol); err != nil {
+					log.Printf("Failed to unsubscribe from %s: %v", symbol, err)
+				}
+			}
+		}
+		for _, symbol := range symbols {
+			if !slices.Contains(f.symbols, symbol) {
+				log.Printf("Subscribing to %s", symbol)
+				if err := sendSubscription(f.conn, "subscribe", symbol); err != nil {
+					log.Printf("Failed to subscribe to %s: %v", symbol, err)
+				}
+			}
+		}
+	}
+	f.symbols = slices.Clone(symbols)
+}
+
+// attach makes conn the current connection and subscribes it to symbols.
+func (f *feed) attach(conn *websocket.Conn, symbols []string) {
+	f.mu.Lock()
+	f.conn, f.symbols = conn, nil
+	f.mu.Unlock()
+	f.subscribe(symbols)
+}
+
+func (f *feed) detach() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.conn = nil
+}
+
 func main() {
 	// - Load Configuration
-	cfg, err := config.LoadConfig("config/config.yml")
+	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
+	logging.Apply(cfg.Logging.Level)
+
+	// - Wait for Kafka to be ready and the topic to exist.
+	if err := kafka.EnsureTopicWithRetry(context.Background(), cfg.Kafka); err != nil {
+		log.Fatalf("Could not ensure Kafka topic exists: %v", err)
+	}
+
+	// - Watch the configuration, so symbols can be added or removed live
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	current := &feed{}
+	watcher := config.NewWatcher(configPath, cfg, config.DefaultReloadInterval)
+	watcher.OnReload(func(old, new *config.Config) {
+		logging.Apply(new.Logging.Level)
+		current.subscribe(new.Symbols)
+	})
+	go watcher.Run(ctx)
+
+	// - Setup Kafka Writer
+	transport, err := kafka.NewTransport(cfg.Kafka)
+	if err != nil {
+		log.Fatalf("Invalid Kafka security settings: %v", err)
+	}
+	kafkaWriter, err := ingestor.NewWriter(cfg.Kafka, transport, cfg.Timeouts.BackgroundOperation)
+	if err != nil {
+		log.Fatalf("Invalid Kafka producer settings: %v", err)
+	}
+	codec, err := events.CodecFor(cfg.Kafka.Producer.Encoding)
+	if err != nil {
+		log.Fatalf("Invalid Kafka producer settings: %v", err)
+	}
+	var spool *ingestor.Spool
+	if cfg.Kafka.Producer.SpoolDir != "" {
+		spool, err = ingestor.OpenSpool(cfg.Kafka.Producer.SpoolDir)
+		if err != nil {
+			log.Fatalf("Failed to open spool: %v", err)
+		}
+	}
+	publisher := ingestor.NewPublisher(kafkaWriter, spool, cfg.Kafka.Producer,
+		cfg.Timeouts.BackgroundOperation)
+	defer func() {
+		if err := publisher.Close(); err != nil {
+			log.Printf("Failed to close Kafka writer: %v", err)
+		}
+		log.Println("Kafka writer closed.")
+	}()
+	log.Println("Kafka writer configured successfully")
+
+	stream := func(ctx context.Context) error {
+		return streamTrades(ctx, cfg.Finnhub.Token, watcher, current, publisher, codec)
+	}
+
+	// - A single ingestor streams until the connection fails
+	if !cfg.Leader.Enabled {
+		if err := stream(ctx); err != nil {
+			log.Printf("Stopped streaming: %v", err)
+		}
+		return
+	}
+
+	// - Replicas take turns: only the lease holder streams
+	DB, err := mongoGo.ConnectDB(cfg.MongoDB.URL, cfg.Timeouts.BackgroundOperation)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+		defer cancel()
+		if err := DB.Disconnect(ctx); err != nil {
+			log.Printf("Error during MongoDB disconnect: %v", err)
+		}
+		log.Println("MongoDB client disconnected.")
+	}()
+	lease := leader.NewMongoLease(mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName,
+		cfg.MongoDB.LeasesCollection()), leaseName)
+	holder := leader.NewHolderID()
+	elector := leader.NewElector(lease, holder, cfg.Leader.TTL())
+	log.Printf("Leader election enabled, campaigning as %s", holder)
 
-	// - Retry loop to wait for Kafka to be truly ready.
 	for {
-		err := kafka.EnsureTopic(cfg.Kafka)
-		if err == nil {
+		leadCtx, stepDown, err := elector.Lead(ctx)
+		if err != nil {
+			break // shutting down
+		}
+		err = stream(leadCtx)
+		stepDown()
+		if errors.Is(err, errPublish) {
+			log.Printf("Stopped streaming: %v", err)
+			return
+		}
+		if ctx.Err() != nil {
 			break
 		}
-		log.Println("Could not ensure Kafka topic exists, retrying in 2 seconds...")
-		time.Sleep(2 * time.Second)
+		log.Printf("Stopped streaming: %v. Standing by.", err)
+		select {
+		case <-ctx.Done():
+		case <-time.After(rejoinDelay):
+		}
 	}
+	log.Println("Ingestor exiting.")
+}
 
+// streamTrades connects to Finnhub, subscribes to the configured symbols
+// and publishes every trade until ctx is done or the connection fails.
+func streamTrades(ctx context.Context, token string, watcher *config.Watcher, current *feed,
+	publisher *ingestor.Publisher, codec events.Codec) error {
 	// - Establish WebSocket Connection
-	u := url.URL{Scheme: "wss", Host: "ws.finnhub.io", RawQuery: "token=" + cfg.Finnhub.Token}
-	// log.Printf("Connecting to %s", u.String())
-
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	u := url.URL{Scheme: "wss", Host: "ws.finnhub.io", RawQuery: "token=" + token}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), nil)
 	if err != nil {
-		log.Fatalf("Failed to connect to WebSocket: %v", err)
+		return fmt.Errorf("failed to connect to WebSocket: %w", err)
 	}
 	defer conn.Close()
 	log.Println("Successfully connected to Finnhub WebSocket")
@@ -45,56 +215,67 @@ func main() {
 	// FinnHub has given ping messages before
 	conn.SetPingHandler(nil)
 
-	// - Subscribe to Symbols
-	// Iterate over the symbols from your config file
-	for _, symbol := range cfg.Symbols {
-		subMsg, _ := json.Marshal(map[string]interface{}{"type": "subscribe", "symbol": symbol})
-		log.Printf("Subscribing to %s", symbol)
-		if err := conn.WriteMessage(websocket.TextMessage, subMsg); err != nil {
-			log.Fatalf("Failed to subscribe to %s: %v", symbol, err)
-		}
-	}
+	// - Subscribe to Symbols, and keep them in line with the config
+	current.attach(conn, watcher.Current().Symbols)
+	defer current.detach()
 
-	// - Setup Kafka Writer
-	kafkaWriter := &kafkaGo.Writer{
-		Addr:     kafkaGo.TCP(cfg.Kafka.BrokerURL),
-		Topic:    cfg.Kafka.Topic,
-		Balancer: &kafkaGo.LeastBytes{},
-	}
-	defer kafkaWriter.Close()
-	log.Println("Kafka writer configured successfully")
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		// Unblock the read loop below when ctx is done, e.g. on shutdown
+		// or when leadership is lost.
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
 
 	// - The Kafka Write Loop
 	log.Println("Waiting for messages...")
 	for {
 		// Read a message from the connection
-		var message interface{}
-		err := conn.ReadJSON(&message)
+		_, raw, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("Error reading message: %v", err)
-			break
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("error reading message: %w", err)
 		}
-		log.Printf("Message: %v", message)
+		receivedAt := time.Now()
+		logging.Debugf("Message: %s", raw)
 
-		// Skip logging pings
-		msgMap, ok := message.(map[string]interface{})
-		if ok && msgMap["type"] == "ping" {
-			log.Println("Received PING message.")
+		var frame models.FinnhubTradeMessage
+		if err := json.Unmarshal(raw, &frame); err != nil {
+			log.Printf("Failed to unmarshal message: %v. Raw value: %s", err, raw)
 			continue
 		}
 
-		// Otherwise, send message to Kafka
-		msgBytes, err := json.Marshal(message)
-		if err != nil {
-			log.Printf("Failed to marshal message: %v", err)
+		// Skip logging pings, and report anything else that is not a trade
+		switch frame.Type {
+		case "trade":
+		case "ping":
+			logging.Debugf("Received PING message.")
+			continue
+		default:
+			log.Printf("Received non-trade message: %s", raw)
 			continue
 		}
-		err = kafkaWriter.WriteMessages(context.Background(),
-			kafkaGo.Message{Value: msgBytes})
-		if err != nil {
-			log.Printf("Failed to write message to Kafka: %v", err)
-		} else {
-			log.Printf("Successfully sent message to Kafka: %s", string(msgBytes))
+
+		// Otherwise, send one trade event per trade to Kafka
+		for _, trade := range events.FromFinnhub(frame, receivedAt) {
+			msg, err := events.EncodeTrade(trade, codec)
+			if err != nil {
+				log.Printf("Failed to encode trade: %v", err)
+				continue
+			}
+			if err := publisher.Publish(ctx, msg); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return fmt.Errorf("%w: %v", errPublish, err)
+			}
 		}
+		logging.Debugf("Published %d trade(s).", len(frame.Data))
 	}
 }
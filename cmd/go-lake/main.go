@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"financial-data-backend-2/internal/config"
+	"financial-data-backend-2/internal/kafka"
+	"financial-data-backend-2/internal/lake"
+	"financial-data-backend-2/internal/logging"
+	"financial-data-backend-2/internal/models"
+	"financial-data-backend-2/internal/processor"
+	"log"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+)
+
+const configPath = "config/config.yml"
+
+// How often open files are checked for age, and failed uploads retried
+const rollCheckInterval = 10 * time.Second
+
+func main() {
+	// - Load Configuration
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	logging.Apply(cfg.Logging.Level)
+	if err := cfg.Lake.Validate(); err != nil {
+		log.Fatalf("Invalid lake configuration: %v", err)
+	}
+	if err := cfg.Dedup.Validate(); err != nil {
+		log.Fatalf("Invalid dedup configuration: %v", err)
+	}
+	lakeCfg := cfg.Lake.WithDefaults()
+
+	// - Wait for Kafka to be ready and the topic to exist.
+	if err := kafka.EnsureTopicWithRetry(context.Background(), cfg.Kafka); err != nil {
+		log.Fatalf("Could not ensure Kafka topic exists: %v", err)
+	}
+
+	// - Setup Kafka Reader, in a consumer group of its own so that it
+	// sees every trade, independently of the processor. A new group
+	// starts from the topic's history.
+	dialer, err := kafka.NewDialer(cfg.Kafka)
+	if err != nil {
+		log.Fatalf("Invalid Kafka security settings: %v", err)
+	}
+	r := kafkaGo.NewReader(kafkaGo.ReaderConfig{
+		Brokers: cfg.Kafka.BrokerList(),
+		Topic:   cfg.Kafka.Topic,
+		Dialer:  dialer,
+		GroupID: lakeCfg.GroupID,
+	})
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Fatal("failed to close Kafka Reader:", err)
+		}
+		log.Println("Kafka Reader closed.")
+	}()
+	log.Printf("Kafka reader configured successfully. Consumer Group ID: %s", lakeCfg.GroupID)
+
+	// - Setup where finished files go: a bucket, or the lake directory
+	var store lake.Store = lake.NewLocalStore(lakeCfg.Path)
+	if s3 := lakeCfg.S3; s3.Bucket != "" {
+		store, err = lake.NewS3Store(s3.Endpoint, s3.Region, s3.AccessKey, s3.SecretKey, s3.Bucket, s3.Prefix, s3.Insecure)
+		if err != nil {
+			log.Fatalf("Failed to set up the S3 store: %v", err)
+		}
+		log.Printf("Uploading files to bucket %s at %s", s3.Bucket, s3.Endpoint)
+	} else {
+		log.Printf("Writing files under %s", lakeCfg.Path)
+	}
+	codec, err := lake.ParseCodec(lakeCfg.Compression)
+	if err != nil {
+		log.Fatalf("Invalid lake configuration: %v", err)
+	}
+	// Files in progress are kept out of the partitions, so that readers
+	// never see them
+	sink, err := lake.NewSink(lake.SinkConfig{
+		RollSize:     lakeCfg.RollSize,
+		RollInterval: lakeCfg.RollInterval,
+		Codec:        codec,
+	}, filepath.Join(lakeCfg.Path, "_tmp"), store)
+	if err != nil {
+		log.Fatalf("Failed to set up the lake: %v", err)
+	}
+
+	// Graceful shutdown setup
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// - Key trades the same way as the processor, so that lake rows can be
+	// deduplicated and joined with stored trades, and flag bad ticks
+	transformer := processor.Transformer{Validators: processor.NewTickValidators(cfg.Validation)}
+	if cfg.Dedup.WithDefaults().Key == config.DedupByContent {
+		transformer.Key = processor.ContentKey
+	}
+
+	// - Read in the background, so that files are rolled by age even
+	// while no trades arrive
+	messages := make(chan kafkaGo.Message)
+	go func() {
+		defer close(messages)
+		for {
+			m, err := r.FetchMessage(ctx)
+			if err != nil {
+				if errors.Is(err, context.Canceled) {
+					return
+				}
+				log.Printf("Error reading message: %v", err)
+				continue
+			}
+			select {
+			case messages <- m:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// - Commit the offsets of the messages whose trades are all in
+	// finished files. Trades of uncommitted messages are written again
+	// after a restart, so the lake holds each trade at least once.
+	committed := make(map[int]int64)
+	commit := func(ctx context.Context) {
+		var msgs []kafkaGo.Message
+		for partition, offset := range sink.Committable() {
+			if offset > committed[partition] {
+				msgs = append(msgs, kafkaGo.Message{Topic: cfg.Kafka.Topic, Partition: partition, Offset: offset - 1})
+			}
+		}
+		if len(msgs) == 0 {
+			return
+		}
+		if err := r.CommitMessages(ctx, msgs...); err != nil {
+			log.Printf("Failed to commit offsets: %v", err)
+			return
+		}
+		for _, m := range msgs {
+			committed[m.Partition] = m.Offset + 1
+		}
+	}
+
+	// - The Read Loop
+	var writeErr error
+	log.Println("Waiting for messages...")
+	ticker := time.NewTicker(rollCheckInterval)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case m, ok := <-messages:
+			if !ok {
+				log.Println("Context cancelled, shutting down lake writer.")
+				break loop
+			}
+			var trades []models.TradeRecord
+			data, err := transformer.Transform(m)
+			if err != nil {
+				log.Printf("Failed to transform message: %v. Raw value: %s", err, string(m.Value))
+			} else if data != nil {
+				for _, record := range data.TradeRecords {
+					if trade, ok := record.(models.TradeRecord); ok {
+						trades = append(trades, trade)
+					}
+				}
+			}
+			if err := sink.Add(ctx, trades, m.Partition, m.Offset, time.Now()); err != nil {
+				if errors.Is(err, lake.ErrWrite) {
+					// Later messages must not be committed past this one:
+					// finish what was written, and stop, to read it again.
+					writeErr = err
+					log.Printf("Stopping the lake writer: %v", err)
+					stop()
+					break loop
+				}
+				log.Printf("Failed to finish files: %v", err)
+			}
+		case now := <-ticker.C:
+			if err := sink.Roll(ctx, now); err != nil {
+				log.Printf("Failed to roll files: %v", err)
+			}
+			commit(ctx)
+		}
+	}
+
+	// - Finish the open files and commit what they hold
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+	defer cancel()
+	if err := sink.Close(shutdownCtx); err != nil {
+		log.Printf("Failed to finish files: %v", err)
+	}
+	if n := sink.Pending(); n > 0 {
+		log.Printf("WARNING: %d file(s) could not be stored; their trades will be written again", n)
+	}
+	commit(shutdownCtx)
+	if writeErr != nil {
+		// Exit with an error, so that the writer is restarted
+		log.Fatalf("Lake writer exiting after a failed write: %v", writeErr)
+	}
+	log.Println("Cleanup finished. Lake writer exiting.")
+}
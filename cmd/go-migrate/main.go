@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"financial-data-backend-2/internal/config"
+	mongoGo "financial-data-backend-2/internal/mongo"
+	"financial-data-backend-2/internal/postgres"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+const configPath = "config/config.yml"
+
+const usage = `Usage: migrate <command>
+
+Commands:
+  up      apply all pending migrations
+  status  list migrations and whether they have been applied`
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	// - Load Configuration
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+
+	// - Setup MongoDB database
+	DB, err := mongoGo.ConnectDB(cfg.MongoDB.URL, cfg.Timeouts.BackgroundOperation)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+		defer cancel()
+		if err := DB.Disconnect(ctx); err != nil {
+			log.Printf("Error during MongoDB disconnect: %v", err)
+		}
+	}()
+
+	mongoMigrator, err := mongoGo.NewMigrator(DB.Database(cfg.MongoDB.DatabaseName),
+		mongoGo.Migrations(cfg.MongoDB))
+	if err != nil {
+		log.Fatalf("Invalid migrations: %v", err)
+	}
+	// MongoDB keeps alert rules and leases with either storage backend.
+	migrators := []migrator{{"MongoDB", mongoAdapter{mongoMigrator}}}
+
+	if err := cfg.Storage.Validate(); err != nil {
+		log.Fatalf("Invalid storage configuration: %v", err)
+	}
+	if cfg.Storage.WithDefaults().Backend == config.StoragePostgres {
+		db, err := postgres.ConnectDB(cfg.Storage.PostgresURL, cfg.Timeouts.BackgroundOperation)
+		if err != nil {
+			log.Fatalf("Failed to connect to Postgres: %v", err)
+		}
+		defer db.Close()
+		pgMigrator, err := postgres.NewMigrator(db, postgres.Migrations())
+		if err != nil {
+			log.Fatalf("Invalid migrations: %v", err)
+		}
+		migrators = append(migrators, migrator{"Postgres", postgresAdapter{pgMigrator}})
+	}
+
+	// Creating collections and indexes can take a while on big databases.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	switch os.Args[1] {
+	case "up":
+		for _, mg := range migrators {
+			applied, err := mg.up(ctx)
+			for _, m := range applied {
+				fmt.Printf("%s: applied %d: %s\n", mg.name, m.Version, m.Description)
+			}
+			if err != nil {
+				log.Fatalf("%s migration failed: %v", mg.name, err)
+			}
+			if len(applied) == 0 {
+				fmt.Printf("%s: database is up to date.\n", mg.name)
+			}
+		}
+	case "status":
+		for _, mg := range migrators {
+			statuses, err := mg.status(ctx)
+			if err != nil {
+				log.Fatalf("Could not read %s migration status: %v", mg.name, err)
+			}
+			fmt.Printf("%s:\n", mg.name)
+			for _, s := range statuses {
+				applied := "pending"
+				if s.AppliedAt != nil {
+					applied = "applied " + s.AppliedAt.Format(time.RFC3339)
+				}
+				fmt.Printf("%4d  %-28s  %s\n", s.Version, applied, s.Description)
+			}
+		}
+	default:
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// migrator is a database's migrations, under the name they are listed by.
+type migrator struct {
+	name string
+	migrations
+}
+
+// migrations is what the commands need of mongo.Migrator and
+// postgres.Migrator.
+type migrations interface {
+	up(context.Context) ([]mongoGo.Migration, error)
+	status(context.Context) ([]mongoGo.MigrationStatus, error)
+}
+
+type mongoAdapter struct{ *mongoGo.Migrator }
+
+func (a mongoAdapter) up(ctx context.Context) ([]mongoGo.Migration, error) { return a.Up(ctx) }
+
+func (a mongoAdapter) status(ctx context.Context) ([]mongoGo.MigrationStatus, error) {
+	return a.Status(ctx)
+}
+
+// postgresAdapter lists Postgres migrations by version and description,
+// all that is printed of them.
+type postgresAdapter struct{ *postgres.Migrator }
+
+func (a postgresAdapter) up(ctx context.Context) ([]mongoGo.Migration, error) {
+	applied, err := a.Up(ctx)
+	migrations := make([]mongoGo.Migration, len(applied))
+	for i, m := range applied {
+		migrations[i] = mongoGo.Migration{Version: m.Version, Description: m.Description}
+	}
+	return migrations, err
+}
+
+func (a postgresAdapter) status(ctx context.Context) ([]mongoGo.MigrationStatus, error) {
+	statuses, err := a.Status(ctx)
+	converted := make([]mongoGo.MigrationStatus, len(statuses))
+	for i, s := range statuses {
+		converted[i] = mongoGo.MigrationStatus{Version: s.Version, Description: s.Description, AppliedAt: s.AppliedAt}
+	}
+	return converted, err
+}
@@ -5,41 +5,54 @@ import (
 	"errors"
 	"financial-data-backend-2/internal/config"
 	"financial-data-backend-2/internal/kafka"
+	"financial-data-backend-2/internal/logging"
+	"financial-data-backend-2/internal/models"
 	mongoGo "financial-data-backend-2/internal/mongo"
+	"financial-data-backend-2/internal/postgres"
 	"financial-data-backend-2/internal/processor"
 	"log"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
 	kafkaGo "github.com/segmentio/kafka-go"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const configPath = "config/config.yml"
+
 func main() {
 	// - Load Configuration
-	cfg, err := config.LoadConfig("config/config.yml")
+	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
 		log.Fatalf("Error loading configuration: %v", err)
 	}
+	logging.Apply(cfg.Logging.Level)
+	if err := cfg.Lateness.Validate(); err != nil {
+		log.Fatalf("Invalid lateness configuration: %v", err)
+	}
+	if err := cfg.Dedup.Validate(); err != nil {
+		log.Fatalf("Invalid dedup configuration: %v", err)
+	}
+	if err := cfg.Kafka.TopicSettings.Validate(); err != nil {
+		log.Fatalf("Invalid Kafka topic configuration: %v", err)
+	}
 
-	// - Retry loop to wait for Kafka to be truly ready.
-	for {
-		err := kafka.EnsureTopic(cfg.Kafka)
-		if err == nil {
-			break
-		}
-		log.Println("Could not ensure Kafka topic exists, retrying in 2 seconds...")
-		time.Sleep(2 * time.Second)
+	// - Wait for Kafka to be ready and the topic to exist.
+	if err := kafka.EnsureTopicWithRetry(context.Background(), cfg.Kafka); err != nil {
+		log.Fatalf("Could not ensure Kafka topic exists: %v", err)
 	}
 
 	// - Setup Kafka Reader
+	dialer, err := kafka.NewDialer(cfg.Kafka)
+	if err != nil {
+		log.Fatalf("Invalid Kafka security settings: %v", err)
+	}
 	// Create the Kafka Reader
 	r := kafkaGo.NewReader(kafkaGo.ReaderConfig{
-		Brokers: []string{cfg.Kafka.BrokerURL},
+		Brokers: cfg.Kafka.BrokerList(),
 		Topic:   cfg.Kafka.Topic,
+		Dialer:  dialer,
 		GroupID: "finnhub-websocket-consumer-group",
 		//    Essential for distributed consumption and offset tracking
 		// MaxBytes:    10e6,
@@ -56,61 +69,187 @@ func main() {
 	log.Println(`Kafka reader configured successfully. 
 	Consumer Group ID: finnhub-websocket-consumer-group`)
 
-	// - Setup MongoDB database
-	DB, err := mongoGo.ConnectDB(cfg.MongoDB.URL, cfg.Timeouts.BackgroundOperation)
-	if err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	// - Setup the store: MongoDB or Postgres
+	if err := cfg.Storage.Validate(); err != nil {
+		log.Fatalf("Invalid storage configuration: %v", err)
 	}
-	defer func() {
-		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
-		defer cancel()
-		if err := DB.Disconnect(ctx); err != nil {
-			log.Fatalf("Error during MongoDB disconnect: %v", err)
+	store, closeStore := openStore(cfg)
+	defer closeStore()
+
+	// Graceful shutdown setup
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// - Watch the configuration for changes that can be applied live
+	watcher := config.NewWatcher(configPath, cfg, config.DefaultReloadInterval)
+	watcher.OnReload(func(old, new *config.Config) {
+		logging.Apply(new.Logging.Level)
+	})
+	go watcher.Run(ctx)
+
+	// - Key trades by message or by content, and flag bad ticks, e.g.
+	// fat-finger prices, so that they stay out of candles and the symbol
+	// metadata
+	dedupCfg := cfg.Dedup.WithDefaults()
+	transformer := processor.Transformer{Validators: processor.NewTickValidators(cfg.Validation)}
+	if dedupCfg.Key == config.DedupByContent {
+		transformer.Key = processor.ContentKey
+	}
+	var seen *processor.SeenCache
+	if dedupCfg.CacheSize > 0 {
+		seen = processor.NewSeenCache(dedupCfg.CacheSize)
+	}
+	log.Printf("Deduplicating trades by %s (caching %d keys)", dedupCfg.Key, dedupCfg.CacheSize)
+
+	// - Set aside trades that arrive too late or too far ahead, and report
+	// how many there are
+	policy := processor.NewLatenessPolicy(cfg.Lateness)
+	go reportLateness(ctx, policy, cfg.Lateness.WithDefaults().ReportInterval)
+
+	// - Retry writes that fail transiently, and pause while the database is down
+	processorCfg := cfg.Processor.WithDefaults()
+	breaker := processor.NewBreaker(processorCfg)
+
+	// - Handle a batch of messages: store their trades together and update
+	// the symbols. It reports whether the messages are done with and may be
+	// committed.
+	handle := func(batch []kafkaGo.Message) bool {
+		timeout := watcher.Current().Timeouts.BackgroundOperation
+
+		// Transform data
+		var records []interface{}
+		for _, m := range batch {
+			logging.Debugf("Message received | Topic: %s | Partition: %d | Offset: %d\n",
+				m.Topic, m.Partition, m.Offset)
+			logging.Debugf("Message Value: %s", string(m.Value))
+			data, err := transformer.Transform(m)
+			if err != nil {
+				log.Printf("Failed to transform message: %v. Raw value: %s", err, string(m.Value))
+				continue
+			}
+			if data == nil { // Message was a ping, not a trade, or had no valid data
+				logging.Debugf("Skipping message (not a valid trade).")
+				continue
+			}
+			records = append(records, data.TradeRecords...)
+		}
+		if len(records) == 0 {
+			return true
+		}
+		if seen != nil {
+			var skipped int
+			records, skipped = seen.Filter(records)
+			if skipped > 0 {
+				logging.Debugf("Skipped %d recently stored trade(s)", skipped)
+			}
 		}
-		log.Println("MongoDB client disconnected.")
-	}()
 
-	// - Setup collections
-	symbolCollection := mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName,
-		cfg.MongoDB.SymbolsCollectionName)
-	tradeCollection := mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName,
-		cfg.MongoDB.CollectionName)
+		timeSeries, late := policy.Apply(records)
+		if len(late) > 0 {
+			err := breaker.Do(ctx, func() error {
+				lateCtx, lateCancel := context.WithTimeout(context.Background(), timeout)
+				defer lateCancel()
+				return store.InsertLateTrades(lateCtx, late)
+			})
+			if errors.Is(err, context.Canceled) {
+				log.Printf("Shutting down before setting aside %d late trade(s), the messages will be read again: %v", len(late), err)
+				return false
+			}
+			if err != nil {
+				log.Printf("Failed to set aside %d late trade(s): %v", len(late), err)
+			}
+		}
+		if len(timeSeries) == 0 {
+			return true
+		}
 
-	// Ensure a unique index exists on the symbol collection for performance.
-	// This is a one-time setup.
-	_, err = symbolCollection.Indexes().CreateOne(
-		context.Background(),
-		mongo.IndexModel{
-			Keys:    bson.M{"symbol": 1},
-			Options: options.Index().SetUnique(true),
-		},
-	)
-	if err != nil {
-		log.Printf("Could not create unique index on symbols (may already exist): %v", err)
-	}
-
-	// Also ensure a unique index exists on the trade collection for message.
-	// This is a one-time setup.
-	_, err = tradeCollection.Indexes().CreateOne(
-		context.Background(),
-		mongo.IndexModel{
-			Keys:    bson.M{"message_key": 1},
-			Options: options.Index().SetUnique(true),
-		},
-	)
-	if err != nil {
-		log.Printf("Could not create unique index on message key (may already exist): %v", err)
+		// Insert trade records in batch
+		var inserted []models.TradeRecord
+		err := breaker.Do(ctx, func() error {
+			insertCtx, insertCancel := context.WithTimeout(context.Background(), timeout)
+			defer insertCancel()
+			stored, err := store.InsertTrades(insertCtx, timeSeries)
+			inserted = append(inserted, stored...)
+			return err
+		})
+		if errors.Is(err, context.Canceled) {
+			log.Printf("Shutting down before storing trades, the messages will be read again: %v", err)
+			return false
+		}
+		if err != nil {
+			// The trades themselves were refused. We should not proceed.
+			log.Printf("CRITICAL: Failed to insert trade records: %v. Skipping metadata update.", err)
+			return true
+		}
+		if len(inserted) < len(timeSeries) {
+			// We've successfully prevented duplicates. Only the trades stored
+			// now are added to the metadata, so they are not counted twice.
+			log.Printf("Info: Blocked %d duplicate trade insertion(s) for %d message(s)", len(timeSeries)-len(inserted), len(batch))
+		}
+		logging.Debugf("Successfully inserted %d trade records.", len(inserted))
+		if seen != nil {
+			// Stored now or before
+			seen.Add(timeSeries)
+		}
+
+		// Update symbol metadata
+		summaries := processor.Summarize(inserted)
+		updateCtx, updateCancel := context.WithTimeout(context.Background(), timeout)
+		for _, summary := range summaries {
+			// Merges the count, first/last trade, last price and the day's
+			// open/high/low/volume (or creates the symbol)
+			if err := store.UpdateSymbol(updateCtx, summary); err != nil {
+				// This is a non-critical failure. We log it but don't stop the system.
+				// This is a "eventual consistency" trade-off; go-reconciler repairs
+				// the counts and trade times.
+				log.Printf("Failed to upsert symbol metadata for '%s': %v", summary.Symbol, err)
+			}
+		}
+		updateCancel()
+
+		logging.Debugf("Updated metadata for %d unique symbol(s).", len(summaries))
+		return true
 	}
 
-	// Graceful shutdown setup
-	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer stop()
+	// - Handle partitions concurrently, each in order, and commit every
+	// message once it is handled. A message whose trades were refused is
+	// committed too: it is logged, not retried. Messages left unfinished
+	// at shutdown are not, and neither are the ones after them, since
+	// committing an offset commits every earlier one of its partition.
+	var unfinishedMu sync.Mutex
+	unfinished := make(map[int]bool)
+	pool := processor.NewPartitionPool(processorCfg.Workers, processorCfg.QueueSize, processorCfg.BatchSize, func(batch []kafkaGo.Message) {
+		done := handle(batch)
+		var commit []kafkaGo.Message
+		unfinishedMu.Lock()
+		for _, m := range batch {
+			if !done {
+				unfinished[m.Partition] = true
+			}
+			if !unfinished[m.Partition] {
+				commit = append(commit, m)
+			}
+		}
+		unfinishedMu.Unlock()
+		if len(commit) == 0 {
+			return
+		}
+		// The read context may be cancelled by now, on shutdown.
+		commitCtx, commitCancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+		defer commitCancel()
+		if err := r.CommitMessages(commitCtx, commit...); err != nil {
+			log.Printf("Failed to commit %d message(s) up to offset %d of partition %d: %v",
+				len(commit), commit[len(commit)-1].Offset, commit[len(commit)-1].Partition, err)
+		}
+	})
+	log.Printf("Handling up to %d partition(s) concurrently, in batches of up to %d message(s)",
+		processorCfg.Workers, processorCfg.BatchSize)
 
 	// - The Read Loop
 	log.Println("Waiting for messages...")
 	for {
-		// Read a message from Kafka
-		m, err := r.ReadMessage(ctx)
+		// Fetch a message from Kafka; it is committed once handled
+		m, err := r.FetchMessage(ctx)
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
 				log.Println("Context cancelled, shutting down processor.")
@@ -120,70 +259,122 @@ func main() {
 			log.Printf("Error reading message: %v", err)
 			continue
 		}
+		if err := pool.Submit(ctx, m); err != nil {
+			// Shutting down; the message is read again after a restart.
+			log.Println("Context cancelled, shutting down processor.")
+			break
+		}
+	}
 
-		log.Printf("Message received | Topic: %s | Partition: %d | Offset: %d\n",
-			m.Topic, m.Partition, m.Offset)
-		log.Printf("Message Value: %s", string(m.Value))
+	// - Drain: finish the messages already read, before the reader closes
+	log.Println("Finishing in-flight messages...")
+	pool.Close()
+	s := breaker.Stats()
+	log.Printf("Database writes retried %d time(s), circuit breaker opened %d time(s), now %s",
+		s.Retries, s.Opened, s.State)
+	log.Println("Cleanup finished. Processor exiting.")
+}
 
-		// Transform data
-		data, err := processor.TransformMessage(m)
-		if err != nil {
-			log.Printf("Failed to transform message: %v. Raw value: %s", err, string(m.Value))
-			continue
+// reportLateness logs, every interval, how many trades were on time, late,
+// clamped or rejected, if any were not on time.
+func reportLateness(ctx context.Context, policy *processor.LatenessPolicy, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var previous processor.LatenessStats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
-		if data == nil { // Message was a ping, not a trade, or had no valid data
-			log.Println("Skipping message (not a valid trade).")
+		current := policy.Stats()
+		s := current.Sub(previous)
+		previous = current
+		if s.Late+s.Clamped+s.Rejected == 0 {
 			continue
 		}
-		timeSeries := data.TradeRecords
-		symbolTradeCounts := data.SymbolTradeCounts
-		latestTimestamps := data.LatestTimestamps
+		log.Printf("Trades in the last %v: %d on time, %d late, %d clamped, %d rejected (since start: %d, %d, %d, %d)",
+			interval, s.OnTime, s.Late, s.Clamped, s.Rejected,
+			current.OnTime, current.Late, current.Clamped, current.Rejected)
+	}
+}
 
-		// Insert trade records in batch
-		insertCtx, insertCancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
-		_, err = tradeCollection.InsertMany(insertCtx, timeSeries)
-		insertCancel()
-		if err != nil {
-			// Check if the error is a duplicate key error. This requires checking the error code.
-			if processor.IsDuplicateKeyError(err) {
-				// This is a "good" error. It means we've successfully prevented duplicates.
-				// We'll log it and proceed to the metadata update, just in case the
-				// first attempt failed before it could update the metadata.
-				log.Print("Info: Blocked duplicate trade insertion for message")
-			} else {
-				// This is a "bad" error (DB down, etc.). We should not proceed.
-				log.Printf("CRITICAL: Failed to insert trade records: %v. Skipping metadata update.", err)
-				continue
-			}
-		} else {
-			log.Printf("Successfully inserted %d trade records.", len(timeSeries))
+// openStore connects to the configured backend and returns the store and
+// a function that disconnects it.
+func openStore(cfg *config.Config) (processor.Store, func()) {
+	if cfg.Storage.WithDefaults().Backend == config.StoragePostgres {
+		return openPostgresStore(cfg)
+	}
+	return openMongoStore(cfg)
+}
+
+func openMongoStore(cfg *config.Config) (processor.Store, func()) {
+	DB, err := mongoGo.ConnectDB(cfg.MongoDB.URL, cfg.Timeouts.BackgroundOperation)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	disconnect := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+		defer cancel()
+		if err := DB.Disconnect(ctx); err != nil {
+			log.Fatalf("Error during MongoDB disconnect: %v", err)
 		}
+		log.Println("MongoDB client disconnected.")
+	}
 
-		// Update symbol metadata
-		updateCtx, updateCancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
-		upsertOpts := options.Update().SetUpsert(true)
-		for symbol, count := range symbolTradeCounts {
-			filter := bson.M{"symbol": symbol}
-
-			// $inc increments the tradeCount by the number of trades in this message
-			// $set updates the last trade time (or sets it on insert)
-			update := bson.M{
-				"$inc":         bson.M{"tradeCount": count},
-				"$max":         bson.M{"lastTradeAt": latestTimestamps[symbol]},
-				"$setOnInsert": bson.M{"symbol": symbol},
-			}
+	// - Setup collections
+	symbolCollection := mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName,
+		cfg.MongoDB.SymbolsCollectionName)
+	tradeCollection := mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName,
+		cfg.MongoDB.CollectionName)
+	keyCollection := mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName,
+		cfg.MongoDB.KeysCollection())
+	lateCollection := mongoGo.GetCollection(DB, cfg.MongoDB.DatabaseName,
+		cfg.MongoDB.LateTradesCollection())
 
-			// Perform the upsert operation for each symbol
-			_, err := symbolCollection.UpdateOne(updateCtx, filter, update, upsertOpts)
-			if err != nil {
-				// This is a non-critical failure. We log it but don't stop the system.
-				// This is a "eventual consistency" trade-off.
-				log.Printf("Failed to upsert symbol metadata for '%s': %v", symbol, err)
-			}
+	// Collections and indexes are provisioned by go-migrate; refuse to
+	// start until it has been run, as duplicates are not detected before.
+	migrator, err := mongoGo.NewMigrator(DB.Database(cfg.MongoDB.DatabaseName),
+		mongoGo.Migrations(cfg.MongoDB))
+	if err != nil {
+		log.Fatalf("Invalid migrations: %v", err)
+	}
+	migrateCtx, migrateCancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+	defer migrateCancel()
+	if pending, err := migrator.Pending(migrateCtx); err != nil {
+		log.Fatalf("Could not check database migrations: %v", err)
+	} else if len(pending) > 0 {
+		log.Fatalf("%d database migration(s) pending, run `migrate up` first", len(pending))
+	}
+
+	return processor.NewMongoStore(keyCollection, tradeCollection, lateCollection, symbolCollection), disconnect
+}
+
+func openPostgresStore(cfg *config.Config) (processor.Store, func()) {
+	db, err := postgres.ConnectDB(cfg.Storage.PostgresURL, cfg.Timeouts.BackgroundOperation)
+	if err != nil {
+		log.Fatalf("Failed to connect to Postgres: %v", err)
+	}
+	disconnect := func() {
+		if err := db.Close(); err != nil {
+			log.Printf("Error during Postgres disconnect: %v", err)
 		}
-		updateCancel()
+		log.Println("Postgres connection closed.")
+	}
 
-		log.Printf("Updated metadata for %d unique symbol(s).", len(symbolTradeCounts))
+	// Tables are created by go-migrate, as with MongoDB, and must exist.
+	migrator, err := postgres.NewMigrator(db, postgres.Migrations())
+	if err != nil {
+		log.Fatalf("Invalid migrations: %v", err)
 	}
-	log.Println("Cleanup finished. Processor exiting.")
+	migrateCtx, migrateCancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+	defer migrateCancel()
+	if pending, err := migrator.Pending(migrateCtx); err != nil {
+		log.Fatalf("Could not check database migrations: %v", err)
+	} else if len(pending) > 0 {
+		log.Fatalf("%d database migration(s) pending, run `migrate up` first", len(pending))
+	}
+
+	log.Println("Storing trades in Postgres")
+	return processor.NewPostgresStore(db), disconnect
 }
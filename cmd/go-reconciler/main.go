@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"financial-data-backend-2/internal/config"
+	mongoGo "financial-data-backend-2/internal/mongo"
+	"financial-data-backend-2/internal/postgres"
+	"financial-data-backend-2/internal/reconcile"
+	"flag"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+const configPath = "config/config.yml"
+
+func main() {
+	repair := flag.Bool("repair", false, "fix the symbol documents, rather than only reporting diffs")
+	every := flag.Duration("every", 0, "run repeatedly at this interval, rather than once")
+	flag.Parse()
+
+	// - Load Configuration
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+
+	if err := cfg.Storage.Validate(); err != nil {
+		log.Fatalf("Invalid storage configuration: %v", err)
+	}
+
+	// - Setup the database the trades and symbols are stored in
+	var reconciler symbolReconciler
+	if cfg.Storage.WithDefaults().Backend == config.StoragePostgres {
+		db, err := postgres.ConnectDB(cfg.Storage.PostgresURL, cfg.Timeouts.BackgroundOperation)
+		if err != nil {
+			log.Fatalf("Failed to connect to Postgres: %v", err)
+		}
+		defer db.Close()
+		reconciler = reconcile.NewPostgresReconciler(db)
+	} else {
+		DB, err := mongoGo.ConnectDB(cfg.MongoDB.URL, cfg.Timeouts.BackgroundOperation)
+		if err != nil {
+			log.Fatalf("Failed to connect to MongoDB: %v", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+			defer cancel()
+			if err := DB.Disconnect(ctx); err != nil {
+				log.Printf("Error during MongoDB disconnect: %v", err)
+			}
+			log.Println("MongoDB client disconnected.")
+		}()
+		db := DB.Database(cfg.MongoDB.DatabaseName)
+		reconciler = reconcile.NewReconciler(db.Collection(cfg.MongoDB.SymbolsCollectionName),
+			db.Collection(cfg.MongoDB.CollectionName), db.Collection(cfg.MongoDB.CandlesCollection()))
+	}
+
+	// Graceful shutdown setup
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	for {
+		if err := run(ctx, reconciler, *repair); err != nil {
+			log.Printf("Reconciliation failed: %v", err)
+		}
+		if *every <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Println("Context cancelled, shutting down reconciler.")
+			return
+		case <-time.After(*every):
+		}
+	}
+}
+
+// symbolReconciler is a reconcile.Reconciler or PostgresReconciler.
+type symbolReconciler interface {
+	Diffs(ctx context.Context) ([]reconcile.Diff, error)
+	Repair(ctx context.Context, diffs []reconcile.Diff) (int, error)
+}
+
+func run(ctx context.Context, reconciler symbolReconciler, repair bool) error {
+	diffs, err := reconciler.Diffs(ctx)
+	if err != nil {
+		return err
+	}
+	if len(diffs) == 0 {
+		log.Println("Symbol metadata is in sync with the trades.")
+		return nil
+	}
+	for _, d := range diffs {
+		log.Println(d)
+	}
+	log.Printf("%d symbol(s) out of sync.", len(diffs))
+	if !repair {
+		return nil
+	}
+
+	n, err := reconciler.Repair(ctx, diffs)
+	log.Printf("Repaired %d symbol(s).", n)
+	return err
+}
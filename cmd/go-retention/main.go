@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"financial-data-backend-2/internal/config"
+	"financial-data-backend-2/internal/logging"
+	mongoGo "financial-data-backend-2/internal/mongo"
+	"financial-data-backend-2/internal/postgres"
+	"financial-data-backend-2/internal/retention"
+	"flag"
+	"log"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const configPath = "config/config.yml"
+
+func main() {
+	once := flag.Bool("once", false, "run the job once and exit, e.g. from cron")
+	flag.Parse()
+
+	// - Load Configuration
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	logging.Apply(cfg.Logging.Level)
+	if err := cfg.Retention.Validate(); err != nil {
+		log.Fatalf("Invalid retention settings: %v", err)
+	}
+	if err := cfg.Storage.Validate(); err != nil {
+		log.Fatalf("Invalid storage configuration: %v", err)
+	}
+
+	// Graceful shutdown setup
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var job func(context.Context)
+	if cfg.Storage.WithDefaults().Backend == config.StoragePostgres {
+		db, err := postgres.ConnectDB(cfg.Storage.PostgresURL, cfg.Timeouts.BackgroundOperation)
+		if err != nil {
+			log.Fatalf("Failed to connect to Postgres: %v", err)
+		}
+		defer db.Close()
+		var downsampler *retention.PostgresDownsampler
+		if cfg.Retention.DownsampleAfter > 0 {
+			downsampler = retention.NewPostgresDownsampler(db, cfg.Retention.DownsampleAfter,
+				cfg.Retention.Lookback(), cfg.Aggregate.ExcludeConditions)
+		}
+		job = func(ctx context.Context) { runPostgres(ctx, db, cfg, downsampler) }
+	} else {
+		DB, err := mongoGo.ConnectDB(cfg.MongoDB.URL, cfg.Timeouts.BackgroundOperation)
+		if err != nil {
+			log.Fatalf("Failed to connect to MongoDB: %v", err)
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.BackgroundOperation)
+			defer cancel()
+			if err := DB.Disconnect(ctx); err != nil {
+				log.Printf("Error during MongoDB disconnect: %v", err)
+			}
+			log.Println("MongoDB client disconnected.")
+		}()
+		db := DB.Database(cfg.MongoDB.DatabaseName)
+		var downsampler *retention.Downsampler
+		if cfg.Retention.DownsampleAfter > 0 {
+			downsampler = retention.NewDownsampler(db.Collection(cfg.MongoDB.CollectionName),
+				db.Collection(cfg.MongoDB.CandlesCollection()), db.Collection(cfg.MongoDB.SymbolsCollectionName),
+				cfg.Retention.DownsampleAfter, cfg.Retention.Lookback(), cfg.Aggregate.ExcludeConditions)
+		}
+		job = func(ctx context.Context) { run(ctx, db, cfg, downsampler) }
+	}
+
+	interval := cfg.Retention.RunInterval()
+	for {
+		job(ctx)
+		if *once {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			log.Println("Context cancelled, shutting down retention job.")
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// run downsamples first, so that trades are rolled up before a shorter
+// TTL can expire them.
+func run(ctx context.Context, db *mongo.Database, cfg *config.Config, downsampler *retention.Downsampler) {
+	if downsampler != nil {
+		n, err := downsampler.Run(ctx, time.Now())
+		if err != nil {
+			log.Printf("Downsampling failed after %d candle(s): %v", n, err)
+			// Do not change retention while downsampling is behind.
+			return
+		}
+		log.Printf("Downsampling done, %d candle(s) written.", n)
+	}
+
+	ttlCtx, cancel := context.WithTimeout(ctx, cfg.Timeouts.BackgroundOperation)
+	defer cancel()
+	if err := retention.ApplyTTL(ttlCtx, db, cfg.MongoDB.CollectionName, cfg.Retention.Trades); err != nil {
+		log.Printf("Failed to apply trade retention: %v", err)
+	}
+	if err := retention.ApplyTTL(ttlCtx, db, cfg.MongoDB.CandlesCollection(), cfg.Retention.Candles); err != nil {
+		log.Printf("Failed to apply candle retention: %v", err)
+	}
+}
+
+// runPostgres is run for storage.backend "postgres". Expired trades are
+// dropped a month at a time, with their partition.
+func runPostgres(ctx context.Context, db *sql.DB, cfg *config.Config, downsampler *retention.PostgresDownsampler) {
+	if downsampler != nil {
+		n, err := downsampler.Run(ctx, time.Now())
+		if err != nil {
+			log.Printf("Downsampling failed after %d candle(s): %v", n, err)
+			// Do not drop trades while downsampling is behind.
+			return
+		}
+		log.Printf("Downsampling done, %d candle(s) written.", n)
+	}
+
+	retentionCtx, cancel := context.WithTimeout(ctx, cfg.Timeouts.BackgroundOperation)
+	defer cancel()
+	if err := retention.ApplyPostgresRetention(retentionCtx, db, time.Now(), cfg.Retention.Trades,
+		cfg.Retention.Candles); err != nil {
+		log.Printf("Failed to apply retention: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package alerts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Address ranges that are not on the public internet, besides those
+// netip.Addr reports as loopback, private, link-local and so on.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// public reports whether ip is a public unicast address, which webhooks
+// may be delivered to.
+func public(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	return !slices.ContainsFunc(reservedPrefixes, func(p netip.Prefix) bool { return p.Contains(ip) })
+}
+
+// checkWebhookHost refuses webhook hosts that are plainly internal: the
+// loopback names and non-public IP addresses. Host names are checked
+// again when the webhook client resolves them.
+func checkWebhookHost(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("webhook_url must not point at this host")
+	}
+	if ip, err := netip.ParseAddr(host); err == nil && !public(ip) {
+		return errors.New("webhook_url must not point at a private, loopback or reserved address")
+	}
+	return nil
+}
+
+// NewWebhookClient returns the HTTP client go-alerts delivers webhooks
+// with. It only connects to public addresses, so that rules cannot have
+// it post to internal services, except for the hosts in allowedHosts,
+// e.g. a receiver on the same network.
+func NewWebhookClient(timeout time.Duration, allowedHosts []string) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(allowedHosts, host) {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		for _, ip := range ips {
+			if !public(ip) {
+				return nil, fmt.Errorf("webhook host %s resolves to %s, which is not a public address", host, ip)
+			}
+		}
+		// Connect to the address just checked, not one resolved anew
+		return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].Unmap().String(), port))
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// No proxy: the address checked must be the one connected to
+			DialContext:         dial,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
@@ -0,0 +1,396 @@
+package alerts
+
+import (
+	"context"
+	"encoding/json"
+	"financial-data-backend-2/internal/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var start = time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+
+func dec(s string) primitive.Decimal128 {
+	d, _ := primitive.ParseDecimal128(s)
+	return d
+}
+
+func trade(after time.Duration, price, volume string) models.TradeRecord {
+	return models.TradeRecord{Symbol: "AAPL", Time: start.Add(after), Price: dec(price), Volume: dec(volume)}
+}
+
+func rule(r models.AlertRule) models.AlertRule {
+	r.Id = primitive.NewObjectID()
+	r.Symbol = "AAPL"
+	r.WebhookURL = "https://hooks.example.com/alerts"
+	r.Enabled = true
+	return r
+}
+
+// fired evaluates trades in order and returns the events of every alert.
+func fired(e *Evaluator, trades ...models.TradeRecord) []Event {
+	var events []Event
+	for _, t := range trades {
+		for _, a := range e.Evaluate(t) {
+			events = append(events, a.Event)
+		}
+	}
+	return events
+}
+
+func TestNormalize(t *testing.T) {
+	testCases := []struct {
+		name        string
+		rule        models.AlertRule
+		expectError bool
+	}{
+		{name: "price cross", rule: models.AlertRule{Type: models.AlertPriceCross, Price: dec("200")}},
+		{name: "percent move", rule: models.AlertRule{Type: models.AlertPercentMove, Percent: 2, WindowMinutes: 5, Direction: models.DirectionDown}},
+		{name: "volume spike", rule: models.AlertRule{Type: models.AlertVolumeSpike, Multiplier: 3, WindowMinutes: 1}},
+		{name: "unknown type", rule: models.AlertRule{Type: "news"}, expectError: true},
+		{name: "price cross without price", rule: models.AlertRule{Type: models.AlertPriceCross}, expectError: true},
+		{name: "price cross with window", rule: models.AlertRule{Type: models.AlertPriceCross, Price: dec("1"), WindowMinutes: 5}, expectError: true},
+		{name: "percent move without window", rule: models.AlertRule{Type: models.AlertPercentMove, Percent: 2}, expectError: true},
+		{name: "window too long", rule: models.AlertRule{Type: models.AlertPercentMove, Percent: 2, WindowMinutes: MaxWindowMinutes + 1}, expectError: true},
+		{name: "volume spike with direction", rule: models.AlertRule{Type: models.AlertVolumeSpike, Multiplier: 3, WindowMinutes: 1, Direction: models.DirectionUp}, expectError: true},
+		{name: "invalid direction", rule: models.AlertRule{Type: models.AlertPriceCross, Price: dec("1"), Direction: "sideways"}, expectError: true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			r := rule(tt.rule)
+			err := Normalize(&r)
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	r := rule(models.AlertRule{Type: models.AlertPriceCross, Price: dec("1")})
+	r.WebhookURL = "localhost:9000"
+	assert.Error(t, Normalize(&r), "the webhook URL needs a scheme")
+
+	for _, url := range []string{
+		"http://localhost:9000/alerts",
+		"http://api.localhost/alerts",
+		"http://127.0.0.1/alerts",
+		"http://10.0.0.5/alerts",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]:8080/alerts",
+		"http://[::ffff:192.168.1.1]/alerts",
+		"http://100.64.0.1/alerts",
+		"http://0.0.0.0:8000/alerts",
+	} {
+		r = rule(models.AlertRule{Type: models.AlertPriceCross, Price: dec("1")})
+		r.WebhookURL = url
+		assert.Error(t, Normalize(&r), url)
+	}
+	r = rule(models.AlertRule{Type: models.AlertPriceCross, Price: dec("1")})
+	r.WebhookURL = "https://93.184.215.14/alerts"
+	assert.NoError(t, Normalize(&r), "public addresses are fine")
+
+	r = rule(models.AlertRule{Type: models.AlertPriceCross, Price: dec("1")})
+	assert.NoError(t, Normalize(&r))
+	assert.Equal(t, models.DirectionAny, r.Direction)
+}
+
+func TestEvaluatePriceCross(t *testing.T) {
+	e := NewEvaluator(time.Minute)
+	e.SetRules([]models.AlertRule{rule(models.AlertRule{Type: models.AlertPriceCross, Price: dec("200")})})
+
+	events := fired(e,
+		trade(0, "201", "1"),               // no previous price: no crossing
+		trade(time.Second, "199.5", "1"),   // down
+		trade(2*time.Second, "200.5", "1"), // up, but cooling down
+		trade(2*time.Minute, "199", "1"),   // down
+		trade(3*time.Minute, "198", "1"),   // stays below
+	)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, models.DirectionDown, events[0].Direction)
+		assert.Equal(t, "199.5", events[0].Price)
+		assert.Equal(t, "200", events[0].Reference)
+		assert.Equal(t, "AAPL crossed below 200 at 199.5", events[0].Message)
+		assert.Equal(t, start.Add(time.Second), events[0].TradeTime)
+		assert.Equal(t, start.Add(2*time.Minute), events[1].TradeTime)
+	}
+
+	// Only upward crossings
+	e.SetRules([]models.AlertRule{rule(models.AlertRule{Type: models.AlertPriceCross, Price: dec("200"), Direction: models.DirectionUp})})
+	events = fired(e, trade(0, "201", "1"), trade(time.Second, "199", "1"), trade(2*time.Second, "200.5", "1"))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, models.DirectionUp, events[0].Direction)
+	}
+}
+
+func TestEvaluatePercentMove(t *testing.T) {
+	e := NewEvaluator(0)
+	e.SetRules([]models.AlertRule{rule(models.AlertRule{Type: models.AlertPercentMove, Percent: 5, WindowMinutes: 2})})
+
+	events := fired(e,
+		trade(0, "100", "1"),
+		trade(time.Minute, "104", "1"),
+		trade(3*time.Minute, "108", "1"),   // 100 has left the window: 104 -> 108 is 3.85%
+		trade(3*time.Minute, "109.5", "1"), // up 5.29% from 104
+		trade(4*time.Minute, "112", "1"),   // measured from 109.5 again
+		trade(5*time.Minute, "104", "1"),   // down 7.14% from 112
+	)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, models.DirectionUp, events[0].Direction)
+		assert.Equal(t, "5.29", events[0].Value)
+		assert.Equal(t, "104", events[0].Reference)
+		assert.Equal(t, "AAPL moved 5.29% in 2 minute(s), from 104 to 109.5", events[0].Message)
+		assert.Equal(t, models.DirectionDown, events[1].Direction)
+		assert.Equal(t, "-7.14", events[1].Value)
+		assert.Equal(t, "112", events[1].Reference)
+	}
+}
+
+func TestEvaluateVolumeSpike(t *testing.T) {
+	e := NewEvaluator(0)
+	e.SetRules([]models.AlertRule{rule(models.AlertRule{Type: models.AlertVolumeSpike, Multiplier: 3, WindowMinutes: 1})})
+
+	// A baseline of 10 per minute, after which 25 is not enough but 30 is
+	var trades []models.TradeRecord
+	for i := 0; i < baselineWindows; i++ {
+		trades = append(trades, trade(time.Duration(i)*time.Minute, "100", "10"))
+	}
+	trades = append(trades,
+		trade(baselineWindows*time.Minute, "100", "25"),
+		trade(baselineWindows*time.Minute+time.Second, "100", "5"),
+		trade(baselineWindows*time.Minute+2*time.Second, "100", "5"), // quiet within the window
+	)
+	events := fired(e, trades...)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "30", events[0].Value)
+		assert.Equal(t, "10", events[0].Reference)
+		assert.Equal(t, start.Add(baselineWindows*time.Minute+time.Second), events[0].TradeTime)
+	}
+
+	// Not until the baseline has been observed
+	e.SetRules([]models.AlertRule{rule(models.AlertRule{Type: models.AlertVolumeSpike, Multiplier: 3, WindowMinutes: 1})})
+	assert.Empty(t, fired(e, trade(0, "100", "1"), trade(time.Minute, "100", "1000")))
+}
+
+func TestSetRules(t *testing.T) {
+	r := rule(models.AlertRule{Type: models.AlertPriceCross, Price: dec("200")})
+	e := NewEvaluator(0)
+	e.SetRules([]models.AlertRule{r})
+	fired(e, trade(0, "199", "1"))
+
+	// Unchanged rules keep the last price
+	e.SetRules([]models.AlertRule{r})
+	assert.Len(t, fired(e, trade(time.Second, "201", "1")), 1)
+
+	// Edited rules start over
+	r.UpdatedAt = start
+	e.SetRules([]models.AlertRule{r})
+	assert.Empty(t, fired(e, trade(2*time.Second, "199", "1")))
+
+	// Disabled rules are left out
+	r.Enabled = false
+	e.SetRules([]models.AlertRule{r})
+	assert.Empty(t, fired(e, trade(3*time.Second, "201", "1")))
+}
+
+type memoryLog struct {
+	mu         sync.Mutex
+	deliveries []models.AlertDelivery
+}
+
+func (l *memoryLog) Record(_ context.Context, d models.AlertDelivery) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.deliveries = append(l.deliveries, d)
+	return nil
+}
+
+func TestDeliver(t *testing.T) {
+	testCases := []struct {
+		name             string
+		statuses         []int // responses of the receiver, in order
+		expectedStatus   string
+		expectedAttempts int
+	}{
+		{name: "delivered at once", statuses: []int{http.StatusNoContent}, expectedStatus: models.DeliveryDelivered, expectedAttempts: 1},
+		{name: "delivered after retries", statuses: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK}, expectedStatus: models.DeliveryDelivered, expectedAttempts: 3},
+		{name: "rejected", statuses: []int{http.StatusGone}, expectedStatus: models.DeliveryFailed, expectedAttempts: 1},
+		{name: "attempts run out", statuses: []int{500, 500, 500, 500}, expectedStatus: models.DeliveryFailed, expectedAttempts: 3},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// given a local receiver that checks the signature
+			r := rule(models.AlertRule{Type: models.AlertPriceCross, Price: dec("200")})
+			r.Secret = "s3cr3t"
+			var (
+				mu       sync.Mutex
+				requests int
+				received []Event
+			)
+			receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				body, _ := io.ReadAll(req.Body)
+				assert.True(t, Verify(r.Secret, req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderSignature), body))
+				assert.False(t, Verify("wrong", req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderSignature), body))
+				var event Event
+				assert.NoError(t, json.Unmarshal(body, &event))
+				assert.Equal(t, event.Id, req.Header.Get(HeaderEventId))
+
+				mu.Lock()
+				defer mu.Unlock()
+				received = append(received, event)
+				w.WriteHeader(tt.statuses[requests])
+				requests++
+			}))
+			defer receiver.Close()
+			r.WebhookURL = receiver.URL
+
+			deliveries := &memoryLog{}
+			d := NewDispatcher(receiver.Client(), deliveries, 3, time.Millisecond, 1)
+			alert := Alert{Rule: r, Event: Event{
+				Id: primitive.NewObjectID().Hex(), RuleId: r.Id.Hex(), Symbol: "AAPL", Price: "200.5", TriggeredAt: start,
+			}}
+
+			// when
+			delivery := d.Deliver(context.Background(), alert)
+
+			// then
+			assert.Equal(t, tt.expectedStatus, delivery.Status)
+			assert.Equal(t, tt.expectedAttempts, delivery.Attempts)
+			assert.Equal(t, tt.statuses[tt.expectedAttempts-1], delivery.ResponseStatus)
+			assert.Equal(t, r.Id, delivery.RuleId)
+			assert.Equal(t, start, delivery.TriggeredAt)
+			if tt.expectedStatus == models.DeliveryDelivered {
+				assert.Empty(t, delivery.Error)
+			} else {
+				assert.Contains(t, delivery.Error, "webhook responded")
+			}
+			assert.Len(t, received, tt.expectedAttempts)
+			for _, event := range received {
+				assert.Equal(t, alert.Event.Id, event.Id, "retries share the event id")
+			}
+			assert.Equal(t, []models.AlertDelivery{delivery}, deliveries.deliveries)
+		})
+	}
+}
+
+func TestDeliverUnreachable(t *testing.T) {
+	receiver := httptest.NewServer(http.NotFoundHandler())
+	receiver.Close()
+
+	r := rule(models.AlertRule{Type: models.AlertPriceCross, Price: dec("200")})
+	r.WebhookURL = receiver.URL
+	d := NewDispatcher(http.DefaultClient, &memoryLog{}, 2, time.Millisecond, 1)
+	delivery := d.Deliver(context.Background(), Alert{Rule: r, Event: Event{Id: primitive.NewObjectID().Hex()}})
+
+	assert.Equal(t, models.DeliveryFailed, delivery.Status)
+	assert.Equal(t, 2, delivery.Attempts)
+	assert.Zero(t, delivery.ResponseStatus)
+	assert.NotEmpty(t, delivery.Error)
+}
+
+func TestDispatcherRun(t *testing.T) {
+	received := make(chan string, 2)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		received <- req.Header.Get(HeaderEventId)
+	}))
+	defer receiver.Close()
+
+	r := rule(models.AlertRule{Type: models.AlertPriceCross, Price: dec("200")})
+	r.WebhookURL = receiver.URL
+	d := NewDispatcher(receiver.Client(), &memoryLog{}, 1, time.Millisecond, 1)
+	assert.True(t, d.Enqueue(Alert{Rule: r, Event: Event{Id: "first"}}))
+	assert.False(t, d.Enqueue(Alert{Rule: r, Event: Event{Id: "dropped"}}), "the queue is full")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx, 2, time.Second)
+		close(done)
+	}()
+	assert.Equal(t, "first", <-received)
+	cancel()
+	<-done
+}
+
+func TestDispatcherRunDrains(t *testing.T) {
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get(HeaderEventId) == "slow" {
+			<-release
+		}
+	}))
+	defer receiver.Close()
+	defer close(release)
+
+	r := rule(models.AlertRule{Type: models.AlertPriceCross, Price: dec("200")})
+	r.WebhookURL = receiver.URL
+	status := func(deliveries *memoryLog) map[string]string {
+		deliveries.mu.Lock()
+		defer deliveries.mu.Unlock()
+		byPayload := make(map[string]string)
+		for _, d := range deliveries.deliveries {
+			var event Event
+			json.Unmarshal([]byte(d.Payload), &event)
+			byPayload[event.Id] = d.Status + " " + d.Error
+		}
+		return byPayload
+	}
+
+	// Queued at shutdown, the alerts are still delivered
+	deliveries := &memoryLog{}
+	d := NewDispatcher(receiver.Client(), deliveries, 1, time.Millisecond, 3)
+	for _, id := range []string{"a", "b", "c"} {
+		assert.True(t, d.Enqueue(Alert{Rule: r, Event: Event{Id: id}}))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	d.Run(ctx, 2, time.Second)
+	assert.Equal(t, map[string]string{"a": "delivered ", "b": "delivered ", "c": "delivered "}, status(deliveries))
+
+	// unless the drain time runs out: the alerts left are recorded as
+	// undelivered
+	deliveries = &memoryLog{}
+	d = NewDispatcher(receiver.Client(), deliveries, 1, time.Millisecond, 3)
+	for _, id := range []string{"slow", "b", "c"} {
+		assert.True(t, d.Enqueue(Alert{Rule: r, Event: Event{Id: id}}))
+	}
+	d.Run(ctx, 1, 50*time.Millisecond)
+	assert.Len(t, deliveries.deliveries, 3)
+	for id, s := range status(deliveries) {
+		assert.Contains(t, s, models.DeliveryFailed, id)
+	}
+	assert.Contains(t, status(deliveries)["b"], "not delivered before shutdown")
+	assert.Contains(t, status(deliveries)["c"], "not delivered before shutdown")
+}
+
+func TestWebhookClient(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+	// A host name that resolves to the loopback receiver
+	url := strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1)
+
+	// The receiver is refused, by name or by address
+	_, err := NewWebhookClient(time.Second, nil).Post(url, "application/json", nil)
+	assert.ErrorContains(t, err, "not a public address")
+	_, err = NewWebhookClient(time.Second, nil).Post(receiver.URL, "application/json", nil)
+	assert.ErrorContains(t, err, "not a public address")
+
+	// unless it is allowed
+	res, err := NewWebhookClient(time.Second, []string{"localhost"}).Post(url, "application/json", nil)
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusNoContent, res.StatusCode)
+	}
+}
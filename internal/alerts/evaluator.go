@@ -0,0 +1,316 @@
+package alerts
+
+import (
+	"financial-data-backend-2/internal/models"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Event is the JSON payload of a triggered rule's webhook.
+type Event struct {
+	// Unique per alert; retries of a delivery share it.
+	Id        string `json:"id"`
+	RuleId    string `json:"rule_id"`
+	Symbol    string `json:"symbol"`
+	Type      string `json:"type"`
+	Direction string `json:"direction,omitempty"`
+	// Price of the trade that triggered the rule
+	Price string `json:"price"`
+	// What triggered it: the price crossed (price_cross), the percent
+	// moved (percent_move) or the window's volume (volume_spike)
+	Value string `json:"value"`
+	// What Value was compared with: the rule's price, the window's low or
+	// high, or the baseline volume
+	Reference   string    `json:"reference"`
+	Message     string    `json:"message"`
+	TradeTime   time.Time `json:"trade_time"`
+	TriggeredAt time.Time `json:"triggered_at"`
+}
+
+// Alert is a triggered rule and the event to deliver.
+type Alert struct {
+	Rule  models.AlertRule
+	Event Event
+}
+
+// Evaluator checks every trade against the enabled rules of its symbol.
+// Rules are evaluated on trade time, in the order trades are read.
+type Evaluator struct {
+	mu       sync.Mutex
+	cooldown time.Duration
+	bySymbol map[string][]*ruleState
+	now      func() time.Time
+}
+
+// NewEvaluator returns an evaluator without rules. A rule that fired
+// stays quiet for cooldown.
+func NewEvaluator(cooldown time.Duration) *Evaluator {
+	return &Evaluator{cooldown: cooldown, bySymbol: map[string][]*ruleState{}, now: time.Now}
+}
+
+type sample struct {
+	at    time.Time
+	price float64
+}
+
+type minuteVolume struct {
+	minute time.Time
+	volume float64
+}
+
+type ruleState struct {
+	rule      models.AlertRule
+	window    time.Duration
+	lastFired time.Time
+	latest    time.Time
+
+	// price_cross
+	threshold float64
+	prevPrice float64
+	hasPrev   bool
+
+	// percent_move: the window's prices, in increasing (lows) and
+	// decreasing (highs) order, so that the first is its low or high
+	lows, highs []sample
+
+	// volume_spike: the volume of every minute, oldest first, since
+	// observing began
+	minutes []minuteVolume
+	since   time.Time
+}
+
+// SetRules replaces the rules. Rules that did not change since the last
+// call keep their state, e.g. the prices seen within their window.
+func (e *Evaluator) SetRules(rules []models.AlertRule) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	previous := make(map[primitive.ObjectID]*ruleState)
+	for _, states := range e.bySymbol {
+		for _, st := range states {
+			previous[st.rule.Id] = st
+		}
+	}
+
+	bySymbol := make(map[string][]*ruleState)
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if err := Normalize(&rule); err != nil {
+			log.Printf("Skipping invalid alert rule %s: %v", rule.Id.Hex(), err)
+			continue
+		}
+		st, ok := previous[rule.Id]
+		if !ok || !st.rule.UpdatedAt.Equal(rule.UpdatedAt) {
+			st = newRuleState(rule)
+		}
+		bySymbol[rule.Symbol] = append(bySymbol[rule.Symbol], st)
+	}
+	e.bySymbol = bySymbol
+}
+
+func newRuleState(rule models.AlertRule) *ruleState {
+	st := &ruleState{rule: rule, window: time.Duration(rule.WindowMinutes) * time.Minute}
+	if rule.Type == models.AlertPriceCross {
+		st.threshold = toFloat(rule.Price)
+	}
+	return st
+}
+
+// Evaluate returns the alerts a trade triggers.
+func (e *Evaluator) Evaluate(trade models.TradeRecord) []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	states := e.bySymbol[trade.Symbol]
+	if len(states) == 0 {
+		return nil
+	}
+	price := toFloat(trade.Price)
+	volume := toFloat(trade.Volume)
+	if math.IsNaN(price) || math.IsNaN(volume) {
+		return nil
+	}
+
+	var alerts []Alert
+	for _, st := range states {
+		// Late trades count as of the latest one seen.
+		at := trade.Time
+		if at.Before(st.latest) {
+			at = st.latest
+		}
+		st.latest = at
+
+		var event *Event
+		switch st.rule.Type {
+		case models.AlertPriceCross:
+			event = st.priceCross(price)
+		case models.AlertPercentMove:
+			event = st.percentMove(at, price)
+		case models.AlertVolumeSpike:
+			event = st.volumeSpike(at, volume)
+		}
+		if event == nil || !st.ready(at, e.cooldown) {
+			continue
+		}
+		st.lastFired = at
+		if st.rule.Type == models.AlertPercentMove {
+			// Measure the next move from here.
+			st.lows = []sample{{at, price}}
+			st.highs = []sample{{at, price}}
+		}
+
+		event.Id = primitive.NewObjectID().Hex()
+		event.RuleId = st.rule.Id.Hex()
+		event.Symbol = trade.Symbol
+		event.Type = st.rule.Type
+		event.Price = formatFloat(price)
+		event.TradeTime = trade.Time
+		event.TriggeredAt = e.now()
+		alerts = append(alerts, Alert{Rule: st.rule, Event: *event})
+	}
+	return alerts
+}
+
+// ready reports whether the rule is out of its cooldown. A volume spike
+// lasts for a window, so it is not reported again within one.
+func (st *ruleState) ready(at time.Time, cooldown time.Duration) bool {
+	if st.lastFired.IsZero() {
+		return true
+	}
+	if st.rule.Type == models.AlertVolumeSpike && st.window > cooldown {
+		cooldown = st.window
+	}
+	return !at.Before(st.lastFired.Add(cooldown))
+}
+
+func (st *ruleState) priceCross(price float64) *Event {
+	prev, hasPrev := st.prevPrice, st.hasPrev
+	st.prevPrice, st.hasPrev = price, true
+	if !hasPrev {
+		return nil
+	}
+
+	var direction string
+	switch {
+	case prev < st.threshold && price >= st.threshold:
+		direction = models.DirectionUp
+	case prev > st.threshold && price <= st.threshold:
+		direction = models.DirectionDown
+	default:
+		return nil
+	}
+	if !matches(st.rule.Direction, direction) {
+		return nil
+	}
+	word := map[string]string{models.DirectionUp: "above", models.DirectionDown: "below"}[direction]
+	return &Event{
+		Direction: direction,
+		Value:     formatFloat(price),
+		Reference: st.rule.Price.String(),
+		Message: fmt.Sprintf("%s crossed %s %s at %s", st.rule.Symbol, word,
+			st.rule.Price.String(), formatFloat(price)),
+	}
+}
+
+func (st *ruleState) percentMove(at time.Time, price float64) *Event {
+	start := at.Add(-st.window)
+	for len(st.lows) > 0 && st.lows[0].at.Before(start) {
+		st.lows = st.lows[1:]
+	}
+	for len(st.highs) > 0 && st.highs[0].at.Before(start) {
+		st.highs = st.highs[1:]
+	}
+	for len(st.lows) > 0 && st.lows[len(st.lows)-1].price >= price {
+		st.lows = st.lows[:len(st.lows)-1]
+	}
+	for len(st.highs) > 0 && st.highs[len(st.highs)-1].price <= price {
+		st.highs = st.highs[:len(st.highs)-1]
+	}
+	st.lows = append(st.lows, sample{at, price})
+	st.highs = append(st.highs, sample{at, price})
+
+	low, high := st.lows[0].price, st.highs[0].price
+	var direction string
+	var change, reference float64
+	if up := (price - low) / low * 100; low > 0 && up >= st.rule.Percent && matches(st.rule.Direction, models.DirectionUp) {
+		direction, change, reference = models.DirectionUp, up, low
+	} else if down := (high - price) / high * 100; high > 0 && down >= st.rule.Percent && matches(st.rule.Direction, models.DirectionDown) {
+		direction, change, reference = models.DirectionDown, -down, high
+	} else {
+		return nil
+	}
+	return &Event{
+		Direction: direction,
+		Value:     strconv.FormatFloat(change, 'f', 2, 64),
+		Reference: formatFloat(reference),
+		Message: fmt.Sprintf("%s moved %s%% in %d minute(s), from %s to %s", st.rule.Symbol,
+			strconv.FormatFloat(change, 'f', 2, 64), st.rule.WindowMinutes, formatFloat(reference), formatFloat(price)),
+	}
+}
+
+func (st *ruleState) volumeSpike(at time.Time, volume float64) *Event {
+	minute := at.Truncate(time.Minute)
+	if st.since.IsZero() {
+		st.since = minute
+	}
+	if n := len(st.minutes); n > 0 && st.minutes[n-1].minute.Equal(minute) {
+		st.minutes[n-1].volume += volume
+	} else {
+		st.minutes = append(st.minutes, minuteVolume{minute, volume})
+	}
+
+	// The window and the baseline before it, in whole minutes
+	windowStart := minute.Add(-st.window + time.Minute)
+	baselineStart := windowStart.Add(-baselineWindows * st.window)
+	for len(st.minutes) > 0 && st.minutes[0].minute.Before(baselineStart) {
+		st.minutes = st.minutes[1:]
+	}
+	if st.since.After(baselineStart) {
+		// Not observed for long enough to know the baseline
+		return nil
+	}
+
+	var current, baseline float64
+	for _, m := range st.minutes {
+		if m.minute.Before(windowStart) {
+			baseline += m.volume
+		} else {
+			current += m.volume
+		}
+	}
+	baseline /= baselineWindows
+	if baseline <= 0 || current < st.rule.Multiplier*baseline {
+		return nil
+	}
+	return &Event{
+		Value:     formatFloat(current),
+		Reference: formatFloat(baseline),
+		Message: fmt.Sprintf("%s traded %s in %d minute(s), %sx its average of %s", st.rule.Symbol,
+			formatFloat(current), st.rule.WindowMinutes, strconv.FormatFloat(current/baseline, 'f', 2, 64),
+			formatFloat(baseline)),
+	}
+}
+
+func matches(want, direction string) bool {
+	return want == models.DirectionAny || want == direction
+}
+
+func toFloat(d primitive.Decimal128) float64 {
+	f, err := strconv.ParseFloat(d.String(), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
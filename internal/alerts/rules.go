@@ -0,0 +1,101 @@
+// Package alerts evaluates alert rules against the trade stream and
+// delivers the triggered alerts to signed HTTP webhooks.
+package alerts
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"financial-data-backend-2/internal/models"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaxWindowMinutes bounds the window of percent_move and volume_spike
+// rules, to keep the evaluator's state small.
+const MaxWindowMinutes = 24 * 60
+
+// A volume_spike rule compares the volume of its window with the average
+// of this many preceding windows.
+const baselineWindows = 10
+
+// Normalize fills in a rule's defaults and checks it.
+func Normalize(rule *models.AlertRule) error {
+	rule.Symbol = strings.TrimSpace(rule.Symbol)
+	if rule.Symbol == "" {
+		return errors.New("symbol is required")
+	}
+	if rule.Direction == "" && rule.Type != models.AlertVolumeSpike {
+		rule.Direction = models.DirectionAny
+	}
+
+	switch rule.Type {
+	case models.AlertPriceCross:
+		if rule.Price == (primitive.Decimal128{}) || rule.Price.IsNaN() || rule.Price.IsInf() != 0 {
+			return errors.New("price_cross rules need a price")
+		}
+		if rule.Percent != 0 || rule.Multiplier != 0 || rule.WindowMinutes != 0 {
+			return errors.New("price_cross rules only take a price and a direction")
+		}
+	case models.AlertPercentMove:
+		if !positive(rule.Percent) {
+			return errors.New("percent_move rules need a positive percent")
+		}
+		if err := checkWindow(rule.WindowMinutes); err != nil {
+			return err
+		}
+		if rule.Price != (primitive.Decimal128{}) || rule.Multiplier != 0 {
+			return errors.New("percent_move rules only take a percent, a window and a direction")
+		}
+	case models.AlertVolumeSpike:
+		if !positive(rule.Multiplier) {
+			return errors.New("volume_spike rules need a positive multiplier")
+		}
+		if err := checkWindow(rule.WindowMinutes); err != nil {
+			return err
+		}
+		if rule.Price != (primitive.Decimal128{}) || rule.Percent != 0 || rule.Direction != "" {
+			return errors.New("volume_spike rules only take a multiplier and a window")
+		}
+	default:
+		return fmt.Errorf("type must be one of %s, %s, %s",
+			models.AlertPriceCross, models.AlertPercentMove, models.AlertVolumeSpike)
+	}
+
+	switch rule.Direction {
+	case "", models.DirectionUp, models.DirectionDown, models.DirectionAny:
+	default:
+		return fmt.Errorf("direction must be one of %s, %s, %s",
+			models.DirectionUp, models.DirectionDown, models.DirectionAny)
+	}
+
+	u, err := url.Parse(rule.WebhookURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("webhook_url must be an absolute http(s) URL")
+	}
+	return checkWebhookHost(u.Hostname())
+}
+
+func positive(f float64) bool {
+	return f > 0 && !math.IsInf(f, 0)
+}
+
+func checkWindow(minutes int) error {
+	if minutes < 1 || minutes > MaxWindowMinutes {
+		return fmt.Errorf("window_minutes must be between 1 and %d", MaxWindowMinutes)
+	}
+	return nil
+}
+
+// NewSecret returns a random webhook signing key.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
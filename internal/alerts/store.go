@@ -0,0 +1,40 @@
+package alerts
+
+import (
+	"context"
+	"financial-data-backend-2/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Store reads the rules and writes the delivery log in MongoDB.
+type Store struct {
+	rules      *mongo.Collection
+	deliveries *mongo.Collection
+}
+
+func NewStore(rules, deliveries *mongo.Collection) *Store {
+	return &Store{rules: rules, deliveries: deliveries}
+}
+
+// EnabledRules returns every enabled rule.
+func (s *Store) EnabledRules(ctx context.Context) ([]models.AlertRule, error) {
+	cursor, err := s.rules.Find(ctx, bson.M{"enabled": true})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rules []models.AlertRule
+	if err = cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Record implements DeliveryLog.
+func (s *Store) Record(ctx context.Context, delivery models.AlertDelivery) error {
+	_, err := s.deliveries.InsertOne(ctx, delivery)
+	return err
+}
@@ -0,0 +1,238 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"financial-data-backend-2/internal/models"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Webhook request headers
+const (
+	HeaderEventId   = "X-Alert-Id"
+	HeaderTimestamp = "X-Alert-Timestamp"
+	// "sha256=" and the hex HMAC-SHA256 of "<timestamp>.<body>", keyed
+	// with the rule's secret
+	HeaderSignature = "X-Alert-Signature"
+)
+
+// Sign returns the signature header of a webhook body sent at timestamp
+// (Unix seconds).
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a webhook request's signature, as a receiver would.
+// Receivers should also reject old timestamps, to prevent replays.
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, ts, body)), []byte(signature))
+}
+
+// errShutdown is the error of deliveries cut short by a shutdown.
+var errShutdown = errors.New("not delivered before shutdown")
+
+// DeliveryLog records the outcome of every delivery.
+type DeliveryLog interface {
+	Record(context.Context, models.AlertDelivery) error
+}
+
+// Dispatcher delivers alerts to their webhooks from a queue, retrying
+// with exponential backoff.
+type Dispatcher struct {
+	client      *http.Client
+	deliveries  DeliveryLog
+	maxAttempts int
+	backoff     time.Duration
+	queue       chan Alert
+	now         func() time.Time
+}
+
+// NewDispatcher returns a dispatcher that tries each delivery up to
+// maxAttempts times, waiting backoff, then twice as long, and so on
+// between attempts. Up to queueSize alerts wait for delivery.
+func NewDispatcher(client *http.Client, deliveries DeliveryLog, maxAttempts int, backoff time.Duration, queueSize int) *Dispatcher {
+	return &Dispatcher{
+		client:      client,
+		deliveries:  deliveries,
+		maxAttempts: maxAttempts,
+		backoff:     backoff,
+		queue:       make(chan Alert, queueSize),
+		now:         time.Now,
+	}
+}
+
+// Enqueue queues an alert for delivery. It returns false, dropping the
+// alert, if the queue is full.
+func (d *Dispatcher) Enqueue(alert Alert) bool {
+	select {
+	case d.queue <- alert:
+		return true
+	default:
+		return false
+	}
+}
+
+// Run delivers queued alerts with the given number of workers until ctx
+// is done. It then delivers the alerts still queued for up to drain, and
+// records those left as failed, so that no alert is missing from the
+// delivery log.
+func (d *Dispatcher) Run(ctx context.Context, workers int, drain time.Duration) {
+	// Deliveries go on for up to drain after ctx is done
+	deliverCtx, cancel := context.WithCancelCause(context.WithoutCancel(ctx))
+	defer cancel(nil)
+	go func() {
+		<-ctx.Done()
+		timer := time.NewTimer(drain)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel(errShutdown)
+		case <-deliverCtx.Done():
+		}
+	}()
+
+	done := make(chan struct{})
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for {
+				select {
+				case <-ctx.Done():
+					// Once deliverCtx is done too, Deliver records the
+					// alerts left as failed without trying them
+					for {
+						select {
+						case alert := <-d.queue:
+							d.Deliver(deliverCtx, alert)
+						default:
+							return
+						}
+					}
+				case alert := <-d.queue:
+					d.Deliver(deliverCtx, alert)
+				}
+			}
+		}()
+	}
+	for i := 0; i < workers; i++ {
+		<-done
+	}
+}
+
+// Deliver posts an alert to its webhook, retrying until it is accepted
+// (any 2xx response), it is rejected (any other 4xx than 408 and 429),
+// the attempts run out or ctx is done. The outcome is recorded in the
+// delivery log and returned.
+func (d *Dispatcher) Deliver(ctx context.Context, alert Alert) models.AlertDelivery {
+	body, _ := json.Marshal(alert.Event)
+	id, _ := primitive.ObjectIDFromHex(alert.Event.Id)
+	delivery := models.AlertDelivery{
+		Id:          id,
+		RuleId:      alert.Rule.Id,
+		Symbol:      alert.Event.Symbol,
+		Status:      models.DeliveryFailed,
+		Payload:     string(body),
+		TriggeredAt: alert.Event.TriggeredAt,
+	}
+
+	wait := d.backoff
+	for delivery.Attempts < d.maxAttempts {
+		if delivery.Attempts > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+			wait *= 2
+		}
+		if ctx.Err() != nil {
+			delivery.Error = context.Cause(ctx).Error()
+			break
+		}
+
+		delivery.Attempts++
+		status, err := d.post(ctx, alert, body)
+		delivery.ResponseStatus = status
+		if err == nil {
+			delivery.Status = models.DeliveryDelivered
+			delivery.Error = ""
+			break
+		}
+		delivery.Error = err.Error()
+		if !retryable(status) {
+			break
+		}
+	}
+	delivery.CompletedAt = d.now()
+
+	if delivery.Status == models.DeliveryFailed {
+		log.Printf("Failed to deliver alert %s of rule %s after %d attempt(s): %s",
+			alert.Event.Id, alert.Event.RuleId, delivery.Attempts, delivery.Error)
+	}
+	// Record even if ctx is done, e.g. at shutdown.
+	recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	if err := d.deliveries.Record(recordCtx, delivery); err != nil {
+		log.Printf("Failed to record delivery of alert %s: %v", alert.Event.Id, err)
+	}
+	return delivery
+}
+
+// post sends one attempt. It returns the response status, or zero if
+// there was no response.
+func (d *Dispatcher) post(ctx context.Context, alert Alert, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, alert.Rule.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := d.now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "financial-data-alerts")
+	req.Header.Set(HeaderEventId, alert.Event.Id)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(alert.Rule.Secret, timestamp, body))
+
+	res, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	// Keep a little of the body for the log; drain the rest so that the
+	// connection can be reused.
+	snippet, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg := fmt.Sprintf("webhook responded %s", res.Status)
+		if s := strings.TrimSpace(string(snippet)); s != "" {
+			msg += ": " + s
+		}
+		return res.StatusCode, errors.New(msg)
+	}
+	return res.StatusCode, nil
+}
+
+// retryable reports whether an attempt that got status (zero for no
+// response) may succeed if tried again.
+func retryable(status int) bool {
+	return status == 0 || status == http.StatusRequestTimeout ||
+		status == http.StatusTooManyRequests || status >= 500
+}
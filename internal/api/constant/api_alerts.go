@@ -0,0 +1,6 @@
+package constant
+
+const (
+	DefaultDeliveriesLimit int = 50
+	MaxDeliveriesLimit     int = 500
+)
@@ -0,0 +1,11 @@
+package constant
+
+import "time"
+
+const (
+	// Window used when 'from' is not given, ending at 'to'
+	DefaultGapsWindow time.Duration = 7 * 24 * time.Hour
+	MaxGapsWindow     time.Duration = 366 * 24 * time.Hour
+	DefaultGapsLimit  int           = 100
+	MaxGapsLimit      int           = 1000
+)
@@ -0,0 +1,20 @@
+package constant
+
+import "time"
+
+const (
+	DefaultIndicatorInterval string = "1m"
+	DefaultIndicatorLimit    int    = 100
+	MaxIndicatorLimit        int    = 1000
+)
+
+// Candle intervals indicators can be computed over
+var IndicatorIntervals = map[string]time.Duration{
+	"1m":  time.Minute,
+	"5m":  5 * time.Minute,
+	"15m": 15 * time.Minute,
+	"30m": 30 * time.Minute,
+	"1h":  time.Hour,
+	"4h":  4 * time.Hour,
+	"1d":  24 * time.Hour,
+}
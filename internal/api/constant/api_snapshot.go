@@ -0,0 +1,5 @@
+package constant
+
+const (
+	MaxSnapshotSymbols int = 100
+)
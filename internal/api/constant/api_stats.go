@@ -0,0 +1,9 @@
+package constant
+
+import "time"
+
+const (
+	// Window used when 'from' is not given, ending at 'to'
+	DefaultStatsWindow time.Duration = 24 * time.Hour
+	MaxStatsWindow     time.Duration = 31 * 24 * time.Hour
+)
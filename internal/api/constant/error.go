@@ -1,6 +1,10 @@
 package constant
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
 
 type CustomError struct {
 	StatusCode int
@@ -15,6 +19,11 @@ func (err CustomError) Error() string {
 	return err.Message
 }
 
+// Because adds the reason for an error to its message.
+func (err CustomError) Because(reason error) CustomError {
+	return CustomError{StatusCode: err.StatusCode, Message: err.Message + ": " + reason.Error()}
+}
+
 var (
 	ErrNoSymbol = NewCError(http.StatusBadRequest,
 		"please provide symbol")
@@ -24,4 +33,53 @@ var (
 
 	ErrInvalidCursor = NewCError(http.StatusBadRequest,
 		"invalid 'before' query parameter: must be a non-negative integer (Unix millisecond timestamp)")
+
+	ErrSymbolNotFound = NewCError(http.StatusNotFound,
+		"symbol not found")
+
+	ErrInvalidSort = NewCError(http.StatusBadRequest,
+		"invalid 'sort' query parameter: must be one of symbol, trade_count, first_trade_at, last_trade_at, last_price, optionally prefixed with '-' for descending order")
+
+	ErrNoSymbols = NewCError(http.StatusBadRequest,
+		"please provide symbols, e.g. ?symbols=AAPL,MSFT")
+
+	ErrTooManySymbols = NewCError(http.StatusBadRequest,
+		fmt.Sprintf("too many symbols: at most %d per request", MaxSnapshotSymbols))
+
+	ErrInvalidWindow = NewCError(http.StatusBadRequest,
+		"invalid 'from'/'to' query parameters: must be Unix millisecond timestamps, with 'from' before 'to' and at most 31 days apart")
+
+	ErrInvalidGapsWindow = NewCError(http.StatusBadRequest,
+		fmt.Sprintf("invalid 'from'/'to' query parameters: must be Unix millisecond timestamps, with 'from' before 'to' and at most %d days apart",
+			MaxGapsWindow/(24*time.Hour)))
+
+	ErrInvalidGapsLimit = NewCError(http.StatusBadRequest,
+		fmt.Sprintf("invalid 'limit' query parameter: must be an integer between 1 and %d", MaxGapsLimit))
+
+	ErrInvalidGapsCursor = NewCError(http.StatusBadRequest,
+		"invalid 'after' query parameter: must be the 'next_cursor' of the previous page")
+
+	ErrInvalidIndicator = NewCError(http.StatusBadRequest,
+		"invalid indicator: 'name' must be one of sma, ema, rsi, macd, bollinger, atr; periods ('period', 'fast', 'slow', 'signal') between 1 and 500, with 'fast' below 'slow'; and 'stddev' positive")
+
+	ErrInvalidInterval = NewCError(http.StatusBadRequest,
+		"invalid 'interval' query parameter: must be one of 1m, 5m, 15m, 30m, 1h, 4h, 1d")
+
+	ErrInvalidExcludeFlagged = NewCError(http.StatusBadRequest,
+		"invalid 'exclude_flagged' query parameter: must be true or false")
+
+	ErrInvalidAlertRule = NewCError(http.StatusBadRequest,
+		"invalid alert rule")
+
+	ErrAlertRuleNotFound = NewCError(http.StatusNotFound,
+		"alert rule not found")
+
+	ErrRateLimited = NewCError(http.StatusTooManyRequests,
+		"too many requests, please slow down")
+
+	ErrUnauthorized = NewCError(http.StatusUnauthorized,
+		"missing or invalid admin token: send 'Authorization: Bearer <admin.token>'")
+
+	ErrAdminDisabled = NewCError(http.StatusForbidden,
+		"admin endpoints are disabled: no admin.token is configured")
 )
@@ -1,30 +1,211 @@
 package dto
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // GetSymbols
 
 type GetSymbolsSingle struct {
-	Symbol      string    `json:"symbol"`
-	TradeCount  int64     `json:"trade_count"`
-	LastTradeAt time.Time `json:"last_trade_at"`
+	Symbol       string     `json:"symbol"`
+	Exchange     string     `json:"exchange,omitempty"`
+	AssetClass   string     `json:"asset_class,omitempty"`
+	TradeCount   int64      `json:"trade_count"`
+	FirstTradeAt *time.Time `json:"first_trade_at,omitempty"`
+	LastTradeAt  time.Time  `json:"last_trade_at"`
+	LastPrice    string     `json:"last_price,omitempty"`
 }
 
 type GetSymbolsRes struct {
 	Available []GetSymbolsSingle `json:"available"`
 }
 
+// GetSymbol
+
+type GetSymbolRes struct {
+	GetSymbolsSingle
+	// The latest (UTC) day the symbol traded; null until it has.
+	Day *DaySummaryDTO `json:"day"`
+}
+
+type DaySummaryDTO struct {
+	Date   string `json:"date"`
+	OpenAt string `json:"open_at"`
+	Open   string `json:"open"`
+	High   string `json:"high"`
+	Low    string `json:"low"`
+	Volume string `json:"volume"`
+}
+
+// GetSnapshot
+
+type SnapshotRes struct {
+	Quotes []QuoteDTO `json:"quotes"`
+	// Requested symbols that have no trades
+	Missing []string `json:"missing"`
+}
+
+type QuoteDTO struct {
+	Symbol    string `json:"symbol"`
+	Price     string `json:"price"`
+	Volume    string `json:"volume"`
+	Timestamp string `json:"timestamp"`
+	// Left out until the symbol has traded on two different days
+	PrevClose     string `json:"prev_close,omitempty"`
+	Change        string `json:"change,omitempty"`
+	ChangePercent string `json:"change_percent,omitempty"`
+}
+
 // GetTradesPerSymbol
 
 type PaginatedTradesResponseDTO struct {
 	Data       []TradeResponseDTO `json:"data"`
 	Pagination PaginationDTO      `json:"pagination"`
+	// True once the raw trades have run out but older data is still
+	// available, at reduced resolution, as candles.
+	ReducedResolution bool `json:"reduced_resolution"`
 }
 
 type TradeResponseDTO struct {
-	Timestamp string `json:"timestamp"`
-	Price     string `json:"price"`
-	Volume    string `json:"volume"`
+	Timestamp  string   `json:"timestamp"`
+	Price      string   `json:"price"`
+	Volume     string   `json:"volume"`
+	Conditions []string `json:"conditions,omitempty"`
+	// Why the trade looks like a bad tick, if it does
+	Flags []string `json:"flags,omitempty"`
+}
+
+// GetCandlesPerSymbol
+
+type PaginatedCandlesResponseDTO struct {
+	Data       []CandleResponseDTO `json:"data"`
+	Pagination PaginationDTO       `json:"pagination"`
+}
+
+type CandleResponseDTO struct {
+	Timestamp  string `json:"timestamp"`
+	Open       string `json:"open"`
+	High       string `json:"high"`
+	Low        string `json:"low"`
+	Close      string `json:"close"`
+	Volume     string `json:"volume"`
+	TradeCount int64  `json:"trade_count"`
+}
+
+// GetStatsPerSymbol
+
+type StatsResponseDTO struct {
+	Symbol     string `json:"symbol"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	TradeCount int64  `json:"trade_count"`
+	// The rest is left out if there were no trades
+	FirstTradeAt string `json:"first_trade_at,omitempty"`
+	LastTradeAt  string `json:"last_trade_at,omitempty"`
+	Open         string `json:"open,omitempty"`
+	Close        string `json:"close,omitempty"`
+	Low          string `json:"low,omitempty"`
+	High         string `json:"high,omitempty"`
+	Volume       string `json:"volume,omitempty"`
+	VWAP         string `json:"vwap,omitempty"`
+	TWAP         string `json:"twap,omitempty"`
+	Volatility   string `json:"volatility,omitempty"`
+}
+
+// GetIndicator
+
+type IndicatorResponseDTO struct {
+	Symbol   string              `json:"symbol"`
+	Name     string              `json:"name"`
+	Interval string              `json:"interval"`
+	Params   map[string]float64  `json:"params"`
+	Data     []IndicatorPointDTO `json:"data"`
+}
+
+type IndicatorPointDTO struct {
+	// Start of the candle
+	Timestamp string             `json:"timestamp"`
+	Values    map[string]float64 `json:"values"`
+}
+
+// Alert rules
+
+type AlertRuleReq struct {
+	Symbol    string `json:"symbol"`
+	Type      string `json:"type"`
+	Direction string `json:"direction"`
+	// A decimal string, e.g. "199.95"
+	Price         string  `json:"price"`
+	Percent       float64 `json:"percent"`
+	Multiplier    float64 `json:"multiplier"`
+	WindowMinutes int     `json:"window_minutes"`
+	WebhookURL    string  `json:"webhook_url"`
+	// Generated if left out when creating, kept if left out when updating
+	Secret string `json:"secret"`
+	// Defaults to true
+	Enabled *bool `json:"enabled"`
+}
+
+type AlertRuleDTO struct {
+	Id            string  `json:"id"`
+	Symbol        string  `json:"symbol"`
+	Type          string  `json:"type"`
+	Direction     string  `json:"direction,omitempty"`
+	Price         string  `json:"price,omitempty"`
+	Percent       float64 `json:"percent,omitempty"`
+	Multiplier    float64 `json:"multiplier,omitempty"`
+	WindowMinutes int     `json:"window_minutes,omitempty"`
+	WebhookURL    string  `json:"webhook_url"`
+	// Only returned when the rule is created
+	Secret    string `json:"secret,omitempty"`
+	Enabled   bool   `json:"enabled"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type AlertRulesRes struct {
+	Rules []AlertRuleDTO `json:"rules"`
+}
+
+type AlertDeliveryDTO struct {
+	Id             string `json:"id"`
+	Status         string `json:"status"`
+	Attempts       int    `json:"attempts"`
+	ResponseStatus int    `json:"response_status,omitempty"`
+	Error          string `json:"error,omitempty"`
+	// The webhook's JSON body
+	Payload     json.RawMessage `json:"payload"`
+	TriggeredAt string          `json:"triggered_at"`
+	CompletedAt string          `json:"completed_at"`
+}
+
+type AlertDeliveriesRes struct {
+	Deliveries []AlertDeliveryDTO `json:"deliveries"`
+}
+
+// GetGaps
+
+type DataGapDTO struct {
+	Symbol          string  `json:"symbol"`
+	From            string  `json:"from"`
+	To              string  `json:"to"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	// No trades had arrived when it was last checked
+	Ongoing    bool   `json:"ongoing"`
+	DetectedAt string `json:"detected_at"`
+	UpdatedAt  string `json:"updated_at"`
+}
+
+type DataGapsRes struct {
+	Gaps       []DataGapDTO      `json:"gaps"`
+	Pagination GapsPaginationDTO `json:"pagination"`
+}
+
+type GapsPaginationDTO struct {
+	// The 'after' query parameter of the next page. It will be null if
+	// there are no more pages.
+	NextCursor *string `json:"next_cursor"`
 }
 
 type PaginationDTO struct {
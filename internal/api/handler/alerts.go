@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"encoding/json"
+	"financial-data-backend-2/internal/alerts"
+	"financial-data-backend-2/internal/api/constant"
+	"financial-data-backend-2/internal/api/dto"
+	"financial-data-backend-2/internal/models"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (hd *Handler) GetAlertRules(ctx *gin.Context) {
+	// usecase
+	// e.g. ?symbol=AAPL
+	rules, err := hd.uc.GetAlertRules(ctx.Request.Context(), ctx.Query("symbol"))
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// process response before returning
+	res := dto.AlertRulesRes{Rules: make([]dto.AlertRuleDTO, len(rules))}
+	for i, rule := range rules {
+		res.Rules[i] = alertRuleDTO(rule)
+	}
+
+	// return response
+	ctx.JSON(http.StatusOK,
+		gin.H{
+			"message": nil,
+			"error":   nil,
+			"data":    res,
+		})
+}
+
+func (hd *Handler) GetAlertRule(ctx *gin.Context) {
+	// request validation
+	id, ok := parseRuleId(ctx)
+	if !ok {
+		return
+	}
+
+	// usecase
+	rule, err := hd.uc.GetAlertRule(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	if rule == nil {
+		ctx.Error(constant.ErrAlertRuleNotFound)
+		return
+	}
+
+	// return response
+	ctx.JSON(http.StatusOK,
+		gin.H{
+			"message": nil,
+			"error":   nil,
+			"data":    alertRuleDTO(*rule),
+		})
+}
+
+func (hd *Handler) CreateAlertRule(ctx *gin.Context) {
+	// request validation
+	rule, ok := bindAlertRule(ctx)
+	if !ok {
+		return
+	}
+
+	// usecase
+	created, err := hd.uc.CreateAlertRule(ctx.Request.Context(), rule)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// process response before returning; the secret is only shown now
+	res := alertRuleDTO(*created)
+	res.Secret = created.Secret
+
+	// return response
+	ctx.JSON(http.StatusCreated,
+		gin.H{
+			"message": nil,
+			"error":   nil,
+			"data":    res,
+		})
+}
+
+func (hd *Handler) UpdateAlertRule(ctx *gin.Context) {
+	// request validation
+	id, ok := parseRuleId(ctx)
+	if !ok {
+		return
+	}
+	rule, ok := bindAlertRule(ctx)
+	if !ok {
+		return
+	}
+	rule.Id = id
+
+	// usecase
+	updated, err := hd.uc.UpdateAlertRule(ctx.Request.Context(), rule)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	if updated == nil {
+		ctx.Error(constant.ErrAlertRuleNotFound)
+		return
+	}
+
+	// return response
+	ctx.JSON(http.StatusOK,
+		gin.H{
+			"message": nil,
+			"error":   nil,
+			"data":    alertRuleDTO(*updated),
+		})
+}
+
+func (hd *Handler) DeleteAlertRule(ctx *gin.Context) {
+	// request validation
+	id, ok := parseRuleId(ctx)
+	if !ok {
+		return
+	}
+
+	// usecase
+	deleted, err := hd.uc.DeleteAlertRule(ctx.Request.Context(), id)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	if !deleted {
+		ctx.Error(constant.ErrAlertRuleNotFound)
+		return
+	}
+
+	// return response
+	ctx.JSON(http.StatusOK,
+		gin.H{
+			"message": "alert rule deleted",
+			"error":   nil,
+			"data":    nil,
+		})
+}
+
+func (hd *Handler) GetAlertDeliveries(ctx *gin.Context) {
+	// request validation
+	// e.g. ?limit=20
+	id, ok := parseRuleId(ctx)
+	if !ok {
+		return
+	}
+	limit := constant.DefaultDeliveriesLimit
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit <= 0 {
+			ctx.Error(constant.ErrInvalidLimit)
+			return
+		}
+		limit = min(parsedLimit, constant.MaxDeliveriesLimit)
+	}
+
+	// usecase
+	deliveries, err := hd.uc.GetAlertDeliveries(ctx.Request.Context(), id, limit)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// process response before returning
+	res := dto.AlertDeliveriesRes{Deliveries: make([]dto.AlertDeliveryDTO, len(deliveries))}
+	for i, d := range deliveries {
+		res.Deliveries[i] = dto.AlertDeliveryDTO{
+			Id:             d.Id.Hex(),
+			Status:         d.Status,
+			Attempts:       d.Attempts,
+			ResponseStatus: d.ResponseStatus,
+			Error:          d.Error,
+			TriggeredAt:    d.TriggeredAt.UTC().Format(time.RFC3339Nano),
+			CompletedAt:    d.CompletedAt.UTC().Format(time.RFC3339Nano),
+		}
+		if d.Payload != "" {
+			res.Deliveries[i].Payload = json.RawMessage(d.Payload)
+		}
+	}
+
+	// return response
+	ctx.JSON(http.StatusOK,
+		gin.H{
+			"message": nil,
+			"error":   nil,
+			"data":    res,
+		})
+}
+
+// parseRuleId reads the ':id' path parameter. Ids that are not ObjectIDs
+// cannot exist, so they are reported as not found.
+func parseRuleId(ctx *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.Error(constant.ErrAlertRuleNotFound)
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// bindAlertRule reads and validates an alert rule from the request body.
+// If it is invalid, it records the error on ctx and returns ok == false.
+func bindAlertRule(ctx *gin.Context) (models.AlertRule, bool) {
+	var req dto.AlertRuleReq
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.Error(constant.ErrInvalidAlertRule.Because(err))
+		return models.AlertRule{}, false
+	}
+
+	rule := models.AlertRule{
+		Symbol:        req.Symbol,
+		Type:          req.Type,
+		Direction:     req.Direction,
+		Percent:       req.Percent,
+		Multiplier:    req.Multiplier,
+		WindowMinutes: req.WindowMinutes,
+		WebhookURL:    req.WebhookURL,
+		Secret:        req.Secret,
+		Enabled:       req.Enabled == nil || *req.Enabled,
+	}
+	if req.Price != "" {
+		price, err := primitive.ParseDecimal128(req.Price)
+		if err != nil {
+			ctx.Error(constant.ErrInvalidAlertRule.Because(err))
+			return models.AlertRule{}, false
+		}
+		rule.Price = price
+	}
+	if err := alerts.Normalize(&rule); err != nil {
+		ctx.Error(constant.ErrInvalidAlertRule.Because(err))
+		return models.AlertRule{}, false
+	}
+	return rule, true
+}
+
+// alertRuleDTO leaves out the rule's secret.
+func alertRuleDTO(rule models.AlertRule) dto.AlertRuleDTO {
+	res := dto.AlertRuleDTO{
+		Id:            rule.Id.Hex(),
+		Symbol:        rule.Symbol,
+		Type:          rule.Type,
+		Direction:     rule.Direction,
+		Percent:       rule.Percent,
+		Multiplier:    rule.Multiplier,
+		WindowMinutes: rule.WindowMinutes,
+		WebhookURL:    rule.WebhookURL,
+		Enabled:       rule.Enabled,
+		CreatedAt:     rule.CreatedAt.UTC().Format(time.RFC3339Nano),
+		UpdatedAt:     rule.UpdatedAt.UTC().Format(time.RFC3339Nano),
+	}
+	if rule.Type == models.AlertPriceCross {
+		res.Price = rule.Price.String()
+	}
+	return res
+}
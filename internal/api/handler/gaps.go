@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"financial-data-backend-2/internal/api/constant"
+	"financial-data-backend-2/internal/api/dto"
+	"financial-data-backend-2/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (hd *Handler) GetGaps(ctx *gin.Context) {
+	// request validation
+	// e.g. ?symbol=AAPL&from=1700000000000&to=1700086400000&limit=100
+	from, to, ok := parseWindowOf(ctx, constant.DefaultGapsWindow, constant.MaxGapsWindow,
+		constant.ErrInvalidGapsWindow)
+	if !ok {
+		return
+	}
+
+	limit := constant.DefaultGapsLimit
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 || parsed > constant.MaxGapsLimit {
+			ctx.Error(constant.ErrInvalidGapsLimit)
+			return
+		}
+		limit = parsed
+	}
+
+	// The next page starts after the last gap of the previous one
+	var after *models.GapCursor
+	if afterStr := ctx.Query("after"); afterStr != "" {
+		cursor, ok := parseGapCursor(afterStr)
+		if !ok {
+			ctx.Error(constant.ErrInvalidGapsCursor)
+			return
+		}
+		after = &cursor
+	}
+
+	// usecase
+	gaps, err := hd.uc.GetGaps(ctx.Request.Context(), ctx.Query("symbol"), from, to, limit, after)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// process response before returning
+	res := dto.DataGapsRes{Gaps: make([]dto.DataGapDTO, len(gaps))}
+	for i, g := range gaps {
+		res.Gaps[i] = dto.DataGapDTO{
+			Symbol:          g.Symbol,
+			From:            g.From.UTC().Format(time.RFC3339Nano),
+			To:              g.To.UTC().Format(time.RFC3339Nano),
+			DurationSeconds: g.To.Sub(g.From).Seconds(),
+			Ongoing:         g.Ongoing,
+			DetectedAt:      g.DetectedAt.UTC().Format(time.RFC3339Nano),
+			UpdatedAt:       g.UpdatedAt.UTC().Format(time.RFC3339Nano),
+		}
+	}
+	if len(gaps) == limit {
+		last := gaps[len(gaps)-1]
+		next := formatGapCursor(models.GapCursor{From: last.From, Symbol: last.Symbol})
+		res.Pagination.NextCursor = &next
+	}
+
+	// return response
+	ctx.JSON(http.StatusOK,
+		gin.H{
+			"message": nil,
+			"error":   nil,
+			"data":    res,
+		})
+}
+
+// formatGapCursor writes a cursor as "<from, Unix ms>,<symbol>", e.g.
+// "1700000000000,AAPL". Gaps are stored with millisecond precision.
+func formatGapCursor(c models.GapCursor) string {
+	return strconv.FormatInt(c.From.UnixMilli(), 10) + "," + c.Symbol
+}
+
+func parseGapCursor(s string) (models.GapCursor, bool) {
+	fromStr, symbol, found := strings.Cut(s, ",")
+	if !found || symbol == "" {
+		return models.GapCursor{}, false
+	}
+	from, err := strconv.ParseInt(fromStr, 10, 64)
+	if err != nil || from < 0 {
+		return models.GapCursor{}, false
+	}
+	return models.GapCursor{From: time.UnixMilli(from), Symbol: symbol}, true
+}
@@ -4,17 +4,33 @@ import (
 	"financial-data-backend-2/internal/api/constant"
 	"financial-data-backend-2/internal/api/dto"
 	"financial-data-backend-2/internal/api/usecase"
-	"log"
+	"financial-data-backend-2/internal/indicators"
+	"financial-data-backend-2/internal/models"
+	"math/big"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type HandlerItf interface {
 	GetSymbols(*gin.Context)
+	GetSymbol(*gin.Context)
+	GetSnapshot(*gin.Context)
 	GetTradesPerSymbol(*gin.Context)
+	GetCandlesPerSymbol(*gin.Context)
+	GetStatsPerSymbol(*gin.Context)
+	GetIndicator(*gin.Context)
+	GetAlertRules(*gin.Context)
+	GetAlertRule(*gin.Context)
+	CreateAlertRule(*gin.Context)
+	UpdateAlertRule(*gin.Context)
+	DeleteAlertRule(*gin.Context)
+	GetAlertDeliveries(*gin.Context)
+	GetGaps(*gin.Context)
 }
 
 type Handler struct {
@@ -25,9 +41,34 @@ func NewHandler(uc usecase.UsecaseItf) *Handler {
 	return &Handler{uc: uc}
 }
 
+// Fields symbols can be sorted by, and the document fields they sort on
+var symbolSortFields = map[string]string{
+	"symbol":         "symbol",
+	"trade_count":    "tradeCount",
+	"first_trade_at": "firstTradeAt",
+	"last_trade_at":  "lastTradeAt",
+	"last_price":     "lastPrice",
+}
+
 func (hd *Handler) GetSymbols(ctx *gin.Context) {
+	// request validation
+	// e.g. ?asset_class=crypto&exchange=BINANCE&sort=-trade_count
+	query := models.SymbolQuery{
+		AssetClass: strings.ToLower(ctx.Query("asset_class")),
+		Exchange:   strings.ToUpper(ctx.Query("exchange")),
+	}
+	if sortStr := ctx.Query("sort"); sortStr != "" {
+		query.Descending = strings.HasPrefix(sortStr, "-")
+		field, ok := symbolSortFields[strings.TrimPrefix(sortStr, "-")]
+		if !ok {
+			ctx.Error(constant.ErrInvalidSort)
+			return
+		}
+		query.SortBy = field
+	}
+
 	// usecase
-	symbols, err := hd.uc.GetSymbols(ctx.Request.Context())
+	symbols, err := hd.uc.GetSymbols(ctx.Request.Context(), query)
 	if err != nil {
 		ctx.Error(err)
 		return
@@ -38,12 +79,7 @@ func (hd *Handler) GetSymbols(ctx *gin.Context) {
 	GetSymbolsRes.Available = make([]dto.GetSymbolsSingle,
 		len(symbols))
 	for i, symbol := range symbols {
-		GetSymbolsRes.Available[i] =
-			dto.GetSymbolsSingle{
-				Symbol:      symbol.Symbol,
-				TradeCount:  symbol.TradeCount,
-				LastTradeAt: symbol.LastTradeAt,
-			}
+		GetSymbolsRes.Available[i] = symbolDTO(symbol)
 	}
 
 	// return response
@@ -55,7 +91,7 @@ func (hd *Handler) GetSymbols(ctx *gin.Context) {
 		})
 }
 
-func (hd *Handler) GetTradesPerSymbol(ctx *gin.Context) {
+func (hd *Handler) GetSymbol(ctx *gin.Context) {
 	// request validation
 	symbol := ctx.Param("symbol")
 	if symbol == "" {
@@ -63,40 +99,177 @@ func (hd *Handler) GetTradesPerSymbol(ctx *gin.Context) {
 		return
 	}
 
-	// Get limit and offset
-	var limit int
-	limitStr := ctx.Query("limit")
-	log.Print(limitStr)
-	if limitStr == "" {
-		limit = constant.DefaultLimit
-	} else {
-		parsedLimit, err := strconv.Atoi(limitStr)
-		if err != nil || parsedLimit <= 0 {
-			ctx.Error(constant.ErrInvalidLimit)
-			return
+	// usecase
+	doc, err := hd.uc.GetSymbol(ctx.Request.Context(), symbol)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+	if doc == nil {
+		ctx.Error(constant.ErrSymbolNotFound)
+		return
+	}
+
+	// process response before returning
+	res := dto.GetSymbolRes{GetSymbolsSingle: symbolDTO(*doc)}
+	if day := doc.Day; day != nil {
+		res.Day = &dto.DaySummaryDTO{
+			Date:   day.Date,
+			OpenAt: day.OpenAt.Format(time.RFC3339Nano),
+			Open:   day.Open.String(),
+			High:   day.High.String(),
+			Low:    day.Low.String(),
+			Volume: day.Volume.String(),
 		}
-		limit = parsedLimit
 	}
 
-	// Parse the 'before' cursor. It's a Unix millisecond timestamp.
-	// If it's not provided, it defaults to 0, which our service
-	// will treat as "get the latest".
-	var before int64
-	beforeStr := ctx.DefaultQuery("before", constant.DefaultCursorStr)
-	if beforeStr == "" {
-		before = constant.DefaultCursor
-	} else {
-		parsedBefore, err := strconv.ParseInt(beforeStr, 10, 64)
-		if err != nil || parsedBefore < 0 {
-			ctx.Error(constant.ErrInvalidCursor)
+	// return response
+	ctx.JSON(http.StatusOK,
+		gin.H{
+			"message": nil,
+			"error":   nil,
+			"data":    res,
+		})
+}
+
+// symbolDTO leaves out the fields a symbol does not have yet, e.g. when it
+// was last updated before they were maintained.
+func symbolDTO(symbol models.SymbolDocument) dto.GetSymbolsSingle {
+	res := dto.GetSymbolsSingle{
+		Symbol:      symbol.Symbol,
+		Exchange:    symbol.Exchange,
+		AssetClass:  symbol.AssetClass,
+		TradeCount:  symbol.TradeCount,
+		LastTradeAt: symbol.LastTradeAt,
+	}
+	if !symbol.FirstTradeAt.IsZero() {
+		firstTradeAt := symbol.FirstTradeAt
+		res.FirstTradeAt = &firstTradeAt
+	}
+	if symbol.LastPrice != (primitive.Decimal128{}) {
+		res.LastPrice = symbol.LastPrice.String()
+	}
+	return res
+}
+
+func (hd *Handler) GetSnapshot(ctx *gin.Context) {
+	// request validation
+	// e.g. ?symbols=AAPL,MSFT,BINANCE:BTCUSDT
+	var symbols []string
+	seen := make(map[string]bool)
+	for _, s := range strings.Split(ctx.Query("symbols"), ",") {
+		if s = strings.TrimSpace(s); s != "" && !seen[s] {
+			seen[s] = true
+			symbols = append(symbols, s)
+		}
+	}
+	if len(symbols) == 0 {
+		ctx.Error(constant.ErrNoSymbols)
+		return
+	}
+	if len(symbols) > constant.MaxSnapshotSymbols {
+		ctx.Error(constant.ErrTooManySymbols)
+		return
+	}
+
+	// usecase
+	docs, err := hd.uc.GetSnapshot(ctx.Request.Context(), symbols)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// process response before returning
+	res := dto.SnapshotRes{Quotes: make([]dto.QuoteDTO, 0, len(docs)), Missing: []string{}}
+	found := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		if doc.LastPrice == (primitive.Decimal128{}) {
+			continue // Last updated before prices were kept
+		}
+		found[doc.Symbol] = true
+		quote := dto.QuoteDTO{
+			Symbol:    doc.Symbol,
+			Price:     doc.LastPrice.String(),
+			Volume:    doc.LastVolume.String(),
+			Timestamp: doc.LastTradeAt.Format(time.RFC3339Nano),
+		}
+		if doc.Day != nil && doc.Day.PrevClose != (primitive.Decimal128{}) {
+			quote.PrevClose = doc.Day.PrevClose.String()
+			quote.Change, quote.ChangePercent = priceChange(doc.LastPrice, doc.Day.PrevClose)
+		}
+		res.Quotes = append(res.Quotes, quote)
+	}
+	for _, symbol := range symbols {
+		if !found[symbol] {
+			res.Missing = append(res.Missing, symbol)
+		}
+	}
+
+	// return response
+	ctx.JSON(http.StatusOK,
+		gin.H{
+			"message": nil,
+			"error":   nil,
+			"data":    res,
+		})
+}
+
+// priceChange returns price - prevClose, exactly, and the change in
+// percent, rounded to two decimals. Both are empty if either is not a
+// finite number or prevClose is zero.
+func priceChange(price, prevClose primitive.Decimal128) (change, percent string) {
+	p, pExp, err := price.BigInt()
+	if err != nil {
+		return "", ""
+	}
+	c, cExp, err := prevClose.BigInt()
+	if err != nil || c.Sign() == 0 {
+		return "", ""
+	}
+	// Scale both to the smaller exponent
+	exp := min(pExp, cExp)
+	p.Mul(p, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(pExp-exp)), nil))
+	c.Mul(c, new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(cExp-exp)), nil))
+
+	diff := new(big.Int).Sub(p, c)
+	d, ok := primitive.ParseDecimal128FromBigInt(diff, exp)
+	if !ok {
+		return "", ""
+	}
+	ratio := new(big.Rat).SetFrac(new(big.Int).Mul(diff, big.NewInt(100)), c)
+	return d.String(), ratio.FloatString(2)
+}
+
+func (hd *Handler) GetTradesPerSymbol(ctx *gin.Context) {
+	// request validation
+	symbol := ctx.Param("symbol")
+	if symbol == "" {
+		ctx.Error(constant.ErrNoSymbol)
+		return
+	}
+
+	limit, before, ok := parsePage(ctx)
+	if !ok {
+		return
+	}
+
+	// Parse the conditions to leave out, e.g. "I,Z"
+	excludeConditions := parseConditions(ctx.Query("exclude_conditions"))
+
+	// Bad ticks are returned, with their flags, unless asked otherwise
+	excludeFlagged := false
+	if str := ctx.Query("exclude_flagged"); str != "" {
+		parsed, err := strconv.ParseBool(str)
+		if err != nil {
+			ctx.Error(constant.ErrInvalidExcludeFlagged)
 			return
 		}
-		before = parsedBefore
+		excludeFlagged = parsed
 	}
 
 	// usecase
 	trades, err := hd.uc.GetTradesPerSymbol(ctx.Request.Context(),
-		symbol, limit, before)
+		symbol, limit, before, excludeConditions, excludeFlagged)
 	if err != nil {
 		ctx.Error(err)
 		return
@@ -108,9 +281,11 @@ func (hd *Handler) GetTradesPerSymbol(ctx *gin.Context) {
 	for _, trade := range trades {
 		res.Data = append(res.Data,
 			dto.TradeResponseDTO{
-				Timestamp: trade.Time.Format(time.RFC3339Nano),
-				Price:     trade.Price.String(),
-				Volume:    trade.Volume.String(),
+				Timestamp:  trade.Time.Format(time.RFC3339Nano),
+				Price:      trade.Price.String(),
+				Volume:     trade.Volume.String(),
+				Conditions: trade.Conditions,
+				Flags:      trade.Flags,
 			})
 	}
 
@@ -119,6 +294,24 @@ func (hd *Handler) GetTradesPerSymbol(ctx *gin.Context) {
 	if len(trades) == limit {
 		next := trades[len(trades)-1].Time.UnixMilli()
 		res.Pagination.NextCursor = &next
+	} else {
+		// The raw trades have run out. Older ones may have expired after
+		// being downsampled into candles. Candles are stamped with the
+		// start of their minute, so the candle of the oldest trade's own
+		// minute is not older data.
+		oldest := before
+		if len(trades) > 0 {
+			oldest = trades[len(trades)-1].Time.UnixMilli()
+		}
+		if oldest > 0 {
+			oldest = time.UnixMilli(oldest).Truncate(time.Minute).UnixMilli()
+		}
+		reduced, err := hd.uc.HasCandlesBefore(ctx.Request.Context(), symbol, oldest)
+		if err != nil {
+			ctx.Error(err)
+			return
+		}
+		res.ReducedResolution = reduced
 	}
 
 	// return response
@@ -129,3 +322,282 @@ func (hd *Handler) GetTradesPerSymbol(ctx *gin.Context) {
 			"data":    res,
 		})
 }
+
+func (hd *Handler) GetCandlesPerSymbol(ctx *gin.Context) {
+	// request validation
+	symbol := ctx.Param("symbol")
+	if symbol == "" {
+		ctx.Error(constant.ErrNoSymbol)
+		return
+	}
+	limit, before, ok := parsePage(ctx)
+	if !ok {
+		return
+	}
+
+	// usecase
+	candles, err := hd.uc.GetCandlesPerSymbol(ctx.Request.Context(),
+		symbol, limit, before)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// Figure out response DTO
+	// - candles
+	var res dto.PaginatedCandlesResponseDTO
+	for _, candle := range candles {
+		res.Data = append(res.Data,
+			dto.CandleResponseDTO{
+				Timestamp:  candle.Time.Format(time.RFC3339Nano),
+				Open:       candle.Open.String(),
+				High:       candle.High.String(),
+				Low:        candle.Low.String(),
+				Close:      candle.Close.String(),
+				Volume:     candle.Volume.String(),
+				TradeCount: candle.TradeCount,
+			})
+	}
+
+	// - next cursor
+	res.Pagination = dto.PaginationDTO{}
+	if len(candles) == limit {
+		next := candles[len(candles)-1].Time.UnixMilli()
+		res.Pagination.NextCursor = &next
+	}
+
+	// return response
+	ctx.JSON(http.StatusOK,
+		gin.H{
+			"message": nil,
+			"error":   nil,
+			"data":    res,
+		})
+}
+
+func (hd *Handler) GetStatsPerSymbol(ctx *gin.Context) {
+	// request validation
+	symbol := ctx.Param("symbol")
+	if symbol == "" {
+		ctx.Error(constant.ErrNoSymbol)
+		return
+	}
+	from, to, ok := parseWindow(ctx)
+	if !ok {
+		return
+	}
+	excludeConditions := parseConditions(ctx.Query("exclude_conditions"))
+
+	// usecase
+	stats, err := hd.uc.GetStatsPerSymbol(ctx.Request.Context(),
+		symbol, from, to, excludeConditions)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// Figure out response DTO
+	res := dto.StatsResponseDTO{
+		Symbol: symbol,
+		From:   from.UTC().Format(time.RFC3339Nano),
+		To:     to.UTC().Format(time.RFC3339Nano),
+	}
+	if stats != nil {
+		res.TradeCount = stats.TradeCount
+		res.FirstTradeAt = stats.FirstTradeAt.Format(time.RFC3339Nano)
+		res.LastTradeAt = stats.LastTradeAt.Format(time.RFC3339Nano)
+		res.Open = stats.Open.String()
+		res.Close = stats.Close.String()
+		res.Low = stats.Low.String()
+		res.High = stats.High.String()
+		res.Volume = stats.Volume.String()
+		res.VWAP = stats.VWAP.String()
+		res.TWAP = stats.TWAP.String()
+		res.Volatility = stats.Volatility.String()
+	}
+
+	// return response
+	ctx.JSON(http.StatusOK,
+		gin.H{
+			"message": nil,
+			"error":   nil,
+			"data":    res,
+		})
+}
+
+func (hd *Handler) GetIndicator(ctx *gin.Context) {
+	// request validation
+	// e.g. ?name=rsi&period=14&interval=1m
+	symbol := ctx.Param("symbol")
+	if symbol == "" {
+		ctx.Error(constant.ErrNoSymbol)
+		return
+	}
+
+	intervalStr := ctx.DefaultQuery("interval", constant.DefaultIndicatorInterval)
+	interval, ok := constant.IndicatorIntervals[intervalStr]
+	if !ok {
+		ctx.Error(constant.ErrInvalidInterval)
+		return
+	}
+
+	limit := constant.DefaultIndicatorLimit
+	if limitStr := ctx.Query("limit"); limitStr != "" {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit <= 0 {
+			ctx.Error(constant.ErrInvalidLimit)
+			return
+		}
+		limit = min(parsedLimit, constant.MaxIndicatorLimit)
+	}
+
+	spec := indicators.Spec{Name: strings.ToLower(ctx.Query("name"))}
+	for param, field := range map[string]*int{
+		"period": &spec.Period, "fast": &spec.Fast, "slow": &spec.Slow, "signal": &spec.Signal,
+	} {
+		if str := ctx.Query(param); str != "" {
+			parsed, err := strconv.Atoi(str)
+			if err != nil || parsed <= 0 {
+				ctx.Error(constant.ErrInvalidIndicator)
+				return
+			}
+			*field = parsed
+		}
+	}
+	if str := ctx.Query("stddev"); str != "" {
+		parsed, err := strconv.ParseFloat(str, 64)
+		if err != nil || parsed <= 0 {
+			ctx.Error(constant.ErrInvalidIndicator)
+			return
+		}
+		spec.StdDev = parsed
+	}
+	spec = spec.WithDefaults()
+	if err := spec.Validate(); err != nil {
+		ctx.Error(constant.ErrInvalidIndicator)
+		return
+	}
+	excludeConditions := parseConditions(ctx.Query("exclude_conditions"))
+
+	// usecase
+	points, err := hd.uc.GetIndicator(ctx.Request.Context(),
+		symbol, spec, interval, limit, excludeConditions)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	// Figure out response DTO
+	res := dto.IndicatorResponseDTO{
+		Symbol:   symbol,
+		Name:     spec.Name,
+		Interval: intervalStr,
+		Data:     make([]dto.IndicatorPointDTO, len(points)),
+	}
+	switch spec.Name {
+	case indicators.NameMACD:
+		res.Params = map[string]float64{
+			"fast": float64(spec.Fast), "slow": float64(spec.Slow), "signal": float64(spec.Signal),
+		}
+	case indicators.NameBollinger:
+		res.Params = map[string]float64{"period": float64(spec.Period), "stddev": spec.StdDev}
+	default:
+		res.Params = map[string]float64{"period": float64(spec.Period)}
+	}
+	for i, p := range points {
+		res.Data[i] = dto.IndicatorPointDTO{
+			Timestamp: p.Time.UTC().Format(time.RFC3339Nano),
+			Values:    p.Values,
+		}
+	}
+
+	// return response
+	ctx.JSON(http.StatusOK,
+		gin.H{
+			"message": nil,
+			"error":   nil,
+			"data":    res,
+		})
+}
+
+// parseWindow reads the 'from' and 'to' query parameters, Unix millisecond
+// timestamps. 'to' defaults to now and 'from' to a day before 'to'. If they
+// are invalid, it records the error on ctx and returns ok == false.
+func parseWindow(ctx *gin.Context) (from, to time.Time, ok bool) {
+	return parseWindowOf(ctx, constant.DefaultStatsWindow, constant.MaxStatsWindow, constant.ErrInvalidWindow)
+}
+
+// parseWindowOf is parseWindow with 'from' defaulting to window before
+// 'to', at most maxWindow apart, recording invalid on ctx otherwise.
+func parseWindowOf(ctx *gin.Context, window, maxWindow time.Duration, invalid error) (from, to time.Time, ok bool) {
+	to = time.Now()
+	if toStr := ctx.Query("to"); toStr != "" {
+		parsed, err := strconv.ParseInt(toStr, 10, 64)
+		if err != nil || parsed <= 0 {
+			ctx.Error(invalid)
+			return time.Time{}, time.Time{}, false
+		}
+		to = time.UnixMilli(parsed)
+	}
+
+	from = to.Add(-window)
+	if fromStr := ctx.Query("from"); fromStr != "" {
+		parsed, err := strconv.ParseInt(fromStr, 10, 64)
+		if err != nil || parsed < 0 {
+			ctx.Error(invalid)
+			return time.Time{}, time.Time{}, false
+		}
+		from = time.UnixMilli(parsed)
+	}
+
+	if !from.Before(to) || to.Sub(from) > maxWindow {
+		ctx.Error(invalid)
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// parseConditions splits a comma-separated list of trade conditions, e.g.
+// "I,Z".
+func parseConditions(list string) []string {
+	var conditions []string
+	for _, c := range strings.Split(list, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			conditions = append(conditions, c)
+		}
+	}
+	return conditions
+}
+
+// parsePage reads the 'limit' and 'before' query parameters. If they are
+// invalid, it records the error on ctx and returns ok == false.
+func parsePage(ctx *gin.Context) (limit int, before int64, ok bool) {
+	// Get limit
+	limitStr := ctx.Query("limit")
+	if limitStr == "" {
+		limit = constant.DefaultLimit
+	} else {
+		parsedLimit, err := strconv.Atoi(limitStr)
+		if err != nil || parsedLimit <= 0 {
+			ctx.Error(constant.ErrInvalidLimit)
+			return 0, 0, false
+		}
+		limit = parsedLimit
+	}
+
+	// Parse the 'before' cursor. It's a Unix millisecond timestamp.
+	// If it's not provided, it defaults to 0, which our service
+	// will treat as "get the latest".
+	beforeStr := ctx.DefaultQuery("before", constant.DefaultCursorStr)
+	if beforeStr == "" {
+		before = constant.DefaultCursor
+	} else {
+		parsedBefore, err := strconv.ParseInt(beforeStr, 10, 64)
+		if err != nil || parsedBefore < 0 {
+			ctx.Error(constant.ErrInvalidCursor)
+			return 0, 0, false
+		}
+		before = parsedBefore
+	}
+	return limit, before, true
+}
@@ -6,16 +6,19 @@ import (
 	"financial-data-backend-2/internal/api/middleware"
 	"financial-data-backend-2/internal/api/usecase"
 	"financial-data-backend-2/internal/api/usecase/mocks"
+	"financial-data-backend-2/internal/indicators"
 	"financial-data-backend-2/internal/models"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func setupRouter(uc usecase.UsecaseItf) *gin.Engine {
@@ -32,10 +35,23 @@ func setupRouter(uc usecase.UsecaseItf) *gin.Engine {
 	v1 := r.Group("/api/v1")
 	{
 		v1.GET("/symbols", handler.GetSymbols)
+		v1.GET("/symbols/:symbol", handler.GetSymbol)
+		v1.GET("/snapshot", handler.GetSnapshot)
 		v1.GET("/trades/:symbol", handler.GetTradesPerSymbol)
+		v1.GET("/candles/:symbol", handler.GetCandlesPerSymbol)
+		v1.GET("/stats/:symbol", handler.GetStatsPerSymbol)
+		v1.GET("/indicators/:symbol", handler.GetIndicator)
+		v1.GET("/alerts", handler.GetAlertRules)
+		v1.POST("/alerts", handler.CreateAlertRule)
+		v1.GET("/alerts/:id", handler.GetAlertRule)
+		v1.PUT("/alerts/:id", handler.UpdateAlertRule)
+		v1.DELETE("/alerts/:id", handler.DeleteAlertRule)
+		v1.GET("/alerts/:id/deliveries", handler.GetAlertDeliveries)
+		v1.GET("/admin/gaps", handler.GetGaps)
 	}
 	return r
 }
+
 func TestIntegratedGetTradesPerSymbolHandler(t *testing.T) {
 	/**
 	Instead of testing the handler logic thoroughly, these are
@@ -62,11 +78,63 @@ func TestIntegratedGetTradesPerSymbolHandler(t *testing.T) {
 			url:  "/api/v1/trades/AAPL?limit=1",
 			setupMock: func(mockUC *mocks.UsecaseItf) {
 				// We expect the handler to parse "AAPL", 1, and 0 and pass them here.
-				mockUC.On("GetTradesPerSymbol", mock.Anything, "AAPL", 1, int64(0)).Return(mockTrades, nil)
+				mockUC.On("GetTradesPerSymbol", mock.Anything, "AAPL", 1, int64(0), []string(nil), false).Return(mockTrades, nil)
 			},
 			expectedStatusCode:   http.StatusOK,
 			expectedBodyContains: `"next_cursor":` + fmt.Sprintf("%d", mockTradeTime.UnixMilli()),
 		},
+		{
+			name: "Success - should pass excluded conditions and return trade conditions",
+			url:  "/api/v1/trades/AAPL?exclude_conditions=I,%20Z,",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetTradesPerSymbol", mock.Anything, "AAPL", constant.DefaultLimit, int64(0), []string{"I", "Z"}, false).
+					Return([]models.TradeRecord{{Time: mockTradeTime, Conditions: []string{"12"}}}, nil)
+				mockUC.On("HasCandlesBefore", mock.Anything, "AAPL", mockTradeTime.Truncate(time.Minute).UnixMilli()).Return(false, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `"conditions":["12"]`,
+		},
+		{
+			name: "Success - should pass exclude_flagged and return trade flags",
+			url:  "/api/v1/trades/AAPL?exclude_flagged=false",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetTradesPerSymbol", mock.Anything, "AAPL", constant.DefaultLimit, int64(0), []string(nil), false).
+					Return([]models.TradeRecord{{Time: mockTradeTime, Flags: []string{models.FlagPriceOutlier}}}, nil)
+				mockUC.On("HasCandlesBefore", mock.Anything, "AAPL", mockTradeTime.Truncate(time.Minute).UnixMilli()).Return(false, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `"flags":["price_outlier"]`,
+		},
+		{
+			name:                 "Failure - invalid exclude_flagged parameter",
+			url:                  "/api/v1/trades/AAPL?exclude_flagged=maybe",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: "exclude_flagged",
+		},
+		{
+			name: "Success - should flag older data that only exists as candles",
+			url:  "/api/v1/trades/AAPL?limit=5&before=1700000040000",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetTradesPerSymbol", mock.Anything, "AAPL", 5, int64(1700000040000), []string(nil), false).Return(nil, nil)
+				mockUC.On("HasCandlesBefore", mock.Anything, "AAPL", int64(1700000040000)).Return(true, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `"reduced_resolution":true`,
+		},
+		{
+			// The candle of the first trade's minute starts before the
+			// trade, but holds no older data.
+			name: "Success - a first trade in the middle of a minute is not reduced resolution",
+			url:  "/api/v1/trades/AAPL?limit=5",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetTradesPerSymbol", mock.Anything, "AAPL", 5, int64(0), []string(nil), false).
+					Return([]models.TradeRecord{{Time: time.UnixMilli(1700000070500)}}, nil)
+				mockUC.On("HasCandlesBefore", mock.Anything, "AAPL", int64(1700000040000)).Return(false, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `"reduced_resolution":false`,
+		},
 		{
 			name: "Failure - invalid limit parameter (returns custom error)",
 			url:  "/api/v1/trades/AAPL?limit=abc",
@@ -80,7 +148,7 @@ func TestIntegratedGetTradesPerSymbolHandler(t *testing.T) {
 			name: "Failure - usecase returns a custom error",
 			url:  "/api/v1/trades/TSLA",
 			setupMock: func(mockUC *mocks.UsecaseItf) {
-				mockUC.On("GetTradesPerSymbol", mock.Anything, "TSLA", constant.DefaultLimit, int64(0)).Return(nil, constant.ErrNoSymbol)
+				mockUC.On("GetTradesPerSymbol", mock.Anything, "TSLA", constant.DefaultLimit, int64(0), []string(nil), false).Return(nil, constant.ErrNoSymbol)
 			},
 			expectedStatusCode:   http.StatusBadRequest,
 			expectedBodyContains: constant.ErrNoSymbol.Error(),
@@ -89,7 +157,7 @@ func TestIntegratedGetTradesPerSymbolHandler(t *testing.T) {
 			name: "Failure - usecase returns a generic error",
 			url:  "/api/v1/trades/NVDA",
 			setupMock: func(mockUC *mocks.UsecaseItf) {
-				mockUC.On("GetTradesPerSymbol", mock.Anything, "NVDA", constant.DefaultLimit, int64(0)).Return(nil, usecaseError)
+				mockUC.On("GetTradesPerSymbol", mock.Anything, "NVDA", constant.DefaultLimit, int64(0), []string(nil), false).Return(nil, usecaseError)
 			},
 			expectedStatusCode:   http.StatusInternalServerError,
 			expectedBodyContains: usecaseError.Error(),
@@ -98,10 +166,11 @@ func TestIntegratedGetTradesPerSymbolHandler(t *testing.T) {
 			name: "Failure - usecase is too slow and times out",
 			url:  "/api/v1/trades/GOOGL",
 			setupMock: func(mockUC *mocks.UsecaseItf) {
-				mockUC.On("GetTradesPerSymbol", mock.Anything, "GOOGL", constant.DefaultLimit, int64(0)).
+				mockUC.On("GetTradesPerSymbol", mock.Anything, "GOOGL", constant.DefaultLimit, int64(0), []string(nil), false).
 					// This mock will sleep for longer than the middleware timeout.
 					After(200*time.Millisecond).
 					Return(nil, nil)
+				mockUC.On("HasCandlesBefore", mock.Anything, "GOOGL", int64(0)).Return(false, nil).Maybe()
 			},
 			expectedStatusCode:   http.StatusGatewayTimeout,
 			expectedBodyContains: "request timed out",
@@ -130,3 +199,732 @@ func TestIntegratedGetTradesPerSymbolHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestIntegratedGetCandlesPerSymbolHandler(t *testing.T) {
+	mockCandleTime := time.UnixMilli(1700000040000).UTC()
+	price, _ := primitive.ParseDecimal128("196.38")
+	volume, _ := primitive.ParseDecimal128("265")
+	mockCandles := []models.Candle{{
+		Symbol: "AAPL", Time: mockCandleTime,
+		Open: price, High: price, Low: price, Close: price, Volume: volume, TradeCount: 3,
+	}}
+
+	testCases := []struct {
+		name                 string
+		url                  string
+		setupMock            func(mockUC *mocks.UsecaseItf)
+		expectedStatusCode   int
+		expectedBodyContains string
+	}{
+		{
+			name: "Success - should return candles with correct DTO format",
+			url:  "/api/v1/candles/AAPL?limit=1",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetCandlesPerSymbol", mock.Anything, "AAPL", 1, int64(0)).Return(mockCandles, nil)
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedBodyContains: `{"timestamp":"2023-11-14T22:14:00Z","open":"196.38","high":"196.38","low":"196.38",` +
+				`"close":"196.38","volume":"265","trade_count":3}],"pagination":{"next_cursor":1700000040000}`,
+		},
+		{
+			name:                 "Failure - invalid cursor",
+			url:                  "/api/v1/candles/AAPL?before=-1",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrInvalidCursor.Error(),
+		},
+		{
+			name: "Failure - usecase returns a generic error",
+			url:  "/api/v1/candles/NVDA",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetCandlesPerSymbol", mock.Anything, "NVDA", constant.DefaultLimit, int64(0)).
+					Return(nil, errors.New("a simulated usecase error"))
+			},
+			expectedStatusCode:   http.StatusInternalServerError,
+			expectedBodyContains: "a simulated usecase error",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// ARRANGE
+			mockUC := new(mocks.UsecaseItf)
+			tt.setupMock(mockUC)
+			router := setupRouter(mockUC)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.url, nil)
+
+			// ACT
+			router.ServeHTTP(w, req)
+
+			// ASSERT
+			assert.Equal(t, tt.expectedStatusCode, w.Code, "status code should match")
+			assert.Contains(t, w.Body.String(), tt.expectedBodyContains, "response body should contain expected text")
+			mockUC.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIntegratedGetSymbolsHandler(t *testing.T) {
+	lastTradeAt := time.UnixMilli(1700000040000).UTC()
+	price, _ := primitive.ParseDecimal128("196.38")
+	volume, _ := primitive.ParseDecimal128("265")
+	mockSymbol := models.SymbolDocument{
+		Symbol:       "AAPL",
+		Exchange:     "US",
+		AssetClass:   models.AssetClassStock,
+		TradeCount:   3,
+		FirstTradeAt: lastTradeAt.Add(-time.Minute),
+		LastTradeAt:  lastTradeAt,
+		LastPrice:    price,
+		Day: &models.DaySummary{
+			Date: "2023-11-14", OpenAt: lastTradeAt.Add(-time.Minute),
+			Open: price, High: price, Low: price, Volume: volume,
+		},
+	}
+
+	testCases := []struct {
+		name                 string
+		url                  string
+		setupMock            func(mockUC *mocks.UsecaseItf)
+		expectedStatusCode   int
+		expectedBodyContains string
+	}{
+		{
+			name: "Success - should pass filters and sorting",
+			url:  "/api/v1/symbols?asset_class=Stock&exchange=us&sort=-trade_count",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				query := models.SymbolQuery{AssetClass: "stock", Exchange: "US", SortBy: "tradeCount", Descending: true}
+				mockUC.On("GetSymbols", mock.Anything, query).Return([]models.SymbolDocument{mockSymbol}, nil)
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedBodyContains: `{"symbol":"AAPL","exchange":"US","asset_class":"stock","trade_count":3,` +
+				`"first_trade_at":"2023-11-14T22:13:00Z","last_trade_at":"2023-11-14T22:14:00Z","last_price":"196.38"}`,
+		},
+		{
+			name: "Success - should leave out fields the symbol does not have",
+			url:  "/api/v1/symbols",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetSymbols", mock.Anything, models.SymbolQuery{}).
+					Return([]models.SymbolDocument{{Symbol: "MSFT", LastTradeAt: lastTradeAt}}, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `{"symbol":"MSFT","trade_count":0,"last_trade_at":"2023-11-14T22:14:00Z"}`,
+		},
+		{
+			name:                 "Failure - invalid sort",
+			url:                  "/api/v1/symbols?sort=price",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrInvalidSort.Error(),
+		},
+		{
+			name: "Success - should return symbol details",
+			url:  "/api/v1/symbols/AAPL",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetSymbol", mock.Anything, "AAPL").Return(&mockSymbol, nil)
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedBodyContains: `"last_price":"196.38","day":{"date":"2023-11-14","open_at":"2023-11-14T22:13:00Z",` +
+				`"open":"196.38","high":"196.38","low":"196.38","volume":"265"}}`,
+		},
+		{
+			name: "Failure - unknown symbol",
+			url:  "/api/v1/symbols/NOPE",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetSymbol", mock.Anything, "NOPE").Return(nil, nil)
+			},
+			expectedStatusCode:   http.StatusNotFound,
+			expectedBodyContains: constant.ErrSymbolNotFound.Error(),
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// ARRANGE
+			mockUC := new(mocks.UsecaseItf)
+			tt.setupMock(mockUC)
+			router := setupRouter(mockUC)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.url, nil)
+
+			// ACT
+			router.ServeHTTP(w, req)
+
+			// ASSERT
+			assert.Equal(t, tt.expectedStatusCode, w.Code, "status code should match")
+			assert.Contains(t, w.Body.String(), tt.expectedBodyContains, "response body should contain expected text")
+			mockUC.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIntegratedGetSnapshotHandler(t *testing.T) {
+	lastTradeAt := time.UnixMilli(1700000040000).UTC()
+	dec := func(s string) primitive.Decimal128 {
+		d, _ := primitive.ParseDecimal128(s)
+		return d
+	}
+	tooMany := make([]string, constant.MaxSnapshotSymbols+1)
+	for i := range tooMany {
+		tooMany[i] = fmt.Sprintf("S%d", i)
+	}
+
+	testCases := []struct {
+		name                 string
+		url                  string
+		setupMock            func(mockUC *mocks.UsecaseItf)
+		expectedStatusCode   int
+		expectedBodyContains string
+	}{
+		{
+			name: "Success - should return quotes with change vs previous close",
+			url:  "/api/v1/snapshot?symbols=AAPL,%20MSFT,AAPL",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetSnapshot", mock.Anything, []string{"AAPL", "MSFT"}).Return([]models.SymbolDocument{{
+					Symbol:      "AAPL",
+					LastTradeAt: lastTradeAt,
+					LastPrice:   dec("196.38"),
+					LastVolume:  dec("265"),
+					Day:         &models.DaySummary{PrevClose: dec("200")},
+				}}, nil)
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedBodyContains: `{"quotes":[{"symbol":"AAPL","price":"196.38","volume":"265","timestamp":"2023-11-14T22:14:00Z",` +
+				`"prev_close":"200","change":"-3.62","change_percent":"-1.81"}],"missing":["MSFT"]}`,
+		},
+		{
+			name: "Success - should leave out the change without a previous close",
+			url:  "/api/v1/snapshot?symbols=AAPL",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetSnapshot", mock.Anything, []string{"AAPL"}).Return([]models.SymbolDocument{{
+					Symbol: "AAPL", LastTradeAt: lastTradeAt, LastPrice: dec("196.38"), LastVolume: dec("265"),
+				}}, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `"timestamp":"2023-11-14T22:14:00Z"}],"missing":[]}`,
+		},
+		{
+			name:                 "Failure - no symbols",
+			url:                  "/api/v1/snapshot?symbols=,",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrNoSymbols.Error(),
+		},
+		{
+			name:                 "Failure - too many symbols",
+			url:                  "/api/v1/snapshot?symbols=" + strings.Join(tooMany, ","),
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrTooManySymbols.Error(),
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// ARRANGE
+			mockUC := new(mocks.UsecaseItf)
+			tt.setupMock(mockUC)
+			router := setupRouter(mockUC)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.url, nil)
+
+			// ACT
+			router.ServeHTTP(w, req)
+
+			// ASSERT
+			assert.Equal(t, tt.expectedStatusCode, w.Code, "status code should match")
+			assert.Contains(t, w.Body.String(), tt.expectedBodyContains, "response body should contain expected text")
+			mockUC.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIntegratedGetStatsPerSymbolHandler(t *testing.T) {
+	from := time.UnixMilli(1700000000000)
+	to := time.UnixMilli(1700003600000)
+	dec := func(s string) primitive.Decimal128 {
+		d, _ := primitive.ParseDecimal128(s)
+		return d
+	}
+	mockStats := &models.TradeStats{
+		TradeCount:   3,
+		FirstTradeAt: from.UTC(),
+		LastTradeAt:  from.Add(3 * time.Second).UTC(),
+		Open:         dec("10"), Close: dec("15"), Low: dec("10"), High: dec("20"),
+		Volume: dec("6"), VWAP: dec("16.6666666667"), TWAP: dec("16.2500000000"),
+		Volatility: dec("0.7504758415"),
+	}
+
+	testCases := []struct {
+		name                 string
+		url                  string
+		setupMock            func(mockUC *mocks.UsecaseItf)
+		expectedStatusCode   int
+		expectedBodyContains string
+	}{
+		{
+			name: "Success - should return stats with correct DTO format",
+			url:  "/api/v1/stats/AAPL?from=1700000000000&to=1700003600000&exclude_conditions=I",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetStatsPerSymbol", mock.Anything, "AAPL", from, to, []string{"I"}).Return(mockStats, nil)
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedBodyContains: `{"symbol":"AAPL","from":"2023-11-14T22:13:20Z","to":"2023-11-14T23:13:20Z","trade_count":3,` +
+				`"first_trade_at":"2023-11-14T22:13:20Z","last_trade_at":"2023-11-14T22:13:23Z","open":"10","close":"15",` +
+				`"low":"10","high":"20","volume":"6","vwap":"16.6666666667","twap":"16.2500000000","volatility":"0.7504758415"}`,
+		},
+		{
+			name: "Success - should default to the day before 'to' and report no trades",
+			url:  "/api/v1/stats/AAPL?to=1700003600000",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetStatsPerSymbol", mock.Anything, "AAPL", to.Add(-24*time.Hour), to, []string(nil)).Return(nil, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `"from":"2023-11-13T23:13:20Z","to":"2023-11-14T23:13:20Z","trade_count":0}`,
+		},
+		{
+			name:                 "Failure - 'from' after 'to'",
+			url:                  "/api/v1/stats/AAPL?from=1700003600000&to=1700000000000",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrInvalidWindow.Error(),
+		},
+		{
+			name:                 "Failure - window too long",
+			url:                  "/api/v1/stats/AAPL?from=0&to=1700000000000",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrInvalidWindow.Error(),
+		},
+		{
+			name: "Failure - usecase returns a generic error",
+			url:  "/api/v1/stats/NVDA?from=1700000000000&to=1700003600000",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetStatsPerSymbol", mock.Anything, "NVDA", from, to, []string(nil)).
+					Return(nil, errors.New("a simulated usecase error"))
+			},
+			expectedStatusCode:   http.StatusInternalServerError,
+			expectedBodyContains: "a simulated usecase error",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// ARRANGE
+			mockUC := new(mocks.UsecaseItf)
+			tt.setupMock(mockUC)
+			router := setupRouter(mockUC)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.url, nil)
+
+			// ACT
+			router.ServeHTTP(w, req)
+
+			// ASSERT
+			assert.Equal(t, tt.expectedStatusCode, w.Code, "status code should match")
+			assert.Contains(t, w.Body.String(), tt.expectedBodyContains, "response body should contain expected text")
+			mockUC.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIntegratedGetIndicatorHandler(t *testing.T) {
+	pointTime := time.UnixMilli(1700000040000)
+	mockPoints := []indicators.Point{
+		{Time: pointTime, Values: map[string]float64{"macd": 1.5, "signal": 1, "histogram": 0.5}},
+	}
+
+	testCases := []struct {
+		name                 string
+		url                  string
+		setupMock            func(mockUC *mocks.UsecaseItf)
+		expectedStatusCode   int
+		expectedBodyContains string
+	}{
+		{
+			name: "Success - should default the parameters",
+			url:  "/api/v1/indicators/AAPL?name=RSI",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				spec := indicators.Spec{Name: "rsi", Period: 14, Fast: 12, Slow: 26, Signal: 9, StdDev: 2}
+				mockUC.On("GetIndicator", mock.Anything, "AAPL", spec, time.Minute, constant.DefaultIndicatorLimit, []string(nil)).
+					Return(nil, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `{"symbol":"AAPL","name":"rsi","interval":"1m","params":{"period":14},"data":[]}`,
+		},
+		{
+			name: "Success - should return points with correct DTO format",
+			url:  "/api/v1/indicators/AAPL?name=macd&fast=5&slow=10&signal=3&interval=5m&limit=5000",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				spec := indicators.Spec{Name: "macd", Period: 20, Fast: 5, Slow: 10, Signal: 3, StdDev: 2}
+				mockUC.On("GetIndicator", mock.Anything, "AAPL", spec, 5*time.Minute, constant.MaxIndicatorLimit, []string(nil)).
+					Return(mockPoints, nil)
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedBodyContains: `"interval":"5m","params":{"fast":5,"signal":3,"slow":10},` +
+				`"data":[{"timestamp":"2023-11-14T22:14:00Z","values":{"histogram":0.5,"macd":1.5,"signal":1}}]}`,
+		},
+		{
+			name:                 "Failure - unknown indicator",
+			url:                  "/api/v1/indicators/AAPL?name=stochastic",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrInvalidIndicator.Error(),
+		},
+		{
+			name:                 "Failure - invalid period",
+			url:                  "/api/v1/indicators/AAPL?name=sma&period=abc",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrInvalidIndicator.Error(),
+		},
+		{
+			name:                 "Failure - invalid interval",
+			url:                  "/api/v1/indicators/AAPL?name=sma&interval=7m",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrInvalidInterval.Error(),
+		},
+		{
+			name: "Failure - usecase returns a generic error",
+			url:  "/api/v1/indicators/NVDA?name=atr",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetIndicator", mock.Anything, "NVDA", mock.Anything, time.Minute, constant.DefaultIndicatorLimit, []string(nil)).
+					Return(nil, errors.New("a simulated usecase error"))
+			},
+			expectedStatusCode:   http.StatusInternalServerError,
+			expectedBodyContains: "a simulated usecase error",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// ARRANGE
+			mockUC := new(mocks.UsecaseItf)
+			tt.setupMock(mockUC)
+			router := setupRouter(mockUC)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.url, nil)
+
+			// ACT
+			router.ServeHTTP(w, req)
+
+			// ASSERT
+			assert.Equal(t, tt.expectedStatusCode, w.Code, "status code should match")
+			assert.Contains(t, w.Body.String(), tt.expectedBodyContains, "response body should contain expected text")
+			mockUC.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIntegratedAlertRuleHandlers(t *testing.T) {
+	id, _ := primitive.ObjectIDFromHex("6560f3b0a1b2c3d4e5f60718")
+	created := time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+	price, _ := primitive.ParseDecimal128("200.5")
+	rule := models.AlertRule{
+		Id: id, Symbol: "AAPL", Type: models.AlertPriceCross, Direction: models.DirectionUp, Price: price,
+		WebhookURL: "https://hooks.example.com/hook", Secret: "s3cr3t", Enabled: true, CreatedAt: created, UpdatedAt: created,
+	}
+	// What the handler passes on, before the usecase adds the id, secret and times
+	input := models.AlertRule{
+		Symbol: "AAPL", Type: models.AlertPriceCross, Direction: models.DirectionUp, Price: price,
+		WebhookURL: "https://hooks.example.com/hook", Enabled: true,
+	}
+	ruleJSON := `{"id":"6560f3b0a1b2c3d4e5f60718","symbol":"AAPL","type":"price_cross","direction":"up","price":"200.5",` +
+		`"webhook_url":"https://hooks.example.com/hook","enabled":true,"created_at":"2025-11-20T14:30:00Z","updated_at":"2025-11-20T14:30:00Z"}`
+	body := `{"symbol":"AAPL","type":"price_cross","direction":"up","price":"200.5","webhook_url":"https://hooks.example.com/hook"}`
+
+	testCases := []struct {
+		name                 string
+		method               string
+		url                  string
+		body                 string
+		setupMock            func(mockUC *mocks.UsecaseItf)
+		expectedStatusCode   int
+		expectedBodyContains string
+	}{
+		{
+			name:   "List - should filter by symbol and hide secrets",
+			method: "GET",
+			url:    "/api/v1/alerts?symbol=AAPL",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetAlertRules", mock.Anything, "AAPL").Return([]models.AlertRule{rule}, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `"data":{"rules":[` + ruleJSON + `]}`,
+		},
+		{
+			name:   "Get - should return the rule",
+			method: "GET",
+			url:    "/api/v1/alerts/6560f3b0a1b2c3d4e5f60718",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetAlertRule", mock.Anything, id).Return(&rule, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `"data":` + ruleJSON,
+		},
+		{
+			name:                 "Get - malformed id is not found",
+			method:               "GET",
+			url:                  "/api/v1/alerts/nope",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusNotFound,
+			expectedBodyContains: constant.ErrAlertRuleNotFound.Error(),
+		},
+		{
+			name:   "Create - should return the secret once",
+			method: "POST",
+			url:    "/api/v1/alerts",
+			body:   body,
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("CreateAlertRule", mock.Anything, input).Return(&rule, nil)
+			},
+			expectedStatusCode:   http.StatusCreated,
+			expectedBodyContains: `"secret":"s3cr3t"`,
+		},
+		{
+			name:                 "Create - invalid rule",
+			method:               "POST",
+			url:                  "/api/v1/alerts",
+			body:                 `{"symbol":"AAPL","type":"percent_move","percent":5,"webhook_url":"https://hooks.example.com/hook"}`,
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: "invalid alert rule: window_minutes must be between 1 and 1440",
+		},
+		{
+			name:                 "Create - internal webhook",
+			method:               "POST",
+			url:                  "/api/v1/alerts",
+			body:                 strings.Replace(body, "https://hooks.example.com/hook", "http://169.254.169.254/latest", 1),
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: "invalid alert rule: webhook_url must not point at a private, loopback or reserved address",
+		},
+		{
+			name:                 "Create - malformed body",
+			method:               "POST",
+			url:                  "/api/v1/alerts",
+			body:                 `{"symbol":`,
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: "invalid alert rule",
+		},
+		{
+			name:   "Update - can disable the rule",
+			method: "PUT",
+			url:    "/api/v1/alerts/6560f3b0a1b2c3d4e5f60718",
+			body:   strings.Replace(body, "{", `{"enabled":false,`, 1),
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				update := input
+				update.Id = id
+				update.Enabled = false
+				updated := rule
+				updated.Enabled = false
+				mockUC.On("UpdateAlertRule", mock.Anything, update).Return(&updated, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `"enabled":false`,
+		},
+		{
+			name:   "Update - not found",
+			method: "PUT",
+			url:    "/api/v1/alerts/6560f3b0a1b2c3d4e5f60718",
+			body:   body,
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("UpdateAlertRule", mock.Anything, mock.Anything).Return(nil, nil)
+			},
+			expectedStatusCode:   http.StatusNotFound,
+			expectedBodyContains: constant.ErrAlertRuleNotFound.Error(),
+		},
+		{
+			name:   "Delete - should delete the rule",
+			method: "DELETE",
+			url:    "/api/v1/alerts/6560f3b0a1b2c3d4e5f60718",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("DeleteAlertRule", mock.Anything, id).Return(true, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `"message":"alert rule deleted"`,
+		},
+		{
+			name:   "Delete - not found",
+			method: "DELETE",
+			url:    "/api/v1/alerts/6560f3b0a1b2c3d4e5f60718",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("DeleteAlertRule", mock.Anything, id).Return(false, nil)
+			},
+			expectedStatusCode:   http.StatusNotFound,
+			expectedBodyContains: constant.ErrAlertRuleNotFound.Error(),
+		},
+		{
+			name:   "Deliveries - should return the log with its payloads",
+			method: "GET",
+			url:    "/api/v1/alerts/6560f3b0a1b2c3d4e5f60718/deliveries?limit=1000",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetAlertDeliveries", mock.Anything, id, constant.MaxDeliveriesLimit).Return([]models.AlertDelivery{{
+					Id: id, RuleId: id, Status: models.DeliveryFailed, Attempts: 5, Error: "webhook responded 500",
+					Payload: `{"id":"x"}`, TriggeredAt: created, CompletedAt: created.Add(time.Minute),
+				}}, nil)
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedBodyContains: `{"deliveries":[{"id":"6560f3b0a1b2c3d4e5f60718","status":"failed","attempts":5,` +
+				`"error":"webhook responded 500","payload":{"id":"x"},"triggered_at":"2025-11-20T14:30:00Z","completed_at":"2025-11-20T14:31:00Z"}]}`,
+		},
+		{
+			name:   "Failure - usecase returns a generic error",
+			method: "GET",
+			url:    "/api/v1/alerts",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetAlertRules", mock.Anything, "").Return(nil, errors.New("a simulated usecase error"))
+			},
+			expectedStatusCode:   http.StatusInternalServerError,
+			expectedBodyContains: "a simulated usecase error",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// ARRANGE
+			mockUC := new(mocks.UsecaseItf)
+			tt.setupMock(mockUC)
+			router := setupRouter(mockUC)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+
+			// ACT
+			router.ServeHTTP(w, req)
+
+			// ASSERT
+			assert.Equal(t, tt.expectedStatusCode, w.Code, "status code should match")
+			assert.Contains(t, w.Body.String(), tt.expectedBodyContains, "response body should contain expected text")
+			if tt.method == "GET" {
+				assert.NotContains(t, w.Body.String(), "s3cr3t", "secrets should not be listed")
+			}
+			mockUC.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIntegratedGetGapsHandler(t *testing.T) {
+	from := time.UnixMilli(1700000000000)
+	to := time.UnixMilli(1700003600000)
+	gaps := []models.DataGap{{
+		Symbol: "AAPL", From: from.Add(time.Millisecond).UTC(), To: from.Add(10 * time.Minute).UTC(),
+		DetectedAt: from.Add(15 * time.Minute).UTC(), UpdatedAt: from.Add(15 * time.Minute).UTC(),
+	}}
+
+	testCases := []struct {
+		name                 string
+		url                  string
+		setupMock            func(mockUC *mocks.UsecaseItf)
+		expectedStatusCode   int
+		expectedBodyContains string
+	}{
+		{
+			name: "Success - should return gaps with correct DTO format",
+			url:  "/api/v1/admin/gaps?symbol=AAPL&from=1700000000000&to=1700003600000",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetGaps", mock.Anything, "AAPL", from, to, constant.DefaultGapsLimit, (*models.GapCursor)(nil)).
+					Return(gaps, nil)
+			},
+			expectedStatusCode: http.StatusOK,
+			expectedBodyContains: `{"gaps":[{"symbol":"AAPL","from":"2023-11-14T22:13:20.001Z","to":"2023-11-14T22:23:20Z",` +
+				`"duration_seconds":599.999,"ongoing":false,"detected_at":"2023-11-14T22:28:20Z","updated_at":"2023-11-14T22:28:20Z"}],` +
+				`"pagination":{"next_cursor":null}}`,
+		},
+		{
+			name: "Success - all symbols over the week before 'to'",
+			url:  "/api/v1/admin/gaps?to=1700003600000",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetGaps", mock.Anything, "", to.Add(-constant.DefaultGapsWindow), to,
+					constant.DefaultGapsLimit, (*models.GapCursor)(nil)).Return(nil, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `{"gaps":[],"pagination":{"next_cursor":null}}`,
+		},
+		{
+			name: "Success - a full page has a cursor to the next one",
+			url:  "/api/v1/admin/gaps?from=1700000000000&to=1700003600000&limit=1",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetGaps", mock.Anything, "", from, to, 1, (*models.GapCursor)(nil)).Return(gaps, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `"pagination":{"next_cursor":"1700000000001,AAPL"}`,
+		},
+		{
+			name: "Success - the next page starts after the cursor",
+			url:  "/api/v1/admin/gaps?from=1700000000000&to=1700003600000&limit=1&after=1700000000001,BINANCE:BTCUSDT",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetGaps", mock.Anything, "", from, to, 1,
+					&models.GapCursor{From: from.Add(time.Millisecond), Symbol: "BINANCE:BTCUSDT"}).Return(nil, nil)
+			},
+			expectedStatusCode:   http.StatusOK,
+			expectedBodyContains: `{"gaps":[],"pagination":{"next_cursor":null}}`,
+		},
+		{
+			name:                 "Failure - window longer than gaps allow",
+			url:                  "/api/v1/admin/gaps?from=1600000000000&to=1700003600000",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrInvalidGapsWindow.Error(),
+		},
+		{
+			name:                 "Failure - 'from' after 'to'",
+			url:                  "/api/v1/admin/gaps?from=1700003600000&to=1700000000000",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrInvalidGapsWindow.Error(),
+		},
+		{
+			name:                 "Failure - limit above the maximum",
+			url:                  "/api/v1/admin/gaps?limit=1001",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrInvalidGapsLimit.Error(),
+		},
+		{
+			name:                 "Failure - malformed cursor",
+			url:                  "/api/v1/admin/gaps?after=AAPL",
+			setupMock:            func(mockUC *mocks.UsecaseItf) {},
+			expectedStatusCode:   http.StatusBadRequest,
+			expectedBodyContains: constant.ErrInvalidGapsCursor.Error(),
+		},
+		{
+			name: "Failure - usecase returns a generic error",
+			url:  "/api/v1/admin/gaps?from=1700000000000&to=1700003600000",
+			setupMock: func(mockUC *mocks.UsecaseItf) {
+				mockUC.On("GetGaps", mock.Anything, "", from, to, constant.DefaultGapsLimit, (*models.GapCursor)(nil)).
+					Return(nil, errors.New("a simulated usecase error"))
+			},
+			expectedStatusCode:   http.StatusInternalServerError,
+			expectedBodyContains: "a simulated usecase error",
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			// ARRANGE
+			mockUC := new(mocks.UsecaseItf)
+			tt.setupMock(mockUC)
+			router := setupRouter(mockUC)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tt.url, nil)
+
+			// ACT
+			router.ServeHTTP(w, req)
+
+			// ASSERT
+			assert.Equal(t, tt.expectedStatusCode, w.Code, "status code should match")
+			assert.Contains(t, w.Body.String(), tt.expectedBodyContains, "response body should contain expected text")
+			mockUC.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"financial-data-backend-2/internal/api/constant"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminAuth lets a request through only if it has the header
+// "Authorization: Bearer <token>". The token is looked up on every request,
+// so that it can be changed while the server is running; while it is
+// empty, every request is refused.
+func AdminAuth(token func() string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		expected := token()
+		if expected == "" {
+			c.Error(constant.ErrAdminDisabled)
+			c.Abort()
+			return
+		}
+		given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(expected)) != 1 {
+			c.Error(constant.ErrUnauthorized)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
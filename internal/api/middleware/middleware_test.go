@@ -177,3 +177,82 @@ func TestTimeoutMiddleware(t *testing.T) {
 	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
 	assert.Equal(t, `{"success":false,"error":"request timed out","data":null}`, w.Body.String())
 }
+
+func TestRateLimitMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Freeze time so that the bucket only refills when we say so.
+	now := time.Unix(0, 0)
+	rl := NewRateLimiter(1, 2)
+	rl.now = func() time.Time { return now }
+	rl.SetLimit(1, 2)
+
+	r := gin.New()
+	r.Use(Error())
+	r.Use(RateLimit(rl))
+	r.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// The burst of 2 is served, the third request is rejected.
+	assert.Equal(t, http.StatusOK, serve().Code)
+	assert.Equal(t, http.StatusOK, serve().Code)
+	w := serve()
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, `{"success":false,"error":"too many requests, please slow down","data":null}`, w.Body.String())
+
+	// One second later, one more token is available.
+	now = now.Add(time.Second)
+	assert.Equal(t, http.StatusOK, serve().Code)
+	assert.Equal(t, http.StatusTooManyRequests, serve().Code)
+
+	// Disabling the limit at runtime lets everything through.
+	rl.SetLimit(0, 0)
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, serve().Code)
+	}
+}
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	token := ""
+	r := gin.New()
+	r.Use(Error())
+	r.Use(AdminAuth(func() string { return token }))
+	r.GET("/", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	serve := func(authorization string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Without a configured token, every request is refused.
+	assert.Equal(t, http.StatusForbidden, serve(""))
+	assert.Equal(t, http.StatusForbidden, serve("Bearer "))
+
+	token = "s3cret"
+	assert.Equal(t, http.StatusUnauthorized, serve(""))
+	assert.Equal(t, http.StatusUnauthorized, serve("Bearer wrong"))
+	assert.Equal(t, http.StatusUnauthorized, serve("s3cret"))
+	assert.Equal(t, http.StatusOK, serve("Bearer s3cret"))
+
+	// The token can change while the server runs.
+	token = "rotated"
+	assert.Equal(t, http.StatusUnauthorized, serve("Bearer s3cret"))
+	assert.Equal(t, http.StatusOK, serve("Bearer rotated"))
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"financial-data-backend-2/internal/api/constant"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimiter is a token bucket shared by every request to the server.
+// Its rate can be changed at runtime with SetLimit.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens per second; 0 means unlimited
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func NewRateLimiter(requestsPerSecond float64, burst int) *RateLimiter {
+	rl := &RateLimiter{now: time.Now}
+	rl.SetLimit(requestsPerSecond, burst)
+	return rl
+}
+
+// SetLimit changes the rate and burst. A burst below 1 defaults to one
+// second's worth of requests.
+func (rl *RateLimiter) SetLimit(requestsPerSecond float64, burst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.rate = requestsPerSecond
+	rl.burst = float64(burst)
+	if rl.burst < 1 {
+		rl.burst = max(requestsPerSecond, 1)
+	}
+	rl.tokens = rl.burst
+	rl.last = rl.now()
+}
+
+// Allow reports whether a request may proceed, consuming a token if so.
+func (rl *RateLimiter) Allow() bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.rate <= 0 {
+		return true
+	}
+
+	now := rl.now()
+	rl.tokens = min(rl.burst, rl.tokens+now.Sub(rl.last).Seconds()*rl.rate)
+	rl.last = now
+	if rl.tokens < 1 {
+		return false
+	}
+	rl.tokens--
+	return true
+}
+
+func RateLimit(rl *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !rl.Allow() {
+			c.Error(constant.ErrRateLimited)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -10,8 +10,14 @@ import (
 )
 
 func Timeout(duration time.Duration) gin.HandlerFunc {
+	return TimeoutFunc(func() time.Duration { return duration })
+}
+
+// TimeoutFunc is like Timeout, but looks the duration up on every
+// request so that it can be changed while the server is running.
+func TimeoutFunc(duration func() time.Duration) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(c.Request.Context(), duration)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), duration())
 		defer cancel()
 
 		c.Request = c.Request.WithContext(ctx)
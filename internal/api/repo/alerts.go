@@ -0,0 +1,91 @@
+package repo
+
+import (
+	"context"
+	"financial-data-backend-2/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetAlertRules returns the alert rules of a symbol, or all of them if it
+// is empty, oldest first.
+func (r *Repo) GetAlertRules(ctx context.Context, symbol string) ([]models.AlertRule, error) {
+	filter := bson.M{}
+	if symbol != "" {
+		filter["symbol"] = symbol
+	}
+	cursor, err := r.ac.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rules []models.AlertRule
+	if err = cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// GetAlertRule returns an alert rule, or nil if there is none with the id.
+func (r *Repo) GetAlertRule(ctx context.Context, id primitive.ObjectID) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	err := r.ac.FindOne(ctx, bson.M{"_id": id}).Decode(&rule)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *Repo) CreateAlertRule(ctx context.Context, rule models.AlertRule) error {
+	_, err := r.ac.InsertOne(ctx, rule)
+	return err
+}
+
+// UpdateAlertRule replaces an alert rule. It returns false if there is
+// none with the id.
+func (r *Repo) UpdateAlertRule(ctx context.Context, rule models.AlertRule) (bool, error) {
+	res, err := r.ac.ReplaceOne(ctx, bson.M{"_id": rule.Id}, rule)
+	if err != nil {
+		return false, err
+	}
+	return res.MatchedCount > 0, nil
+}
+
+// DeleteAlertRule returns false if there is no alert rule with the id. Its
+// delivery log is kept until it expires.
+func (r *Repo) DeleteAlertRule(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	res, err := r.ac.DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return false, err
+	}
+	return res.DeletedCount > 0, nil
+}
+
+// GetAlertDeliveries returns the latest deliveries of an alert rule,
+// newest first.
+func (r *Repo) GetAlertDeliveries(ctx context.Context, ruleId primitive.ObjectID, limit int) ([]models.AlertDelivery, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "triggered_at", Value: -1}}).
+		SetLimit(int64(limit))
+	cursor, err := r.dc.Find(ctx, bson.M{"rule_id": ruleId}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var deliveries []models.AlertDelivery
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,38 @@
+package repo
+
+import (
+	"context"
+	"financial-data-backend-2/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetGaps returns up to limit gaps of a symbol, or of all symbols if it is
+// empty, that overlap [from, to), oldest first, then by symbol. A page
+// starts after the gap of after, if any.
+func (r *Repo) GetGaps(ctx context.Context, symbol string, from, to time.Time, limit int, after *models.GapCursor) ([]models.DataGap, error) {
+	filter := bson.M{"from": bson.M{"$lt": to}, "to": bson.M{"$gt": from}}
+	if symbol != "" {
+		filter["symbol"] = symbol
+	}
+	if after != nil {
+		filter["$or"] = bson.A{
+			bson.M{"from": bson.M{"$gt": after.From}},
+			bson.M{"from": after.From, "symbol": bson.M{"$gt": after.Symbol}},
+		}
+	}
+	sort := bson.D{{Key: "from", Value: 1}, {Key: "symbol", Value: 1}}
+	cursor, err := r.gc.Find(ctx, filter, options.Find().SetSort(sort).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var gaps []models.DataGap
+	if err = cursor.All(ctx, &gaps); err != nil {
+		return nil, err
+	}
+	return gaps, nil
+}
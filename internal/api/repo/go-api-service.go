@@ -3,31 +3,70 @@ package repo
 import (
 	"context"
 	"financial-data-backend-2/internal/models"
+	"financial-data-backend-2/internal/retention"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 //go:generate mockery --name RepoItf --case underscore --keeptree
 type RepoItf interface {
-	GetSymbols(context.Context) ([]models.SymbolDocument, error)
-	GetTradesPerSymbol(context.Context, string, int, int64) ([]models.TradeRecord, error)
+	GetSymbols(context.Context, models.SymbolQuery) ([]models.SymbolDocument, error)
+	GetSymbol(context.Context, string) (*models.SymbolDocument, error)
+	GetSnapshot(context.Context, []string) ([]models.SymbolDocument, error)
+	GetTradesPerSymbol(context.Context, string, int, int64, []string, bool) ([]models.TradeRecord, error)
+	GetCandlesPerSymbol(context.Context, string, int, int64) ([]models.Candle, error)
+	HasCandlesBefore(context.Context, string, int64) (bool, error)
+	GetStatsPerSymbol(context.Context, string, time.Time, time.Time, []string) (*models.TradeStats, error)
+	GetCandleSeries(context.Context, string, time.Duration, time.Time, time.Time, []string) ([]models.Candle, error)
+	GetAlertRules(context.Context, string) ([]models.AlertRule, error)
+	GetAlertRule(context.Context, primitive.ObjectID) (*models.AlertRule, error)
+	CreateAlertRule(context.Context, models.AlertRule) error
+	UpdateAlertRule(context.Context, models.AlertRule) (bool, error)
+	DeleteAlertRule(context.Context, primitive.ObjectID) (bool, error)
+	GetAlertDeliveries(context.Context, primitive.ObjectID, int) ([]models.AlertDelivery, error)
+	GetGaps(context.Context, string, time.Time, time.Time, int, *models.GapCursor) ([]models.DataGap, error)
 }
 
 type Repo struct {
 	sc *mongo.Collection
 	tc *mongo.Collection
+	cc *mongo.Collection
+	// Alert rules and their delivery log
+	ac *mongo.Collection
+	dc *mongo.Collection
+	// Gaps in the trades, found by go-gap-detector
+	gc *mongo.Collection
 }
 
-func NewRepo(symbolCollection, tradeCollection *mongo.Collection) *Repo {
-	return &Repo{sc: symbolCollection, tc: tradeCollection}
+func NewRepo(symbolCollection, tradeCollection, candleCollection, alertRuleCollection, alertDeliveryCollection, dataGapCollection *mongo.Collection) *Repo {
+	return &Repo{sc: symbolCollection, tc: tradeCollection, cc: candleCollection,
+		ac: alertRuleCollection, dc: alertDeliveryCollection, gc: dataGapCollection}
 }
 
-func (rp *Repo) GetSymbols(c context.Context) ([]models.SymbolDocument, error) {
-	results, err := rp.sc.Find(c, bson.D{}, options.Find().SetSort(
-		bson.D{{Key: "symbol", Value: 1}}))
+func (rp *Repo) GetSymbols(c context.Context, query models.SymbolQuery) ([]models.SymbolDocument, error) {
+	filter := bson.M{}
+	if query.AssetClass != "" {
+		filter["assetClass"] = query.AssetClass
+	}
+	if query.Exchange != "" {
+		filter["exchange"] = query.Exchange
+	}
+
+	// Ties, and symbols missing the field, are ordered by symbol.
+	order := 1
+	if query.Descending {
+		order = -1
+	}
+	sort := bson.D{{Key: "symbol", Value: order}}
+	if query.SortBy != "" && query.SortBy != "symbol" {
+		sort = bson.D{{Key: query.SortBy, Value: order}, {Key: "symbol", Value: 1}}
+	}
+
+	results, err := rp.sc.Find(c, filter, options.Find().SetSort(sort))
 	if err != nil {
 		return nil, err
 	}
@@ -40,7 +79,39 @@ func (rp *Repo) GetSymbols(c context.Context) ([]models.SymbolDocument, error) {
 	return symbols, nil
 }
 
-func (r *Repo) GetTradesPerSymbol(ctx context.Context, symbol string, limit int, before int64) ([]models.TradeRecord, error) {
+// GetSymbol returns the metadata of a symbol, or nil if it has none.
+func (rp *Repo) GetSymbol(c context.Context, symbol string) (*models.SymbolDocument, error) {
+	var doc models.SymbolDocument
+	err := rp.sc.FindOne(c, bson.M{"symbol": symbol}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// GetSnapshot returns the metadata of the given symbols that have any,
+// in no particular order.
+func (rp *Repo) GetSnapshot(c context.Context, symbols []string) ([]models.SymbolDocument, error) {
+	if len(symbols) == 0 {
+		return nil, nil
+	}
+	results, err := rp.sc.Find(c, bson.M{"symbol": bson.M{"$in": symbols}})
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close(c)
+
+	var docs []models.SymbolDocument
+	if err = results.All(c, &docs); err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+func (r *Repo) GetTradesPerSymbol(ctx context.Context, symbol string, limit int, before int64, excludeConditions []string, excludeFlagged bool) ([]models.TradeRecord, error) {
 	var trades []models.TradeRecord
 	if limit <= 0 {
 		return nil, nil
@@ -54,6 +125,16 @@ func (r *Repo) GetTradesPerSymbol(ctx context.Context, symbol string, limit int,
 		filter["time"] = bson.M{"$lt": time.UnixMilli(before)}
 	}
 
+	// Leave out trades carrying any of the given conditions.
+	if len(excludeConditions) > 0 {
+		filter["conditions"] = bson.M{"$nin": excludeConditions}
+	}
+
+	// Leave out trades flagged as bad ticks.
+	if excludeFlagged {
+		filter["flags"] = bson.M{"$exists": false}
+	}
+
 	findOptions := options.Find().
 		SetSort(bson.D{{Key: "time", Value: -1}}).
 		SetLimit(int64(limit))
@@ -70,3 +151,205 @@ func (r *Repo) GetTradesPerSymbol(ctx context.Context, symbol string, limit int,
 
 	return trades, nil
 }
+
+// GetCandlesPerSymbol pages through the minute candles of a symbol, newest
+// first, the same way as GetTradesPerSymbol.
+func (r *Repo) GetCandlesPerSymbol(ctx context.Context, symbol string, limit int, before int64) ([]models.Candle, error) {
+	var candles []models.Candle
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	filter := bson.M{"symbol": symbol}
+	if before > 0 {
+		filter["time"] = bson.M{"$lt": time.UnixMilli(before)}
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "time", Value: -1}}).
+		SetLimit(int64(limit))
+
+	cursor, err := r.cc.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &candles); err != nil {
+		return nil, err
+	}
+
+	return candles, nil
+}
+
+// HasCandlesBefore reports whether a symbol has minute candles older than
+// the given Unix millisecond time (or at all, if it is 0).
+func (r *Repo) HasCandlesBefore(ctx context.Context, symbol string, before int64) (bool, error) {
+	filter := bson.M{"symbol": symbol}
+	if before > 0 {
+		filter["time"] = bson.M{"$lt": time.UnixMilli(before)}
+	}
+	n, err := r.cc.CountDocuments(ctx, filter, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// GetStatsPerSymbol aggregates a symbol's trades in [from, to), leaving out
+// flagged ones and those with any of excludeConditions. It returns nil if
+// there are none.
+//
+// VWAP is the sum of price*volume over the volume. TWAP weighs each price
+// by how long it held: until the next trade, or until 'to' (or now, if
+// earlier) for the last one. All arithmetic is done on decimals by
+// MongoDB, apart from the logarithms of the volatility.
+func (r *Repo) GetStatsPerSymbol(ctx context.Context, symbol string, from, to time.Time, excludeConditions []string) (*models.TradeStats, error) {
+	match := bson.M{
+		"symbol": symbol,
+		"time":   bson.M{"$gte": from, "$lt": to},
+		"flags":  bson.M{"$exists": false},
+	}
+	if len(excludeConditions) > 0 {
+		match["conditions"] = bson.M{"$nin": excludeConditions}
+	}
+	end := to
+	if now := time.Now(); now.Before(end) {
+		end = now
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$setWindowFields", Value: bson.M{
+			"sortBy": bson.M{"time": 1},
+			"output": bson.M{
+				"nextTime":  bson.M{"$shift": bson.M{"output": "$time", "by": 1}},
+				"prevPrice": bson.M{"$shift": bson.M{"output": "$price", "by": -1}},
+			},
+		}}},
+		{{Key: "$set", Value: bson.M{
+			// Milliseconds the price held
+			"held": bson.M{"$max": bson.A{0, bson.M{"$subtract": bson.A{
+				bson.M{"$ifNull": bson.A{"$nextTime", end}}, "$time",
+			}}}},
+			"logReturn": bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$prevPrice", 0}},
+				bson.M{"$ln": bson.M{"$divide": bson.A{"$price", "$prevPrice"}}},
+				nil,
+			}},
+		}}},
+		{{Key: "$sort", Value: bson.M{"time": 1}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "trade_count", Value: bson.M{"$sum": 1}},
+			{Key: "first_trade_at", Value: bson.M{"$first": "$time"}},
+			{Key: "last_trade_at", Value: bson.M{"$last": "$time"}},
+			{Key: "open", Value: bson.M{"$first": "$price"}},
+			{Key: "close", Value: bson.M{"$last": "$price"}},
+			{Key: "low", Value: bson.M{"$min": "$price"}},
+			{Key: "high", Value: bson.M{"$max": "$price"}},
+			{Key: "avg", Value: bson.M{"$avg": "$price"}},
+			{Key: "volume", Value: bson.M{"$sum": "$volume"}},
+			{Key: "notional", Value: bson.M{"$sum": bson.M{"$multiply": bson.A{"$price", "$volume"}}}},
+			{Key: "weighted", Value: bson.M{"$sum": bson.M{"$multiply": bson.A{"$price", "$held"}}}},
+			{Key: "held", Value: bson.M{"$sum": "$held"}},
+			{Key: "squaredReturns", Value: bson.M{"$sum": bson.M{"$multiply": bson.A{"$logReturn", "$logReturn"}}}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":            0,
+			"trade_count":    1,
+			"first_trade_at": 1,
+			"last_trade_at":  1,
+			"open":           1,
+			"close":          1,
+			"low":            1,
+			"high":           1,
+			"volume":         1,
+			// Without volume, e.g. for some forex feeds, VWAP is the average.
+			"vwap": bson.M{"$round": bson.A{bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$volume", 0}}, bson.M{"$divide": bson.A{"$notional", "$volume"}}, "$avg",
+			}}, models.StatsScale}},
+			// A single instant has no duration to weigh by.
+			"twap": bson.M{"$round": bson.A{bson.M{"$cond": bson.A{
+				bson.M{"$gt": bson.A{"$held", 0}}, bson.M{"$divide": bson.A{"$weighted", "$held"}}, "$avg",
+			}}, models.StatsScale}},
+			"volatility": bson.M{"$round": bson.A{
+				bson.M{"$sqrt": bson.M{"$toDecimal": "$squaredReturns"}}, models.StatsScale,
+			}},
+		}}},
+	}
+
+	cursor, err := r.tc.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var stats []models.TradeStats
+	if err = cursor.All(ctx, &stats); err != nil {
+		return nil, err
+	}
+	if len(stats) == 0 {
+		return nil, nil
+	}
+	return &stats[0], nil
+}
+
+// GetCandleSeries returns a symbol's candles of the given size (a whole
+// number of minutes) in [from, to), oldest first. They are aggregated from
+// the raw trades, and from the stored minute candles for the minutes before
+// the oldest raw trade, as older trades may have expired. Buckets without
+// trades are left out.
+func (r *Repo) GetCandleSeries(ctx context.Context, symbol string, size time.Duration, from, to time.Time, excludeConditions []string) ([]models.Candle, error) {
+	// Raw trades are preferred, as they honour excludeConditions. The
+	// stored candles cover the minutes before the oldest one.
+	until := to
+	var oldest struct {
+		Time time.Time `bson:"time"`
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "time", Value: 1}}).SetProjection(bson.M{"time": 1})
+	err := r.tc.FindOne(ctx, bson.M{
+		"symbol": symbol,
+		"time":   bson.M{"$gte": from, "$lt": to},
+	}, opts).Decode(&oldest)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	if err == nil {
+		until = oldest.Time.Truncate(time.Minute)
+	}
+
+	raw := retention.BucketPipeline(symbol, until, to, time.Minute, excludeConditions)
+	cursor, err := r.cc.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"symbol": symbol,
+			"time":   bson.M{"$gte": from, "$lt": until},
+		}}},
+		{{Key: "$unionWith", Value: bson.M{"coll": r.tc.Name(), "pipeline": raw}}},
+		{{Key: "$sort", Value: bson.D{{Key: "time", Value: 1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.M{"$dateTrunc": bson.M{
+				"date": "$time", "unit": "minute", "binSize": int64(size / time.Minute),
+			}}},
+			{Key: "open", Value: bson.M{"$first": "$open"}},
+			{Key: "high", Value: bson.M{"$max": "$high"}},
+			{Key: "low", Value: bson.M{"$min": "$low"}},
+			{Key: "close", Value: bson.M{"$last": "$close"}},
+			{Key: "volume", Value: bson.M{"$sum": "$volume"}},
+			{Key: "trade_count", Value: bson.M{"$sum": "$trade_count"}},
+		}}},
+		{{Key: "$set", Value: bson.M{"symbol": bson.M{"$literal": symbol}, "time": "$_id"}}},
+		{{Key: "$unset", Value: "_id"}},
+		{{Key: "$sort", Value: bson.D{{Key: "time", Value: 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var candles []models.Candle
+	if err = cursor.All(ctx, &candles); err != nil {
+		return nil, err
+	}
+	return candles, nil
+}
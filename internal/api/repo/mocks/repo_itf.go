@@ -5,6 +5,8 @@ package mocks
 import (
 	context "context"
 	models "financial-data-backend-2/internal/models"
+	primitive "go.mongodb.org/mongo-driver/bson/primitive"
+	time "time"
 
 	mock "github.com/stretchr/testify/mock"
 )
@@ -14,9 +16,325 @@ type RepoItf struct {
 	mock.Mock
 }
 
-// GetSymbols provides a mock function with given fields: _a0
-func (_m *RepoItf) GetSymbols(_a0 context.Context) ([]models.SymbolDocument, error) {
-	ret := _m.Called(_a0)
+// CreateAlertRule provides a mock function with given fields: _a0, _a1
+func (_m *RepoItf) CreateAlertRule(_a0 context.Context, _a1 models.AlertRule) error {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateAlertRule")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.AlertRule) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteAlertRule provides a mock function with given fields: _a0, _a1
+func (_m *RepoItf) DeleteAlertRule(_a0 context.Context, _a1 primitive.ObjectID) (bool, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteAlertRule")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, primitive.ObjectID) (bool, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, primitive.ObjectID) bool); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, primitive.ObjectID) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAlertDeliveries provides a mock function with given fields: _a0, _a1, _a2
+func (_m *RepoItf) GetAlertDeliveries(_a0 context.Context, _a1 primitive.ObjectID, _a2 int) ([]models.AlertDelivery, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetAlertDeliveries")
+	}
+
+	var r0 []models.AlertDelivery
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, primitive.ObjectID, int) ([]models.AlertDelivery, error)); ok {
+		return rf(_a0, _a1, _a2)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, primitive.ObjectID, int) []models.AlertDelivery); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.AlertDelivery)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, primitive.ObjectID, int) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAlertRule provides a mock function with given fields: _a0, _a1
+func (_m *RepoItf) GetAlertRule(_a0 context.Context, _a1 primitive.ObjectID) (*models.AlertRule, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetAlertRule")
+	}
+
+	var r0 *models.AlertRule
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, primitive.ObjectID) (*models.AlertRule, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, primitive.ObjectID) *models.AlertRule); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.AlertRule)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, primitive.ObjectID) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetAlertRules provides a mock function with given fields: _a0, _a1
+func (_m *RepoItf) GetAlertRules(_a0 context.Context, _a1 string) ([]models.AlertRule, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetAlertRules")
+	}
+
+	var r0 []models.AlertRule
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) ([]models.AlertRule, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) []models.AlertRule); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.AlertRule)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetCandleSeries provides a mock function with given fields: _a0, _a1, _a2, _a3, _a4, _a5
+func (_m *RepoItf) GetCandleSeries(_a0 context.Context, _a1 string, _a2 time.Duration, _a3 time.Time, _a4 time.Time, _a5 []string) ([]models.Candle, error) {
+	ret := _m.Called(_a0, _a1, _a2, _a3, _a4, _a5)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetCandleSeries")
+	}
+
+	var r0 []models.Candle
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration, time.Time, time.Time, []string) ([]models.Candle, error)); ok {
+		return rf(_a0, _a1, _a2, _a3, _a4, _a5)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Duration, time.Time, time.Time, []string) []models.Candle); ok {
+		r0 = rf(_a0, _a1, _a2, _a3, _a4, _a5)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Candle)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Duration, time.Time, time.Time, []string) error); ok {
+		r1 = rf(_a0, _a1, _a2, _a3, _a4, _a5)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetCandlesPerSymbol provides a mock function with given fields: _a0, _a1, _a2, _a3
+func (_m *RepoItf) GetCandlesPerSymbol(_a0 context.Context, _a1 string, _a2 int, _a3 int64) ([]models.Candle, error) {
+	ret := _m.Called(_a0, _a1, _a2, _a3)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetCandlesPerSymbol")
+	}
+
+	var r0 []models.Candle
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, int64) ([]models.Candle, error)); ok {
+		return rf(_a0, _a1, _a2, _a3)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, int64) []models.Candle); ok {
+		r0 = rf(_a0, _a1, _a2, _a3)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.Candle)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int, int64) error); ok {
+		r1 = rf(_a0, _a1, _a2, _a3)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetGaps provides a mock function with given fields: _a0, _a1, _a2, _a3, _a4, _a5
+func (_m *RepoItf) GetGaps(_a0 context.Context, _a1 string, _a2 time.Time, _a3 time.Time, _a4 int, _a5 *models.GapCursor) ([]models.DataGap, error) {
+	ret := _m.Called(_a0, _a1, _a2, _a3, _a4, _a5)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetGaps")
+	}
+
+	var r0 []models.DataGap
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time, int, *models.GapCursor) ([]models.DataGap, error)); ok {
+		return rf(_a0, _a1, _a2, _a3, _a4, _a5)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time, int, *models.GapCursor) []models.DataGap); ok {
+		r0 = rf(_a0, _a1, _a2, _a3, _a4, _a5)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.DataGap)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time, time.Time, int, *models.GapCursor) error); ok {
+		r1 = rf(_a0, _a1, _a2, _a3, _a4, _a5)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSnapshot provides a mock function with given fields: _a0, _a1
+func (_m *RepoItf) GetSnapshot(_a0 context.Context, _a1 []string) ([]models.SymbolDocument, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetSnapshot")
+	}
+
+	var r0 []models.SymbolDocument
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, []string) ([]models.SymbolDocument, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, []string) []models.SymbolDocument); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]models.SymbolDocument)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, []string) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetStatsPerSymbol provides a mock function with given fields: _a0, _a1, _a2, _a3, _a4
+func (_m *RepoItf) GetStatsPerSymbol(_a0 context.Context, _a1 string, _a2 time.Time, _a3 time.Time, _a4 []string) (*models.TradeStats, error) {
+	ret := _m.Called(_a0, _a1, _a2, _a3, _a4)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetStatsPerSymbol")
+	}
+
+	var r0 *models.TradeStats
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time, []string) (*models.TradeStats, error)); ok {
+		return rf(_a0, _a1, _a2, _a3, _a4)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time, time.Time, []string) *models.TradeStats); ok {
+		r0 = rf(_a0, _a1, _a2, _a3, _a4)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.TradeStats)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time, time.Time, []string) error); ok {
+		r1 = rf(_a0, _a1, _a2, _a3, _a4)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSymbol provides a mock function with given fields: _a0, _a1
+func (_m *RepoItf) GetSymbol(_a0 context.Context, _a1 string) (*models.SymbolDocument, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetSymbol")
+	}
+
+	var r0 *models.SymbolDocument
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*models.SymbolDocument, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) *models.SymbolDocument); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.SymbolDocument)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetSymbols provides a mock function with given fields: _a0, _a1
+func (_m *RepoItf) GetSymbols(_a0 context.Context, _a1 models.SymbolQuery) ([]models.SymbolDocument, error) {
+	ret := _m.Called(_a0, _a1)
 
 	if len(ret) == 0 {
 		panic("no return value specified for GetSymbols")
@@ -24,19 +342,19 @@ func (_m *RepoItf) GetSymbols(_a0 context.Context) ([]models.SymbolDocument, err
 
 	var r0 []models.SymbolDocument
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context) ([]models.SymbolDocument, error)); ok {
-		return rf(_a0)
+	if rf, ok := ret.Get(0).(func(context.Context, models.SymbolQuery) ([]models.SymbolDocument, error)); ok {
+		return rf(_a0, _a1)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context) []models.SymbolDocument); ok {
-		r0 = rf(_a0)
+	if rf, ok := ret.Get(0).(func(context.Context, models.SymbolQuery) []models.SymbolDocument); ok {
+		r0 = rf(_a0, _a1)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]models.SymbolDocument)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
-		r1 = rf(_a0)
+	if rf, ok := ret.Get(1).(func(context.Context, models.SymbolQuery) error); ok {
+		r1 = rf(_a0, _a1)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -44,9 +362,9 @@ func (_m *RepoItf) GetSymbols(_a0 context.Context) ([]models.SymbolDocument, err
 	return r0, r1
 }
 
-// GetTradesPerSymbol provides a mock function with given fields: _a0, _a1, _a2, _a3
-func (_m *RepoItf) GetTradesPerSymbol(_a0 context.Context, _a1 string, _a2 int, _a3 int64) ([]models.TradeRecord, error) {
-	ret := _m.Called(_a0, _a1, _a2, _a3)
+// GetTradesPerSymbol provides a mock function with given fields: _a0, _a1, _a2, _a3, _a4, _a5
+func (_m *RepoItf) GetTradesPerSymbol(_a0 context.Context, _a1 string, _a2 int, _a3 int64, _a4 []string, _a5 bool) ([]models.TradeRecord, error) {
+	ret := _m.Called(_a0, _a1, _a2, _a3, _a4, _a5)
 
 	if len(ret) == 0 {
 		panic("no return value specified for GetTradesPerSymbol")
@@ -54,19 +372,75 @@ func (_m *RepoItf) GetTradesPerSymbol(_a0 context.Context, _a1 string, _a2 int,
 
 	var r0 []models.TradeRecord
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, int, int64) ([]models.TradeRecord, error)); ok {
-		return rf(_a0, _a1, _a2, _a3)
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, int64, []string, bool) ([]models.TradeRecord, error)); ok {
+		return rf(_a0, _a1, _a2, _a3, _a4, _a5)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string, int, int64) []models.TradeRecord); ok {
-		r0 = rf(_a0, _a1, _a2, _a3)
+	if rf, ok := ret.Get(0).(func(context.Context, string, int, int64, []string, bool) []models.TradeRecord); ok {
+		r0 = rf(_a0, _a1, _a2, _a3, _a4, _a5)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]models.TradeRecord)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, string, int, int64) error); ok {
-		r1 = rf(_a0, _a1, _a2, _a3)
+	if rf, ok := ret.Get(1).(func(context.Context, string, int, int64, []string, bool) error); ok {
+		r1 = rf(_a0, _a1, _a2, _a3, _a4, _a5)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// HasCandlesBefore provides a mock function with given fields: _a0, _a1, _a2
+func (_m *RepoItf) HasCandlesBefore(_a0 context.Context, _a1 string, _a2 int64) (bool, error) {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	if len(ret) == 0 {
+		panic("no return value specified for HasCandlesBefore")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64) (bool, error)); ok {
+		return rf(_a0, _a1, _a2)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64) bool); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int64) error); ok {
+		r1 = rf(_a0, _a1, _a2)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdateAlertRule provides a mock function with given fields: _a0, _a1
+func (_m *RepoItf) UpdateAlertRule(_a0 context.Context, _a1 models.AlertRule) (bool, error) {
+	ret := _m.Called(_a0, _a1)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateAlertRule")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.AlertRule) (bool, error)); ok {
+		return rf(_a0, _a1)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.AlertRule) bool); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.AlertRule) error); ok {
+		r1 = rf(_a0, _a1)
 	} else {
 		r1 = ret.Error(1)
 	}
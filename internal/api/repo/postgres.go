@@ -0,0 +1,334 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"financial-data-backend-2/internal/models"
+	"financial-data-backend-2/internal/postgres"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresRepo reads trades, candles and symbol metadata from Postgres,
+// with the same results as Repo. Alert rules stay in MongoDB, where
+// go-alerts reads them, so the embedded Repo serves them.
+type PostgresRepo struct {
+	*Repo
+	db *sql.DB
+}
+
+func NewPostgresRepo(db *sql.DB, alerts *Repo) *PostgresRepo {
+	return &PostgresRepo{Repo: alerts, db: db}
+}
+
+// Columns of the symbols table that symbols can be sorted by, by the
+// models.SymbolDocument field that SymbolQuery.SortBy names
+var symbolSortColumns = map[string]string{
+	"symbol":       "symbol",
+	"tradeCount":   "trade_count",
+	"firstTradeAt": "first_trade_at",
+	"lastTradeAt":  "last_trade_at",
+	"lastPrice":    "last_price",
+}
+
+const symbolColumns = `symbol, exchange, asset_class, trade_count, first_trade_at, last_trade_at,
+	last_price, last_volume, day_date, day_open_at, day_open, day_high, day_low, day_volume, day_prev_close`
+
+func (r *PostgresRepo) GetSymbols(ctx context.Context, query models.SymbolQuery) ([]models.SymbolDocument, error) {
+	var f filter
+	if query.AssetClass != "" {
+		f.add("asset_class = %s", query.AssetClass)
+	}
+	if query.Exchange != "" {
+		f.add("exchange = %s", query.Exchange)
+	}
+
+	// Ties are ordered by symbol. Missing values come first, as with
+	// MongoDB.
+	column, ok := symbolSortColumns[query.SortBy]
+	if !ok {
+		column = "symbol"
+	}
+	order := "ASC NULLS FIRST"
+	if query.Descending {
+		order = "DESC NULLS LAST"
+	}
+
+	return r.querySymbols(ctx, `SELECT `+symbolColumns+` FROM `+postgres.SymbolsTable+
+		` WHERE `+f.where()+` ORDER BY `+column+` `+order+`, symbol ASC`, f.args...)
+}
+
+// GetSymbol returns the metadata of a symbol, or nil if it has none.
+func (r *PostgresRepo) GetSymbol(ctx context.Context, symbol string) (*models.SymbolDocument, error) {
+	docs, err := r.querySymbols(ctx, `SELECT `+symbolColumns+` FROM `+postgres.SymbolsTable+
+		` WHERE symbol = $1`, symbol)
+	if err != nil || len(docs) == 0 {
+		return nil, err
+	}
+	return &docs[0], nil
+}
+
+// GetSnapshot returns the metadata of the given symbols that have any,
+// in no particular order.
+func (r *PostgresRepo) GetSnapshot(ctx context.Context, symbols []string) ([]models.SymbolDocument, error) {
+	if len(symbols) == 0 {
+		return nil, nil
+	}
+	return r.querySymbols(ctx, `SELECT `+symbolColumns+` FROM `+postgres.SymbolsTable+
+		` WHERE symbol = ANY($1)`, pq.Array(symbols))
+}
+
+func (r *PostgresRepo) querySymbols(ctx context.Context, query string, args ...any) ([]models.SymbolDocument, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var symbols []models.SymbolDocument
+	for rows.Next() {
+		var doc models.SymbolDocument
+		var exchange, assetClass sql.NullString
+		var date sql.NullTime
+		var day models.DaySummary
+		err := rows.Scan(&doc.Symbol, &exchange, &assetClass, &doc.TradeCount,
+			postgres.Time(&doc.FirstTradeAt), postgres.Time(&doc.LastTradeAt),
+			postgres.Decimal(&doc.LastPrice), postgres.Decimal(&doc.LastVolume),
+			&date, postgres.Time(&day.OpenAt), postgres.Decimal(&day.Open), postgres.Decimal(&day.High),
+			postgres.Decimal(&day.Low), postgres.Decimal(&day.Volume), postgres.Decimal(&day.PrevClose))
+		if err != nil {
+			return nil, err
+		}
+		doc.Exchange, doc.AssetClass = exchange.String, assetClass.String
+		if date.Valid {
+			day.Date = date.Time.Format(time.DateOnly)
+			doc.Day = &day
+		}
+		symbols = append(symbols, doc)
+	}
+	return symbols, rows.Err()
+}
+
+func (r *PostgresRepo) GetTradesPerSymbol(ctx context.Context, symbol string, limit int, before int64, excludeConditions []string, excludeFlagged bool) ([]models.TradeRecord, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	var f filter
+	f.add("symbol = %s", symbol)
+	// If a 'before' cursor is provided, only trades OLDER than it.
+	if before > 0 {
+		f.add("time < %s", time.UnixMilli(before))
+	}
+	// Leave out trades carrying any of the given conditions.
+	if len(excludeConditions) > 0 {
+		f.add("NOT coalesce(conditions && %s, false)", pq.Array(excludeConditions))
+	}
+	// Leave out trades flagged as bad ticks.
+	if excludeFlagged {
+		f.add(postgres.Unflagged)
+	}
+
+	query := `SELECT message_key, symbol, time, price, volume, conditions, flags, original_time
+		FROM ` + postgres.TradesTable + ` WHERE ` + f.where() + ` ORDER BY time DESC LIMIT ` + f.param(limit)
+	rows, err := r.db.QueryContext(ctx, query, f.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var trades []models.TradeRecord
+	for rows.Next() {
+		var t models.TradeRecord
+		err := rows.Scan(&t.MessageKey, &t.Symbol, postgres.Time(&t.Time), postgres.Decimal(&t.Price),
+			postgres.Decimal(&t.Volume), pq.Array(&t.Conditions), pq.Array(&t.Flags),
+			postgres.Time(&t.OriginalTime))
+		if err != nil {
+			return nil, err
+		}
+		trades = append(trades, t)
+	}
+	return trades, rows.Err()
+}
+
+// GetCandlesPerSymbol pages through the minute candles of a symbol, newest
+// first, the same way as GetTradesPerSymbol.
+func (r *PostgresRepo) GetCandlesPerSymbol(ctx context.Context, symbol string, limit int, before int64) ([]models.Candle, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+	var f filter
+	f.add("symbol = %s", symbol)
+	if before > 0 {
+		f.add("time < %s", time.UnixMilli(before))
+	}
+	query := `SELECT time, open, high, low, close, volume, trade_count FROM ` + postgres.CandlesTable +
+		` WHERE ` + f.where() + ` ORDER BY time DESC LIMIT ` + f.param(limit)
+	return r.queryCandles(ctx, query, symbol, f.args...)
+}
+
+// HasCandlesBefore reports whether a symbol has minute candles older than
+// the given Unix millisecond time (or at all, if it is 0).
+func (r *PostgresRepo) HasCandlesBefore(ctx context.Context, symbol string, before int64) (bool, error) {
+	var f filter
+	f.add("symbol = %s", symbol)
+	if before > 0 {
+		f.add("time < %s", time.UnixMilli(before))
+	}
+	var exists bool
+	err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM `+postgres.CandlesTable+
+		` WHERE `+f.where()+`)`, f.args...).Scan(&exists)
+	return exists, err
+}
+
+// GetStatsPerSymbol aggregates a symbol's trades in [from, to) like
+// Repo.GetStatsPerSymbol, with the same decimal arithmetic and rounding.
+func (r *PostgresRepo) GetStatsPerSymbol(ctx context.Context, symbol string, from, to time.Time, excludeConditions []string) (*models.TradeStats, error) {
+	var f filter
+	f.add("symbol = %s", symbol)
+	f.add("time >= %s AND time < %s", from, to)
+	f.add(postgres.Unflagged)
+	if len(excludeConditions) > 0 {
+		f.add("NOT coalesce(conditions && %s, false)", pq.Array(excludeConditions))
+	}
+	end := to
+	if now := time.Now(); now.Before(end) {
+		end = now
+	}
+
+	query := fmt.Sprintf(`WITH ordered AS (
+		SELECT time, price, volume,
+			lead(time) OVER w AS next_time,
+			lag(price) OVER w AS prev_price
+		FROM %[1]s WHERE %[2]s
+		WINDOW w AS (ORDER BY time)
+	), weighed AS (
+		SELECT time, price, volume,
+			-- Milliseconds the price held
+			greatest(0, extract(epoch FROM coalesce(next_time, %[3]s::timestamptz) - time)::numeric * 1000) AS held,
+			CASE WHEN prev_price > 0 THEN ln(price / prev_price) END AS log_return
+		FROM ordered
+	)
+	SELECT count(*), min(time), max(time),
+		(array_agg(price ORDER BY time))[1], (array_agg(price ORDER BY time DESC))[1],
+		min(price), max(price), sum(volume),
+		-- Without volume, e.g. for some forex feeds, VWAP is the average.
+		round(CASE WHEN sum(volume) > 0 THEN sum(price * volume) / sum(volume) ELSE avg(price) END, %[4]d),
+		-- A single instant has no duration to weigh by.
+		round(CASE WHEN sum(held) > 0 THEN sum(price * held) / sum(held) ELSE avg(price) END, %[4]d),
+		round(sqrt(coalesce(sum(log_return * log_return), 0)), %[4]d)
+	FROM weighed
+	HAVING count(*) > 0`, postgres.TradesTable, f.where(), f.param(end), models.StatsScale)
+
+	var s models.TradeStats
+	err := r.db.QueryRowContext(ctx, query, f.args...).Scan(&s.TradeCount,
+		postgres.Time(&s.FirstTradeAt), postgres.Time(&s.LastTradeAt),
+		postgres.Decimal(&s.Open), postgres.Decimal(&s.Close), postgres.Decimal(&s.Low),
+		postgres.Decimal(&s.High), postgres.Decimal(&s.Volume), postgres.Decimal(&s.VWAP),
+		postgres.Decimal(&s.TWAP), postgres.Decimal(&s.Volatility))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetCandleSeries returns a symbol's candles of the given size like
+// Repo.GetCandleSeries: from the raw trades, and from the stored minute
+// candles for the minutes before the oldest raw trade.
+func (r *PostgresRepo) GetCandleSeries(ctx context.Context, symbol string, size time.Duration, from, to time.Time, excludeConditions []string) ([]models.Candle, error) {
+	// Raw trades are preferred, as they honour excludeConditions. The
+	// stored candles cover the minutes before the oldest one.
+	until := to
+	var oldest time.Time
+	err := r.db.QueryRowContext(ctx, `SELECT min(time) FROM `+postgres.TradesTable+
+		` WHERE symbol = $1 AND time >= $2 AND time < $3`, symbol, from, to).Scan(postgres.Time(&oldest))
+	if err != nil {
+		return nil, err
+	}
+	if !oldest.IsZero() {
+		until = oldest.Truncate(time.Minute)
+	}
+
+	var f filter
+	f.add("symbol = %s", symbol)
+	stored := f.param(from)
+	rawFrom := f.param(until)
+	raw := []string{"symbol = $1", "time >= " + rawFrom, "time < " + f.param(to), postgres.Unflagged}
+	if len(excludeConditions) > 0 {
+		raw = append(raw, "NOT coalesce(conditions && "+f.param(pq.Array(excludeConditions))+", false)")
+	}
+	bucket := f.param(fmt.Sprintf("%d seconds", int64(size/time.Second)))
+
+	// Buckets start at multiples of size since 2000-01-01 UTC, as with
+	// MongoDB's $dateTrunc.
+	query := fmt.Sprintf(`WITH minutes AS (
+		SELECT time, open, high, low, close, volume, trade_count FROM %[1]s
+		WHERE symbol = $1 AND time >= %[3]s AND time < %[4]s
+		UNION ALL
+		SELECT date_bin('1 minute', time, TIMESTAMPTZ '2000-01-01 00:00:00Z'),
+			(array_agg(price ORDER BY time))[1], max(price), min(price),
+			(array_agg(price ORDER BY time DESC))[1], sum(volume), count(*)
+		FROM %[2]s WHERE %[5]s
+		GROUP BY 1
+	)
+	SELECT date_bin(%[6]s::interval, time, TIMESTAMPTZ '2000-01-01 00:00:00Z') AS bucket,
+		(array_agg(open ORDER BY time))[1], max(high), min(low),
+		(array_agg(close ORDER BY time DESC))[1], sum(volume), sum(trade_count)
+	FROM minutes GROUP BY bucket ORDER BY bucket`,
+		postgres.CandlesTable, postgres.TradesTable, stored, rawFrom, strings.Join(raw, " AND "), bucket)
+	return r.queryCandles(ctx, query, symbol, f.args...)
+}
+
+func (r *PostgresRepo) queryCandles(ctx context.Context, query, symbol string, args ...any) ([]models.Candle, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candles []models.Candle
+	for rows.Next() {
+		c := models.Candle{Symbol: symbol}
+		err := rows.Scan(postgres.Time(&c.Time), postgres.Decimal(&c.Open), postgres.Decimal(&c.High),
+			postgres.Decimal(&c.Low), postgres.Decimal(&c.Close), postgres.Decimal(&c.Volume), &c.TradeCount)
+		if err != nil {
+			return nil, err
+		}
+		candles = append(candles, c)
+	}
+	return candles, rows.Err()
+}
+
+// filter builds a WHERE clause and numbers its parameters.
+type filter struct {
+	conds []string
+	args  []any
+}
+
+// add adds a condition, in which each %s stands for the next of args.
+func (f *filter) add(cond string, args ...any) {
+	refs := make([]any, len(args))
+	for i, arg := range args {
+		refs[i] = f.param(arg)
+	}
+	f.conds = append(f.conds, fmt.Sprintf(cond, refs...))
+}
+
+// param adds a parameter and returns its reference, e.g. "$3".
+func (f *filter) param(arg any) string {
+	f.args = append(f.args, arg)
+	return fmt.Sprintf("$%d", len(f.args))
+}
+
+func (f *filter) where() string {
+	if len(f.conds) == 0 {
+		return "TRUE"
+	}
+	return strings.Join(f.conds, " AND ")
+}
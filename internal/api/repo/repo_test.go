@@ -2,8 +2,10 @@ package repo
 
 import (
 	"context"
+	"database/sql"
 	"financial-data-backend-2/internal/models"
 	mongoGo "financial-data-backend-2/internal/mongo"
+	"financial-data-backend-2/internal/postgres"
 	"fmt"
 	"log"
 	"os"
@@ -11,6 +13,7 @@ import (
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -21,11 +24,21 @@ var (
 	databaseName          string = "financialDataRepoTest"
 	symbolsCollectionName string = "symbols"
 	tradesCollectionName  string = "finnhub_trades"
+	candlesCollectionName string = "finnhub_trades_candles_1m"
+	alertRulesName        string = "alert_rules"
+	alertDeliveriesName   string = "alert_deliveries"
+	dataGapsName          string = "data_gaps"
 	testSymbol            string = "TEST"
 
 	testRepo             *Repo
 	testSymbolCollection *mongo.Collection
 	testTradeCollection  *mongo.Collection
+	testCandleCollection *mongo.Collection
+	testAlertDeliveries  *mongo.Collection
+	testDataGaps         *mongo.Collection
+
+	// The storage backends the market data tests run against
+	testBackends []testBackend
 
 	// We'll create 20 trades, 1 second apart, with the most recent being 'now'.
 	mockTradeData []any = make([]any, 20)
@@ -49,7 +62,25 @@ func TestMain(m *testing.M) {
 
 	testSymbolCollection = testDbClient.Database(databaseName).Collection(symbolsCollectionName)
 	testTradeCollection = testDbClient.Database(databaseName).Collection(tradesCollectionName)
-	testRepo = NewRepo(testSymbolCollection, testTradeCollection)
+	testCandleCollection = testDbClient.Database(databaseName).Collection(candlesCollectionName)
+	testAlertDeliveries = testDbClient.Database(databaseName).Collection(alertDeliveriesName)
+	testDataGaps = testDbClient.Database(databaseName).Collection(dataGapsName)
+	testRepo = NewRepo(testSymbolCollection, testTradeCollection, testCandleCollection,
+		testDbClient.Database(databaseName).Collection(alertRulesName), testAlertDeliveries, testDataGaps)
+	testBackends = append(testBackends, mongoBackend())
+
+	// Setup a Postgres schema, with its tables, and repo, if there is a
+	// Postgres to test against
+	var testPostgres *sql.DB
+	if postgresUrl := os.Getenv("POSTGRES_URL_TEST"); postgresUrl != "" {
+		testPostgres, err = setupPostgres(postgresUrl)
+		if err != nil {
+			log.Fatalf("Failed to setup Postgres: %v", err)
+		}
+		testBackends = append(testBackends, postgresBackend(testPostgres))
+	} else {
+		log.Println("POSTGRES_URL_TEST is not set. Skipping the Postgres backend.")
+	}
 
 	// Create our mock data
 	now = time.Now().UTC().Truncate(time.Millisecond)
@@ -65,6 +96,14 @@ func TestMain(m *testing.M) {
 			Volume:     volume,
 		}
 	}
+	// The most recent trade is an odd lot.
+	oddLot := mockTradeData[0].(models.TradeRecord)
+	oddLot.Conditions = []string{"I"}
+	mockTradeData[0] = oddLot
+	// The one before is a bad tick.
+	badTick := mockTradeData[1].(models.TradeRecord)
+	badTick.Flags = []string{models.FlagPriceOutlier}
+	mockTradeData[1] = badTick
 
 	// 2. RUN THE TESTS
 	exitCode := m.Run()
@@ -81,13 +120,167 @@ func TestMain(m *testing.M) {
 		log.Fatalf("Error during MongoDB disconnect: %v", err)
 	}
 	log.Println("MongoDB client disconnected.")
+	if testPostgres != nil {
+		if _, err := testPostgres.ExecContext(ctx, `DROP SCHEMA `+postgresSchema+` CASCADE`); err != nil {
+			log.Fatalf("Error when tearing down test schema: %v", err)
+		}
+		testPostgres.Close()
+		log.Println("Test schema torn.")
+	}
 
 	os.Exit(exitCode)
 }
+
+// testBackend is a storage backend the market data tests run against:
+// its repo, and how to replace the stored symbols, trades or candles.
+type testBackend struct {
+	name       string
+	repo       RepoItf
+	setSymbols func(context.Context, []any) error
+	setTrades  func(context.Context, []any) error
+	setCandles func(context.Context, []any) error
+}
+
+// forEachBackend runs a test against every storage backend.
+func forEachBackend(t *testing.T, test func(t *testing.T, b testBackend)) {
+	for _, b := range testBackends {
+		t.Run(b.name, func(t *testing.T) { test(t, b) })
+	}
+}
+
+func mongoBackend() testBackend {
+	set := func(c *mongo.Collection) func(context.Context, []any) error {
+		return func(ctx context.Context, docs []any) error {
+			if _, err := c.DeleteMany(ctx, bson.M{}); err != nil {
+				return err
+			}
+			if len(docs) == 0 {
+				return nil
+			}
+			_, err := c.InsertMany(ctx, docs)
+			return err
+		}
+	}
+	return testBackend{
+		name:       "mongodb",
+		repo:       testRepo,
+		setSymbols: set(testSymbolCollection),
+		setTrades:  set(testTradeCollection),
+		setCandles: set(testCandleCollection),
+	}
+}
+
+// Postgres schema the tests create their tables in
+const postgresSchema = "financial_data_repo_test"
+
+// setupPostgres creates a fresh test schema, with the tables of every
+// migration, and connects to it.
+func setupPostgres(postgresUrl string) (*sql.DB, error) {
+	db, err := postgres.ConnectDB(postgresUrl, 15*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	_, err = db.ExecContext(ctx, `DROP SCHEMA IF EXISTS `+postgresSchema+` CASCADE; CREATE SCHEMA `+postgresSchema)
+	db.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	schemaUrl, err := postgres.InSchema(postgresUrl, postgresSchema)
+	if err != nil {
+		return nil, err
+	}
+	db, err = postgres.ConnectDB(schemaUrl, 15*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	migrator, err := postgres.NewMigrator(db, postgres.Migrations())
+	if err != nil {
+		return nil, err
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func postgresBackend(db *sql.DB) testBackend {
+	// Alert rules are read from MongoDB with either backend.
+	return testBackend{
+		name: "postgres",
+		repo: NewPostgresRepo(db, testRepo),
+		setSymbols: func(ctx context.Context, docs []any) error {
+			if _, err := db.ExecContext(ctx, `DELETE FROM `+postgres.SymbolsTable); err != nil {
+				return err
+			}
+			for _, d := range docs {
+				doc := d.(models.SymbolDocument)
+				day := models.DaySummary{}
+				var date any
+				if doc.Day != nil {
+					day, date = *doc.Day, doc.Day.Date
+				}
+				_, err := db.ExecContext(ctx, `INSERT INTO `+postgres.SymbolsTable+` (`+symbolColumns+`)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+					doc.Symbol, doc.Exchange, doc.AssetClass, doc.TradeCount,
+					postgres.TimeArg(doc.FirstTradeAt), postgres.TimeArg(doc.LastTradeAt),
+					postgres.DecimalArg(doc.LastPrice), postgres.DecimalArg(doc.LastVolume),
+					date, postgres.TimeArg(day.OpenAt), postgres.DecimalArg(day.Open),
+					postgres.DecimalArg(day.High), postgres.DecimalArg(day.Low),
+					postgres.DecimalArg(day.Volume), postgres.DecimalArg(day.PrevClose))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		setTrades: func(ctx context.Context, docs []any) error {
+			if _, err := db.ExecContext(ctx, `DELETE FROM `+postgres.TradesTable); err != nil {
+				return err
+			}
+			for _, d := range docs {
+				t := d.(models.TradeRecord)
+				if err := postgres.EnsurePartition(ctx, db, t.Time); err != nil {
+					return err
+				}
+				_, err := db.ExecContext(ctx, `INSERT INTO `+postgres.TradesTable+`
+					(message_key, symbol, time, price, volume, conditions, flags, original_time)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+					t.MessageKey, t.Symbol, t.Time, postgres.DecimalArg(t.Price), postgres.DecimalArg(t.Volume),
+					pq.Array(t.Conditions), pq.Array(t.Flags), postgres.TimeArg(t.OriginalTime))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		setCandles: func(ctx context.Context, docs []any) error {
+			if _, err := db.ExecContext(ctx, `DELETE FROM `+postgres.CandlesTable); err != nil {
+				return err
+			}
+			for _, d := range docs {
+				c := d.(models.Candle)
+				_, err := db.ExecContext(ctx, `INSERT INTO `+postgres.CandlesTable+`
+					(symbol, time, open, high, low, close, volume, trade_count)
+					VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+					c.Symbol, c.Time, postgres.DecimalArg(c.Open), postgres.DecimalArg(c.High),
+					postgres.DecimalArg(c.Low), postgres.DecimalArg(c.Close), postgres.DecimalArg(c.Volume),
+					c.TradeCount)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
 func TestGetSymbols(t *testing.T) {
 	testCases := []struct {
 		name                string
 		collectionInput     func() []any
+		query               models.SymbolQuery
 		expectedNumSymbols  int
 		expectedFirstSymbol string
 	}{
@@ -129,47 +322,110 @@ func TestGetSymbols(t *testing.T) {
 			expectedNumSymbols:  3,
 			expectedFirstSymbol: "M",
 		},
+		{
+			name: "filtered by asset class and sorted by trade count, descending",
+			collectionInput: func() []any {
+				return []any{
+					models.SymbolDocument{Symbol: "AAPL", AssetClass: models.AssetClassStock, TradeCount: 50},
+					models.SymbolDocument{Symbol: "BINANCE:BTCUSDT", AssetClass: models.AssetClassCrypto, TradeCount: 5},
+					models.SymbolDocument{Symbol: "BINANCE:ETHUSDT", AssetClass: models.AssetClassCrypto, TradeCount: 10},
+				}
+			},
+			query:               models.SymbolQuery{AssetClass: models.AssetClassCrypto, SortBy: "tradeCount", Descending: true},
+			expectedNumSymbols:  2,
+			expectedFirstSymbol: "BINANCE:ETHUSDT",
+		},
 	}
 
-	for _, tt := range testCases {
-		t.Run(tt.name, func(t *testing.T) {
-			// given
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			_, err := testSymbolCollection.DeleteMany(ctx, bson.M{})
-			assert.NoError(t, err)
-			_, err = testSymbolCollection.InsertMany(ctx, tt.collectionInput())
-			if tt.collectionInput() != nil && len(tt.collectionInput()) > 0 {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		for _, tt := range testCases {
+			t.Run(tt.name, func(t *testing.T) {
+				// given
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				assert.NoError(t, b.setSymbols(ctx, tt.collectionInput()))
+
+				// when
+				symbols, err := b.repo.GetSymbols(context.Background(), tt.query)
+
+				// then
 				assert.NoError(t, err)
-			} else {
-				assert.Error(t, err)
-			}
+				if tt.collectionInput() != nil && len(tt.collectionInput()) > 0 {
+					assert.NotNil(t, symbols)
+				} else {
+					assert.Nil(t, symbols)
+				}
+				assert.Len(t, symbols, tt.expectedNumSymbols)
 
-			// when
-			symbols, err := testRepo.GetSymbols(context.Background())
+				// If we expect results, check the first one to verify sorting
+				if tt.expectedNumSymbols > 0 {
+					assert.Equal(t, tt.expectedFirstSymbol, symbols[0].Symbol)
+				}
+			})
+		}
+	})
+}
 
-			// then
-			assert.NoError(t, err)
-			if tt.collectionInput() != nil && len(tt.collectionInput()) > 0 {
-				assert.NotNil(t, symbols)
-			} else {
-				assert.Nil(t, symbols)
-			}
-			assert.Len(t, symbols, tt.expectedNumSymbols)
+func TestGetSymbol(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := b.setSymbols(ctx, []any{models.SymbolDocument{
+			Symbol:     "OANDA:EUR_USD",
+			Exchange:   "OANDA",
+			AssetClass: models.AssetClassForex,
+			TradeCount: 3,
+			Day:        &models.DaySummary{Date: "2025-11-20"},
+		}})
+		assert.NoError(t, err)
 
-			// If we expect results, check the first one to verify sorting
-			if tt.expectedNumSymbols > 0 {
-				assert.Equal(t, tt.expectedFirstSymbol, symbols[0].Symbol)
-			}
+		symbol, err := b.repo.GetSymbol(ctx, "OANDA:EUR_USD")
+		assert.NoError(t, err)
+		if assert.NotNil(t, symbol) {
+			assert.Equal(t, "OANDA", symbol.Exchange)
+			assert.Equal(t, int64(3), symbol.TradeCount)
+			assert.Equal(t, "2025-11-20", symbol.Day.Date)
+		}
+
+		symbol, err = b.repo.GetSymbol(ctx, "MISSING")
+		assert.NoError(t, err)
+		assert.Nil(t, symbol)
+	})
+}
+
+func TestGetSnapshot(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := b.setSymbols(ctx, []any{
+			models.SymbolDocument{Symbol: "AAPL"},
+			models.SymbolDocument{Symbol: "MSFT"},
+			models.SymbolDocument{Symbol: "TSLA"},
 		})
-	}
+		assert.NoError(t, err)
+
+		docs, err := b.repo.GetSnapshot(ctx, []string{"TSLA", "AAPL", "NOPE"})
+		assert.NoError(t, err)
+		var symbols []string
+		for _, doc := range docs {
+			symbols = append(symbols, doc.Symbol)
+		}
+		assert.ElementsMatch(t, []string{"AAPL", "TSLA"}, symbols)
+
+		docs, err = b.repo.GetSnapshot(ctx, nil)
+		assert.NoError(t, err)
+		assert.Empty(t, docs)
+	})
 }
+
 func TestGetTradesPerSymbol(t *testing.T) {
 	testCases := []struct {
 		name                   string
 		symbol                 string
 		limit                  int
 		before                 int64 // UnixMilli timestamp
+		excludeConditions      []string
+		excludeFlagged         bool
 		expectedNumTrades      int
 		expectedFirstTradeTime time.Time
 	}{
@@ -197,6 +453,25 @@ func TestGetTradesPerSymbol(t *testing.T) {
 			expectedNumTrades:      5,                                      // Should only get the remaining 5
 			expectedFirstTradeTime: now.Add(-15 * time.Second),
 		},
+		{
+			name:                   "Excluded conditions are left out",
+			symbol:                 testSymbol,
+			limit:                  10,
+			before:                 0,
+			excludeConditions:      []string{"I", "Z"},
+			expectedNumTrades:      10,
+			expectedFirstTradeTime: now.Add(-1 * time.Second), // Skips the odd lot
+		},
+		{
+			name:                   "Flagged trades are left out",
+			symbol:                 testSymbol,
+			limit:                  10,
+			before:                 0,
+			excludeConditions:      []string{"I"},
+			excludeFlagged:         true,
+			expectedNumTrades:      10,
+			expectedFirstTradeTime: now.Add(-2 * time.Second), // Skips the odd lot and the bad tick
+		},
 		{
 			name:              "Non-existent symbol returns empty slice",
 			symbol:            "NOSYMBOL",
@@ -220,32 +495,368 @@ func TestGetTradesPerSymbol(t *testing.T) {
 		},
 	}
 
-	for _, tt := range testCases {
-		t.Run(tt.name, func(t *testing.T) {
-			// given
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			_, err := testTradeCollection.DeleteMany(ctx, bson.M{})
-			assert.NoError(t, err)
-			_, err = testTradeCollection.InsertMany(ctx, mockTradeData)
-			assert.NoError(t, err)
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		for _, tt := range testCases {
+			t.Run(tt.name, func(t *testing.T) {
+				// given
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				assert.NoError(t, b.setTrades(ctx, mockTradeData))
 
-			// ACT
-			trades, err := testRepo.GetTradesPerSymbol(context.Background(), tt.symbol, tt.limit, tt.before)
+				// ACT
+				trades, err := b.repo.GetTradesPerSymbol(context.Background(), tt.symbol, tt.limit, tt.before, tt.excludeConditions, tt.excludeFlagged)
 
-			// ASSERT
-			assert.NoError(t, err)
-			if tt.symbol == testSymbol && tt.expectedNumTrades > 0 {
-				assert.NotNil(t, trades)
-			} else {
-				assert.Nil(t, trades)
-			}
-			assert.Len(t, trades, tt.expectedNumTrades)
+				// ASSERT
+				assert.NoError(t, err)
+				if tt.symbol == testSymbol && tt.expectedNumTrades > 0 {
+					assert.NotNil(t, trades)
+				} else {
+					assert.Nil(t, trades)
+				}
+				assert.Len(t, trades, tt.expectedNumTrades)
 
-			// If we expect results, check the first one to verify sorting
-			if tt.expectedNumTrades > 0 {
-				assert.Equal(t, tt.expectedFirstTradeTime, trades[0].Time)
-			}
+				// If we expect results, check the first one to verify sorting
+				if tt.expectedNumTrades > 0 {
+					assert.Equal(t, tt.expectedFirstTradeTime, trades[0].Time)
+				}
+			})
+		}
+	})
+}
+
+func TestGetCandlesPerSymbol(t *testing.T) {
+	// 5 candles, 1 minute apart, the newest starting an hour ago
+	newest := now.Add(-time.Hour).Truncate(time.Minute)
+	price, _ := primitive.ParseDecimal128("100.0")
+	volume, _ := primitive.ParseDecimal128("10")
+	candles := make([]any, 5)
+	for i := range candles {
+		candles[i] = models.Candle{
+			Symbol: testSymbol, Time: newest.Add(time.Duration(-i) * time.Minute),
+			Open: price, High: price, Low: price, Close: price, Volume: volume, TradeCount: 1,
+		}
+	}
+
+	testCases := []struct {
+		name                    string
+		symbol                  string
+		limit                   int
+		before                  int64
+		expectedNumCandles      int
+		expectedFirstCandleTime time.Time
+		expectedHasCandles      bool
+	}{
+		{
+			name:                    "Get first page",
+			symbol:                  testSymbol,
+			limit:                   3,
+			expectedNumCandles:      3,
+			expectedFirstCandleTime: newest,
+			expectedHasCandles:      true,
+		},
+		{
+			name:                    "Get last page using cursor",
+			symbol:                  testSymbol,
+			limit:                   3,
+			before:                  newest.Add(-2 * time.Minute).UnixMilli(),
+			expectedNumCandles:      2,
+			expectedFirstCandleTime: newest.Add(-3 * time.Minute),
+			expectedHasCandles:      true,
+		},
+		{
+			name:               "No candles before the oldest",
+			symbol:             testSymbol,
+			limit:              3,
+			before:             newest.Add(-4 * time.Minute).UnixMilli(),
+			expectedNumCandles: 0,
+			expectedHasCandles: false,
+		},
+		{
+			name:               "Non-existent symbol",
+			symbol:             "NOSYMBOL",
+			limit:              3,
+			expectedNumCandles: 0,
+			expectedHasCandles: false,
+		},
+	}
+
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		for _, tt := range testCases {
+			t.Run(tt.name, func(t *testing.T) {
+				// given
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				assert.NoError(t, b.setCandles(ctx, candles))
+
+				// ACT
+				got, err := b.repo.GetCandlesPerSymbol(context.Background(), tt.symbol, tt.limit, tt.before)
+				assert.NoError(t, err)
+				hasCandles, err := b.repo.HasCandlesBefore(context.Background(), tt.symbol, tt.before)
+				assert.NoError(t, err)
+
+				// ASSERT
+				assert.Len(t, got, tt.expectedNumCandles)
+				if tt.expectedNumCandles > 0 {
+					assert.Equal(t, tt.expectedFirstCandleTime, got[0].Time)
+				}
+				assert.Equal(t, tt.expectedHasCandles, hasCandles)
+			})
+		}
+	})
+}
+
+func TestGetStatsPerSymbol(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+	trade := func(i int, after time.Duration, price, volume string, conditions ...string) any {
+		p, _ := primitive.ParseDecimal128(price)
+		v, _ := primitive.ParseDecimal128(volume)
+		return models.TradeRecord{
+			Id:         primitive.NewObjectID(),
+			MessageKey: fmt.Sprintf("stats-key-%d", i),
+			Symbol:     "STATS",
+			Time:       start.Add(after),
+			Price:      p,
+			Volume:     v,
+			Conditions: conditions,
+		}
+	}
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		err := b.setTrades(ctx, []any{
+			trade(0, 0, "10", "1"),
+			trade(1, time.Second, "20", "3"),
+			trade(2, 2*time.Second, "1000", "50", "I"), // excluded odd lot
+			trade(3, 3*time.Second, "15", "2"),
+			trade(4, time.Hour, "99", "1"), // outside the window
 		})
+		assert.NoError(t, err)
+
+		// when
+		stats, err := b.repo.GetStatsPerSymbol(ctx, "STATS", start, start.Add(4*time.Second), []string{"I"})
+
+		// then
+		assert.NoError(t, err)
+		if assert.NotNil(t, stats) {
+			assert.Equal(t, int64(3), stats.TradeCount)
+			assert.True(t, stats.FirstTradeAt.Equal(start))
+			assert.True(t, stats.LastTradeAt.Equal(start.Add(3*time.Second)))
+			assert.Equal(t, "10", stats.Open.String())
+			assert.Equal(t, "15", stats.Close.String())
+			assert.Equal(t, "10", stats.Low.String())
+			assert.Equal(t, "20", stats.High.String())
+			assert.Equal(t, "6", stats.Volume.String())
+			// 100 / 6
+			assert.Equal(t, "16.6666666667", stats.VWAP.String())
+			// (10*1s + 20*2s + 15*1s) / 4s
+			assert.Equal(t, "16.2500000000", stats.TWAP.String())
+			// sqrt(ln(20/10)^2 + ln(15/20)^2)
+			assert.Equal(t, "0.7504758415", stats.Volatility.String())
+		}
+
+		stats, err = b.repo.GetStatsPerSymbol(ctx, "STATS", start.Add(-time.Hour), start, nil)
+		assert.NoError(t, err)
+		assert.Nil(t, stats)
+	})
+}
+
+func TestGetCandleSeries(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Date(2025, 11, 20, 14, 0, 0, 0, time.UTC)
+	dec := func(s string) primitive.Decimal128 {
+		d, _ := primitive.ParseDecimal128(s)
+		return d
 	}
+	trade := func(i int, after time.Duration, price string, conditions ...string) any {
+		return models.TradeRecord{
+			Id:         primitive.NewObjectID(),
+			MessageKey: fmt.Sprintf("series-key-%d", i),
+			Symbol:     "SERIES",
+			Time:       start.Add(after),
+			Price:      dec(price),
+			Volume:     dec("1"),
+			Conditions: conditions,
+		}
+	}
+	candle := func(after time.Duration, open, high, low, close string) any {
+		return models.Candle{
+			Symbol: "SERIES", Time: start.Add(after),
+			Open: dec(open), High: dec(high), Low: dec(low), Close: dec(close), Volume: dec("2"), TradeCount: 2,
+		}
+	}
+
+	forEachBackend(t, func(t *testing.T, b testBackend) {
+		// Minute candles for 14:00-14:06, whose trades have expired, and raw
+		// trades from 14:07.
+		err := b.setCandles(ctx, []any{
+			candle(0, "10", "12", "9", "11"),
+			candle(4*time.Minute, "11", "15", "11", "14"),
+			candle(6*time.Minute, "14", "14", "8", "8"),
+			candle(7*time.Minute, "99", "99", "99", "99"), // superseded by the raw trades
+		})
+		assert.NoError(t, err)
+		err = b.setTrades(ctx, []any{
+			trade(0, 7*time.Minute, "8"),
+			trade(1, 8*time.Minute, "1000", "I"), // excluded odd lot
+			trade(2, 9*time.Minute, "9"),
+			trade(3, 16*time.Minute, "20"), // outside the window
+		})
+		assert.NoError(t, err)
+
+		// when
+		got, err := b.repo.GetCandleSeries(ctx, "SERIES", 5*time.Minute, start, start.Add(15*time.Minute), []string{"I"})
+
+		// then
+		assert.NoError(t, err)
+		if assert.Len(t, got, 2) {
+			assert.True(t, got[0].Time.Equal(start))
+			assert.Equal(t, "10", got[0].Open.String())
+			assert.Equal(t, "15", got[0].High.String())
+			assert.Equal(t, "14", got[0].Close.String())
+			assert.Equal(t, int64(4), got[0].TradeCount)
+
+			// The 14:06 minute candle and the raw trades share a bucket.
+			assert.True(t, got[1].Time.Equal(start.Add(5*time.Minute)))
+			assert.Equal(t, "14", got[1].Open.String())
+			assert.Equal(t, "14", got[1].High.String())
+			assert.Equal(t, "8", got[1].Low.String())
+			assert.Equal(t, "9", got[1].Close.String())
+			assert.Equal(t, "4", got[1].Volume.String())
+			assert.Equal(t, int64(4), got[1].TradeCount)
+		}
+	})
+}
+
+func TestAlertRules(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	price, _ := primitive.ParseDecimal128("200")
+	rule := models.AlertRule{
+		Id: primitive.NewObjectID(), Symbol: "AAPL", Type: models.AlertPriceCross, Direction: models.DirectionUp,
+		Price: price, WebhookURL: "http://localhost:9000", Secret: "s3cr3t", Enabled: true,
+		CreatedAt: now, UpdatedAt: now,
+	}
+	other := models.AlertRule{
+		Id: primitive.NewObjectID(), Symbol: "MSFT", Type: models.AlertVolumeSpike, Multiplier: 3, WindowMinutes: 5,
+		WebhookURL: "http://localhost:9000", Secret: "s3cr3t", CreatedAt: now, UpdatedAt: now,
+	}
+	assert.NoError(t, testRepo.CreateAlertRule(ctx, rule))
+	assert.NoError(t, testRepo.CreateAlertRule(ctx, other))
+
+	// read
+	got, err := testRepo.GetAlertRule(ctx, rule.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, &rule, got)
+	rules, err := testRepo.GetAlertRules(ctx, "")
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+	rules, err = testRepo.GetAlertRules(ctx, "MSFT")
+	assert.NoError(t, err)
+	if assert.Len(t, rules, 1) {
+		assert.Equal(t, other.Id, rules[0].Id)
+	}
+
+	// update
+	rule.Enabled = false
+	updated, err := testRepo.UpdateAlertRule(ctx, rule)
+	assert.NoError(t, err)
+	assert.True(t, updated)
+	got, err = testRepo.GetAlertRule(ctx, rule.Id)
+	assert.NoError(t, err)
+	assert.False(t, got.Enabled)
+	updated, err = testRepo.UpdateAlertRule(ctx, models.AlertRule{Id: primitive.NewObjectID()})
+	assert.NoError(t, err)
+	assert.False(t, updated)
+
+	// delete
+	deleted, err := testRepo.DeleteAlertRule(ctx, rule.Id)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+	got, err = testRepo.GetAlertRule(ctx, rule.Id)
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+	deleted, err = testRepo.DeleteAlertRule(ctx, rule.Id)
+	assert.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+func TestGetAlertDeliveries(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ruleId := primitive.NewObjectID()
+	delivery := func(after time.Duration, rule primitive.ObjectID) any {
+		return models.AlertDelivery{
+			Id: primitive.NewObjectID(), RuleId: rule, Symbol: "AAPL", Status: models.DeliveryDelivered,
+			Attempts: 1, ResponseStatus: 200, Payload: "{}", TriggeredAt: now.Add(after), CompletedAt: now.Add(after),
+		}
+	}
+	_, err := testAlertDeliveries.InsertMany(ctx, []any{
+		delivery(0, ruleId),
+		delivery(time.Minute, ruleId),
+		delivery(2*time.Minute, ruleId),
+		delivery(3*time.Minute, primitive.NewObjectID()), // another rule
+	})
+	assert.NoError(t, err)
+
+	got, err := testRepo.GetAlertDeliveries(ctx, ruleId, 2)
+	assert.NoError(t, err)
+	if assert.Len(t, got, 2) {
+		assert.True(t, got[0].TriggeredAt.Equal(now.Add(2*time.Minute)))
+		assert.True(t, got[1].TriggeredAt.Equal(now.Add(time.Minute)))
+	}
+}
+
+func TestGetGaps(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	gap := func(symbol string, from, to time.Duration) any {
+		return models.DataGap{Id: primitive.NewObjectID(), Symbol: symbol, From: now.Add(from), To: now.Add(to),
+			DetectedAt: now, UpdatedAt: now}
+	}
+	_, err := testDataGaps.InsertMany(ctx, []any{
+		gap("AAPL", -3*time.Hour, -2*time.Hour),
+		gap("AAPL", -time.Hour, -30*time.Minute),
+		gap("MSFT", -90*time.Minute, -45*time.Minute),
+		gap("NVDA", -time.Hour, -50*time.Minute),
+		gap("AAPL", time.Hour, 2*time.Hour),
+	})
+	assert.NoError(t, err)
+	from := now.Add(-2*time.Hour - time.Minute)
+
+	// Gaps overlapping the window, even partly, oldest first, then by symbol
+	got, err := testRepo.GetGaps(ctx, "", from, now, 10, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, got, 4) {
+		assert.Equal(t, "AAPL", got[0].Symbol)
+		assert.Equal(t, "MSFT", got[1].Symbol)
+		assert.True(t, got[2].From.Equal(now.Add(-time.Hour)))
+		assert.Equal(t, "AAPL", got[2].Symbol)
+		assert.Equal(t, "NVDA", got[3].Symbol)
+	}
+
+	// Pages continue after the last gap, even one starting with the next
+	page, err := testRepo.GetGaps(ctx, "", from, now, 3, nil)
+	assert.NoError(t, err)
+	assert.Len(t, page, 3)
+	last := page[len(page)-1]
+	page, err = testRepo.GetGaps(ctx, "", from, now, 3, &models.GapCursor{From: last.From, Symbol: last.Symbol})
+	assert.NoError(t, err)
+	if assert.Len(t, page, 1) {
+		assert.Equal(t, "NVDA", page[0].Symbol)
+	}
+
+	got, err = testRepo.GetGaps(ctx, "MSFT", from, now, 10, nil)
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+
+	// The window ends where the gap starts
+	got, err = testRepo.GetGaps(ctx, "AAPL", now, now.Add(time.Hour), 10, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, got)
 }
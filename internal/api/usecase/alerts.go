@@ -0,0 +1,72 @@
+package usecase
+
+import (
+	"context"
+	"financial-data-backend-2/internal/alerts"
+	"financial-data-backend-2/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func (uc *Usecase) GetAlertRules(ctx context.Context, symbol string) ([]models.AlertRule, error) {
+	// repo
+	return uc.rp.GetAlertRules(ctx, symbol)
+}
+
+func (uc *Usecase) GetAlertRule(ctx context.Context, id primitive.ObjectID) (*models.AlertRule, error) {
+	// repo
+	return uc.rp.GetAlertRule(ctx, id)
+}
+
+// CreateAlertRule stores a validated rule, generating its signing secret
+// unless one was given.
+func (uc *Usecase) CreateAlertRule(ctx context.Context, rule models.AlertRule) (*models.AlertRule, error) {
+	if rule.Secret == "" {
+		secret, err := alerts.NewSecret()
+		if err != nil {
+			return nil, err
+		}
+		rule.Secret = secret
+	}
+	rule.Id = primitive.NewObjectID()
+	rule.CreatedAt = uc.now().UTC().Truncate(time.Millisecond)
+	rule.UpdatedAt = rule.CreatedAt
+
+	// repo
+	if err := uc.rp.CreateAlertRule(ctx, rule); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// UpdateAlertRule replaces a validated rule, keeping its secret unless a
+// new one was given. It returns nil if there is no rule with the id.
+func (uc *Usecase) UpdateAlertRule(ctx context.Context, rule models.AlertRule) (*models.AlertRule, error) {
+	// repo
+	existing, err := uc.rp.GetAlertRule(ctx, rule.Id)
+	if err != nil || existing == nil {
+		return nil, err
+	}
+	if rule.Secret == "" {
+		rule.Secret = existing.Secret
+	}
+	rule.CreatedAt = existing.CreatedAt
+	rule.UpdatedAt = uc.now().UTC().Truncate(time.Millisecond)
+
+	updated, err := uc.rp.UpdateAlertRule(ctx, rule)
+	if err != nil || !updated {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (uc *Usecase) DeleteAlertRule(ctx context.Context, id primitive.ObjectID) (bool, error) {
+	// repo
+	return uc.rp.DeleteAlertRule(ctx, id)
+}
+
+func (uc *Usecase) GetAlertDeliveries(ctx context.Context, ruleId primitive.ObjectID, limit int) ([]models.AlertDelivery, error) {
+	// repo
+	return uc.rp.GetAlertDeliveries(ctx, ruleId, limit)
+}
@@ -0,0 +1,12 @@
+package usecase
+
+import (
+	"context"
+	"financial-data-backend-2/internal/models"
+	"time"
+)
+
+func (uc *Usecase) GetGaps(ctx context.Context, symbol string, from, to time.Time, limit int, after *models.GapCursor) ([]models.DataGap, error) {
+	// repo
+	return uc.rp.GetGaps(ctx, symbol, from, to, limit, after)
+}
@@ -3,29 +3,137 @@ package usecase
 import (
 	"context"
 	"financial-data-backend-2/internal/api/repo"
+	"financial-data-backend-2/internal/indicators"
 	"financial-data-backend-2/internal/models"
+	"math"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 //go:generate mockery --name UsecaseItf --case underscore --keeptree
 type UsecaseItf interface {
-	GetSymbols(context.Context) ([]models.SymbolDocument, error)
-	GetTradesPerSymbol(context.Context, string, int, int64) ([]models.TradeRecord, error)
+	GetSymbols(context.Context, models.SymbolQuery) ([]models.SymbolDocument, error)
+	GetSymbol(context.Context, string) (*models.SymbolDocument, error)
+	GetSnapshot(context.Context, []string) ([]models.SymbolDocument, error)
+	GetTradesPerSymbol(context.Context, string, int, int64, []string, bool) ([]models.TradeRecord, error)
+	GetCandlesPerSymbol(context.Context, string, int, int64) ([]models.Candle, error)
+	HasCandlesBefore(context.Context, string, int64) (bool, error)
+	GetStatsPerSymbol(context.Context, string, time.Time, time.Time, []string) (*models.TradeStats, error)
+	GetIndicator(context.Context, string, indicators.Spec, time.Duration, int, []string) ([]indicators.Point, error)
+	GetAlertRules(context.Context, string) ([]models.AlertRule, error)
+	GetAlertRule(context.Context, primitive.ObjectID) (*models.AlertRule, error)
+	CreateAlertRule(context.Context, models.AlertRule) (*models.AlertRule, error)
+	UpdateAlertRule(context.Context, models.AlertRule) (*models.AlertRule, error)
+	DeleteAlertRule(context.Context, primitive.ObjectID) (bool, error)
+	GetAlertDeliveries(context.Context, primitive.ObjectID, int) ([]models.AlertDelivery, error)
+	GetGaps(context.Context, string, time.Time, time.Time, int, *models.GapCursor) ([]models.DataGap, error)
 }
 
 type Usecase struct {
-	rp repo.RepoItf
+	rp       repo.RepoItf
+	snapshot *SnapshotCache
+	now      func() time.Time
 }
 
 func NewUsecase(rp repo.RepoItf) *Usecase {
-	return &Usecase{rp: rp}
+	return &Usecase{rp: rp, now: time.Now}
+}
+
+func (uc *Usecase) GetSymbols(ctx context.Context, query models.SymbolQuery) ([]models.SymbolDocument, error) {
+	// repo
+	return uc.rp.GetSymbols(ctx, query)
+}
+
+func (uc *Usecase) GetSymbol(ctx context.Context, symbol string) (*models.SymbolDocument, error) {
+	// repo
+	return uc.rp.GetSymbol(ctx, symbol)
+}
+
+// UseSnapshotCache serves snapshots from cache while it is fresh.
+func (uc *Usecase) UseSnapshotCache(cache *SnapshotCache) {
+	uc.snapshot = cache
+}
+
+// GetSnapshot returns the metadata of the given symbols that have any, in
+// the order asked for.
+func (uc *Usecase) GetSnapshot(ctx context.Context, symbols []string) ([]models.SymbolDocument, error) {
+	if uc.snapshot != nil {
+		if docs, ok := uc.snapshot.Get(symbols); ok {
+			return docs, nil
+		}
+	}
+
+	// repo
+	docs, err := uc.rp.GetSnapshot(ctx, symbols)
+	if err != nil {
+		return nil, err
+	}
+	bySymbol := make(map[string]models.SymbolDocument, len(docs))
+	for _, doc := range docs {
+		bySymbol[doc.Symbol] = doc
+	}
+	return pick(bySymbol, symbols), nil
 }
 
-func (uc *Usecase) GetSymbols(ctx context.Context) ([]models.SymbolDocument, error) {
+func (uc *Usecase) GetTradesPerSymbol(ctx context.Context, symbol string, limit int, before int64, excludeConditions []string, excludeFlagged bool) ([]models.TradeRecord, error) {
 	// repo
-	return uc.rp.GetSymbols(ctx)
+	return uc.rp.GetTradesPerSymbol(ctx, symbol, limit, before, excludeConditions, excludeFlagged)
 }
 
-func (uc *Usecase) GetTradesPerSymbol(ctx context.Context, symbol string, limit int, before int64) ([]models.TradeRecord, error) {
+func (uc *Usecase) GetCandlesPerSymbol(ctx context.Context, symbol string, limit int, before int64) ([]models.Candle, error) {
 	// repo
-	return uc.rp.GetTradesPerSymbol(ctx, symbol, limit, before)
+	return uc.rp.GetCandlesPerSymbol(ctx, symbol, limit, before)
+}
+
+func (uc *Usecase) HasCandlesBefore(ctx context.Context, symbol string, before int64) (bool, error) {
+	// repo
+	return uc.rp.HasCandlesBefore(ctx, symbol, before)
+}
+
+func (uc *Usecase) GetStatsPerSymbol(ctx context.Context, symbol string, from, to time.Time, excludeConditions []string) (*models.TradeStats, error) {
+	// repo
+	return uc.rp.GetStatsPerSymbol(ctx, symbol, from, to, excludeConditions)
+}
+
+// GetIndicator computes an indicator (see indicators.Spec, which must be
+// valid) over candles of the given interval and returns its latest limit
+// points, oldest first. The current, incomplete candle is included.
+func (uc *Usecase) GetIndicator(ctx context.Context, symbol string, spec indicators.Spec, interval time.Duration, limit int, excludeConditions []string) ([]indicators.Point, error) {
+	// Fetch enough candles to warm the indicator up before the first point
+	now := uc.now()
+	candleCount := limit + spec.Lookback()
+	from := now.Truncate(interval).Add(-time.Duration(candleCount-1) * interval)
+
+	// repo
+	series, err := uc.rp.GetCandleSeries(ctx, symbol, interval, from, now.Add(time.Nanosecond), excludeConditions)
+	if err != nil {
+		return nil, err
+	}
+
+	candles := make([]indicators.Candle, len(series))
+	for i, c := range series {
+		candles[i] = indicators.Candle{
+			Time:   c.Time,
+			Open:   toFloat(c.Open),
+			High:   toFloat(c.High),
+			Low:    toFloat(c.Low),
+			Close:  toFloat(c.Close),
+			Volume: toFloat(c.Volume),
+		}
+	}
+	points := indicators.Compute(spec, candles)
+	if len(points) > limit {
+		points = points[len(points)-limit:]
+	}
+	return points, nil
+}
+
+func toFloat(d primitive.Decimal128) float64 {
+	f, err := strconv.ParseFloat(d.String(), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
 }
@@ -0,0 +1,88 @@
+package usecase
+
+import (
+	"context"
+	"financial-data-backend-2/internal/api/repo"
+	"financial-data-backend-2/internal/models"
+	"log"
+	"sync"
+	"time"
+)
+
+// The cache is stale, and bypassed, once it has missed this many
+// refreshes, e.g. while MongoDB is unreachable.
+const staleAfterRefreshes = 3
+
+// SnapshotCache keeps every symbol's metadata in memory, reloading it
+// from the repo periodically, so that snapshots need no database query.
+type SnapshotCache struct {
+	rp       repo.RepoItf
+	interval time.Duration
+
+	mu        sync.RWMutex
+	bySymbol  map[string]models.SymbolDocument
+	updatedAt time.Time
+	now       func() time.Time
+}
+
+func NewSnapshotCache(rp repo.RepoItf, interval time.Duration) *SnapshotCache {
+	return &SnapshotCache{rp: rp, interval: interval, now: time.Now}
+}
+
+// Run refreshes the cache every interval until ctx is done.
+func (c *SnapshotCache) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		refreshCtx, cancel := context.WithTimeout(ctx, c.interval*staleAfterRefreshes)
+		if err := c.Refresh(refreshCtx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to refresh snapshot cache: %v", err)
+		}
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Refresh reloads every symbol.
+func (c *SnapshotCache) Refresh(ctx context.Context) error {
+	docs, err := c.rp.GetSymbols(ctx, models.SymbolQuery{})
+	if err != nil {
+		return err
+	}
+	bySymbol := make(map[string]models.SymbolDocument, len(docs))
+	for _, doc := range docs {
+		bySymbol[doc.Symbol] = doc
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bySymbol = bySymbol
+	c.updatedAt = c.now()
+	return nil
+}
+
+// Get returns the cached metadata of the given symbols that have any, in
+// the order asked for. ok is false if the cache is stale.
+func (c *SnapshotCache) Get(symbols []string) (docs []models.SymbolDocument, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.bySymbol == nil || c.now().Sub(c.updatedAt) > c.interval*staleAfterRefreshes {
+		return nil, false
+	}
+	return pick(c.bySymbol, symbols), true
+}
+
+// pick returns the documents of symbols, skipping unknown ones.
+func pick(bySymbol map[string]models.SymbolDocument, symbols []string) []models.SymbolDocument {
+	docs := make([]models.SymbolDocument, 0, len(symbols))
+	for _, symbol := range symbols {
+		if doc, ok := bySymbol[symbol]; ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
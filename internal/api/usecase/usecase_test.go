@@ -5,14 +5,20 @@ import (
 	"errors"
 	"financial-data-backend-2/internal/api/repo"
 	"financial-data-backend-2/internal/api/repo/mocks"
+	"financial-data-backend-2/internal/indicators"
 	"financial-data-backend-2/internal/models"
 	"testing"
 	"time"
 
 	"github.com/go-playground/assert"
+	testifyMock "github.com/stretchr/testify/mock"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// excludeConditions is passed on to the repo as given, e.g. from
+// aggregates.exclude_conditions: here, odd lots.
+var excludeConditions = []string{"I"}
+
 func TestGetSymbols(t *testing.T) {
 	testCases := []struct {
 		name           string
@@ -25,7 +31,7 @@ func TestGetSymbols(t *testing.T) {
 			repoSetup: func(ctx context.Context) repo.RepoItf {
 				mock := new(mocks.RepoItf)
 				var empty []models.SymbolDocument
-				mock.On("GetSymbols", ctx).
+				mock.On("GetSymbols", ctx, models.SymbolQuery{}).
 					Return(empty, nil)
 				return mock
 			},
@@ -44,7 +50,7 @@ func TestGetSymbols(t *testing.T) {
 					TradeCount:  14,
 					LastTradeAt: time.UnixMilli(200),
 				})
-				mock.On("GetSymbols", ctx).
+				mock.On("GetSymbols", ctx, models.SymbolQuery{}).
 					Return(nonempty, nil)
 				return mock
 			},
@@ -63,7 +69,7 @@ func TestGetSymbols(t *testing.T) {
 			name: "return error",
 			repoSetup: func(ctx context.Context) repo.RepoItf {
 				mock := new(mocks.RepoItf)
-				mock.On("GetSymbols", ctx).
+				mock.On("GetSymbols", ctx, models.SymbolQuery{}).
 					Return(nil, errors.New("api usecase error"))
 				return mock
 			},
@@ -80,7 +86,7 @@ func TestGetSymbols(t *testing.T) {
 			uc := NewUsecase(tt.repoSetup(context.Background()))
 
 			//when
-			output, err := uc.GetSymbols(context.Background())
+			output, err := uc.GetSymbols(context.Background(), models.SymbolQuery{})
 
 			//then
 			assert.Equal(t, tt.expectedOutput(), output)
@@ -88,6 +94,63 @@ func TestGetSymbols(t *testing.T) {
 		})
 	}
 }
+
+func TestGetSymbol(t *testing.T) {
+	testCases := []struct {
+		name           string
+		repoSetup      func(context.Context) repo.RepoItf
+		expectedOutput *models.SymbolDocument
+		expectedErr    error
+	}{
+		{
+			name: "return symbol without error",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetSymbol", ctx, "A").
+					Return(&models.SymbolDocument{Symbol: "A", TradeCount: 14}, nil)
+				return mock
+			},
+			expectedOutput: &models.SymbolDocument{Symbol: "A", TradeCount: 14},
+			expectedErr:    nil,
+		},
+		{
+			name: "return nil for unknown symbol",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetSymbol", ctx, "A").
+					Return(nil, nil)
+				return mock
+			},
+			expectedOutput: nil,
+			expectedErr:    nil,
+		},
+		{
+			name: "return error",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetSymbol", ctx, "A").
+					Return(nil, errors.New("api usecase error"))
+				return mock
+			},
+			expectedOutput: nil,
+			expectedErr:    errors.New("api usecase error"),
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			//given
+			uc := NewUsecase(tt.repoSetup(context.Background()))
+
+			//when
+			output, err := uc.GetSymbol(context.Background(), "A")
+
+			//then
+			assert.Equal(t, tt.expectedOutput, output)
+			assert.Equal(t, tt.expectedErr, err)
+		})
+	}
+}
 func TestGetTradesPerSymbol(t *testing.T) {
 	price, _ := primitive.ParseDecimal128("123.50")
 	volume, _ := primitive.ParseDecimal128("50")
@@ -109,7 +172,7 @@ func TestGetTradesPerSymbol(t *testing.T) {
 			repoSetup: func(ctx context.Context) repo.RepoItf {
 				mock := new(mocks.RepoItf)
 				var empty []models.TradeRecord
-				mock.On("GetTradesPerSymbol", ctx, "A", 14, int64(256)).
+				mock.On("GetTradesPerSymbol", ctx, "A", 14, int64(256), excludeConditions, true).
 					Return(empty, nil)
 				return mock
 			},
@@ -134,7 +197,7 @@ func TestGetTradesPerSymbol(t *testing.T) {
 						Volume: volume,
 					})
 				mock := new(mocks.RepoItf)
-				mock.On("GetTradesPerSymbol", ctx, "A", 14, int64(256)).
+				mock.On("GetTradesPerSymbol", ctx, "A", 14, int64(256), excludeConditions, true).
 					Return(nonempty, nil)
 				return mock
 			},
@@ -158,7 +221,7 @@ func TestGetTradesPerSymbol(t *testing.T) {
 			inputBefore: 256,
 			repoSetup: func(ctx context.Context) repo.RepoItf {
 				mock := new(mocks.RepoItf)
-				mock.On("GetTradesPerSymbol", ctx, "A", 14, int64(256)).
+				mock.On("GetTradesPerSymbol", ctx, "A", 14, int64(256), excludeConditions, true).
 					Return(nil, errors.New("api usecase error"))
 				return mock
 			},
@@ -176,7 +239,7 @@ func TestGetTradesPerSymbol(t *testing.T) {
 
 			//when
 			output, err := uc.GetTradesPerSymbol(context.Background(),
-				tt.inputSymbol, tt.inputLimit, tt.inputBefore)
+				tt.inputSymbol, tt.inputLimit, tt.inputBefore, excludeConditions, true)
 
 			//then
 			assert.Equal(t, tt.expectedOutput(), output)
@@ -184,3 +247,423 @@ func TestGetTradesPerSymbol(t *testing.T) {
 		})
 	}
 }
+
+func TestGetCandlesPerSymbol(t *testing.T) {
+	price, _ := primitive.ParseDecimal128("123.50")
+	candles := []models.Candle{{Symbol: "A", Time: time.UnixMilli(60000), Open: price, Close: price}}
+
+	testCases := []struct {
+		name           string
+		repoSetup      func(context.Context) repo.RepoItf
+		expectedOutput []models.Candle
+		expectedErr    error
+	}{
+		{
+			name: "return candles without error",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetCandlesPerSymbol", ctx, "A", 14, int64(256)).
+					Return(candles, nil)
+				return mock
+			},
+			expectedOutput: candles,
+			expectedErr:    nil,
+		},
+		{
+			name: "return error",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetCandlesPerSymbol", ctx, "A", 14, int64(256)).
+					Return(nil, errors.New("api usecase error"))
+				return mock
+			},
+			expectedOutput: nil,
+			expectedErr:    errors.New("api usecase error"),
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			//given
+			uc := NewUsecase(tt.repoSetup(context.Background()))
+
+			//when
+			output, err := uc.GetCandlesPerSymbol(context.Background(), "A", 14, 256)
+
+			//then
+			assert.Equal(t, tt.expectedOutput, output)
+			assert.Equal(t, tt.expectedErr, err)
+		})
+	}
+}
+
+func TestHasCandlesBefore(t *testing.T) {
+	testCases := []struct {
+		name           string
+		repoSetup      func(context.Context) repo.RepoItf
+		expectedOutput bool
+		expectedErr    error
+	}{
+		{
+			name: "return true without error",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("HasCandlesBefore", ctx, "A", int64(256)).Return(true, nil)
+				return mock
+			},
+			expectedOutput: true,
+			expectedErr:    nil,
+		},
+		{
+			name: "return error",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("HasCandlesBefore", ctx, "A", int64(256)).Return(false, errors.New("api usecase error"))
+				return mock
+			},
+			expectedOutput: false,
+			expectedErr:    errors.New("api usecase error"),
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			//given
+			uc := NewUsecase(tt.repoSetup(context.Background()))
+
+			//when
+			output, err := uc.HasCandlesBefore(context.Background(), "A", 256)
+
+			//then
+			assert.Equal(t, tt.expectedOutput, output)
+			assert.Equal(t, tt.expectedErr, err)
+		})
+	}
+}
+
+func TestGetSnapshot(t *testing.T) {
+	aapl := models.SymbolDocument{Symbol: "AAPL", TradeCount: 1}
+	msft := models.SymbolDocument{Symbol: "MSFT", TradeCount: 2}
+	now := time.UnixMilli(1700000040000)
+
+	testCases := []struct {
+		name           string
+		repoSetup      func(context.Context) repo.RepoItf
+		cacheAge       time.Duration // -1 for no cache
+		expectedOutput []models.SymbolDocument
+		expectedErr    error
+	}{
+		{
+			name: "without cache, return repo results in the order asked for",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetSnapshot", ctx, []string{"MSFT", "NOPE", "AAPL"}).
+					Return([]models.SymbolDocument{aapl, msft}, nil)
+				return mock
+			},
+			cacheAge:       -1,
+			expectedOutput: []models.SymbolDocument{msft, aapl},
+		},
+		{
+			name: "serve fresh cache without querying the repo",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetSymbols", ctx, models.SymbolQuery{}).
+					Return([]models.SymbolDocument{aapl, msft}, nil)
+				return mock
+			},
+			cacheAge:       time.Second,
+			expectedOutput: []models.SymbolDocument{msft, aapl},
+		},
+		{
+			name: "bypass stale cache",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetSymbols", ctx, models.SymbolQuery{}).
+					Return([]models.SymbolDocument{aapl}, nil)
+				mock.On("GetSnapshot", ctx, []string{"MSFT", "NOPE", "AAPL"}).
+					Return([]models.SymbolDocument{aapl, msft}, nil)
+				return mock
+			},
+			cacheAge:       time.Minute,
+			expectedOutput: []models.SymbolDocument{msft, aapl},
+		},
+		{
+			name: "return error",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetSnapshot", ctx, []string{"MSFT", "NOPE", "AAPL"}).
+					Return(nil, errors.New("api usecase error"))
+				return mock
+			},
+			cacheAge:       -1,
+			expectedOutput: nil,
+			expectedErr:    errors.New("api usecase error"),
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			//given
+			ctx := context.Background()
+			rp := tt.repoSetup(ctx)
+			uc := NewUsecase(rp)
+			if tt.cacheAge >= 0 {
+				cache := NewSnapshotCache(rp, time.Second)
+				// Refresh, then pretend cacheAge has passed
+				cache.now = func() time.Time { return now }
+				if err := cache.Refresh(ctx); err != nil {
+					t.Fatal(err)
+				}
+				cache.now = func() time.Time { return now.Add(tt.cacheAge) }
+				uc.UseSnapshotCache(cache)
+			}
+
+			//when
+			output, err := uc.GetSnapshot(ctx, []string{"MSFT", "NOPE", "AAPL"})
+
+			//then
+			assert.Equal(t, tt.expectedOutput, output)
+			assert.Equal(t, tt.expectedErr, err)
+		})
+	}
+}
+
+func TestGetStatsPerSymbol(t *testing.T) {
+	from := time.UnixMilli(1700000000000)
+	to := from.Add(time.Hour)
+	stats := &models.TradeStats{TradeCount: 3}
+
+	testCases := []struct {
+		name           string
+		repoSetup      func(context.Context) repo.RepoItf
+		expectedOutput *models.TradeStats
+		expectedErr    error
+	}{
+		{
+			name: "return stats without error",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetStatsPerSymbol", ctx, "A", from, to, excludeConditions).
+					Return(stats, nil)
+				return mock
+			},
+			expectedOutput: stats,
+			expectedErr:    nil,
+		},
+		{
+			name: "return error",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetStatsPerSymbol", ctx, "A", from, to, excludeConditions).
+					Return(nil, errors.New("api usecase error"))
+				return mock
+			},
+			expectedOutput: nil,
+			expectedErr:    errors.New("api usecase error"),
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			//given
+			uc := NewUsecase(tt.repoSetup(context.Background()))
+
+			//when
+			output, err := uc.GetStatsPerSymbol(context.Background(), "A", from, to, excludeConditions)
+
+			//then
+			assert.Equal(t, tt.expectedOutput, output)
+			assert.Equal(t, tt.expectedErr, err)
+		})
+	}
+}
+
+func TestGetIndicator(t *testing.T) {
+	now := time.Date(2025, 11, 20, 14, 30, 45, 0, time.UTC)
+	closes := []string{"10", "11", "12", "13", "14"}
+	series := make([]models.Candle, len(closes))
+	for i, c := range closes {
+		price, _ := primitive.ParseDecimal128(c)
+		series[i] = models.Candle{Time: now.Truncate(time.Minute).Add(time.Duration(i-4) * time.Minute), Close: price}
+	}
+	spec := indicators.Spec{Name: indicators.NameSMA, Period: 3}
+
+	testCases := []struct {
+		name           string
+		limit          int
+		repoSetup      func(context.Context) repo.RepoItf
+		expectedOutput []indicators.Point
+		expectedErr    error
+	}{
+		{
+			name:  "return the latest points",
+			limit: 2,
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				// 2 points plus 2 candles of warm-up, ending with the current one
+				from := time.Date(2025, 11, 20, 14, 27, 0, 0, time.UTC)
+				mock.On("GetCandleSeries", ctx, "A", time.Minute, from, now.Add(time.Nanosecond), excludeConditions).
+					Return(series, nil)
+				return mock
+			},
+			expectedOutput: []indicators.Point{
+				{Time: series[3].Time, Values: map[string]float64{"sma": 12}},
+				{Time: series[4].Time, Values: map[string]float64{"sma": 13}},
+			},
+		},
+		{
+			name:  "return error",
+			limit: 2,
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				from := time.Date(2025, 11, 20, 14, 27, 0, 0, time.UTC)
+				mock.On("GetCandleSeries", ctx, "A", time.Minute, from, now.Add(time.Nanosecond), excludeConditions).
+					Return(nil, errors.New("api usecase error"))
+				return mock
+			},
+			expectedOutput: nil,
+			expectedErr:    errors.New("api usecase error"),
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			//given
+			uc := NewUsecase(tt.repoSetup(context.Background()))
+			uc.now = func() time.Time { return now }
+
+			//when
+			output, err := uc.GetIndicator(context.Background(), "A", spec, time.Minute, tt.limit, excludeConditions)
+
+			//then
+			assert.Equal(t, tt.expectedOutput, output)
+			assert.Equal(t, tt.expectedErr, err)
+		})
+	}
+}
+
+func TestCreateAlertRule(t *testing.T) {
+	now := time.Date(2025, 11, 20, 14, 30, 45, 0, time.UTC)
+	rule := models.AlertRule{Symbol: "A", Type: models.AlertVolumeSpike, Multiplier: 3, WindowMinutes: 5, Enabled: true}
+
+	testCases := []struct {
+		name        string
+		secret      string
+		repoErr     error
+		expectedErr error
+	}{
+		{name: "generate a secret"},
+		{name: "keep the given secret", secret: "s3cr3t"},
+		{name: "return error", repoErr: errors.New("api usecase error"), expectedErr: errors.New("api usecase error")},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			//given
+			var stored models.AlertRule
+			mock := new(mocks.RepoItf)
+			mock.On("CreateAlertRule", context.Background(), testifyMock.AnythingOfType("models.AlertRule")).
+				Run(func(args testifyMock.Arguments) { stored = args.Get(1).(models.AlertRule) }).
+				Return(tt.repoErr)
+			uc := NewUsecase(mock)
+			uc.now = func() time.Time { return now }
+			input := rule
+			input.Secret = tt.secret
+
+			//when
+			output, err := uc.CreateAlertRule(context.Background(), input)
+
+			//then
+			assert.Equal(t, tt.expectedErr, err)
+			if tt.expectedErr != nil {
+				assert.Equal(t, (*models.AlertRule)(nil), output)
+				return
+			}
+			assert.Equal(t, &stored, output)
+			assert.Equal(t, false, stored.Id.IsZero())
+			assert.Equal(t, now, stored.CreatedAt)
+			assert.Equal(t, now, stored.UpdatedAt)
+			if tt.secret != "" {
+				assert.Equal(t, tt.secret, stored.Secret)
+			} else {
+				assert.Equal(t, 64, len(stored.Secret))
+			}
+		})
+	}
+}
+
+func TestUpdateAlertRule(t *testing.T) {
+	now := time.Date(2025, 11, 20, 14, 30, 45, 0, time.UTC)
+	created := now.Add(-time.Hour)
+	id := primitive.NewObjectID()
+	existing := &models.AlertRule{Id: id, Symbol: "A", Type: models.AlertVolumeSpike, Multiplier: 3, WindowMinutes: 5,
+		Secret: "old", Enabled: true, CreatedAt: created, UpdatedAt: created}
+	update := models.AlertRule{Id: id, Symbol: "A", Type: models.AlertVolumeSpike, Multiplier: 4, WindowMinutes: 5}
+	expected := update
+	expected.Secret = "old"
+	expected.CreatedAt = created
+	expected.UpdatedAt = now
+
+	testCases := []struct {
+		name           string
+		repoSetup      func(context.Context) repo.RepoItf
+		expectedOutput *models.AlertRule
+		expectedErr    error
+	}{
+		{
+			name: "keep the secret and creation time",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetAlertRule", ctx, id).Return(existing, nil)
+				mock.On("UpdateAlertRule", ctx, expected).Return(true, nil)
+				return mock
+			},
+			expectedOutput: &expected,
+		},
+		{
+			name: "return nil if not found",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetAlertRule", ctx, id).Return(nil, nil)
+				return mock
+			},
+			expectedOutput: nil,
+		},
+		{
+			name: "return nil if deleted meanwhile",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetAlertRule", ctx, id).Return(existing, nil)
+				mock.On("UpdateAlertRule", ctx, expected).Return(false, nil)
+				return mock
+			},
+			expectedOutput: nil,
+		},
+		{
+			name: "return error",
+			repoSetup: func(ctx context.Context) repo.RepoItf {
+				mock := new(mocks.RepoItf)
+				mock.On("GetAlertRule", ctx, id).Return(nil, errors.New("api usecase error"))
+				return mock
+			},
+			expectedOutput: nil,
+			expectedErr:    errors.New("api usecase error"),
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			//given
+			uc := NewUsecase(tt.repoSetup(context.Background()))
+			uc.now = func() time.Time { return now }
+
+			//when
+			output, err := uc.UpdateAlertRule(context.Background(), update)
+
+			//then
+			assert.Equal(t, tt.expectedOutput, output)
+			assert.Equal(t, tt.expectedErr, err)
+		})
+	}
+}
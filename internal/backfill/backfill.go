@@ -0,0 +1,210 @@
+// Package backfill fills the gaps in stored trades, e.g. while the
+// pipeline was down, with trades fetched from a REST data source.
+package backfill
+
+import (
+	"context"
+	"financial-data-backend-2/internal/gaps"
+	"financial-data-backend-2/internal/models"
+	"financial-data-backend-2/internal/processor"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+)
+
+// Topic the trade records are keyed with, in place of the Kafka topic
+// live trades are read from
+const Topic = "backfill"
+
+// Trades stored per InsertTrades call
+const batchSize = 1000
+
+// Filled is a gap and the trades stored in it.
+type Filled struct {
+	gaps.Gap
+	Trades int
+}
+
+// Report is what a backfill did for a symbol.
+type Report struct {
+	Symbol string
+	// Trades fetched from the source
+	Fetched int
+	// Fetched trades outside the gaps, which are already covered by
+	// stored trades
+	Covered int
+	// Fetched trades in the gaps that were stored before, e.g. by an
+	// earlier backfill that was interrupted
+	Duplicates int
+	// Trades stored now
+	Inserted int
+	// Gaps found, and those that trades were stored in
+	Gaps   []gaps.Gap
+	Filled []Filled
+}
+
+func (r Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d gap(s), %d filled with %d trade(s); %d fetched, %d already covered, %d duplicate(s)",
+		r.Symbol, len(r.Gaps), len(r.Filled), r.Inserted, r.Fetched, r.Covered, r.Duplicates)
+	for _, f := range r.Filled {
+		fmt.Fprintf(&b, "\n  %s - %s (%v): %d trade(s)", f.From.UTC().Format(time.RFC3339Nano),
+			f.To.UTC().Format(time.RFC3339Nano), f.Duration(), f.Trades)
+	}
+	return b.String()
+}
+
+// Backfiller stores fetched trades in the gaps of the stored ones. Only
+// gaps are filled, as fetched trades cannot be told apart from the stored
+// ones by their keys, which derive from the Kafka messages they were read
+// from.
+type Backfiller struct {
+	source      Source
+	store       processor.Store
+	times       gaps.TradeTimes
+	transformer processor.Transformer
+	minGap      time.Duration
+	retention   time.Duration
+	now         func() time.Time
+}
+
+// NewBackfiller fills the gaps of at least minGap. The transformer keys
+// and validates trades, as the processor's does. Trades older than
+// retention, which would have expired already, are not backfilled; a zero
+// retention keeps trades forever.
+func NewBackfiller(source Source, store processor.Store, times gaps.TradeTimes, transformer processor.Transformer, minGap, retention time.Duration) *Backfiller {
+	return &Backfiller{source: source, store: store, times: times, transformer: transformer, minGap: minGap,
+		retention: retention, now: time.Now}
+}
+
+// Run fills the symbol's gaps in [from, to), fetching the trades of each
+// day with gaps in turn. Trades are keyed the same way every time, so
+// running it again, e.g. after a failure, stores each trade once.
+func (b *Backfiller) Run(ctx context.Context, symbol string, from, to time.Time) (Report, error) {
+	report := Report{Symbol: symbol}
+	if b.retention > 0 {
+		// Before then, the trades have expired: all of it looks like a gap
+		if expired := b.now().Add(-b.retention); from.Before(expired) {
+			log.Printf("Backfilling %s from %s only: older trades have expired under retention.trades (%v)",
+				symbol, expired.UTC().Format(time.RFC3339), b.retention)
+			from = expired
+		}
+	}
+	if !from.Before(to) {
+		return report, nil
+	}
+	found, err := gaps.Find(ctx, b.times, symbol, from, to, b.minGap)
+	if err != nil {
+		return report, fmt.Errorf("failed to find gaps: %w", err)
+	}
+	report.Gaps = found
+	if len(found) == 0 {
+		return report, nil
+	}
+
+	filled := make([]int, len(found))
+	for _, day := range days(found) {
+		dayFrom, dayTo := day, day.Add(24*time.Hour)
+		if dayFrom.Before(from) {
+			dayFrom = from
+		}
+		if dayTo.After(to) {
+			dayTo = to
+		}
+		trades, err := b.source.Ticks(ctx, symbol, dayFrom, dayTo)
+		if err == nil {
+			err = b.fill(ctx, trades, found, filled, &report)
+		}
+		if err != nil {
+			report.Filled = filledGaps(found, filled)
+			return report, err
+		}
+	}
+	report.Filled = filledGaps(found, filled)
+	return report, nil
+}
+
+// days returns the UTC days the gaps are in, in order.
+func days(found []gaps.Gap) []time.Time {
+	var days []time.Time
+	for _, g := range found {
+		for day := g.From.UTC().Truncate(24 * time.Hour); day.Before(g.To); day = day.Add(24 * time.Hour) {
+			if len(days) == 0 || day.After(days[len(days)-1]) {
+				days = append(days, day)
+			}
+		}
+	}
+	return days
+}
+
+// fill stores the fetched trades that are in the gaps found, counting
+// those stored per gap in filled.
+func (b *Backfiller) fill(ctx context.Context, trades []models.TradeEvent, found []gaps.Gap, filled []int, report *Report) error {
+	report.Fetched += len(trades)
+	sort.SliceStable(trades, func(i, j int) bool { return trades[i].ExchangeTime < trades[j].ExchangeTime })
+
+	// Keep the trades in gaps, numbering the trades of each millisecond
+	// so that identical ones get keys of their own
+	var kept []models.TradeEvent
+	g := 0
+	for i, trade := range trades {
+		trade.Sequence = 0
+		if i > 0 && trades[i-1].ExchangeTime == trade.ExchangeTime {
+			trade.Sequence = trades[i-1].Sequence + 1
+		}
+		trades[i] = trade
+		t := time.UnixMilli(trade.ExchangeTime)
+		for g < len(found) && !t.Before(found[g].To) {
+			g++
+		}
+		if g == len(found) || !found[g].Contains(t) {
+			report.Covered++
+			continue
+		}
+		kept = append(kept, trade)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	data, err := b.transformer.Records(kafkaGo.Message{Topic: Topic}, kept)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(data.TradeRecords); start += batchSize {
+		batch := data.TradeRecords[start:min(start+batchSize, len(data.TradeRecords))]
+		inserted, err := b.store.InsertTrades(ctx, batch)
+		report.Inserted += len(inserted)
+		report.Duplicates += len(batch) - len(inserted)
+		for _, r := range inserted {
+			i := sort.Search(len(found), func(i int) bool { return r.Time.Before(found[i].To) })
+			filled[i]++
+		}
+		// As in the processor, metadata is best effort: go-reconciler
+		// repairs what is missed.
+		for _, summary := range processor.Summarize(inserted) {
+			if err := b.store.UpdateSymbol(ctx, summary); err != nil {
+				log.Printf("Failed to upsert symbol metadata for '%s': %v", summary.Symbol, err)
+			}
+		}
+		if err != nil {
+			report.Duplicates -= len(batch) - len(inserted)
+			return fmt.Errorf("failed to insert trades: %w", err)
+		}
+	}
+	return nil
+}
+
+func filledGaps(found []gaps.Gap, trades []int) []Filled {
+	var filled []Filled
+	for i, n := range trades {
+		if n > 0 {
+			filled = append(filled, Filled{Gap: found[i], Trades: n})
+		}
+	}
+	return filled
+}
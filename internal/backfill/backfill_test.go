@@ -0,0 +1,301 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"financial-data-backend-2/internal/models"
+	"financial-data-backend-2/internal/processor"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var day = time.Date(2025, 11, 20, 0, 0, 0, 0, time.UTC)
+
+func at(hour, minute, second int) time.Time {
+	return day.Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second)
+}
+
+// finnhub serves /stock/tick from trades, by day, in pages of pageSize.
+type finnhub struct {
+	trades   map[string][]finnhubTrade
+	pageSize int
+	// Requests answered with 429 before the next one succeeds
+	limited  int
+	requests int
+	// The dates asked for, in order
+	dates []string
+}
+
+type finnhubTrade struct {
+	time   time.Time
+	price  float64
+	volume float64
+	conds  []string
+}
+
+func (f *finnhub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.requests++
+	if r.URL.Path != "/stock/tick" || r.URL.Query().Get("token") != "secret" {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if f.limited > 0 {
+		f.limited--
+		w.Header().Set("Retry-After", "0")
+		http.Error(w, "API limit reached", http.StatusTooManyRequests)
+		return
+	}
+	q := r.URL.Query()
+	date, err := time.Parse(time.DateOnly, q.Get("date"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	skip, _ := strconv.Atoi(q.Get("skip"))
+	if skip == 0 {
+		f.dates = append(f.dates, q.Get("date"))
+	}
+	var ofDay []finnhubTrade
+	for _, t := range f.trades[q.Get("symbol")] {
+		if !t.time.Before(date) && t.time.Before(date.Add(24*time.Hour)) {
+			ofDay = append(ofDay, t)
+		}
+	}
+	page := finnhubTicks{Symbol: q.Get("symbol"), Skip: skip, Total: len(ofDay),
+		Times: []int64{}, Prices: []float64{}, Volume: []float64{}, Conds: [][]string{}}
+	for i := skip; i < len(ofDay) && i < skip+f.pageSize; i++ {
+		page.Times = append(page.Times, ofDay[i].time.UnixMilli())
+		page.Prices = append(page.Prices, ofDay[i].price)
+		page.Volume = append(page.Volume, ofDay[i].volume)
+		page.Conds = append(page.Conds, ofDay[i].conds)
+	}
+	page.Count = len(page.Times)
+	json.NewEncoder(w).Encode(page)
+}
+
+func newSource(t *testing.T, f *finnhub) *FinnhubSource {
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+	s := NewFinnhubSource(server.Client(), server.URL, "secret")
+	s.backoff = time.Millisecond
+	return s
+}
+
+func TestFinnhubSource(t *testing.T) {
+	f := &finnhub{pageSize: 2, limited: 1, trades: map[string][]finnhubTrade{"AAPL": {
+		{time: at(14, 30, 0), price: 189.5, volume: 100, conds: []string{"1", "12"}},
+		{time: at(14, 30, 1), price: 189.55, volume: 5},
+		{time: at(14, 31, 0), price: 189.6, volume: 10},
+		{time: at(14, 32, 0), price: 189.7, volume: 10},
+		{time: day.Add(24*time.Hour + time.Hour), price: 190, volume: 1},
+	}}}
+	s := newSource(t, f)
+
+	trades, err := s.Ticks(context.Background(), "AAPL", at(14, 30, 0), at(14, 32, 0))
+	require.NoError(t, err)
+	require.Len(t, trades, 3)
+	assert.Equal(t, "189.5", trades[0].Price)
+	assert.Equal(t, "100", trades[0].Volume)
+	assert.Equal(t, at(14, 30, 0).UnixMilli(), trades[0].ExchangeTime)
+	assert.Equal(t, []string{"1", "12"}, trades[0].Conditions)
+	assert.Equal(t, "finnhub-rest", trades[0].Source)
+	assert.Equal(t, "189.6", trades[2].Price)
+	// One rate-limited request, then two pages
+	assert.Equal(t, 3, f.requests)
+
+	// A range over two days asks for each
+	trades, err = s.Ticks(context.Background(), "AAPL", at(14, 32, 0), day.Add(48*time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, trades, 2)
+
+	f.limited = 10
+	_, err = s.Ticks(context.Background(), "AAPL", at(14, 30, 0), at(14, 32, 0))
+	assert.ErrorContains(t, err, "status 429")
+}
+
+// memoryStore keeps trades in memory, keyed as the real stores are.
+type memoryStore struct {
+	mu      sync.Mutex
+	trades  map[string]models.TradeRecord
+	symbols []processor.SymbolSummary
+	fail    error
+}
+
+func newMemoryStore(trades ...models.TradeRecord) *memoryStore {
+	s := &memoryStore{trades: make(map[string]models.TradeRecord)}
+	for _, t := range trades {
+		s.trades[t.MessageKey] = t
+	}
+	return s
+}
+
+func (s *memoryStore) InsertTrades(ctx context.Context, records []interface{}) ([]models.TradeRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fail != nil {
+		return nil, s.fail
+	}
+	var inserted []models.TradeRecord
+	for _, r := range records {
+		t := r.(models.TradeRecord)
+		if _, ok := s.trades[t.MessageKey]; !ok {
+			s.trades[t.MessageKey] = t
+			inserted = append(inserted, t)
+		}
+	}
+	return inserted, nil
+}
+
+func (s *memoryStore) InsertLateTrades(ctx context.Context, trades []models.LateTrade) error {
+	return nil
+}
+
+func (s *memoryStore) UpdateSymbol(ctx context.Context, summary processor.SymbolSummary) error {
+	s.symbols = append(s.symbols, summary)
+	return nil
+}
+
+func (s *memoryStore) Scan(ctx context.Context, symbol string, from, to time.Time, fn func(time.Time) error) error {
+	s.mu.Lock()
+	var times []time.Time
+	for _, t := range s.trades {
+		if t.Symbol == symbol && !t.Time.Before(from) && t.Time.Before(to) {
+			times = append(times, t.Time)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	for _, t := range times {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func stored(key string, t time.Time) models.TradeRecord {
+	return models.TradeRecord{MessageKey: key, Symbol: "AAPL", Time: t}
+}
+
+func TestBackfill(t *testing.T) {
+	// Live trades at 14:30, 14:31, 14:40 and 14:41; the pipeline was down
+	// in between, and after. Finnhub has a trade every 30s, and two at
+	// 14:35.
+	store := newMemoryStore(stored("live-1", at(14, 30, 0)), stored("live-2", at(14, 31, 0)),
+		stored("live-3", at(14, 40, 0)), stored("live-4", at(14, 41, 0)))
+	var history []finnhubTrade
+	for ts := at(14, 30, 0); ts.Before(at(15, 0, 0)); ts = ts.Add(30 * time.Second) {
+		history = append(history, finnhubTrade{time: ts, price: 189.5, volume: 1})
+	}
+	history = append(history, finnhubTrade{time: at(14, 35, 0), price: 189.5, volume: 1})
+	sort.SliceStable(history, func(i, j int) bool { return history[i].time.Before(history[j].time) })
+	source := newSource(t, &finnhub{pageSize: 100, trades: map[string][]finnhubTrade{"AAPL": history}})
+
+	b := NewBackfiller(source, store, store, processor.Transformer{}, 2*time.Minute, 0)
+	report, err := b.Run(context.Background(), "AAPL", at(14, 30, 0), at(15, 0, 0))
+	require.NoError(t, err)
+
+	// 14:31-14:40 and 14:41-15:00 are gaps; 14:30-14:31 and 14:40-14:41
+	// are too short to be
+	require.Len(t, report.Gaps, 2)
+	assert.Equal(t, at(14, 31, 0).Add(time.Millisecond), report.Gaps[0].From)
+	assert.Equal(t, at(14, 40, 0), report.Gaps[0].To)
+	assert.Equal(t, at(14, 41, 0).Add(time.Millisecond), report.Gaps[1].From)
+	assert.Equal(t, at(15, 0, 0), report.Gaps[1].To)
+
+	// 14:31:30 to 14:39:30, with the second trade at 14:35, and 14:41:30
+	// to 14:59:30
+	assert.Equal(t, 61, report.Fetched)
+	assert.Equal(t, 18+37, report.Inserted)
+	assert.Equal(t, 61-18-37, report.Covered)
+	assert.Zero(t, report.Duplicates)
+	require.Len(t, report.Filled, 2)
+	assert.Equal(t, 18, report.Filled[0].Trades)
+	assert.Equal(t, 37, report.Filled[1].Trades)
+	assert.Len(t, store.trades, 4+55)
+	require.Len(t, store.symbols, 1)
+	assert.Equal(t, int64(55), store.symbols[0].TradeCount)
+	for key, trade := range store.trades {
+		if trade.Time.Equal(at(14, 35, 0)) {
+			assert.Regexp(t, `^backfill-0-0-AAPL-\d+-[01]$`, key)
+		}
+	}
+
+	// Nothing is missing any more
+	report, err = b.Run(context.Background(), "AAPL", at(14, 30, 0), at(15, 0, 0))
+	require.NoError(t, err)
+	assert.Empty(t, report.Gaps)
+	assert.Zero(t, report.Inserted)
+}
+
+func TestBackfillResumes(t *testing.T) {
+	store := newMemoryStore()
+	history := []finnhubTrade{{time: at(14, 30, 0), price: 1, volume: 1}, {time: at(14, 31, 0), price: 1, volume: 1}}
+	source := newSource(t, &finnhub{pageSize: 100, trades: map[string][]finnhubTrade{"AAPL": history}})
+	b := NewBackfiller(source, store, store, processor.Transformer{}, time.Minute, 0)
+
+	store.fail = errors.New("database down")
+	report, err := b.Run(context.Background(), "AAPL", at(14, 0, 0), at(15, 0, 0))
+	assert.Error(t, err)
+	assert.Zero(t, report.Inserted)
+	assert.Zero(t, report.Duplicates)
+
+	store.fail = nil
+	report, err = b.Run(context.Background(), "AAPL", at(14, 0, 0), at(15, 0, 0))
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Inserted)
+	require.Len(t, report.Filled, 1)
+	assert.Equal(t, at(14, 0, 0), report.Filled[0].From)
+	assert.Contains(t, report.String(), "AAPL: 1 gap(s), 1 filled with 2 trade(s)")
+}
+
+func TestBackfillFetchesDaysWithGaps(t *testing.T) {
+	// A trade every hour for ten days, but for one
+	store := newMemoryStore()
+	var history []finnhubTrade
+	for ts := day.Add(-5 * 24 * time.Hour); ts.Before(day.Add(5 * 24 * time.Hour)); ts = ts.Add(time.Hour) {
+		history = append(history, finnhubTrade{time: ts, price: 1, volume: 1})
+		if !ts.Equal(at(12, 0, 0)) {
+			store.trades[ts.String()] = stored(ts.String(), ts)
+		}
+	}
+	f := &finnhub{pageSize: 100, trades: map[string][]finnhubTrade{"AAPL": history}}
+	b := NewBackfiller(newSource(t, f), store, store, processor.Transformer{}, 90*time.Minute, 0)
+
+	report, err := b.Run(context.Background(), "AAPL", day.Add(-5*24*time.Hour), day.Add(5*24*time.Hour-time.Hour+time.Millisecond))
+	require.NoError(t, err)
+	require.Len(t, report.Gaps, 1)
+	assert.Equal(t, []string{"2025-11-20"}, f.dates)
+	assert.Equal(t, 24, report.Fetched)
+	assert.Equal(t, 1, report.Inserted)
+}
+
+func TestBackfillSkipsExpiredTrades(t *testing.T) {
+	store := newMemoryStore()
+	history := []finnhubTrade{{time: at(10, 0, 0), price: 1, volume: 1}, {time: at(14, 30, 0), price: 1, volume: 1}}
+	f := &finnhub{pageSize: 100, trades: map[string][]finnhubTrade{"AAPL": history}}
+	b := NewBackfiller(newSource(t, f), store, store, processor.Transformer{}, time.Minute, time.Hour)
+	b.now = func() time.Time { return at(15, 0, 0) }
+
+	// Trades before 14:00 have expired, and would be deleted again
+	report, err := b.Run(context.Background(), "AAPL", day.Add(-24*time.Hour), at(15, 0, 0))
+	require.NoError(t, err)
+	require.Len(t, report.Gaps, 1)
+	assert.Equal(t, at(14, 0, 0), report.Gaps[0].From)
+	assert.Equal(t, []string{"2025-11-20"}, f.dates)
+	assert.Equal(t, 1, report.Inserted)
+
+	// Nothing is left once the whole range has expired
+	report, err = b.Run(context.Background(), "AAPL", day.Add(-24*time.Hour), at(13, 0, 0))
+	require.NoError(t, err)
+	assert.Empty(t, report.Gaps)
+}
@@ -0,0 +1,144 @@
+package backfill
+
+import (
+	"context"
+	"encoding/json"
+	"financial-data-backend-2/internal/events"
+	"financial-data-backend-2/internal/models"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Source fetches historical trades.
+type Source interface {
+	// Ticks returns the symbol's trades in [from, to), in time order. The
+	// Backfiller asks for at most a day at a time.
+	Ticks(ctx context.Context, symbol string, from, to time.Time) ([]models.TradeEvent, error)
+}
+
+// DefaultFinnhubURL is the base URL of Finnhub's REST API.
+const DefaultFinnhubURL = "https://finnhub.io/api/v1"
+
+// Trades per request, the most Finnhub returns
+const finnhubPageSize = 25000
+
+// FinnhubSource fetches trades from Finnhub's tick data endpoint
+// (/stock/tick), a day at a time.
+type FinnhubSource struct {
+	client  *http.Client
+	baseURL string
+	token   string
+	// Attempts per request when rate limited, and the wait before the
+	// first retry (doubled after each one) if Finnhub does not say
+	attempts int
+	backoff  time.Duration
+}
+
+// NewFinnhubSource calls the API at baseURL, e.g. DefaultFinnhubURL or a
+// local stub.
+func NewFinnhubSource(client *http.Client, baseURL, token string) *FinnhubSource {
+	return &FinnhubSource{client: client, baseURL: baseURL, token: token, attempts: 5, backoff: time.Second}
+}
+
+// finnhubTicks is a page of /stock/tick. Trade i is at t[i], priced p[i],
+// for v[i], with conditions c[i].
+type finnhubTicks struct {
+	Symbol string     `json:"s"`
+	Skip   int        `json:"skip"`
+	Count  int        `json:"count"`
+	Total  int        `json:"total"`
+	Times  []int64    `json:"t"`
+	Prices []float64  `json:"p"`
+	Volume []float64  `json:"v"`
+	Conds  [][]string `json:"c"`
+}
+
+func (s *FinnhubSource) Ticks(ctx context.Context, symbol string, from, to time.Time) ([]models.TradeEvent, error) {
+	fetchedAt := time.Now()
+	var trades []models.TradeEvent
+	for day := from.UTC().Truncate(24 * time.Hour); day.Before(to); day = day.Add(24 * time.Hour) {
+		for skip := 0; ; {
+			page, err := s.page(ctx, symbol, day, skip)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch %s trades of %s: %w", symbol, day.Format(time.DateOnly), err)
+			}
+			if len(page.Prices) != len(page.Times) || len(page.Volume) != len(page.Times) {
+				return nil, fmt.Errorf("malformed %s trades of %s", symbol, day.Format(time.DateOnly))
+			}
+			for i, ms := range page.Times {
+				t := time.UnixMilli(ms)
+				if t.Before(from) || !t.Before(to) {
+					continue
+				}
+				trade := models.TradeEvent{
+					Symbol:       symbol,
+					Price:        strconv.FormatFloat(page.Prices[i], 'f', -1, 64),
+					Volume:       strconv.FormatFloat(page.Volume[i], 'f', -1, 64),
+					ExchangeTime: ms,
+					ReceiveTime:  fetchedAt.UnixMilli(),
+					Source:       events.SourceFinnhubREST,
+				}
+				if i < len(page.Conds) {
+					trade.Conditions = page.Conds[i]
+				}
+				trades = append(trades, trade)
+			}
+			skip += len(page.Times)
+			if len(page.Times) == 0 || skip >= page.Total {
+				break
+			}
+		}
+	}
+	return trades, nil
+}
+
+// page fetches the trades of a day from skip on, retrying while rate
+// limited.
+func (s *FinnhubSource) page(ctx context.Context, symbol string, day time.Time, skip int) (*finnhubTicks, error) {
+	q := url.Values{
+		"symbol": {symbol},
+		"date":   {day.Format(time.DateOnly)},
+		"limit":  {strconv.Itoa(finnhubPageSize)},
+		"skip":   {strconv.Itoa(skip)},
+		"token":  {s.token},
+	}
+	backoff := s.backoff
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/stock/tick?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusTooManyRequests && attempt < s.attempts {
+			resp.Body.Close()
+			wait := backoff
+			if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+			backoff *= 2
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(wait):
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+			return nil, fmt.Errorf("status %d: %s", resp.StatusCode, body)
+		}
+		var page finnhubTicks
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		return &page, nil
+	}
+}
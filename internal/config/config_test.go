@@ -0,0 +1,206 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerList(t *testing.T) {
+	cfg := KafkaConfig{
+		Brokers:   []string{"kafka-1:9092", "kafka-2:9092"},
+		BrokerURL: "kafka-2:9092, kafka-3:9092",
+	}
+	assert.Equal(t, []string{"kafka-1:9092", "kafka-2:9092", "kafka-3:9092"}, cfg.BrokerList())
+	assert.Empty(t, KafkaConfig{}.BrokerList())
+}
+
+func TestRetentionValidate(t *testing.T) {
+	day := 24 * time.Hour
+	testCases := []struct {
+		name        string
+		cfg         RetentionConfig
+		expectError bool
+	}{
+		{name: "keep everything", cfg: RetentionConfig{}},
+		{name: "downsample well before expiry", cfg: RetentionConfig{Trades: 30 * day, DownsampleAfter: 7 * day}},
+		{name: "expiry without downsampling", cfg: RetentionConfig{Trades: 30 * day}},
+		{name: "downsample after expiry", cfg: RetentionConfig{Trades: 7 * day, DownsampleAfter: 8 * day}, expectError: true},
+		{
+			name:        "no run between downsampling and expiry",
+			cfg:         RetentionConfig{Trades: 7 * day, DownsampleAfter: 7*day - time.Hour, Interval: time.Hour},
+			expectError: true,
+		},
+		{
+			name:        "trades expire within the lookback",
+			cfg:         RetentionConfig{Trades: 7 * day, DownsampleAfter: 6 * day, DownsampleLookback: day - time.Hour},
+			expectError: true,
+		},
+		{name: "negative", cfg: RetentionConfig{Candles: -time.Hour}, expectError: true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSnapshotRefresh(t *testing.T) {
+	assert.Equal(t, DefaultSnapshotRefresh, SnapshotConfig{}.Refresh())
+	assert.Equal(t, 5*time.Second, DefaultSnapshotRefresh)
+	assert.Equal(t, 2*time.Second, SnapshotConfig{RefreshInterval: 2 * time.Second}.Refresh())
+	assert.Negative(t, int64(SnapshotConfig{RefreshInterval: -1}.Refresh()))
+}
+
+func TestAlertsWithDefaults(t *testing.T) {
+	defaults := AlertsConfig{}.WithDefaults()
+	assert.Equal(t, "alerts-evaluator-group", defaults.GroupID)
+	assert.Equal(t, 5, defaults.MaxAttempts)
+	assert.Equal(t, time.Second, defaults.RetryBackoff)
+
+	custom := AlertsConfig{GroupID: "alerts", MaxAttempts: 2, Cooldown: time.Minute}.WithDefaults()
+	assert.Equal(t, "alerts", custom.GroupID)
+	assert.Equal(t, 2, custom.MaxAttempts)
+	assert.Equal(t, time.Minute, custom.Cooldown)
+	assert.Equal(t, 10*time.Second, custom.RulesRefresh)
+}
+
+func TestValidationWithDefaults(t *testing.T) {
+	defaults := ValidationConfig{}.WithDefaults()
+	assert.Equal(t, 0.2, defaults.MedianBand)
+	assert.Equal(t, 50, defaults.MedianWindow)
+	assert.Equal(t, time.Minute, defaults.MaxFuture)
+	assert.Equal(t, 24*time.Hour, defaults.MaxPast)
+
+	custom := ValidationConfig{MedianBand: -1, MaxPast: time.Hour}.WithDefaults()
+	assert.Equal(t, -1.0, custom.MedianBand, "a negative band should stay, disabling the check")
+	assert.Equal(t, time.Hour, custom.MaxPast)
+}
+
+func TestLatenessValidate(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         LatenessConfig
+		expectError bool
+	}{
+		{name: "disabled", cfg: LatenessConfig{}},
+		{name: "clamp", cfg: LatenessConfig{AllowedLateness: time.Minute, MaxFutureSkew: time.Second, FuturePolicy: FutureClamp}},
+		{name: "reject", cfg: LatenessConfig{MaxFutureSkew: time.Second, FuturePolicy: FutureReject}},
+		{name: "unknown policy", cfg: LatenessConfig{FuturePolicy: "drop"}, expectError: true},
+		{name: "negative lateness", cfg: LatenessConfig{AllowedLateness: -time.Second}, expectError: true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	defaults := LatenessConfig{}.WithDefaults()
+	assert.Equal(t, FutureClamp, defaults.FuturePolicy)
+	assert.Equal(t, time.Minute, defaults.ReportInterval)
+}
+
+func TestDedupConfig(t *testing.T) {
+	assert.NoError(t, DedupConfig{}.Validate())
+	assert.NoError(t, DedupConfig{Key: DedupByContent, CacheSize: 10}.Validate())
+	assert.Error(t, DedupConfig{Key: "hash"}.Validate())
+	assert.Error(t, DedupConfig{CacheSize: -1}.Validate())
+
+	assert.Equal(t, DedupConfig{Key: DedupByMessage}, DedupConfig{}.WithDefaults())
+	assert.Equal(t, DedupConfig{Key: DedupByContent, CacheSize: 100000}, DedupConfig{Key: DedupByContent}.WithDefaults())
+}
+
+func TestKafkaTopicValidate(t *testing.T) {
+	assert.NoError(t, KafkaTopicConfig{}.Validate())
+	assert.NoError(t, KafkaTopicConfig{Retention: TradeKeysTTL}.Validate())
+	assert.Error(t, KafkaTopicConfig{Retention: TradeKeysTTL + time.Hour}.Validate())
+}
+
+func TestStorageValidate(t *testing.T) {
+	assert.NoError(t, StorageConfig{}.Validate())
+	assert.NoError(t, StorageConfig{Backend: StorageMongo}.Validate())
+	assert.NoError(t, StorageConfig{Backend: StoragePostgres, PostgresURL: "postgres://localhost/trades"}.Validate())
+	assert.Error(t, StorageConfig{Backend: StoragePostgres}.Validate())
+	assert.Error(t, StorageConfig{Backend: "mysql"}.Validate())
+
+	assert.Equal(t, StorageMongo, StorageConfig{}.WithDefaults().Backend)
+}
+
+func TestLakeConfig(t *testing.T) {
+	assert.NoError(t, LakeConfig{}.Validate())
+	assert.NoError(t, LakeConfig{Compression: "none", S3: S3Config{Endpoint: "minio:9000", Bucket: "lake"}}.Validate())
+	assert.Error(t, LakeConfig{Compression: "gzip"}.Validate())
+	assert.Error(t, LakeConfig{S3: S3Config{Bucket: "lake"}}.Validate())
+
+	assert.Equal(t, LakeConfig{
+		GroupID:      "lake-writer-group",
+		Path:         "lake",
+		RollSize:     64 << 20,
+		RollInterval: 15 * time.Minute,
+		Compression:  "snappy",
+	}, LakeConfig{}.WithDefaults())
+}
+
+func TestProcessorWithDefaults(t *testing.T) {
+	assert.Equal(t, ProcessorConfig{
+		Workers:          4,
+		QueueSize:        100,
+		BatchSize:        100,
+		InitialBackoff:   100 * time.Millisecond,
+		MaxBackoff:       5 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  15 * time.Second,
+	}, ProcessorConfig{}.WithDefaults())
+	custom := ProcessorConfig{
+		Workers:          12,
+		QueueSize:        1,
+		BatchSize:        10,
+		InitialBackoff:   time.Second,
+		MaxBackoff:       time.Minute,
+		BreakerThreshold: 1,
+		BreakerCooldown:  time.Minute,
+	}
+	assert.Equal(t, custom, custom.WithDefaults())
+}
+
+func TestGapsConfig(t *testing.T) {
+	defaults := GapsConfig{}.WithDefaults()
+	assert.Equal(t, 5*time.Minute, defaults.Threshold)
+	assert.Equal(t, 15*time.Minute, defaults.Interval)
+	assert.Equal(t, 24*time.Hour, defaults.Lookback)
+	assert.Equal(t, DefaultMarketHours, defaults.MarketHours)
+	assert.NoError(t, defaults.Validate())
+
+	custom := GapsConfig{MarketHours: map[string]MarketHoursConfig{
+		"stock": {Timezone: "Europe/London", Open: "08:00", Close: "16:30", Days: []string{"Mon", "tue"}},
+	}}.WithDefaults()
+	assert.Equal(t, "Europe/London", custom.MarketHours["stock"].Timezone)
+	assert.Equal(t, DefaultMarketHours["forex"], custom.MarketHours["forex"])
+	assert.NoError(t, custom.Validate())
+
+	for _, h := range []MarketHoursConfig{
+		{Timezone: "Mars/Olympus_Mons"},
+		{Open: "9:30am"},
+		{Close: "24:00"},
+		{Days: []string{"monday"}},
+	} {
+		assert.Error(t, GapsConfig{MarketHours: map[string]MarketHoursConfig{"US": h}}.Validate(), "%+v", h)
+	}
+
+	clock, err := ParseClock("09:30")
+	assert.NoError(t, err)
+	assert.Equal(t, 9*time.Hour+30*time.Minute, clock)
+}
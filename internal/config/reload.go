@@ -0,0 +1,168 @@
+package config
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// DefaultReloadInterval is how often a Watcher checks the config file
+// for modifications.
+const DefaultReloadInterval = 5 * time.Second
+
+// RestartRequired returns the names of the settings that differ between
+// old and new but cannot be applied to a running service (connections,
+// collections and the trade pipeline are set up once at startup).
+func RestartRequired(old, new *Config) []string {
+	var fields []string
+	check := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			fields = append(fields, name)
+		}
+	}
+	check("api_port", old.APIPort, new.APIPort)
+	check("finnhub.token", old.Finnhub.Token, new.Finnhub.Token)
+	check("kafka.brokers", old.Kafka.BrokerList(), new.Kafka.BrokerList())
+	check("kafka.topic", old.Kafka.Topic, new.Kafka.Topic)
+	check("kafka.topic_settings", old.Kafka.TopicSettings, new.Kafka.TopicSettings)
+	check("kafka.tls", old.Kafka.TLS, new.Kafka.TLS)
+	check("kafka.sasl", old.Kafka.SASL, new.Kafka.SASL)
+	check("mongodb.url", old.MongoDB.URL, new.MongoDB.URL)
+	check("mongodb.database_name", old.MongoDB.DatabaseName, new.MongoDB.DatabaseName)
+	check("mongodb.collection_name", old.MongoDB.CollectionName, new.MongoDB.CollectionName)
+	check("mongodb.symbols_collection_name", old.MongoDB.SymbolsCollectionName,
+		new.MongoDB.SymbolsCollectionName)
+	check("storage", old.Storage, new.Storage)
+	check("analytics_engine", old.Analytics, new.Analytics)
+	check("validation", old.Validation, new.Validation)
+	check("lateness", old.Lateness, new.Lateness)
+	check("dedup", old.Dedup, new.Dedup)
+	check("leader_election", old.Leader, new.Leader)
+	check("processor", old.Processor, new.Processor)
+	check("lake", old.Lake, new.Lake)
+	check("gaps", old.Gaps, new.Gaps)
+	return fields
+}
+
+// keepRestartOnly copies every setting that needs a restart from old
+// into new, so that only the live-reloadable settings change.
+func keepRestartOnly(old, new *Config) {
+	new.APIPort = old.APIPort
+	new.Finnhub = old.Finnhub
+	new.Kafka = old.Kafka
+	new.MongoDB = old.MongoDB
+	new.Storage = old.Storage
+	new.Analytics = old.Analytics
+	new.Validation = old.Validation
+	new.Lateness = old.Lateness
+	new.Dedup = old.Dedup
+	new.Leader = old.Leader
+	new.Processor = old.Processor
+	new.Lake = old.Lake
+	new.Gaps = old.Gaps
+}
+
+// Watcher keeps the current configuration of a running service and
+// reloads it when the file changes on disk or the process gets SIGHUP.
+type Watcher struct {
+	path     string
+	interval time.Duration
+	modTime  time.Time
+
+	current atomic.Pointer[Config]
+
+	mu        sync.Mutex
+	callbacks []func(old, new *Config)
+}
+
+// NewWatcher returns a Watcher for the file at path, starting from cfg
+// (which should have been loaded from that same file).
+func NewWatcher(path string, cfg *Config, interval time.Duration) *Watcher {
+	w := &Watcher{path: path, interval: interval}
+	if info, err := os.Stat(path); err == nil {
+		w.modTime = info.ModTime()
+	}
+	w.current.Store(cfg)
+	return w
+}
+
+// Current returns the configuration currently in effect. The returned
+// value must be treated as read-only.
+func (w *Watcher) Current() *Config {
+	return w.current.Load()
+}
+
+// OnReload registers fn to be called after every successful reload.
+// Callbacks run one at a time, on the watcher's goroutine.
+func (w *Watcher) OnReload(fn func(old, new *Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callbacks = append(w.callbacks, fn)
+}
+
+// Reload reads the config file again and applies it. Settings that need
+// a restart keep their current value and are reported in the log.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	next, err := LoadConfig(w.path)
+	if err != nil {
+		return err
+	}
+	old := w.current.Load()
+
+	if rejected := RestartRequired(old, next); len(rejected) > 0 {
+		log.Printf("Config reload: ignoring change to %s (requires a restart)",
+			strings.Join(rejected, ", "))
+		keepRestartOnly(old, next)
+	}
+	if reflect.DeepEqual(old, next) {
+		return nil
+	}
+
+	w.current.Store(next)
+	log.Printf("Config reload: applied new configuration from %s", w.path)
+	for _, fn := range w.callbacks {
+		fn(old, next)
+	}
+	return nil
+}
+
+// Run polls the config file and listens for SIGHUP until ctx is done.
+func (w *Watcher) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Println("Config reload: received SIGHUP")
+			if err := w.Reload(); err != nil {
+				log.Printf("Config reload failed: %v", err)
+			}
+		case <-ticker.C:
+			info, err := os.Stat(w.path)
+			if err != nil || !info.ModTime().After(w.modTime) {
+				continue
+			}
+			w.modTime = info.ModTime()
+			if err := w.Reload(); err != nil {
+				log.Printf("Config reload failed: %v", err)
+			}
+		}
+	}
+}
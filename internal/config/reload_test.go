@@ -0,0 +1,159 @@
+package config
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const baseConfig = `
+api_port: "8000"
+kafka:
+  broker_url: "kafka:29092"
+  topic: "raw_stock_ticks"
+mongodb:
+  database_name: "financialDataDatabase"
+subscribed_symbols: ["AAPL"]
+timeouts:
+  api_request: "5s"
+logging:
+  level: "info"
+`
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := &Config{APIPort: "8000", Kafka: KafkaConfig{BrokerURL: "a:9092"}}
+
+	changed := *old
+	changed.Kafka.BrokerURL = "b:9092"
+	changed.MongoDB.DatabaseName = "other"
+	changed.Timeouts.APIRequest = time.Second
+
+	assert.Equal(t, []string{"kafka.brokers", "mongodb.database_name"},
+		RestartRequired(old, &changed))
+	assert.Empty(t, RestartRequired(old, old))
+}
+
+func TestWatcherReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	writeConfig(t, path, baseConfig)
+	cfg, err := LoadConfig(path)
+	assert.NoError(t, err)
+
+	w := NewWatcher(path, cfg, time.Hour)
+	var calls int
+	w.OnReload(func(old, new *Config) {
+		calls++
+		assert.Equal(t, 5*time.Second, old.Timeouts.APIRequest)
+	})
+
+	// Safe changes are applied, restart-only changes are kept as they were.
+	writeConfig(t, path, `
+api_port: "9000"
+kafka:
+  broker_url: "other:9092"
+  topic: "raw_stock_ticks"
+mongodb:
+  database_name: "financialDataDatabase"
+subscribed_symbols: ["AAPL", "MSFT"]
+timeouts:
+  api_request: "2s"
+logging:
+  level: "debug"
+`)
+	assert.NoError(t, w.Reload())
+
+	current := w.Current()
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, 2*time.Second, current.Timeouts.APIRequest)
+	assert.Equal(t, "debug", current.Logging.Level)
+	assert.Equal(t, []string{"AAPL", "MSFT"}, current.Symbols)
+	assert.Equal(t, "8000", current.APIPort)
+	assert.Equal(t, "kafka:29092", current.Kafka.BrokerURL)
+
+	// Reloading an unchanged file does not notify anyone.
+	assert.NoError(t, w.Reload())
+	assert.Equal(t, 1, calls)
+
+	// A broken file keeps the current configuration.
+	writeConfig(t, path, "timeouts: [")
+	assert.Error(t, w.Reload())
+	assert.Same(t, current, w.Current())
+}
+
+func TestWatcherReloadRestartOnly(t *testing.T) {
+	testCases := []struct {
+		name    string
+		section string // YAML added to baseConfig
+		kept    func(t *testing.T, cfg *Config)
+	}{
+		{
+			name:    "validation",
+			section: "validation:\n  median_band: 0.5\n",
+			kept:    func(t *testing.T, cfg *Config) { assert.Zero(t, cfg.Validation.MedianBand) },
+		},
+		{
+			name:    "lateness",
+			section: "lateness:\n  allowed_lateness: 30s\n",
+			kept:    func(t *testing.T, cfg *Config) { assert.Zero(t, cfg.Lateness.AllowedLateness) },
+		},
+		{
+			name:    "dedup",
+			section: "dedup:\n  key: content\n  cache_size: 10\n",
+			kept:    func(t *testing.T, cfg *Config) { assert.Zero(t, cfg.Dedup.CacheSize) },
+		},
+		{
+			name:    "leader_election",
+			section: "leader_election:\n  enabled: true\n",
+			kept:    func(t *testing.T, cfg *Config) { assert.False(t, cfg.Leader.Enabled) },
+		},
+		{
+			name:    "processor",
+			section: "processor:\n  workers: 12\n  batch_size: 10\n",
+			kept:    func(t *testing.T, cfg *Config) { assert.Zero(t, cfg.Processor.Workers) },
+		},
+		{
+			name:    "lake",
+			section: "lake:\n  path: other\n",
+			kept:    func(t *testing.T, cfg *Config) { assert.Empty(t, cfg.Lake.Path) },
+		},
+		{
+			name:    "gaps",
+			section: "gaps:\n  threshold: 10m\n",
+			kept:    func(t *testing.T, cfg *Config) { assert.Zero(t, cfg.Gaps.Threshold) },
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yml")
+			writeConfig(t, path, baseConfig)
+			cfg, err := LoadConfig(path)
+			assert.NoError(t, err)
+			w := NewWatcher(path, cfg, time.Hour)
+
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			defer log.SetOutput(os.Stderr)
+
+			writeConfig(t, path, baseConfig+tt.section)
+			assert.NoError(t, w.Reload())
+
+			assert.Contains(t, logs.String(), "ignoring change to "+tt.name+" (requires a restart)")
+			assert.NotContains(t, logs.String(), "applied new configuration")
+			assert.Same(t, cfg, w.Current())
+			tt.kept(t, w.Current())
+		})
+	}
+}
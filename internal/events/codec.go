@@ -0,0 +1,107 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"financial-data-backend-2/internal/events/eventspb"
+	"financial-data-backend-2/internal/models"
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+)
+
+//go:generate protoc -I . --go_out=../.. --go_opt=module=financial-data-backend-2 --python_out=../../python-analytics trade_event.proto
+
+const (
+	// HeaderContentType says how a trade event is encoded. Versioned
+	// messages without it are JSON.
+	HeaderContentType = "content-type"
+
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Codec encodes trade events for Kafka.
+type Codec interface {
+	ContentType() string
+	Marshal(models.TradeEvent) ([]byte, error)
+	Unmarshal([]byte, *models.TradeEvent) error
+}
+
+var (
+	JSON     Codec = jsonCodec{}
+	Protobuf Codec = protobufCodec{}
+)
+
+// CodecFor returns the codec for a config value: "json" (the default)
+// or "protobuf".
+func CodecFor(encoding string) (Codec, error) {
+	switch encoding {
+	case "", "json":
+		return JSON, nil
+	case "protobuf":
+		return Protobuf, nil
+	}
+	return nil, fmt.Errorf("unknown trade encoding %q", encoding)
+}
+
+func codecForContentType(contentType string) (Codec, error) {
+	switch contentType {
+	case "", ContentTypeJSON:
+		return JSON, nil
+	case ContentTypeProtobuf:
+		return Protobuf, nil
+	}
+	return nil, fmt.Errorf("unsupported trade content type %q", contentType)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return ContentTypeJSON }
+
+func (jsonCodec) Marshal(trade models.TradeEvent) ([]byte, error) {
+	return json.Marshal(trade)
+}
+
+func (jsonCodec) Unmarshal(data []byte, trade *models.TradeEvent) error {
+	return json.Unmarshal(data, trade)
+}
+
+// protobufCodec encodes the TradeEvent message in trade_event.proto with
+// the generated eventspb package.
+type protobufCodec struct{}
+
+var errMalformedProtobuf = errors.New("malformed protobuf trade event")
+
+func (protobufCodec) ContentType() string { return ContentTypeProtobuf }
+
+func (protobufCodec) Marshal(trade models.TradeEvent) ([]byte, error) {
+	return proto.Marshal(&eventspb.TradeEvent{
+		Symbol:       trade.Symbol,
+		Price:        trade.Price,
+		Volume:       trade.Volume,
+		ExchangeTime: trade.ExchangeTime,
+		ReceiveTime:  trade.ReceiveTime,
+		Source:       trade.Source,
+		Sequence:     int32(trade.Sequence),
+		Conditions:   trade.Conditions,
+	})
+}
+
+func (protobufCodec) Unmarshal(b []byte, trade *models.TradeEvent) error {
+	var msg eventspb.TradeEvent
+	if err := proto.Unmarshal(b, &msg); err != nil {
+		return fmt.Errorf("%w: %v", errMalformedProtobuf, err)
+	}
+	*trade = models.TradeEvent{
+		Symbol:       msg.Symbol,
+		Price:        msg.Price,
+		Volume:       msg.Volume,
+		ExchangeTime: msg.ExchangeTime,
+		ReceiveTime:  msg.ReceiveTime,
+		Source:       msg.Source,
+		Sequence:     int(msg.Sequence),
+		Conditions:   msg.Conditions,
+	}
+	return nil
+}
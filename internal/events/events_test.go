@@ -0,0 +1,167 @@
+package events
+
+import (
+	"financial-data-backend-2/internal/models"
+	"testing"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromFinnhub(t *testing.T) {
+	receivedAt := time.UnixMilli(1700000000500)
+	msg := models.FinnhubTradeMessage{
+		Type: "trade",
+		Data: []models.FinnhubTradeData{
+			{Symbol: "AAPL", Price: 196.38, Volume: 100, Timestamp: 1700000000123, Conditions: []string{"1", "I"}},
+			{Symbol: "BINANCE:BTCUSDT", Price: 64250.1, Volume: 0.00042, Timestamp: 1700000000124},
+		},
+	}
+
+	trades := FromFinnhub(msg, receivedAt)
+
+	assert.Equal(t, []models.TradeEvent{
+		{
+			Symbol: "AAPL", Price: "196.38", Volume: "100",
+			ExchangeTime: 1700000000123, ReceiveTime: 1700000000500,
+			Source: SourceFinnhub, Sequence: 0, Conditions: []string{"1", "I"},
+		},
+		{
+			Symbol: "BINANCE:BTCUSDT", Price: "64250.1", Volume: "0.00042",
+			ExchangeTime: 1700000000124, ReceiveTime: 1700000000500,
+			Source: SourceFinnhub, Sequence: 1,
+		},
+	}, trades)
+	assert.Empty(t, FromFinnhub(models.FinnhubTradeMessage{Type: "ping"}, receivedAt))
+}
+
+func TestEncodeDecodeTrade(t *testing.T) {
+	trade := models.TradeEvent{
+		Symbol: "MSFT", Price: "301.5", Volume: "20",
+		ExchangeTime: 1700000000000, ReceiveTime: 1700000000010,
+		Source: SourceFinnhub, Sequence: 2, Conditions: []string{"12"},
+	}
+
+	for _, codec := range []Codec{JSON, Protobuf} {
+		t.Run(codec.ContentType(), func(t *testing.T) {
+			m, err := EncodeTrade(trade, codec)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("MSFT"), m.Key)
+			assert.Equal(t, TradeSchemaVersion, Header(m, HeaderSchemaVersion))
+			assert.Equal(t, codec.ContentType(), Header(m, HeaderContentType))
+
+			decoded, err := DecodeTrades(m)
+			assert.NoError(t, err)
+			assert.Equal(t, []models.TradeEvent{trade}, decoded)
+		})
+	}
+}
+
+func TestDecodeTradesContentType(t *testing.T) {
+	// Versioned JSON messages from before the content-type header existed
+	m := kafkaGo.Message{
+		Headers: []kafkaGo.Header{{Key: HeaderSchemaVersion, Value: []byte(TradeSchemaVersion)}},
+		Value:   []byte(`{"symbol":"AAPL","price":"1","volume":"2","exchange_time":3}`),
+	}
+	trades, err := DecodeTrades(m)
+	assert.NoError(t, err)
+	assert.Equal(t, "AAPL", trades[0].Symbol)
+
+	m.Headers = append(m.Headers, kafkaGo.Header{Key: HeaderContentType, Value: []byte("application/avro")})
+	_, err = DecodeTrades(m)
+	assert.Error(t, err)
+}
+
+func TestProtobufCodec(t *testing.T) {
+	// The encoding is pinned, so other languages can rely on what we write.
+	trade := models.TradeEvent{Symbol: "A", Price: "1.5", ExchangeTime: 300, Sequence: -1, Conditions: []string{"x", ""}}
+	expected := []byte{
+		0x0a, 0x01, 'A', // symbol
+		0x12, 0x03, '1', '.', '5', // price
+		0x20, 0xac, 0x02, // exchange_time = 300
+		0x38, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01, // sequence = -1
+		0x42, 0x01, 'x', // conditions
+		0x42, 0x00,
+	}
+	b, err := Protobuf.Marshal(trade)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, b)
+
+	var decoded models.TradeEvent
+	assert.NoError(t, Protobuf.Unmarshal(b, &decoded))
+	assert.Equal(t, trade, decoded)
+
+	// Unknown fields from a newer schema are skipped.
+	withUnknown := append([]byte{0x78, 0x05}, b...) // field 15 = 5
+	assert.NoError(t, Protobuf.Unmarshal(withUnknown, &decoded))
+	assert.Equal(t, trade, decoded)
+
+	// Truncated input is an error.
+	assert.Error(t, Protobuf.Unmarshal(b[:len(b)-3], &decoded))
+}
+
+func TestCodecFor(t *testing.T) {
+	codec, err := CodecFor("")
+	assert.NoError(t, err)
+	assert.Equal(t, JSON, codec)
+	codec, err = CodecFor("protobuf")
+	assert.NoError(t, err)
+	assert.Equal(t, Protobuf, codec)
+	_, err = CodecFor("avro")
+	assert.Error(t, err)
+}
+
+func TestDecodeTradesLegacy(t *testing.T) {
+	m := kafkaGo.Message{
+		Value: []byte(`{"type":"trade","data":[{"s":"AAPL","p":150.75,"v":100.5,"t":1678886400123}]}`),
+		Time:  time.UnixMilli(1678886400200),
+	}
+
+	trades, err := DecodeTrades(m)
+	assert.NoError(t, err)
+	assert.Equal(t, []models.TradeEvent{{
+		Symbol: "AAPL", Price: "150.75", Volume: "100.5",
+		ExchangeTime: 1678886400123, ReceiveTime: 1678886400200,
+		Source: SourceFinnhub,
+	}}, trades)
+
+	trades, err = DecodeTrades(kafkaGo.Message{Value: []byte(`{"type":"ping"}`)})
+	assert.NoError(t, err)
+	assert.Empty(t, trades)
+
+	_, err = DecodeTrades(kafkaGo.Message{Value: []byte(`not json`)})
+	assert.Error(t, err)
+}
+
+var benchTrade = models.TradeEvent{
+	Symbol: "BINANCE:BTCUSDT", Price: "64250.12", Volume: "0.0042",
+	ExchangeTime: 1700000000123, ReceiveTime: 1700000000200,
+	Source: SourceFinnhub, Sequence: 3, Conditions: []string{"1", "12"},
+}
+
+func benchmarkEncode(b *testing.B, codec Codec) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := EncodeTrade(benchTrade, codec); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkDecode(b *testing.B, codec Codec) {
+	m, _ := EncodeTrade(benchTrade, codec)
+	b.SetBytes(int64(len(m.Value)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := DecodeTrades(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeJSON(b *testing.B)     { benchmarkEncode(b, JSON) }
+func BenchmarkEncodeProtobuf(b *testing.B) { benchmarkEncode(b, Protobuf) }
+func BenchmarkDecodeJSON(b *testing.B)     { benchmarkDecode(b, JSON) }
+func BenchmarkDecodeProtobuf(b *testing.B) { benchmarkDecode(b, Protobuf) }
@@ -0,0 +1,194 @@
+// Wire schema of models.TradeEvent when the trade topic uses the
+// "protobuf" encoding (content-type application/x-protobuf).
+// Field numbers must never be reused. After changing this file, run
+// go generate ./internal/events to regenerate eventspb and
+// python-analytics/trade_event_pb2.py.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        v5.28.3
+// source: trade_event.proto
+
+package eventspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type TradeEvent struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Symbol        string                 `protobuf:"bytes,1,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	Price         string                 `protobuf:"bytes,2,opt,name=price,proto3" json:"price,omitempty"`                                    // Decimal string, e.g. "196.38"
+	Volume        string                 `protobuf:"bytes,3,opt,name=volume,proto3" json:"volume,omitempty"`                                  // Decimal string
+	ExchangeTime  int64                  `protobuf:"varint,4,opt,name=exchange_time,json=exchangeTime,proto3" json:"exchange_time,omitempty"` // Unix milliseconds
+	ReceiveTime   int64                  `protobuf:"varint,5,opt,name=receive_time,json=receiveTime,proto3" json:"receive_time,omitempty"`    // Unix milliseconds
+	Source        string                 `protobuf:"bytes,6,opt,name=source,proto3" json:"source,omitempty"`
+	Sequence      int32                  `protobuf:"varint,7,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Conditions    []string               `protobuf:"bytes,8,rep,name=conditions,proto3" json:"conditions,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TradeEvent) Reset() {
+	*x = TradeEvent{}
+	mi := &file_trade_event_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TradeEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TradeEvent) ProtoMessage() {}
+
+func (x *TradeEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_trade_event_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TradeEvent.ProtoReflect.Descriptor instead.
+func (*TradeEvent) Descriptor() ([]byte, []int) {
+	return file_trade_event_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *TradeEvent) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+func (x *TradeEvent) GetPrice() string {
+	if x != nil {
+		return x.Price
+	}
+	return ""
+}
+
+func (x *TradeEvent) GetVolume() string {
+	if x != nil {
+		return x.Volume
+	}
+	return ""
+}
+
+func (x *TradeEvent) GetExchangeTime() int64 {
+	if x != nil {
+		return x.ExchangeTime
+	}
+	return 0
+}
+
+func (x *TradeEvent) GetReceiveTime() int64 {
+	if x != nil {
+		return x.ReceiveTime
+	}
+	return 0
+}
+
+func (x *TradeEvent) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *TradeEvent) GetSequence() int32 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *TradeEvent) GetConditions() []string {
+	if x != nil {
+		return x.Conditions
+	}
+	return nil
+}
+
+var File_trade_event_proto protoreflect.FileDescriptor
+
+const file_trade_event_proto_rawDesc = "" +
+	"\n" +
+	"\x11trade_event.proto\x12\x17financialdata.events.v1\"\xee\x01\n" +
+	"\n" +
+	"TradeEvent\x12\x16\n" +
+	"\x06symbol\x18\x01 \x01(\tR\x06symbol\x12\x14\n" +
+	"\x05price\x18\x02 \x01(\tR\x05price\x12\x16\n" +
+	"\x06volume\x18\x03 \x01(\tR\x06volume\x12#\n" +
+	"\rexchange_time\x18\x04 \x01(\x03R\fexchangeTime\x12!\n" +
+	"\freceive_time\x18\x05 \x01(\x03R\vreceiveTime\x12\x16\n" +
+	"\x06source\x18\x06 \x01(\tR\x06source\x12\x1a\n" +
+	"\bsequence\x18\a \x01(\x05R\bsequence\x12\x1e\n" +
+	"\n" +
+	"conditions\x18\b \x03(\tR\n" +
+	"conditionsB3Z1financial-data-backend-2/internal/events/eventspbb\x06proto3"
+
+var (
+	file_trade_event_proto_rawDescOnce sync.Once
+	file_trade_event_proto_rawDescData []byte
+)
+
+func file_trade_event_proto_rawDescGZIP() []byte {
+	file_trade_event_proto_rawDescOnce.Do(func() {
+		file_trade_event_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_trade_event_proto_rawDesc), len(file_trade_event_proto_rawDesc)))
+	})
+	return file_trade_event_proto_rawDescData
+}
+
+var file_trade_event_proto_msgTypes = make([]protoimpl.MessageInfo, 1)
+var file_trade_event_proto_goTypes = []any{
+	(*TradeEvent)(nil), // 0: financialdata.events.v1.TradeEvent
+}
+var file_trade_event_proto_depIdxs = []int32{
+	0, // [0:0] is the sub-list for method output_type
+	0, // [0:0] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_trade_event_proto_init() }
+func file_trade_event_proto_init() {
+	if File_trade_event_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_trade_event_proto_rawDesc), len(file_trade_event_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   1,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_trade_event_proto_goTypes,
+		DependencyIndexes: file_trade_event_proto_depIdxs,
+		MessageInfos:      file_trade_event_proto_msgTypes,
+	}.Build()
+	File_trade_event_proto = out.File
+	file_trade_event_proto_goTypes = nil
+	file_trade_event_proto_depIdxs = nil
+}
@@ -0,0 +1,97 @@
+package events
+
+import (
+	"encoding/json"
+	"financial-data-backend-2/internal/models"
+	"fmt"
+	"strconv"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+)
+
+const (
+	// HeaderSchemaVersion marks messages carrying a models.TradeEvent.
+	// Messages without it are legacy, raw Finnhub frames.
+	HeaderSchemaVersion = "schema-version"
+	TradeSchemaVersion  = "1"
+
+	SourceFinnhub = "finnhub"
+	// Trades fetched afterwards from Finnhub's REST API, by go-backfill
+	SourceFinnhubREST = "finnhub-rest"
+)
+
+// FromFinnhub converts a Finnhub trade frame into trade events.
+func FromFinnhub(msg models.FinnhubTradeMessage, receivedAt time.Time) []models.TradeEvent {
+	if msg.Type != "trade" {
+		return nil
+	}
+	trades := make([]models.TradeEvent, 0, len(msg.Data))
+	for i, trade := range msg.Data {
+		trades = append(trades, models.TradeEvent{
+			Symbol:       trade.Symbol,
+			Price:        strconv.FormatFloat(trade.Price, 'f', -1, 64),
+			Volume:       strconv.FormatFloat(trade.Volume, 'f', -1, 64),
+			ExchangeTime: trade.Timestamp,
+			ReceiveTime:  receivedAt.UnixMilli(),
+			Source:       SourceFinnhub,
+			Sequence:     i,
+			Conditions:   trade.Conditions,
+		})
+	}
+	return trades
+}
+
+// EncodeTrade builds the Kafka message for a trade. It is keyed by
+// symbol, so that trades of one symbol stay in order on one partition.
+func EncodeTrade(trade models.TradeEvent, codec Codec) (kafkaGo.Message, error) {
+	value, err := codec.Marshal(trade)
+	if err != nil {
+		return kafkaGo.Message{}, err
+	}
+	return kafkaGo.Message{
+		Key:   []byte(trade.Symbol),
+		Value: value,
+		Time:  time.UnixMilli(trade.ReceiveTime),
+		Headers: []kafkaGo.Header{
+			{Key: HeaderSchemaVersion, Value: []byte(TradeSchemaVersion)},
+			{Key: HeaderContentType, Value: []byte(codec.ContentType())},
+		},
+	}, nil
+}
+
+// Header returns the value of the named header, or "" if it is absent.
+func Header(m kafkaGo.Message, key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+// DecodeTrades reads the trades in a Kafka message, whether it holds a
+// trade event or a legacy Finnhub frame. Messages without trades (such
+// as pings) return no trades and no error.
+func DecodeTrades(m kafkaGo.Message) ([]models.TradeEvent, error) {
+	switch version := Header(m, HeaderSchemaVersion); version {
+	case "":
+		var finnMsg models.FinnhubTradeMessage
+		if err := json.Unmarshal(m.Value, &finnMsg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
+		}
+		return FromFinnhub(finnMsg, m.Time), nil
+	case TradeSchemaVersion:
+		codec, err := codecForContentType(Header(m, HeaderContentType))
+		if err != nil {
+			return nil, err
+		}
+		var trade models.TradeEvent
+		if err := codec.Unmarshal(m.Value, &trade); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal trade event: %w", err)
+		}
+		return []models.TradeEvent{trade}, nil
+	default:
+		return nil, fmt.Errorf("unsupported trade schema version %q", version)
+	}
+}
@@ -0,0 +1,197 @@
+package gaps
+
+import (
+	"context"
+	"errors"
+	"financial-data-backend-2/internal/models"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store keeps the gaps found, and where each symbol's next scan starts.
+type Store interface {
+	// ResumeAt returns where the symbol's next scan starts. found is false
+	// before its first scan.
+	ResumeAt(ctx context.Context, symbol string) (resumeAt time.Time, found bool, err error)
+	// GapAt returns the symbol's gap that contains t, or nil.
+	GapAt(ctx context.Context, symbol string, t time.Time) (*models.DataGap, error)
+	// Save replaces the symbol's gaps from scanned on with gaps, and
+	// records where its next scan starts.
+	Save(ctx context.Context, symbol string, scanned time.Time, gaps []models.DataGap, resumeAt time.Time) error
+}
+
+// Found is a gap found by a scan, compared with the one stored by earlier
+// scans, if any.
+type Found struct {
+	models.DataGap
+	// Not stored before
+	New bool
+	// Stored before, but it has since ended, or changed otherwise. An
+	// ongoing gap that has only grown has not changed.
+	Changed bool
+}
+
+// Detector scans symbols' trades for gaps during market hours.
+type Detector struct {
+	times     TradeTimes
+	store     Store
+	calendar  *Calendar
+	threshold time.Duration
+	lookback  time.Duration
+}
+
+// NewDetector reports intervals without trades of at least threshold.
+// A symbol's first scan starts lookback ago.
+func NewDetector(times TradeTimes, store Store, calendar *Calendar, threshold, lookback time.Duration) *Detector {
+	return &Detector{times: times, store: store, calendar: calendar, threshold: threshold, lookback: lookback}
+}
+
+// Scan looks for the symbol's gaps up to now, from where its last scan
+// left off, and returns those it found. A gap that is still going on is
+// scanned again next time, and updated.
+func (d *Detector) Scan(ctx context.Context, symbol string, now time.Time) ([]Found, error) {
+	start, found, err := d.store.ResumeAt(ctx, symbol)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		start = now.Add(-d.lookback)
+	}
+	return d.scan(ctx, symbol, start, now)
+}
+
+// Rescan looks for the symbol's gaps from from on, e.g. after a backfill,
+// replacing those found before.
+func (d *Detector) Rescan(ctx context.Context, symbol string, from, now time.Time) ([]Found, error) {
+	// Start with the gap from is in, rather than report part of it
+	gap, err := d.store.GapAt(ctx, symbol, from)
+	if err != nil {
+		return nil, err
+	}
+	if gap != nil {
+		from = gap.From
+	}
+	return d.scan(ctx, symbol, from, now)
+}
+
+func (d *Detector) scan(ctx context.Context, symbol string, start, now time.Time) ([]Found, error) {
+	if !start.Before(now) {
+		return nil, nil
+	}
+	var gaps []models.DataGap
+	// Scan up to now, unless the market is open then: the scan resumes
+	// with its last interval without trades, which may grow into a gap.
+	resumeAt := now
+	for _, session := range d.calendar.For(symbol).Sessions(start, now) {
+		found, tail, err := find(ctx, d.times, symbol, session[0], session[1], d.threshold)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s from %v: %w", symbol, session[0], err)
+		}
+		for _, g := range found {
+			gaps = append(gaps, models.DataGap{Symbol: symbol, From: g.From, To: g.To,
+				Ongoing: g.To.Equal(now), DetectedAt: now, UpdatedAt: now})
+		}
+		if session[1].Equal(now) {
+			resumeAt = tail
+		}
+	}
+	found, err := d.compare(ctx, symbol, gaps)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.store.Save(ctx, symbol, start, gaps, resumeAt); err != nil {
+		return nil, fmt.Errorf("failed to save the gaps of %s: %w", symbol, err)
+	}
+	return found, nil
+}
+
+// compare tells which of the symbol's gaps are new, or have changed since
+// they were stored.
+func (d *Detector) compare(ctx context.Context, symbol string, gaps []models.DataGap) ([]Found, error) {
+	found := make([]Found, len(gaps))
+	for i, g := range gaps {
+		found[i].DataGap = g
+		old, err := d.store.GapAt(ctx, symbol, g.From)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the gaps of %s: %w", symbol, err)
+		}
+		switch {
+		case old == nil || !old.From.Equal(g.From):
+			found[i].New = true
+		case old.Ongoing != g.Ongoing:
+			found[i].Changed = true
+		case !g.Ongoing && !old.To.Equal(g.To):
+			found[i].Changed = true
+		}
+	}
+	return found, nil
+}
+
+// MongoStore keeps gaps in the data gaps collection, and where scans
+// resume in the gap scans collection.
+type MongoStore struct {
+	gaps  *mongo.Collection
+	scans *mongo.Collection
+}
+
+func NewMongoStore(gaps, scans *mongo.Collection) *MongoStore {
+	return &MongoStore{gaps: gaps, scans: scans}
+}
+
+func (s *MongoStore) ResumeAt(ctx context.Context, symbol string) (time.Time, bool, error) {
+	var doc struct {
+		ResumeAt time.Time `bson:"resume_at"`
+	}
+	err := s.scans.FindOne(ctx, bson.M{"_id": symbol}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return doc.ResumeAt, true, nil
+}
+
+func (s *MongoStore) GapAt(ctx context.Context, symbol string, t time.Time) (*models.DataGap, error) {
+	var gap models.DataGap
+	filter := bson.M{"symbol": symbol, "from": bson.M{"$lte": t}, "to": bson.M{"$gt": t}}
+	err := s.gaps.FindOne(ctx, filter).Decode(&gap)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &gap, nil
+}
+
+// Save upserts each gap by its start, keeping when it was first detected,
+// and deletes the gaps from scanned on that were not found again, e.g.
+// because they were backfilled. Where the next scan resumes is recorded
+// last, so that a failed save is retried by the next scan.
+func (s *MongoStore) Save(ctx context.Context, symbol string, scanned time.Time, gaps []models.DataGap, resumeAt time.Time) error {
+	starts := make([]time.Time, len(gaps))
+	for i, g := range gaps {
+		starts[i] = g.From
+		update := bson.M{
+			"$set":         bson.M{"to": g.To, "ongoing": g.Ongoing, "updated_at": g.UpdatedAt},
+			"$setOnInsert": bson.M{"detected_at": g.DetectedAt},
+		}
+		_, err := s.gaps.UpdateOne(ctx, bson.M{"symbol": symbol, "from": g.From}, update,
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return err
+		}
+	}
+	_, err := s.gaps.DeleteMany(ctx, bson.M{"symbol": symbol, "from": bson.M{"$gte": scanned, "$nin": starts}})
+	if err != nil {
+		return err
+	}
+	_, err = s.scans.UpdateOne(ctx, bson.M{"_id": symbol}, bson.M{"$set": bson.M{"resume_at": resumeAt}},
+		options.Update().SetUpsert(true))
+	return err
+}
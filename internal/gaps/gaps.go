@@ -0,0 +1,130 @@
+// Package gaps finds the intervals in which a symbol has no stored trades,
+// e.g. because the pipeline was down.
+package gaps
+
+import (
+	"context"
+	"database/sql"
+	"financial-data-backend-2/internal/postgres"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Trade times are whole milliseconds, as the exchanges report them.
+const resolution = time.Millisecond
+
+// Gap is an interval [From, To) without trades of a symbol: From is just
+// after a trade, or the start of the scan, and To is the next trade, or
+// the end of the scan.
+type Gap struct {
+	Symbol string    `json:"symbol" bson:"symbol"`
+	From   time.Time `json:"from" bson:"from"`
+	To     time.Time `json:"to" bson:"to"`
+}
+
+func (g Gap) Duration() time.Duration {
+	return g.To.Sub(g.From)
+}
+
+// Contains reports whether t is in the gap.
+func (g Gap) Contains(t time.Time) bool {
+	return !t.Before(g.From) && t.Before(g.To)
+}
+
+// TradeTimes lists when a symbol's trades are.
+type TradeTimes interface {
+	// Scan calls fn with the time of each stored trade of the symbol in
+	// [from, to), in order, flagged or not.
+	Scan(ctx context.Context, symbol string, from, to time.Time, fn func(time.Time) error) error
+}
+
+// Find returns the gaps of at least minGap in the symbol's trades in
+// [from, to).
+func Find(ctx context.Context, times TradeTimes, symbol string, from, to time.Time, minGap time.Duration) ([]Gap, error) {
+	gaps, _, err := find(ctx, times, symbol, from, to, minGap)
+	return gaps, err
+}
+
+// find also returns when the trailing interval without trades starts,
+// gap or not: just after the last trade, or from if there is none.
+func find(ctx context.Context, times TradeTimes, symbol string, from, to time.Time, minGap time.Duration) ([]Gap, time.Time, error) {
+	var gaps []Gap
+	start := from
+	add := func(end time.Time) {
+		if g := (Gap{Symbol: symbol, From: start, To: end}); g.Duration() >= minGap {
+			gaps = append(gaps, g)
+		}
+	}
+	err := times.Scan(ctx, symbol, from, to, func(t time.Time) error {
+		add(t)
+		start = t.Add(resolution)
+		return nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	add(to)
+	return gaps, start, nil
+}
+
+// MongoTradeTimes reads trade times from the trades collection.
+type MongoTradeTimes struct {
+	trades *mongo.Collection
+}
+
+func NewMongoTradeTimes(trades *mongo.Collection) *MongoTradeTimes {
+	return &MongoTradeTimes{trades: trades}
+}
+
+func (m *MongoTradeTimes) Scan(ctx context.Context, symbol string, from, to time.Time, fn func(time.Time) error) error {
+	filter := bson.M{"symbol": symbol, "time": bson.M{"$gte": from, "$lt": to}}
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}}).SetProjection(bson.M{"_id": 0, "time": 1})
+	cursor, err := m.trades.Find(ctx, filter, opts)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var doc struct {
+			Time time.Time `bson:"time"`
+		}
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if err := fn(doc.Time); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// PostgresTradeTimes reads trade times from the trades table.
+type PostgresTradeTimes struct {
+	db *sql.DB
+}
+
+func NewPostgresTradeTimes(db *sql.DB) *PostgresTradeTimes {
+	return &PostgresTradeTimes{db: db}
+}
+
+func (p *PostgresTradeTimes) Scan(ctx context.Context, symbol string, from, to time.Time, fn func(time.Time) error) error {
+	rows, err := p.db.QueryContext(ctx, `SELECT time FROM `+postgres.TradesTable+`
+		WHERE symbol = $1 AND time >= $2 AND time < $3 ORDER BY time`, symbol, from, to)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return err
+		}
+		if err := fn(t.UTC()); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
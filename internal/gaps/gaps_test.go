@@ -0,0 +1,267 @@
+package gaps
+
+import (
+	"context"
+	"financial-data-backend-2/internal/config"
+	"financial-data-backend-2/internal/models"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var start = time.Date(2025, 11, 20, 14, 0, 0, 0, time.UTC)
+
+// sliceTimes holds the trade times of one symbol, in order.
+type sliceTimes []time.Time
+
+func (s sliceTimes) Scan(ctx context.Context, symbol string, from, to time.Time, fn func(time.Time) error) error {
+	for _, t := range s {
+		if !t.Before(from) && t.Before(to) {
+			if err := fn(t); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func TestFind(t *testing.T) {
+	ms := time.Millisecond
+	testCases := []struct {
+		name     string
+		times    sliceTimes
+		expected []Gap
+	}{
+		{name: "no trades", expected: []Gap{{Symbol: "AAPL", From: start, To: start.Add(time.Hour)}}},
+		{
+			name:  "trades throughout",
+			times: sliceTimes{start, start.Add(9 * time.Minute), start.Add(18 * time.Minute), start.Add(27 * time.Minute), start.Add(36 * time.Minute), start.Add(45 * time.Minute), start.Add(54 * time.Minute)},
+		},
+		{
+			name:  "gap in the middle",
+			times: sliceTimes{start.Add(time.Minute), start.Add(30 * time.Minute), start.Add(55 * time.Minute)},
+			expected: []Gap{
+				{Symbol: "AAPL", From: start.Add(time.Minute + ms), To: start.Add(30 * time.Minute)},
+				{Symbol: "AAPL", From: start.Add(30*time.Minute + ms), To: start.Add(55 * time.Minute)},
+			},
+		},
+		{
+			name:     "gaps at the ends",
+			times:    sliceTimes{start.Add(20 * time.Minute), start.Add(21 * time.Minute)},
+			expected: []Gap{{Symbol: "AAPL", From: start, To: start.Add(20 * time.Minute)}, {Symbol: "AAPL", From: start.Add(21*time.Minute + ms), To: start.Add(time.Hour)}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gaps, err := Find(context.Background(), tc.times, "AAPL", start, start.Add(time.Hour), 10*time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, gaps)
+		})
+	}
+}
+
+func TestGapContains(t *testing.T) {
+	g := Gap{From: start, To: start.Add(time.Minute)}
+	assert.True(t, g.Contains(start))
+	assert.True(t, g.Contains(start.Add(59*time.Second)))
+	assert.False(t, g.Contains(start.Add(time.Minute)))
+	assert.False(t, g.Contains(start.Add(-time.Millisecond)))
+	assert.Equal(t, time.Minute, g.Duration())
+}
+
+func TestSessions(t *testing.T) {
+	calendar, err := NewCalendar(config.GapsConfig{})
+	require.NoError(t, err)
+	utc := func(month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2025, month, day, hour, minute, 0, 0, time.UTC)
+	}
+	testCases := []struct {
+		name     string
+		symbol   string
+		from, to time.Time
+		expected [][2]time.Time
+	}{
+		{
+			name:   "stocks on weekdays",
+			symbol: "AAPL",
+			from:   utc(11, 21, 0, 0), to: utc(11, 25, 0, 0),
+			expected: [][2]time.Time{
+				{utc(11, 21, 14, 30), utc(11, 21, 21, 0)},
+				{utc(11, 24, 14, 30), utc(11, 24, 21, 0)},
+			},
+		},
+		{
+			name:   "stocks across the start of daylight saving time",
+			symbol: "AAPL",
+			from:   utc(3, 7, 0, 0), to: utc(3, 11, 0, 0),
+			expected: [][2]time.Time{
+				{utc(3, 7, 14, 30), utc(3, 7, 21, 0)},
+				{utc(3, 10, 13, 30), utc(3, 10, 20, 0)},
+			},
+		},
+		{
+			name:   "within a session",
+			symbol: "AAPL",
+			from:   utc(11, 20, 15, 0), to: utc(11, 20, 16, 0),
+			expected: [][2]time.Time{{utc(11, 20, 15, 0), utc(11, 20, 16, 0)}},
+		},
+		{
+			name:   "forex sessions run into each other during the week",
+			symbol: "OANDA:EUR_USD",
+			from:   utc(11, 20, 0, 0), to: utc(11, 25, 0, 0),
+			expected: [][2]time.Time{
+				{utc(11, 20, 0, 0), utc(11, 21, 22, 0)},
+				{utc(11, 23, 22, 0), utc(11, 25, 0, 0)},
+			},
+		},
+		{
+			name:   "crypto never closes",
+			symbol: "BINANCE:BTCUSDT",
+			from:   utc(11, 20, 12, 0), to: utc(11, 23, 12, 0),
+			expected: [][2]time.Time{{utc(11, 20, 12, 0), utc(11, 23, 12, 0)}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sessions := calendar.For(tc.symbol).Sessions(tc.from, tc.to)
+			require.Len(t, sessions, len(tc.expected))
+			for i, s := range sessions {
+				assert.True(t, tc.expected[i][0].Equal(s[0]), "session %d opens at %v, not %v", i, s[0], tc.expected[i][0])
+				assert.True(t, tc.expected[i][1].Equal(s[1]), "session %d ends at %v, not %v", i, s[1], tc.expected[i][1])
+			}
+		})
+	}
+}
+
+// memoryStore keeps gaps by their start, as MongoStore does.
+type memoryStore struct {
+	gaps     map[time.Time]models.DataGap
+	resumeAt map[string]time.Time
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{gaps: make(map[time.Time]models.DataGap), resumeAt: make(map[string]time.Time)}
+}
+
+func (s *memoryStore) ResumeAt(ctx context.Context, symbol string) (time.Time, bool, error) {
+	t, ok := s.resumeAt[symbol]
+	return t, ok, nil
+}
+
+func (s *memoryStore) GapAt(ctx context.Context, symbol string, t time.Time) (*models.DataGap, error) {
+	for _, g := range s.gaps {
+		if !t.Before(g.From) && t.Before(g.To) {
+			return &g, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) Save(ctx context.Context, symbol string, scanned time.Time, gaps []models.DataGap, resumeAt time.Time) error {
+	found := make(map[time.Time]bool)
+	for _, g := range gaps {
+		found[g.From] = true
+		if old, ok := s.gaps[g.From]; ok {
+			g.DetectedAt = old.DetectedAt
+		}
+		s.gaps[g.From] = g
+	}
+	for from := range s.gaps {
+		if !from.Before(scanned) && !found[from] {
+			delete(s.gaps, from)
+		}
+	}
+	s.resumeAt[symbol] = resumeAt
+	return nil
+}
+
+// sorted returns the stored gaps, oldest first.
+func (s *memoryStore) sorted() []models.DataGap {
+	var gaps []models.DataGap
+	for _, g := range s.gaps {
+		gaps = append(gaps, g)
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i].From.Before(gaps[j].From) })
+	return gaps
+}
+
+// everyMinute returns a trade time each minute in [from, to).
+func everyMinute(from, to time.Time) []time.Time {
+	var times []time.Time
+	for t := from; t.Before(to); t = t.Add(time.Minute) {
+		times = append(times, t)
+	}
+	return times
+}
+
+func TestDetector(t *testing.T) {
+	ms := time.Millisecond
+	// Wednesday and Thursday; US stocks trade 14:30 to 21:00 UTC
+	wed := func(hour, minute int) time.Time { return time.Date(2025, 11, 19, hour, minute, 0, 0, time.UTC) }
+	thu := func(hour, minute int) time.Time { return time.Date(2025, 11, 20, hour, minute, 0, 0, time.UTC) }
+	var times sliceTimes
+	times = append(times, everyMinute(wed(16, 0), wed(17, 1))...)
+	times = append(times, everyMinute(wed(17, 30), wed(21, 0))...)
+	times = append(times, everyMinute(thu(14, 30), thu(15, 41))...)
+
+	calendar, err := NewCalendar(config.GapsConfig{})
+	require.NoError(t, err)
+	store := newMemoryStore()
+	detector := NewDetector(&times, store, calendar, 5*time.Minute, 24*time.Hour)
+
+	// The first scan goes back a day. The night is not a gap, and the
+	// trades have stopped 20 minutes ago.
+	found, err := detector.Scan(context.Background(), "AAPL", thu(16, 0))
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, wed(17, 0).Add(ms), found[0].From)
+	assert.Equal(t, wed(17, 30), found[0].To)
+	assert.False(t, found[0].Ongoing)
+	assert.Equal(t, thu(15, 40).Add(ms), found[1].From)
+	assert.Equal(t, thu(16, 0), found[1].To)
+	assert.True(t, found[1].Ongoing)
+	assert.True(t, found[0].New && found[1].New)
+	assert.Equal(t, thu(15, 40).Add(ms), store.resumeAt["AAPL"])
+
+	// Still no trades: the ongoing gap grows, which is not a change
+	found, err = detector.Scan(context.Background(), "AAPL", thu(16, 5))
+	require.NoError(t, err)
+	require.Len(t, found, 1)
+	assert.Equal(t, thu(16, 5), found[0].To)
+	assert.False(t, found[0].New || found[0].Changed)
+
+	// Trades come in again: the ongoing gap ends, and a new one starts
+	times = append(times, thu(15, 50), thu(16, 9))
+	found, err = detector.Scan(context.Background(), "AAPL", thu(16, 10))
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.True(t, found[0].Changed, "the gap has ended")
+	assert.True(t, found[1].New)
+	gaps := store.sorted()
+	require.Len(t, gaps, 3)
+	assert.Equal(t, thu(15, 50), gaps[1].To)
+	assert.False(t, gaps[1].Ongoing)
+	assert.Equal(t, thu(16, 0), gaps[1].DetectedAt)
+	assert.Equal(t, thu(16, 10), gaps[1].UpdatedAt)
+	assert.Equal(t, thu(15, 50).Add(ms), gaps[2].From)
+	assert.Equal(t, thu(16, 9), gaps[2].To)
+
+	// Nothing new yet
+	found, err = detector.Scan(context.Background(), "AAPL", thu(16, 10))
+	require.NoError(t, err)
+	assert.Empty(t, found)
+	assert.Len(t, store.sorted(), 3)
+
+	// Wednesday's gap is backfilled; a rescan from within it removes it
+	times = append(times, everyMinute(wed(17, 1), wed(17, 30))...)
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	_, err = detector.Rescan(context.Background(), "AAPL", wed(17, 15), thu(16, 12))
+	require.NoError(t, err)
+	gaps = store.sorted()
+	require.Len(t, gaps, 2)
+	assert.Equal(t, thu(15, 40).Add(ms), gaps[0].From)
+	assert.Equal(t, thu(15, 50).Add(ms), gaps[1].From)
+}
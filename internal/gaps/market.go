@@ -0,0 +1,128 @@
+package gaps
+
+import (
+	"financial-data-backend-2/internal/config"
+	"financial-data-backend-2/internal/processor"
+	"time"
+)
+
+// MarketHours is when a market is open: on each of its days, from Open to
+// Close after local midnight, Close being on the next day if it is not
+// after Open.
+type MarketHours struct {
+	Location    *time.Location
+	Open, Close time.Duration
+	// Every day if empty
+	Days []time.Weekday
+}
+
+// AlwaysOpen is a market that never closes, e.g. crypto.
+var AlwaysOpen = MarketHours{Location: time.UTC}
+
+// NewMarketHours parses configured market hours.
+func NewMarketHours(cfg config.MarketHoursConfig) (MarketHours, error) {
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return MarketHours{}, err
+	}
+	h := MarketHours{Location: loc}
+	if h.Open, err = config.ParseClock(cfg.Open); err != nil {
+		return MarketHours{}, err
+	}
+	if h.Close, err = config.ParseClock(cfg.Close); err != nil {
+		return MarketHours{}, err
+	}
+	for _, d := range cfg.Days {
+		day, err := config.ParseWeekday(d)
+		if err != nil {
+			return MarketHours{}, err
+		}
+		h.Days = append(h.Days, day)
+	}
+	return h, nil
+}
+
+func (h MarketHours) openOn(day time.Weekday) bool {
+	if len(h.Days) == 0 {
+		return true
+	}
+	for _, d := range h.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// Sessions returns the intervals in [from, to) when the market is open,
+// in order. Back-to-back sessions, e.g. of a market open all day on
+// weekdays, are merged.
+func (h MarketHours) Sessions(from, to time.Time) [][2]time.Time {
+	var sessions [][2]time.Time
+	length := h.Close - h.Open
+	if length <= 0 {
+		length += 24 * time.Hour
+	}
+	// A session may start the day before from
+	local := from.In(h.Location)
+	y, m, d := local.Date()
+	for i := -1; ; i++ {
+		day := time.Date(y, m, d+i, 0, 0, 0, 0, h.Location)
+		if !day.Before(to) {
+			break
+		}
+		if !h.openOn(day.Weekday()) {
+			continue
+		}
+		// Wall clock times, right across daylight saving changes
+		open := time.Date(y, m, d+i, 0, int(h.Open/time.Minute), 0, 0, h.Location)
+		end := time.Date(y, m, d+i, 0, int((h.Open+length)/time.Minute), 0, 0, h.Location)
+		if open.Before(from) {
+			open = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if !open.Before(end) {
+			continue
+		}
+		if n := len(sessions); n > 0 && !sessions[n-1][1].Before(open) {
+			sessions[n-1][1] = end
+			continue
+		}
+		sessions = append(sessions, [2]time.Time{open, end})
+	}
+	return sessions
+}
+
+// Calendar holds the market hours of each exchange and asset class.
+type Calendar struct {
+	hours map[string]MarketHours
+}
+
+// NewCalendar parses the market hours of cfg, with their defaults.
+func NewCalendar(cfg config.GapsConfig) (*Calendar, error) {
+	cfg = cfg.WithDefaults()
+	c := &Calendar{hours: make(map[string]MarketHours, len(cfg.MarketHours))}
+	for market, h := range cfg.MarketHours {
+		hours, err := NewMarketHours(h)
+		if err != nil {
+			return nil, err
+		}
+		c.hours[market] = hours
+	}
+	return c, nil
+}
+
+// For returns the market hours of a symbol: those of its exchange, or else
+// of its asset class, or else AlwaysOpen.
+func (c *Calendar) For(symbol string) MarketHours {
+	exchange, assetClass := processor.ClassifySymbol(symbol)
+	if h, ok := c.hours[exchange]; ok {
+		return h
+	}
+	if h, ok := c.hours[assetClass]; ok {
+		return h
+	}
+	return AlwaysOpen
+}
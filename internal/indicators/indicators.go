@@ -0,0 +1,156 @@
+// Package indicators computes technical indicators over candle series.
+//
+// Every function returns a series aligned with its input. Values are NaN
+// until enough candles have been seen (the warm-up).
+package indicators
+
+import (
+	"math"
+)
+
+// SMA is the simple moving average over period values.
+func SMA(values []float64, period int) []float64 {
+	out := nans(len(values))
+	if period <= 0 {
+		return out
+	}
+	sum := 0.0
+	for i, v := range values {
+		sum += v
+		if i >= period {
+			sum -= values[i-period]
+		}
+		if i >= period-1 {
+			out[i] = sum / float64(period)
+		}
+	}
+	return out
+}
+
+// EMA is the exponential moving average with a smoothing factor of
+// 2/(period+1), seeded with the SMA of the first period values. NaN inputs,
+// e.g. another indicator's warm-up, are skipped.
+func EMA(values []float64, period int) []float64 {
+	return smooth(values, period, 2/float64(period+1))
+}
+
+// RSI is Wilder's relative strength index over period price changes.
+func RSI(closes []float64, period int) []float64 {
+	out := nans(len(closes))
+	if period <= 0 || len(closes) <= period {
+		return out
+	}
+	gains := make([]float64, len(closes))
+	losses := make([]float64, len(closes))
+	for i := range gains {
+		gains[i], losses[i] = math.NaN(), math.NaN()
+	}
+	for i := 1; i < len(closes); i++ {
+		change := closes[i] - closes[i-1]
+		gains[i] = math.Max(change, 0)
+		losses[i] = math.Max(-change, 0)
+	}
+	avgGain := wilder(gains, period)
+	avgLoss := wilder(losses, period)
+	for i := range closes {
+		if math.IsNaN(avgGain[i]) {
+			continue
+		}
+		if avgLoss[i] == 0 {
+			out[i] = 100
+			continue
+		}
+		out[i] = 100 - 100/(1+avgGain[i]/avgLoss[i])
+	}
+	return out
+}
+
+// MACD returns the difference between the fast and slow EMAs, its signal
+// EMA and the histogram (MACD minus signal).
+func MACD(closes []float64, fast, slow, signal int) (macd, signalLine, histogram []float64) {
+	fastEMA := EMA(closes, fast)
+	slowEMA := EMA(closes, slow)
+	macd = make([]float64, len(closes))
+	for i := range closes {
+		macd[i] = fastEMA[i] - slowEMA[i]
+	}
+	signalLine = EMA(macd, signal)
+	histogram = make([]float64, len(closes))
+	for i := range closes {
+		histogram[i] = macd[i] - signalLine[i]
+	}
+	return macd, signalLine, histogram
+}
+
+// Bollinger returns the SMA over period closes and the bands k population
+// standard deviations above and below it.
+func Bollinger(closes []float64, period int, k float64) (middle, upper, lower []float64) {
+	middle = SMA(closes, period)
+	upper = nans(len(closes))
+	lower = nans(len(closes))
+	for i := period - 1; i < len(closes) && period > 0; i++ {
+		variance := 0.0
+		for _, v := range closes[i-period+1 : i+1] {
+			variance += (v - middle[i]) * (v - middle[i])
+		}
+		band := k * math.Sqrt(variance/float64(period))
+		upper[i] = middle[i] + band
+		lower[i] = middle[i] - band
+	}
+	return middle, upper, lower
+}
+
+// ATR is Wilder's average true range. The first candle's true range is
+// its high minus its low.
+func ATR(highs, lows, closes []float64, period int) []float64 {
+	trueRanges := make([]float64, len(closes))
+	for i := range closes {
+		trueRanges[i] = highs[i] - lows[i]
+		if i > 0 {
+			trueRanges[i] = math.Max(trueRanges[i], math.Max(
+				math.Abs(highs[i]-closes[i-1]), math.Abs(lows[i]-closes[i-1])))
+		}
+	}
+	return wilder(trueRanges, period)
+}
+
+// wilder is Wilder's smoothing, an EMA with a factor of 1/period.
+func wilder(values []float64, period int) []float64 {
+	return smooth(values, period, 1/float64(period))
+}
+
+// smooth is an exponential moving average with the given factor, seeded
+// with the SMA of the first period non-NaN values.
+func smooth(values []float64, period int, factor float64) []float64 {
+	out := nans(len(values))
+	if period <= 0 {
+		return out
+	}
+	seen, sum := 0, 0.0
+	prev := math.NaN()
+	for i, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		seen++
+		switch {
+		case seen < period:
+			sum += v
+			continue
+		case seen == period:
+			prev = (sum + v) / float64(period)
+		default:
+			prev += factor * (v - prev)
+		}
+		out[i] = prev
+	}
+	return out
+}
+
+func nans(n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = math.NaN()
+	}
+	return out
+}
@@ -0,0 +1,138 @@
+package indicators
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Closes of the RSI example on StockCharts ("Relative Strength Index").
+// Its table rounds the average gains and losses to two decimals, which
+// shifts the first RSI to 70.53; the values below are unrounded, as in
+// TA-Lib.
+var closes = []float64{
+	44.34, 44.09, 44.15, 43.61, 44.33, 44.83, 45.10, 45.42, 45.84, 46.08, 45.89,
+	46.03, 45.61, 46.28, 46.28, 46.00, 46.03, 46.41, 46.22, 45.64, 46.21, 46.25,
+	45.71, 46.45, 45.78, 45.35, 44.03, 44.18, 44.22, 44.57, 43.42, 42.66, 43.13,
+}
+
+func assertSeries(t *testing.T, expected map[int]float64, warmup int, actual []float64, delta float64) {
+	t.Helper()
+	for i := 0; i < warmup; i++ {
+		assert.True(t, math.IsNaN(actual[i]), "index %d should be warming up, got %v", i, actual[i])
+	}
+	for i, want := range expected {
+		assert.InDelta(t, want, actual[i], delta, "index %d", i)
+	}
+}
+
+func TestSMA(t *testing.T) {
+	sma := SMA(closes, 5)
+	assert.Len(t, sma, len(closes))
+	assertSeries(t, map[int]float64{4: 44.104, 5: 44.202, 6: 44.404, 7: 44.658, 32: 43.6}, 4, sma, 1e-9)
+}
+
+func TestEMA(t *testing.T) {
+	// Seeded with the SMA of the first 10 closes
+	ema := EMA(closes, 10)
+	assertSeries(t, map[int]float64{9: 44.779, 10: 44.981, 11: 45.1717, 32: 44.1193}, 9, ema, 1e-4)
+}
+
+func TestRSI(t *testing.T) {
+	rsi := RSI(closes, 14)
+	expected := []float64{
+		70.46, 66.25, 66.48, 69.35, 66.29, 57.92, 62.88, 63.21, 56.01, 62.34,
+		54.67, 50.39, 40.02, 41.49, 41.90, 45.50, 37.32, 33.09, 37.79,
+	}
+	want := make(map[int]float64, len(expected))
+	for i, v := range expected {
+		want[14+i] = v
+	}
+	assertSeries(t, want, 14, rsi, 0.005)
+
+	// Only gains
+	assert.Equal(t, 100.0, RSI([]float64{1, 2, 3, 4}, 3)[3])
+}
+
+func TestMACD(t *testing.T) {
+	macd, signal, histogram := MACD(closes, 3, 6, 4)
+	// The slow EMA starts at index 5 and the signal 4 MACD values later.
+	assertSeries(t, map[int]float64{32: -0.437733}, 5, macd, 1e-6)
+	assertSeries(t, map[int]float64{32: -0.435210}, 8, signal, 1e-6)
+	assertSeries(t, map[int]float64{32: -0.002523}, 8, histogram, 1e-6)
+	assert.False(t, math.IsNaN(macd[5]))
+}
+
+func TestBollinger(t *testing.T) {
+	middle, upper, lower := Bollinger(closes, 20, 2)
+	assertSeries(t, map[int]float64{19: 45.409, 32: 45.241}, 19, middle, 1e-4)
+	assertSeries(t, map[int]float64{19: 47.1153, 32: 47.6202}, 19, upper, 1e-4)
+	assertSeries(t, map[int]float64{19: 43.7027, 32: 42.8618}, 19, lower, 1e-4)
+}
+
+func TestATR(t *testing.T) {
+	highs := []float64{10, 11, 12, 11, 13, 15}
+	lows := []float64{8, 9, 10, 9, 10, 14}
+	closes := []float64{9, 10, 11, 10, 12, 14.5}
+	// True ranges: 2, 2, 2, 2, 3 and, from the previous close, 3
+	atr := ATR(highs, lows, closes, 3)
+	assertSeries(t, map[int]float64{2: 2, 3: 2, 4: 7.0 / 3, 5: 23.0 / 9}, 2, atr, 1e-9)
+}
+
+func TestCompute(t *testing.T) {
+	start := time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+	candles := make([]Candle, len(closes))
+	for i, c := range closes {
+		candles[i] = Candle{Time: start.Add(time.Duration(i) * time.Minute), High: c + 1, Low: c - 1, Close: c}
+	}
+
+	points := Compute(Spec{Name: NameMACD, Fast: 3, Slow: 6, Signal: 4}, candles)
+	if assert.Len(t, points, len(closes)-8) {
+		assert.Equal(t, start.Add(8*time.Minute), points[0].Time)
+		last := points[len(points)-1].Values
+		assert.Len(t, last, 3)
+		assert.InDelta(t, -0.437733, last["macd"], 1e-6)
+		assert.InDelta(t, -0.435210, last["signal"], 1e-6)
+		assert.InDelta(t, -0.002523, last["histogram"], 1e-6)
+	}
+
+	points = Compute(Spec{Name: NameRSI, Period: 14}, candles)
+	if assert.Len(t, points, len(closes)-14) {
+		assert.InDelta(t, 70.46, points[0].Values["rsi"], 0.005)
+	}
+
+	assert.Empty(t, Compute(Spec{Name: NameSMA, Period: 50}, candles))
+}
+
+func TestSpec(t *testing.T) {
+	testCases := []struct {
+		name        string
+		spec        Spec
+		expectError bool
+		lookback    int
+	}{
+		{name: "rsi defaults", spec: Spec{Name: NameRSI}, lookback: 57},
+		{name: "sma", spec: Spec{Name: NameSMA, Period: 50}, lookback: 49},
+		{name: "macd defaults", spec: Spec{Name: NameMACD}, lookback: 140},
+		{name: "unknown", spec: Spec{Name: "stochastic"}, expectError: true},
+		{name: "period too long", spec: Spec{Name: NameEMA, Period: MaxPeriod + 1}, expectError: true},
+		{name: "negative period", spec: Spec{Name: NameATR, Period: -1}, expectError: true},
+		{name: "macd fast not faster", spec: Spec{Name: NameMACD, Fast: 26, Slow: 12}, expectError: true},
+		{name: "negative band width", spec: Spec{Name: NameBollinger, StdDev: -2}, expectError: true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := tt.spec.WithDefaults()
+			err := spec.Validate()
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.lookback, spec.Lookback())
+		})
+	}
+}
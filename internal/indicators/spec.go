@@ -0,0 +1,172 @@
+package indicators
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Indicator names
+const (
+	NameSMA       = "sma"
+	NameEMA       = "ema"
+	NameRSI       = "rsi"
+	NameMACD      = "macd"
+	NameBollinger = "bollinger"
+	NameATR       = "atr"
+)
+
+// Names lists the supported indicators.
+var Names = []string{NameSMA, NameEMA, NameRSI, NameMACD, NameBollinger, NameATR}
+
+// MaxPeriod bounds every period, to keep the input series small.
+const MaxPeriod = 500
+
+// Exponentially smoothed indicators depend on all earlier data. Fetching
+// this many periods of extra history makes the seed's effect negligible.
+const smoothingPeriods = 4
+
+var (
+	ErrUnknownIndicator = errors.New("unknown indicator")
+	ErrInvalidPeriod    = fmt.Errorf("periods must be between 1 and %d", MaxPeriod)
+)
+
+// Candle is the input of the indicators.
+type Candle struct {
+	Time   time.Time
+	Open   float64
+	High   float64
+	Low    float64
+	Close  float64
+	Volume float64
+}
+
+// Point is an indicator's values at the time of a candle, e.g. {"rsi": 70.5}
+// or {"macd": 1.2, "signal": 0.9, "histogram": 0.3}.
+type Point struct {
+	Time   time.Time
+	Values map[string]float64
+}
+
+// Spec selects an indicator and its parameters. Zero parameters take the
+// indicator's usual defaults.
+type Spec struct {
+	Name string
+	// SMA, EMA, RSI, Bollinger and ATR
+	Period int
+	// MACD
+	Fast, Slow, Signal int
+	// Bollinger band width in standard deviations
+	StdDev float64
+}
+
+// WithDefaults fills in the zero parameters.
+func (s Spec) WithDefaults() Spec {
+	if s.Period == 0 {
+		switch s.Name {
+		case NameRSI, NameATR:
+			s.Period = 14
+		default:
+			s.Period = 20
+		}
+	}
+	if s.Fast == 0 {
+		s.Fast = 12
+	}
+	if s.Slow == 0 {
+		s.Slow = 26
+	}
+	if s.Signal == 0 {
+		s.Signal = 9
+	}
+	if s.StdDev == 0 {
+		s.StdDev = 2
+	}
+	return s
+}
+
+// Validate checks a spec after WithDefaults.
+func (s Spec) Validate() error {
+	known := false
+	for _, name := range Names {
+		known = known || s.Name == name
+	}
+	if !known {
+		return ErrUnknownIndicator
+	}
+	for _, p := range []int{s.Period, s.Fast, s.Slow, s.Signal} {
+		if p < 1 || p > MaxPeriod {
+			return ErrInvalidPeriod
+		}
+	}
+	if s.Name == NameMACD && s.Fast >= s.Slow {
+		return errors.New("the fast period must be shorter than the slow one")
+	}
+	if s.StdDev <= 0 || math.IsInf(s.StdDev, 0) || math.IsNaN(s.StdDev) {
+		return errors.New("the standard deviation multiplier must be positive")
+	}
+	return nil
+}
+
+// Lookback is how many candles before the first wanted point should be
+// fetched: the warm-up, plus extra history for smoothed indicators.
+func (s Spec) Lookback() int {
+	switch s.Name {
+	case NameSMA, NameBollinger:
+		return s.Period - 1
+	case NameEMA:
+		return smoothingPeriods * s.Period
+	case NameRSI, NameATR:
+		return smoothingPeriods*s.Period + 1
+	case NameMACD:
+		return smoothingPeriods * (s.Slow + s.Signal)
+	}
+	return 0
+}
+
+// Compute runs the indicator over candles, oldest first, and returns a
+// point for every candle past the warm-up.
+func Compute(s Spec, candles []Candle) []Point {
+	closes := make([]float64, len(candles))
+	for i, c := range candles {
+		closes[i] = c.Close
+	}
+
+	series := make(map[string][]float64)
+	switch s.Name {
+	case NameSMA:
+		series["sma"] = SMA(closes, s.Period)
+	case NameEMA:
+		series["ema"] = EMA(closes, s.Period)
+	case NameRSI:
+		series["rsi"] = RSI(closes, s.Period)
+	case NameMACD:
+		series["macd"], series["signal"], series["histogram"] = MACD(closes, s.Fast, s.Slow, s.Signal)
+	case NameBollinger:
+		series["middle"], series["upper"], series["lower"] = Bollinger(closes, s.Period, s.StdDev)
+	case NameATR:
+		highs := make([]float64, len(candles))
+		lows := make([]float64, len(candles))
+		for i, c := range candles {
+			highs[i], lows[i] = c.High, c.Low
+		}
+		series["atr"] = ATR(highs, lows, closes, s.Period)
+	}
+
+	var points []Point
+	for i, c := range candles {
+		values := make(map[string]float64, len(series))
+		for key, line := range series {
+			if math.IsNaN(line[i]) {
+				values = nil
+				break
+			}
+			values[key] = line[i]
+		}
+		if values != nil {
+			points = append(points, Point{Time: c.Time, Values: values})
+		}
+	}
+	return points
+}
@@ -0,0 +1,287 @@
+package ingestor
+
+import (
+	"context"
+	"errors"
+	"financial-data-backend-2/internal/config"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeWriter records delivered messages and fails while down is set.
+type fakeWriter struct {
+	mu        sync.Mutex
+	down      bool
+	delivered []string
+}
+
+func (f *fakeWriter) WriteMessages(_ context.Context, msgs ...kafkaGo.Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down {
+		return errors.New("kafka unavailable")
+	}
+	for _, m := range msgs {
+		f.delivered = append(f.delivered, string(m.Value))
+	}
+	return nil
+}
+
+func (f *fakeWriter) Close() error { return nil }
+
+func (f *fakeWriter) setDown(down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down = down
+}
+
+func (f *fakeWriter) messages() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.delivered...)
+}
+
+func msg(i int) kafkaGo.Message {
+	return kafkaGo.Message{
+		Value:   []byte(fmt.Sprintf("m%d", i)),
+		Headers: []kafkaGo.Header{{Key: "n", Value: []byte{byte(i)}}},
+	}
+}
+
+func values(msgs []kafkaGo.Message) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = string(m.Value)
+	}
+	return out
+}
+
+func TestSpoolDrainInOrder(t *testing.T) {
+	spool, err := OpenSpool(t.TempDir())
+	assert.NoError(t, err)
+
+	assert.NoError(t, spool.Append(msg(1), msg(2)))
+	assert.NoError(t, spool.Append(msg(3)))
+	assert.Equal(t, 3, spool.Pending())
+
+	var got []kafkaGo.Message
+	err = spool.Drain(2, func(batch []kafkaGo.Message) error {
+		assert.LessOrEqual(t, len(batch), 2)
+		got = append(got, batch...)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"m1", "m2", "m3"}, values(got))
+	assert.Equal(t, []byte{3}, got[2].Headers[0].Value, "headers should survive the spool")
+	assert.Equal(t, 0, spool.Pending())
+}
+
+func TestSpoolResumesAfterFailureAndRestart(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSpool(dir)
+	assert.NoError(t, err)
+	for i := 1; i <= 5; i++ {
+		assert.NoError(t, spool.Append(msg(i)))
+	}
+
+	// The first batch goes through, the second fails.
+	var got []string
+	calls := 0
+	err = spool.Drain(2, func(batch []kafkaGo.Message) error {
+		calls++
+		if calls == 2 {
+			return errors.New("kafka unavailable")
+		}
+		got = append(got, values(batch)...)
+		return nil
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 3, spool.Pending())
+
+	// Appends after the failed drain go behind the remaining messages.
+	assert.NoError(t, spool.Append(msg(6)))
+	assert.NoError(t, spool.Close())
+
+	// A new process picks up where the old one stopped.
+	reopened, err := OpenSpool(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, reopened.Pending())
+	err = reopened.Drain(10, func(batch []kafkaGo.Message) error {
+		got = append(got, values(batch)...)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"m1", "m2", "m3", "m4", "m5", "m6"}, got)
+	assert.Equal(t, 0, reopened.Pending())
+}
+
+func TestSpoolTruncatesPartialRecord(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSpool(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, spool.Append(msg(1), msg(2)))
+	assert.NoError(t, spool.Close())
+
+	// A crash while appending the third record leaves part of it behind.
+	path := spool.segmentPath(0)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 100, '{', '"'})
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	reopened, err := OpenSpool(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, reopened.Pending())
+
+	// Appends after the restart are not mistaken for part of it.
+	assert.NoError(t, reopened.Append(msg(3)))
+	var got []string
+	err = reopened.Drain(10, func(batch []kafkaGo.Message) error {
+		got = append(got, values(batch)...)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"m1", "m2", "m3"}, got)
+	assert.Equal(t, 0, reopened.Pending())
+}
+
+func TestSpoolSetsAsideCorruptSegment(t *testing.T) {
+	dir := t.TempDir()
+	spool, err := OpenSpool(dir)
+	assert.NoError(t, err)
+	assert.NoError(t, spool.Append(msg(1)))
+	assert.NoError(t, spool.Close())
+	// A complete record that is not JSON
+	assert.NoError(t, os.WriteFile(spool.segmentPath(1), []byte{0, 0, 0, 1, '!'}, 0o644))
+
+	reopened, err := OpenSpool(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reopened.Pending())
+	_, err = os.Stat(spool.segmentPath(1) + corruptExt)
+	assert.NoError(t, err, "the corrupt segment should be kept aside")
+}
+
+func TestPublisherSpoolsWhileKafkaIsDown(t *testing.T) {
+	retryInterval = 0
+	defer func() { retryInterval = 2 * time.Second }()
+
+	writer := &fakeWriter{}
+	spool, err := OpenSpool(t.TempDir())
+	assert.NoError(t, err)
+	p := NewPublisher(writer, spool, config.KafkaProducerConfig{}, time.Second)
+	ctx := context.Background()
+
+	assert.NoError(t, p.Publish(ctx, msg(1)))
+	writer.setDown(true)
+	assert.NoError(t, p.Publish(ctx, msg(2)))
+	assert.NoError(t, p.Publish(ctx, msg(3)))
+	assert.Equal(t, []string{"m1"}, writer.messages())
+	assert.Equal(t, 2, spool.Pending())
+
+	// Once Kafka is back, the spool is drained before the new message.
+	writer.setDown(false)
+	assert.NoError(t, p.Publish(ctx, msg(4)))
+	assert.Equal(t, []string{"m1", "m2", "m3", "m4"}, writer.messages())
+	assert.Equal(t, 0, spool.Pending())
+	assert.NoError(t, p.Close())
+}
+
+func TestPublisherDrainsSpoolWithoutNewMessages(t *testing.T) {
+	retryInterval = 0
+	drainInterval = 10 * time.Millisecond
+	defer func() {
+		retryInterval = 2 * time.Second
+		drainInterval = 2 * time.Second
+	}()
+
+	writer := &fakeWriter{down: true}
+	spool, err := OpenSpool(t.TempDir())
+	assert.NoError(t, err)
+	p := NewPublisher(writer, spool, config.KafkaProducerConfig{}, time.Second)
+	defer p.Close()
+
+	assert.NoError(t, p.Publish(context.Background(), msg(1)))
+	assert.Equal(t, 1, spool.Pending())
+
+	// Kafka comes back, but nothing else is published.
+	writer.setDown(false)
+	assert.Eventually(t, func() bool { return spool.Pending() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"m1"}, writer.messages())
+}
+
+func TestPublisherWithoutSpoolDrops(t *testing.T) {
+	writer := &fakeWriter{down: true}
+	p := NewPublisher(writer, nil, config.KafkaProducerConfig{}, time.Second)
+
+	assert.NoError(t, p.Publish(context.Background(), msg(1)))
+	writer.setDown(false)
+	assert.NoError(t, p.Publish(context.Background(), msg(2)))
+	assert.Equal(t, []string{"m2"}, writer.messages())
+}
+
+func TestPublisherAsyncFlushesOnClose(t *testing.T) {
+	writer := &fakeWriter{}
+	p := NewPublisher(writer, nil, config.KafkaProducerConfig{
+		Async:      true,
+		BufferSize: 4,
+		BatchSize:  3,
+	}, time.Second)
+
+	var want []string
+	for i := 1; i <= 20; i++ {
+		assert.NoError(t, p.Publish(context.Background(), msg(i)))
+		want = append(want, fmt.Sprintf("m%d", i))
+	}
+	assert.NoError(t, p.Close())
+	assert.Equal(t, want, writer.messages())
+}
+
+func TestPublisherAsyncRespectsContext(t *testing.T) {
+	writer := &fakeWriter{}
+	p := NewPublisher(writer, nil, config.KafkaProducerConfig{Async: true, BufferSize: 1}, time.Second)
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// With a cancelled context, Publish either queues or gives up,
+	// but it must never block.
+	for i := 0; i < 10; i++ {
+		if err := p.Publish(ctx, msg(i)); err != nil {
+			assert.ErrorIs(t, err, context.Canceled)
+		}
+	}
+}
+
+func TestProducerSettings(t *testing.T) {
+	acks, err := RequiredAcks("")
+	assert.NoError(t, err)
+	assert.Equal(t, kafkaGo.RequireAll, acks)
+	acks, err = RequiredAcks("one")
+	assert.NoError(t, err)
+	assert.Equal(t, kafkaGo.RequireOne, acks)
+	_, err = RequiredAcks("two")
+	assert.Error(t, err)
+
+	codec, err := Compression("zstd")
+	assert.NoError(t, err)
+	assert.Equal(t, kafkaGo.Zstd, codec)
+	_, err = Compression("brotli")
+	assert.Error(t, err)
+
+	w, err := NewWriter(config.KafkaConfig{
+		Brokers:  []string{"kafka:9092"},
+		Topic:    "raw_stock_ticks",
+		Producer: config.KafkaProducerConfig{Compression: "snappy", BatchSize: 50},
+	}, nil, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, kafkaGo.Snappy, w.Compression)
+	assert.Equal(t, 50, w.BatchSize)
+	assert.Equal(t, kafkaGo.RequireAll, w.RequiredAcks)
+}
@@ -0,0 +1,300 @@
+package ingestor
+
+import (
+	"context"
+	"financial-data-backend-2/internal/config"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+)
+
+const (
+	defaultBatchSize    = 100
+	defaultBatchTimeout = 10 * time.Millisecond
+	defaultBufferSize   = 10000
+)
+
+// How long to wait after a failed write before trying Kafka again.
+var retryInterval = 2 * time.Second
+
+// How often the spool is drained when no new messages arrive.
+var drainInterval = 2 * time.Second
+
+// MessageWriter is the part of *kafkaGo.Writer the Publisher needs.
+type MessageWriter interface {
+	WriteMessages(context.Context, ...kafkaGo.Message) error
+	Close() error
+}
+
+// Publisher sends messages to Kafka, either straight away (sync) or from
+// a background goroutine fed by a bounded buffer (async). Messages that
+// cannot be delivered go to the spool, if one is configured, and are
+// sent in their original order once Kafka is back.
+type Publisher struct {
+	writer       MessageWriter
+	spool        *Spool
+	batchSize    int
+	writeTimeout time.Duration
+
+	buffer chan kafkaGo.Message
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu        sync.Mutex
+	nextRetry time.Time
+	// Held while draining the spool, so that drains do not overlap
+	drainMu sync.Mutex
+}
+
+// RequiredAcks converts the config value into kafka-go's setting.
+func RequiredAcks(s string) (kafkaGo.RequiredAcks, error) {
+	switch strings.ToLower(s) {
+	case "", "all":
+		return kafkaGo.RequireAll, nil
+	case "one":
+		return kafkaGo.RequireOne, nil
+	case "none":
+		return kafkaGo.RequireNone, nil
+	}
+	return 0, fmt.Errorf("unknown required_acks %q", s)
+}
+
+// Compression converts the config value into kafka-go's codec.
+func Compression(s string) (kafkaGo.Compression, error) {
+	switch strings.ToLower(s) {
+	case "", "none":
+		return 0, nil
+	case "gzip":
+		return kafkaGo.Gzip, nil
+	case "snappy":
+		return kafkaGo.Snappy, nil
+	case "lz4":
+		return kafkaGo.Lz4, nil
+	case "zstd":
+		return kafkaGo.Zstd, nil
+	}
+	return 0, fmt.Errorf("unknown compression %q", s)
+}
+
+// NewWriter builds the Kafka writer from the producer settings.
+func NewWriter(cfg config.KafkaConfig, transport *kafkaGo.Transport, writeTimeout time.Duration) (*kafkaGo.Writer, error) {
+	producer := cfg.Producer
+	acks, err := RequiredAcks(producer.RequiredAcks)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := Compression(producer.Compression)
+	if err != nil {
+		return nil, err
+	}
+	batchSize := producer.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	batchTimeout := producer.BatchTimeout
+	if batchTimeout <= 0 {
+		batchTimeout = defaultBatchTimeout
+	}
+
+	return &kafkaGo.Writer{
+		Addr:         kafkaGo.TCP(cfg.BrokerList()...),
+		Topic:        cfg.Topic,
+		Balancer:     &kafkaGo.LeastBytes{},
+		Transport:    transport,
+		RequiredAcks: acks,
+		Compression:  codec,
+		BatchSize:    batchSize,
+		BatchTimeout: batchTimeout,
+		WriteTimeout: writeTimeout,
+	}, nil
+}
+
+// NewPublisher wraps writer. spool may be nil, in which case messages
+// that cannot be delivered are dropped (and logged).
+func NewPublisher(writer MessageWriter, spool *Spool, producer config.KafkaProducerConfig, writeTimeout time.Duration) *Publisher {
+	p := &Publisher{
+		writer:       writer,
+		spool:        spool,
+		batchSize:    producer.BatchSize,
+		writeTimeout: writeTimeout,
+	}
+	if p.batchSize <= 0 {
+		p.batchSize = defaultBatchSize
+	}
+
+	if producer.Async {
+		size := producer.BufferSize
+		if size <= 0 {
+			size = defaultBufferSize
+		}
+		p.buffer = make(chan kafkaGo.Message, size)
+		p.done = make(chan struct{})
+		go p.run()
+	} else if spool != nil {
+		if n := spool.Pending(); n > 0 {
+			log.Printf("Spool holds %d undelivered message(s) from a previous run", n)
+		}
+		p.stop = make(chan struct{})
+		p.done = make(chan struct{})
+		go p.drainLoop()
+	}
+	return p
+}
+
+// Publish sends msg to Kafka. In async mode it only queues the message,
+// blocking while the buffer is full.
+func (p *Publisher) Publish(ctx context.Context, msg kafkaGo.Message) error {
+	if p.buffer == nil {
+		p.deliver([]kafkaGo.Message{msg})
+		return nil
+	}
+	select {
+	case p.buffer <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes whatever is buffered, then closes the writer.
+func (p *Publisher) Close() error {
+	if p.buffer != nil {
+		close(p.buffer)
+		<-p.done
+	} else if p.stop != nil {
+		close(p.stop)
+		<-p.done
+	}
+	if p.spool != nil {
+		if n := p.spool.Pending(); n > 0 {
+			log.Printf("Leaving %d undelivered message(s) in the spool for the next run", n)
+		}
+		p.spool.Close()
+	}
+	return p.writer.Close()
+}
+
+// run is the async mode's background loop. It sends whatever has
+// accumulated in the buffer (up to a batch) and regularly retries the
+// spool even when no new messages arrive.
+func (p *Publisher) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg, ok := <-p.buffer:
+			if !ok {
+				return
+			}
+			batch := []kafkaGo.Message{msg}
+		fill:
+			for len(batch) < p.batchSize {
+				select {
+				case msg, ok := <-p.buffer:
+					if !ok {
+						p.deliver(batch)
+						return
+					}
+					batch = append(batch, msg)
+				default:
+					break fill
+				}
+			}
+			p.deliver(batch)
+		case <-ticker.C:
+			p.drainSpool()
+		}
+	}
+}
+
+// drainLoop is the sync mode's background loop. It retries the spool, so
+// that it is emptied even when no new messages arrive, e.g. outside
+// market hours.
+func (p *Publisher) drainLoop() {
+	defer close(p.done)
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.drainSpool()
+		}
+	}
+}
+
+// deliver writes batch to Kafka, or to the spool if Kafka is failing or
+// older messages are still spooled (to keep them in order).
+func (p *Publisher) deliver(batch []kafkaGo.Message) {
+	if p.spool != nil && p.spool.Pending() > 0 {
+		p.toSpool(batch)
+		p.drainSpool()
+		return
+	}
+
+	if err := p.write(batch); err != nil {
+		if p.spool == nil {
+			log.Printf("Failed to write %d message(s) to Kafka, dropping them: %v", len(batch), err)
+			return
+		}
+		log.Printf("Failed to write %d message(s) to Kafka, spooling them: %v", len(batch), err)
+		p.toSpool(batch)
+		p.backOff()
+	}
+}
+
+func (p *Publisher) write(batch []kafkaGo.Message) error {
+	ctx := context.Background()
+	if p.writeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.writeTimeout)
+		defer cancel()
+	}
+	return p.writer.WriteMessages(ctx, batch...)
+}
+
+func (p *Publisher) toSpool(batch []kafkaGo.Message) {
+	if err := p.spool.Append(batch...); err != nil {
+		log.Printf("CRITICAL: Failed to spool %d message(s), dropping them: %v", len(batch), err)
+	}
+}
+
+// drainSpool sends spooled messages to Kafka, unless a recent failure
+// says Kafka is probably still down.
+func (p *Publisher) drainSpool() {
+	if p.spool == nil || p.spool.Pending() == 0 {
+		return
+	}
+	p.mu.Lock()
+	wait := time.Now().Before(p.nextRetry)
+	p.mu.Unlock()
+	if wait {
+		return
+	}
+
+	p.drainMu.Lock()
+	defer p.drainMu.Unlock()
+	before := p.spool.Pending()
+	err := p.spool.Drain(p.batchSize, p.write)
+	if sent := before - p.spool.Pending(); sent > 0 {
+		log.Printf("Delivered %d spooled message(s) to Kafka", sent)
+	}
+	if err != nil {
+		log.Printf("Kafka still unavailable, %d message(s) remain spooled: %v", p.spool.Pending(), err)
+		p.backOff()
+	}
+}
+
+func (p *Publisher) backOff() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.nextRetry = time.Now().Add(retryInterval)
+}
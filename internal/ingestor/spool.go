@@ -0,0 +1,338 @@
+package ingestor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+)
+
+const (
+	segmentExt  = ".spool"
+	positionExt = ".pos"
+	corruptExt  = ".corrupt"
+)
+
+// errPartialRecord is returned by readBatch when the last record was cut
+// short, e.g. by a crash while it was being appended.
+var errPartialRecord = errors.New("partial record at the end of the segment")
+
+// spoolRecord is how a Kafka message is kept on disk.
+type spoolRecord struct {
+	Key     []byte           `json:"key,omitempty"`
+	Value   []byte           `json:"value"`
+	Headers []kafkaGo.Header `json:"headers,omitempty"`
+	Time    time.Time        `json:"time"`
+}
+
+// Spool is an on-disk, first-in-first-out queue of Kafka messages.
+//
+// Messages are appended to numbered segment files. Draining reads the
+// segments oldest first and records how far it got in a position file,
+// so a restart resumes where it left off. A message may be sent twice if
+// the process dies between sending it and saving the position.
+type Spool struct {
+	dir string
+
+	mu      sync.Mutex
+	active  *os.File
+	nextSeq int
+	pending int
+}
+
+// OpenSpool opens (or creates) a spool in dir, picking up any messages
+// left over from a previous run. A record cut short at the end of a
+// segment, by a crash while it was being appended, is truncated. A
+// segment that is corrupt otherwise is set aside (renamed to
+// .spool.corrupt) rather than sent.
+func OpenSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	s := &Spool{dir: dir}
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	for _, seq := range segments {
+		s.nextSeq = seq + 1
+		n, err := s.countRemaining(seq)
+		if err != nil {
+			log.Printf("CRITICAL: Setting aside spool segment %d: %v", seq, err)
+			if err := os.Rename(s.segmentPath(seq), s.segmentPath(seq)+corruptExt); err != nil {
+				return nil, err
+			}
+			os.Remove(s.positionPath(seq))
+			continue
+		}
+		s.pending += n
+	}
+	return s, nil
+}
+
+// Pending returns how many messages are waiting in the spool.
+func (s *Spool) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// Append adds messages to the end of the spool.
+func (s *Spool) Append(msgs ...kafkaGo.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active == nil {
+		f, err := os.OpenFile(s.segmentPath(s.nextSeq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open spool segment: %w", err)
+		}
+		s.active = f
+		s.nextSeq++
+	}
+
+	// A failed write is cut off again, so that later appends do not
+	// follow a partial record.
+	end, err := s.active.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to write to spool: %w", err)
+	}
+	if err := s.write(msgs); err != nil {
+		if terr := s.active.Truncate(end); terr != nil {
+			// Leave the partial record at the end of a sealed segment
+			s.active.Close()
+			s.active = nil
+		}
+		return err
+	}
+	s.pending += len(msgs)
+	return nil
+}
+
+func (s *Spool) write(msgs []kafkaGo.Message) error {
+	w := bufio.NewWriter(s.active)
+	for _, m := range msgs {
+		data, err := json.Marshal(spoolRecord{Key: m.Key, Value: m.Value, Headers: m.Headers, Time: m.Time})
+		if err != nil {
+			return err
+		}
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+		w.Write(size[:])
+		w.Write(data)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write to spool: %w", err)
+	}
+	if err := s.active.Sync(); err != nil {
+		return fmt.Errorf("failed to sync spool: %w", err)
+	}
+	return nil
+}
+
+// Drain hands spooled messages to send, oldest first, in batches of at
+// most batchSize. It stops at the first error, keeping the messages
+// that were not sent.
+func (s *Spool) Drain(batchSize int, send func([]kafkaGo.Message) error) error {
+	// Seal the active segment, so appends made while draining go into a
+	// new one behind it.
+	s.mu.Lock()
+	if s.active != nil {
+		s.active.Close()
+		s.active = nil
+	}
+	segments, err := s.segments()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, seq := range segments {
+		if err := s.drainSegment(seq, batchSize, send); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Spool) drainSegment(seq, batchSize int, send func([]kafkaGo.Message) error) error {
+	f, err := os.Open(s.segmentPath(seq))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	pos, err := s.readPosition(seq)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+
+	for {
+		batch, size, err := readBatch(r, batchSize)
+		if errors.Is(err, errPartialRecord) {
+			// Only a failed append leaves one, and it was never pending.
+			log.Printf("Dropping a partial record at the end of spool segment %d", seq)
+		} else if err != nil {
+			return fmt.Errorf("corrupt spool segment %d: %w", seq, err)
+		}
+		if len(batch) == 0 {
+			break
+		}
+		if err := send(batch); err != nil {
+			return err
+		}
+		pos += size
+		if err := s.writePosition(seq, pos); err != nil {
+			return err
+		}
+		s.mu.Lock()
+		s.pending -= len(batch)
+		s.mu.Unlock()
+	}
+
+	f.Close()
+	os.Remove(s.positionPath(seq))
+	return os.Remove(s.segmentPath(seq))
+}
+
+// readBatch reads up to n records, returning them and how many bytes
+// they took up. If the last record is cut short, it returns the ones
+// before it and errPartialRecord.
+func readBatch(r *bufio.Reader, n int) ([]kafkaGo.Message, int64, error) {
+	var batch []kafkaGo.Message
+	var size int64
+	for len(batch) < n {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return batch, size, errPartialRecord
+			}
+			return nil, 0, err
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(r, data); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return batch, size, errPartialRecord
+			}
+			return nil, 0, err
+		}
+		var rec spoolRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, 0, err
+		}
+		batch = append(batch, kafkaGo.Message{Key: rec.Key, Value: rec.Value, Headers: rec.Headers, Time: rec.Time})
+		size += int64(len(header) + len(data))
+	}
+	return batch, size, nil
+}
+
+func (s *Spool) countRemaining(seq int) (int, error) {
+	f, err := os.Open(s.segmentPath(seq))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	pos, err := s.readPosition(seq)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := f.Seek(pos, io.SeekStart); err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(f)
+	count := 0
+	for {
+		batch, size, err := readBatch(r, 1000)
+		count += len(batch)
+		pos += size
+		if errors.Is(err, errPartialRecord) {
+			log.Printf("Truncating a partial record at the end of spool segment %d", seq)
+			return count, os.Truncate(s.segmentPath(seq), pos)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("corrupt spool segment %d: %w", seq, err)
+		}
+		if len(batch) == 0 {
+			return count, nil
+		}
+	}
+}
+
+// segments lists the segment numbers in the spool, oldest first.
+func (s *Spool) segments() ([]int, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var seqs []int
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), segmentExt)
+		if !ok {
+			continue
+		}
+		if seq, err := strconv.Atoi(name); err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+	return seqs, nil
+}
+
+func (s *Spool) readPosition(seq int) (int64, error) {
+	data, err := os.ReadFile(s.positionPath(seq))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func (s *Spool) writePosition(seq int, pos int64) error {
+	tmp := s.positionPath(seq) + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatInt(pos, 10)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.positionPath(seq))
+}
+
+func (s *Spool) segmentPath(seq int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%012d%s", seq, segmentExt))
+}
+
+func (s *Spool) positionPath(seq int) string {
+	return s.segmentPath(seq) + positionExt
+}
+
+// Close releases the active segment file.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.active == nil {
+		return nil
+	}
+	err := s.active.Close()
+	s.active = nil
+	return err
+}
@@ -0,0 +1,393 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"financial-data-backend-2/internal/config"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/protocol"
+	"github.com/segmentio/kafka-go/protocol/apiversions"
+	"github.com/segmentio/kafka-go/protocol/saslauthenticate"
+	"github.com/segmentio/kafka-go/protocol/saslhandshake"
+	"github.com/stretchr/testify/assert"
+	"github.com/xdg-go/scram"
+)
+
+// testPKI is a throwaway CA with a server and a client certificate,
+// written to PEM files the way they would be deployed.
+type testPKI struct {
+	caFile, clientCert, clientKey string
+	serverTLS                     *tls.Config
+}
+
+func newTestPKI(t *testing.T) testPKI {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create CA: %v", err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	issue := func(serial int64, cn string, usage x509.ExtKeyUsage) (certPEM, keyPEM []byte) {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("failed to issue certificate: %v", err)
+		}
+		keyDER, _ := x509.MarshalECPrivateKey(key)
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	}
+
+	serverCert, serverKey := issue(2, "kafka-broker", x509.ExtKeyUsageServerAuth)
+	serverPair, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatalf("failed to load server certificate: %v", err)
+	}
+	clientCert, clientKey := issue(3, "go-processor", x509.ExtKeyUsageClientAuth)
+
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		return path
+	}
+
+	return testPKI{
+		caFile:     write("ca.pem", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})),
+		clientCert: write("client.pem", clientCert),
+		clientKey:  write("client-key.pem", clientKey),
+		serverTLS: &tls.Config{
+			Certificates: []tls.Certificate{serverPair},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		},
+	}
+}
+
+// startTLSListener accepts TLS connections and reports the common name
+// of each client certificate (or the handshake error) on the channel.
+func startTLSListener(t *testing.T, serverTLS *tls.Config) (string, <-chan string) {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", serverTLS)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	peers := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			if err := tlsConn.Handshake(); err != nil {
+				peers <- "handshake error"
+			} else {
+				peers <- tlsConn.ConnectionState().PeerCertificates[0].Subject.CommonName
+			}
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), peers
+}
+
+func TestDialerTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	addr, peers := startTLSListener(t, pki.serverTLS)
+
+	cfg := config.KafkaConfig{
+		BrokerURL: addr,
+		TLS: config.KafkaTLSConfig{
+			Enabled:  true,
+			CAFile:   pki.caFile,
+			CertFile: pki.clientCert,
+			KeyFile:  pki.clientKey,
+		},
+	}
+
+	// The dialer (used by EnsureTopic and the reader) presents the client
+	// certificate and trusts the broker through the configured CA.
+	dialer, err := NewDialer(cfg)
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	assert.NoError(t, err)
+	if conn != nil {
+		conn.Close()
+	}
+	assert.Equal(t, "go-processor", <-peers)
+
+	// The transport (used by the writer) does the same.
+	transport, err := NewTransport(cfg)
+	assert.NoError(t, err)
+	tlsConn, err := tls.Dial("tcp", addr, transport.TLS)
+	assert.NoError(t, err)
+	if tlsConn != nil {
+		tlsConn.Close()
+	}
+	assert.Equal(t, "go-processor", <-peers)
+}
+
+func TestDialerTLSUntrustedBroker(t *testing.T) {
+	pki := newTestPKI(t)
+	addr, _ := startTLSListener(t, pki.serverTLS)
+
+	// Without the CA, the broker's certificate must be rejected.
+	dialer, err := NewDialer(config.KafkaConfig{
+		TLS: config.KafkaTLSConfig{
+			Enabled:  true,
+			CertFile: pki.clientCert,
+			KeyFile:  pki.clientKey,
+		},
+	})
+	assert.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = dialer.DialContext(ctx, "tcp", addr)
+	assert.Error(t, err)
+}
+
+func TestTLSConfig(t *testing.T) {
+	tlsCfg, err := TLSConfig(config.KafkaTLSConfig{})
+	assert.NoError(t, err)
+	assert.Nil(t, tlsCfg, "TLS should be off unless enabled")
+
+	_, err = TLSConfig(config.KafkaTLSConfig{Enabled: true, CAFile: "does-not-exist.pem"})
+	assert.Error(t, err)
+}
+
+func TestSASLMechanism(t *testing.T) {
+	testCases := []struct {
+		mechanism    string
+		expectedName string
+		expectError  bool
+	}{
+		{mechanism: "", expectedName: ""},
+		{mechanism: "plain", expectedName: "PLAIN"},
+		{mechanism: "SCRAM-SHA-256", expectedName: "SCRAM-SHA-256"},
+		{mechanism: "scram-sha-512", expectedName: "SCRAM-SHA-512"},
+		{mechanism: "gssapi", expectError: true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.mechanism, func(t *testing.T) {
+			m, err := SASLMechanism(config.KafkaSASLConfig{
+				Mechanism: tt.mechanism,
+				Username:  "user",
+				Password:  "secret",
+			})
+			if tt.expectError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			if tt.expectedName == "" {
+				assert.Nil(t, m)
+			} else {
+				assert.Equal(t, tt.expectedName, m.Name())
+			}
+		})
+	}
+}
+
+// saslServer checks the client's side of one SASL exchange: it returns
+// the challenge to each client response, and done once the client has
+// authenticated.
+type saslServer func(response []byte) (challenge []byte, done bool, err error)
+
+func plainServer(username, password string) saslServer {
+	return func(response []byte) ([]byte, bool, error) {
+		// authzid NUL authcid NUL passwd
+		parts := bytes.Split(response, []byte{0})
+		if len(parts) != 3 || string(parts[1]) != username || string(parts[2]) != password {
+			return nil, false, errors.New("invalid credentials")
+		}
+		return nil, true, nil
+	}
+}
+
+func scramServer(t *testing.T, hash scram.HashGeneratorFcn, username, password string) saslServer {
+	t.Helper()
+	client, err := hash.NewClient(username, password, "")
+	if err != nil {
+		t.Fatalf("failed to create SCRAM client: %v", err)
+	}
+	credentials := client.GetStoredCredentials(scram.KeyFactors{Salt: "salt", Iters: 4096})
+	server, err := hash.NewServer(func(user string) (scram.StoredCredentials, error) {
+		if user != username {
+			return scram.StoredCredentials{}, errors.New("unknown user")
+		}
+		return credentials, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to create SCRAM server: %v", err)
+	}
+	conv := server.NewConversation()
+	return func(response []byte) ([]byte, bool, error) {
+		challenge, err := conv.Step(string(response))
+		return []byte(challenge), conv.Done() && conv.Valid(), err
+	}
+}
+
+// startSASLBroker answers the requests a client makes to authenticate,
+// as a broker offering the one mechanism would. It reports "authenticated"
+// or the reason it refused each connection on the channel.
+func startSASLBroker(t *testing.T, mechanism string, newServer func() saslServer) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	outcomes := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			outcomes <- serveSASL(conn, mechanism, newServer())
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), outcomes
+}
+
+func serveSASL(conn net.Conn, mechanism string, server saslServer) string {
+	for {
+		version, correlationID, _, msg, err := protocol.ReadRequest(conn)
+		if err != nil {
+			return "connection closed before authenticating"
+		}
+		var res protocol.Message
+		outcome := ""
+		switch req := msg.(type) {
+		case *apiversions.Request:
+			res = &apiversions.Response{ApiKeys: []apiversions.ApiKeyResponse{
+				{ApiKey: int16(protocol.SaslHandshake), MinVersion: 0, MaxVersion: 1},
+				{ApiKey: int16(protocol.SaslAuthenticate), MinVersion: 0, MaxVersion: 1},
+			}}
+		case *saslhandshake.Request:
+			res = &saslhandshake.Response{Mechanisms: []string{mechanism}}
+			if req.Mechanism != mechanism {
+				res = &saslhandshake.Response{ErrorCode: int16(kafkaGo.UnsupportedSASLMechanism), Mechanisms: []string{mechanism}}
+				outcome = "unsupported mechanism " + req.Mechanism
+			}
+		case *saslauthenticate.Request:
+			challenge, done, err := server(req.AuthBytes)
+			res = &saslauthenticate.Response{AuthBytes: challenge}
+			if err != nil {
+				res = &saslauthenticate.Response{ErrorCode: int16(kafkaGo.SASLAuthenticationFailed), ErrorMessage: err.Error()}
+				outcome = "authentication failed"
+			} else if done {
+				outcome = "authenticated"
+			}
+		default:
+			return "unexpected request before authenticating"
+		}
+		if err := protocol.WriteResponse(conn, version, correlationID, res); err != nil {
+			return "failed to respond: " + err.Error()
+		}
+		if outcome != "" {
+			return outcome
+		}
+	}
+}
+
+func TestDialerSASL(t *testing.T) {
+	testCases := []struct {
+		mechanism string
+		name      string
+		server    func() saslServer
+	}{
+		{
+			mechanism: "plain",
+			name:      "PLAIN",
+			server:    func() saslServer { return plainServer("go-processor", "secret") },
+		},
+		{
+			mechanism: "scram-sha-256",
+			name:      "SCRAM-SHA-256",
+			server:    func() saslServer { return scramServer(t, scram.SHA256, "go-processor", "secret") },
+		},
+		{
+			mechanism: "scram-sha-512",
+			name:      "SCRAM-SHA-512",
+			server:    func() saslServer { return scramServer(t, scram.SHA512, "go-processor", "secret") },
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.mechanism, func(t *testing.T) {
+			addr, outcomes := startSASLBroker(t, tt.name, tt.server)
+			dial := func(password string) error {
+				dialer, err := NewDialer(config.KafkaConfig{SASL: config.KafkaSASLConfig{
+					Mechanism: tt.mechanism,
+					Username:  "go-processor",
+					Password:  password,
+				}})
+				if err != nil {
+					return err
+				}
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				conn, err := dialer.DialContext(ctx, "tcp", addr)
+				if conn != nil {
+					conn.Close()
+				}
+				return err
+			}
+
+			assert.NoError(t, dial("secret"))
+			assert.Equal(t, "authenticated", <-outcomes)
+
+			err := dial("wrong")
+			assert.ErrorIs(t, err, kafkaGo.SASLAuthenticationFailed)
+			assert.Equal(t, "authentication failed", <-outcomes)
+		})
+	}
+}
@@ -1,52 +1,311 @@
 package kafka
 
 import (
+	"context"
+	"errors"
 	"financial-data-backend-2/internal/config"
+	"fmt"
 	"log"
-	"net"
 	"strconv"
+	"strings"
+	"time"
 
 	kafkaGo "github.com/segmentio/kafka-go"
 )
 
-func EnsureTopic(cfg config.KafkaConfig) error {
-	// Dial the Kafka broker to create a connection for administrative tasks
-	conn, err := kafkaGo.Dial("tcp", cfg.BrokerURL)
+const (
+	defaultRetryAttempts = 30
+	defaultRetryInterval = 2 * time.Second
+)
+
+// ErrMisconfigured means the topic exists but does not match the
+// configuration (or the configuration itself is invalid). Retrying
+// will not fix it, so callers should stop.
+var ErrMisconfigured = errors.New("kafka topic misconfigured")
+
+// topicAdmin is the part of *kafkaGo.Client that EnsureTopic needs.
+type topicAdmin interface {
+	Metadata(context.Context, *kafkaGo.MetadataRequest) (*kafkaGo.MetadataResponse, error)
+	CreateTopics(context.Context, *kafkaGo.CreateTopicsRequest) (*kafkaGo.CreateTopicsResponse, error)
+	CreatePartitions(context.Context, *kafkaGo.CreatePartitionsRequest) (*kafkaGo.CreatePartitionsResponse, error)
+	DescribeConfigs(context.Context, *kafkaGo.DescribeConfigsRequest) (*kafkaGo.DescribeConfigsResponse, error)
+	IncrementalAlterConfigs(context.Context, *kafkaGo.IncrementalAlterConfigsRequest) (*kafkaGo.IncrementalAlterConfigsResponse, error)
+}
+
+// NewClient returns a client for admin requests against the configured
+// brokers, with the same TLS and SASL settings as readers and writers.
+func NewClient(cfg config.KafkaConfig) (*kafkaGo.Client, error) {
+	transport, err := NewTransport(cfg)
 	if err != nil {
-		log.Printf("Failed to dial Kafka for topic creation: %v", err)
-		return err
+		return nil, err
+	}
+	return &kafkaGo.Client{
+		Addr:      kafkaGo.TCP(cfg.BrokerList()...),
+		Timeout:   dialTimeout,
+		Transport: transport,
+	}, nil
+}
+
+// EnsureTopic makes sure the topic exists with at least the configured
+// number of partitions and the configured topic-level configs. It is
+// idempotent: a topic that already exists counts as success, once its
+// configs that differ from the configuration are set.
+func EnsureTopic(ctx context.Context, cfg config.KafkaConfig) error {
+	if len(cfg.BrokerList()) == 0 {
+		return fmt.Errorf("%w: no brokers configured", ErrMisconfigured)
+	}
+	client, err := NewClient(cfg)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrMisconfigured, err)
+	}
+	return ensureTopic(ctx, client, cfg)
+}
+
+// EnsureTopicWithRetry calls EnsureTopic until it succeeds, giving Kafka
+// time to start. Misconfiguration is returned straight away.
+func EnsureTopicWithRetry(ctx context.Context, cfg config.KafkaConfig) error {
+	attempts := cfg.TopicSettings.RetryAttempts
+	if attempts <= 0 {
+		attempts = defaultRetryAttempts
+	}
+	interval := cfg.TopicSettings.RetryInterval
+	if interval <= 0 {
+		interval = defaultRetryInterval
 	}
-	defer conn.Close()
 
-	// Get the controller broker
-	controller, err := conn.Controller()
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = EnsureTopic(ctx, cfg)
+		if err == nil || errors.Is(err, ErrMisconfigured) {
+			return err
+		}
+		log.Printf("Could not ensure Kafka topic exists (attempt %d/%d), retrying in %s: %v",
+			attempt, attempts, interval, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+	return fmt.Errorf("gave up on Kafka topic after %d attempts: %w", attempts, err)
+}
+
+// TopicConfigEntries converts the topic settings into Kafka topic-level
+// configs.
+func TopicConfigEntries(settings config.KafkaTopicConfig) []kafkaGo.ConfigEntry {
+	var entries []kafkaGo.ConfigEntry
+	if settings.Retention > 0 {
+		entries = append(entries, kafkaGo.ConfigEntry{
+			ConfigName:  "retention.ms",
+			ConfigValue: strconv.FormatInt(settings.Retention.Milliseconds(), 10),
+		})
+	}
+	if settings.CompressionType != "" {
+		entries = append(entries, kafkaGo.ConfigEntry{
+			ConfigName:  "compression.type",
+			ConfigValue: settings.CompressionType,
+		})
+	}
+	for name, value := range settings.Configs {
+		entries = append(entries, kafkaGo.ConfigEntry{ConfigName: name, ConfigValue: value})
+	}
+	return entries
+}
+
+func ensureTopic(ctx context.Context, admin topicAdmin, cfg config.KafkaConfig) error {
+	settings := cfg.TopicSettings
+	partitions := max(settings.Partitions, 1)
+	replication := max(settings.ReplicationFactor, 1)
+
+	existing, err := topicPartitions(ctx, admin, cfg.Topic)
 	if err != nil {
-		log.Printf("Failed to get Kafka controller: %v", err)
+		log.Printf("Failed to read Kafka metadata: %v", err)
+		return err
+	}
+
+	if existing == 0 {
+		res, err := admin.CreateTopics(ctx, &kafkaGo.CreateTopicsRequest{
+			Topics: []kafkaGo.TopicConfig{{
+				Topic:             cfg.Topic,
+				NumPartitions:     partitions,
+				ReplicationFactor: replication,
+				ConfigEntries:     TopicConfigEntries(settings),
+			}},
+		})
+		if err != nil {
+			log.Printf("Failed to create Kafka topic: %v", err)
+			return err
+		}
+		switch err := res.Errors[cfg.Topic]; {
+		case err == nil:
+			log.Printf("Kafka topic '%s' created with %d partition(s)", cfg.Topic, partitions)
+			return nil
+		case errors.Is(err, kafkaGo.TopicAlreadyExists):
+			// Someone else created it in the meantime; check it as below.
+			if existing, err = topicPartitions(ctx, admin, cfg.Topic); err != nil {
+				return err
+			}
+			// The metadata may not show all of a topic this new yet, so
+			// try again later rather than report it as misconfigured.
+			if existing < partitions {
+				log.Printf("Kafka topic '%s' was created concurrently but shows %d partition(s) so far", cfg.Topic, existing)
+				return fmt.Errorf("topic '%s' was just created elsewhere and shows %d of %d partition(s)",
+					cfg.Topic, existing, partitions)
+			}
+		case errors.Is(err, kafkaGo.InvalidReplicationFactor),
+			errors.Is(err, kafkaGo.InvalidPartitionNumber),
+			errors.Is(err, kafkaGo.InvalidConfiguration),
+			errors.Is(err, kafkaGo.TopicAuthorizationFailed):
+			return fmt.Errorf("%w: %v", ErrMisconfigured, err)
+		default:
+			log.Printf("Failed to create Kafka topic: %v", err)
+			return err
+		}
+	}
+
+	switch {
+	case existing > partitions:
+		log.Printf("Kafka topic '%s' has %d partitions, more than the %d configured; leaving it as is",
+			cfg.Topic, existing, partitions)
+	case existing < partitions && !settings.GrowPartitions:
+		return fmt.Errorf("%w: topic '%s' has %d partition(s) but %d are configured (set grow_partitions to add them)",
+			ErrMisconfigured, cfg.Topic, existing, partitions)
+	case existing < partitions:
+		res, err := admin.CreatePartitions(ctx, &kafkaGo.CreatePartitionsRequest{
+			Topics: []kafkaGo.TopicPartitionsConfig{{Name: cfg.Topic, Count: int32(partitions)}},
+		})
+		if err == nil {
+			err = res.Errors[cfg.Topic]
+		}
+		if err != nil {
+			log.Printf("Failed to grow Kafka topic partitions: %v", err)
+			return err
+		}
+		log.Printf("Kafka topic '%s' grown from %d to %d partitions", cfg.Topic, existing, partitions)
+	}
+
+	if err := alignConfigs(ctx, admin, cfg.Topic, TopicConfigEntries(settings)); err != nil {
 		return err
 	}
 
-	// Connect to the controller broker
-	controllerConn, err := kafkaGo.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	log.Printf("Kafka topic '%s' is ready", cfg.Topic)
+	return nil
+}
+
+// alignConfigs sets the configs of an existing topic that differ from
+// entries, e.g. after the retention was changed in the configuration. If
+// the broker refuses, the drift is reported as misconfiguration.
+func alignConfigs(ctx context.Context, admin topicAdmin, topic string, entries []kafkaGo.ConfigEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	names := make([]string, len(entries))
+	for i, e := range entries {
+		names[i] = e.ConfigName
+	}
+	res, err := admin.DescribeConfigs(ctx, &kafkaGo.DescribeConfigsRequest{
+		Resources: []kafkaGo.DescribeConfigRequestResource{{
+			ResourceType: kafkaGo.ResourceTypeTopic,
+			ResourceName: topic,
+			ConfigNames:  names,
+		}},
+	})
+	if err == nil {
+		err = resourceError(res.Resources)
+	}
 	if err != nil {
-		log.Printf("Failed to connect to Kafka controller: %v", err)
+		if refused(err) {
+			return fmt.Errorf("%w: cannot read the configs of topic '%s': %v", ErrMisconfigured, topic, err)
+		}
+		log.Printf("Failed to read Kafka topic configs: %v", err)
 		return err
 	}
-	defer controllerConn.Close()
+	current := make(map[string]string)
+	for _, r := range res.Resources {
+		for _, e := range r.ConfigEntries {
+			current[e.ConfigName] = e.ConfigValue
+		}
+	}
 
-	// Define the topic configuration
-	topicConfig := kafkaGo.TopicConfig{
-		Topic:             cfg.Topic,
-		NumPartitions:     1,
-		ReplicationFactor: 1,
+	var changes []kafkaGo.IncrementalAlterConfigsRequestConfig
+	var drift []string
+	for _, e := range entries {
+		value, ok := current[e.ConfigName]
+		if ok && value == e.ConfigValue {
+			continue
+		}
+		changes = append(changes, kafkaGo.IncrementalAlterConfigsRequestConfig{
+			Name: e.ConfigName, Value: e.ConfigValue, ConfigOperation: kafkaGo.ConfigOperationSet,
+		})
+		drift = append(drift, fmt.Sprintf("%s %q -> %q", e.ConfigName, value, e.ConfigValue))
+	}
+	if len(changes) == 0 {
+		return nil
 	}
 
-	// Create the topic
-	err = controllerConn.CreateTopics(topicConfig)
+	alter, err := admin.IncrementalAlterConfigs(ctx, &kafkaGo.IncrementalAlterConfigsRequest{
+		Resources: []kafkaGo.IncrementalAlterConfigsRequestResource{{
+			ResourceType: kafkaGo.ResourceTypeTopic,
+			ResourceName: topic,
+			Configs:      changes,
+		}},
+	})
+	if err == nil {
+		for _, r := range alter.Resources {
+			if r.Error != nil {
+				err = r.Error
+				break
+			}
+		}
+	}
 	if err != nil {
-		log.Printf("Failed to create Kafka topic: %v", err)
+		if refused(err) {
+			return fmt.Errorf("%w: topic '%s' differs from the configuration (%s) and cannot be altered: %v",
+				ErrMisconfigured, topic, strings.Join(drift, ", "), err)
+		}
+		log.Printf("Failed to alter Kafka topic configs: %v", err)
 		return err
 	}
+	log.Printf("Kafka topic '%s' configs updated: %s", topic, strings.Join(drift, ", "))
+	return nil
+}
 
-	log.Printf("Kafka topic '%s' is ready", cfg.Topic)
+func resourceError(resources []kafkaGo.DescribeConfigResponseResource) error {
+	for _, r := range resources {
+		if r.Error != nil {
+			return r.Error
+		}
+	}
 	return nil
 }
+
+// refused reports whether the broker refused a request for good, rather
+// than failed it in a way worth retrying.
+func refused(err error) bool {
+	return errors.Is(err, kafkaGo.InvalidConfiguration) ||
+		errors.Is(err, kafkaGo.PolicyViolation) ||
+		errors.Is(err, kafkaGo.TopicAuthorizationFailed) ||
+		errors.Is(err, kafkaGo.ClusterAuthorizationFailed)
+}
+
+// topicPartitions returns how many partitions topic has, or 0 if it
+// does not exist.
+func topicPartitions(ctx context.Context, admin topicAdmin, topic string) (int, error) {
+	res, err := admin.Metadata(ctx, &kafkaGo.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range res.Topics {
+		if t.Name != topic {
+			continue
+		}
+		if errors.Is(t.Error, kafkaGo.UnknownTopicOrPartition) {
+			return 0, nil
+		}
+		if t.Error != nil {
+			return 0, t.Error
+		}
+		return len(t.Partitions), nil
+	}
+	return 0, nil
+}
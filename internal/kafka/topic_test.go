@@ -0,0 +1,266 @@
+package kafka
+
+import (
+	"context"
+	"financial-data-backend-2/internal/config"
+	"testing"
+	"time"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAdmin is an in-memory cluster holding at most one topic.
+type fakeAdmin struct {
+	partitions   int // 0 means the topic does not exist
+	createErr    error
+	metadataErr  error
+	raceOnCreate int   // partitions another client creates just before us
+	staleReads   []int // partition counts shown once the topic exists, before the real one
+	configs      map[string]string
+	alterErr     error
+	alters       int
+}
+
+func (f *fakeAdmin) Metadata(_ context.Context, req *kafkaGo.MetadataRequest) (*kafkaGo.MetadataResponse, error) {
+	if f.metadataErr != nil {
+		return nil, f.metadataErr
+	}
+	topic := kafkaGo.Topic{Name: req.Topics[0]}
+	partitions := f.partitions
+	if partitions > 0 && len(f.staleReads) > 0 {
+		partitions, f.staleReads = f.staleReads[0], f.staleReads[1:]
+	}
+	if partitions == 0 {
+		topic.Error = kafkaGo.UnknownTopicOrPartition
+	}
+	topic.Partitions = make([]kafkaGo.Partition, partitions)
+	return &kafkaGo.MetadataResponse{Topics: []kafkaGo.Topic{topic}}, nil
+}
+
+func (f *fakeAdmin) CreateTopics(_ context.Context, req *kafkaGo.CreateTopicsRequest) (*kafkaGo.CreateTopicsResponse, error) {
+	t := req.Topics[0]
+	res := &kafkaGo.CreateTopicsResponse{Errors: map[string]error{}}
+	switch {
+	case f.createErr != nil:
+		res.Errors[t.Topic] = f.createErr
+	case f.raceOnCreate > 0:
+		f.partitions = f.raceOnCreate
+		res.Errors[t.Topic] = kafkaGo.TopicAlreadyExists
+	default:
+		f.partitions = t.NumPartitions
+		f.configs = make(map[string]string)
+		for _, e := range t.ConfigEntries {
+			f.configs[e.ConfigName] = e.ConfigValue
+		}
+	}
+	return res, nil
+}
+
+func (f *fakeAdmin) CreatePartitions(_ context.Context, req *kafkaGo.CreatePartitionsRequest) (*kafkaGo.CreatePartitionsResponse, error) {
+	f.partitions = int(req.Topics[0].Count)
+	return &kafkaGo.CreatePartitionsResponse{Errors: map[string]error{}}, nil
+}
+
+func (f *fakeAdmin) DescribeConfigs(_ context.Context, req *kafkaGo.DescribeConfigsRequest) (*kafkaGo.DescribeConfigsResponse, error) {
+	r := kafkaGo.DescribeConfigResponseResource{ResourceName: req.Resources[0].ResourceName}
+	for _, name := range req.Resources[0].ConfigNames {
+		if value, ok := f.configs[name]; ok {
+			r.ConfigEntries = append(r.ConfigEntries,
+				kafkaGo.DescribeConfigResponseConfigEntry{ConfigName: name, ConfigValue: value})
+		}
+	}
+	return &kafkaGo.DescribeConfigsResponse{Resources: []kafkaGo.DescribeConfigResponseResource{r}}, nil
+}
+
+func (f *fakeAdmin) IncrementalAlterConfigs(_ context.Context, req *kafkaGo.IncrementalAlterConfigsRequest) (*kafkaGo.IncrementalAlterConfigsResponse, error) {
+	f.alters++
+	r := kafkaGo.IncrementalAlterConfigsResponseResource{ResourceName: req.Resources[0].ResourceName}
+	if f.alterErr != nil {
+		r.Error = f.alterErr
+	} else {
+		if f.configs == nil {
+			f.configs = make(map[string]string)
+		}
+		for _, c := range req.Resources[0].Configs {
+			f.configs[c.Name] = c.Value
+		}
+	}
+	return &kafkaGo.IncrementalAlterConfigsResponse{Resources: []kafkaGo.IncrementalAlterConfigsResponseResource{r}}, nil
+}
+
+func TestEnsureTopic(t *testing.T) {
+	testCases := []struct {
+		name               string
+		admin              *fakeAdmin
+		settings           config.KafkaTopicConfig
+		expectMisconfig    bool
+		expectError        bool
+		expectedPartitions int
+		expectedConfigs    map[string]string
+		expectedAlters     int
+	}{
+		{
+			name:               "creates a missing topic with defaults",
+			admin:              &fakeAdmin{},
+			expectedPartitions: 1,
+		},
+		{
+			name:               "existing topic is success",
+			admin:              &fakeAdmin{partitions: 3},
+			settings:           config.KafkaTopicConfig{Partitions: 3},
+			expectedPartitions: 3,
+		},
+		{
+			name:               "topic created concurrently is success",
+			admin:              &fakeAdmin{raceOnCreate: 3},
+			settings:           config.KafkaTopicConfig{Partitions: 3},
+			expectedPartitions: 3,
+		},
+		{
+			name:               "more partitions than configured is kept",
+			admin:              &fakeAdmin{partitions: 6},
+			settings:           config.KafkaTopicConfig{Partitions: 3},
+			expectedPartitions: 6,
+		},
+		{
+			name:               "too few partitions is misconfiguration",
+			admin:              &fakeAdmin{partitions: 1},
+			settings:           config.KafkaTopicConfig{Partitions: 3},
+			expectMisconfig:    true,
+			expectedPartitions: 1,
+		},
+		{
+			name:               "too few partitions grown when allowed",
+			admin:              &fakeAdmin{partitions: 1},
+			settings:           config.KafkaTopicConfig{Partitions: 3, GrowPartitions: true},
+			expectedPartitions: 3,
+		},
+		{
+			name:               "invalid replication factor is misconfiguration",
+			admin:              &fakeAdmin{createErr: kafkaGo.InvalidReplicationFactor},
+			settings:           config.KafkaTopicConfig{ReplicationFactor: 3},
+			expectMisconfig:    true,
+			expectedPartitions: 0,
+		},
+		{
+			name:               "created topic gets the configured configs",
+			admin:              &fakeAdmin{},
+			settings:           config.KafkaTopicConfig{Retention: time.Hour},
+			expectedPartitions: 1,
+			expectedConfigs:    map[string]string{"retention.ms": "3600000"},
+		},
+		{
+			name:               "configs of an existing topic are altered",
+			admin:              &fakeAdmin{partitions: 1, configs: map[string]string{"retention.ms": "604800000"}},
+			settings:           config.KafkaTopicConfig{Retention: time.Hour, CompressionType: "zstd"},
+			expectedPartitions: 1,
+			expectedConfigs:    map[string]string{"retention.ms": "3600000", "compression.type": "zstd"},
+			expectedAlters:     1,
+		},
+		{
+			name:               "configs of a topic created concurrently are altered",
+			admin:              &fakeAdmin{raceOnCreate: 1},
+			settings:           config.KafkaTopicConfig{Retention: time.Hour},
+			expectedPartitions: 1,
+			expectedConfigs:    map[string]string{"retention.ms": "3600000"},
+			expectedAlters:     1,
+		},
+		{
+			name:               "matching configs are not altered",
+			admin:              &fakeAdmin{partitions: 1, configs: map[string]string{"retention.ms": "3600000"}},
+			settings:           config.KafkaTopicConfig{Retention: time.Hour},
+			expectedPartitions: 1,
+			expectedConfigs:    map[string]string{"retention.ms": "3600000"},
+		},
+		{
+			name: "configs the broker refuses to alter are misconfiguration",
+			admin: &fakeAdmin{partitions: 1, configs: map[string]string{"retention.ms": "604800000"},
+				alterErr: kafkaGo.PolicyViolation},
+			settings:           config.KafkaTopicConfig{Retention: time.Hour},
+			expectMisconfig:    true,
+			expectedPartitions: 1,
+			expectedConfigs:    map[string]string{"retention.ms": "604800000"},
+			expectedAlters:     1,
+		},
+		{
+			name: "failing to alter configs is retryable",
+			admin: &fakeAdmin{partitions: 1, configs: map[string]string{"retention.ms": "604800000"},
+				alterErr: kafkaGo.RequestTimedOut},
+			settings:           config.KafkaTopicConfig{Retention: time.Hour},
+			expectError:        true,
+			expectedPartitions: 1,
+			expectedConfigs:    map[string]string{"retention.ms": "604800000"},
+			expectedAlters:     1,
+		},
+		{
+			name:               "unreachable broker is retryable",
+			admin:              &fakeAdmin{metadataErr: kafkaGo.BrokerNotAvailable},
+			expectError:        true,
+			expectedPartitions: 0,
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.KafkaConfig{Topic: "raw_stock_ticks", TopicSettings: tt.settings}
+
+			err := ensureTopic(context.Background(), tt.admin, cfg)
+
+			switch {
+			case tt.expectMisconfig:
+				assert.ErrorIs(t, err, ErrMisconfigured)
+			case tt.expectError:
+				assert.Error(t, err)
+				assert.NotErrorIs(t, err, ErrMisconfigured)
+			default:
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.expectedPartitions, tt.admin.partitions)
+			if tt.expectedConfigs != nil {
+				assert.Equal(t, tt.expectedConfigs, tt.admin.configs)
+			}
+			assert.Equal(t, tt.expectedAlters, tt.admin.alters)
+		})
+	}
+}
+
+func TestEnsureTopicConcurrentCreate(t *testing.T) {
+	// Right after another client creates the topic, the metadata may not
+	// show it, or only some of its partitions.
+	for _, stale := range []int{0, 1} {
+		admin := &fakeAdmin{raceOnCreate: 3, staleReads: []int{stale}}
+		cfg := config.KafkaConfig{Topic: "raw_stock_ticks", TopicSettings: config.KafkaTopicConfig{Partitions: 3}}
+
+		err := ensureTopic(context.Background(), admin, cfg)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrMisconfigured, "showing %d partition(s) should be retried", stale)
+
+		// The next attempt sees the whole topic.
+		assert.NoError(t, ensureTopic(context.Background(), admin, cfg))
+		assert.Equal(t, 3, admin.partitions)
+	}
+}
+
+func TestTopicConfigEntries(t *testing.T) {
+	entries := TopicConfigEntries(config.KafkaTopicConfig{
+		Retention:       7 * 24 * time.Hour,
+		CompressionType: "zstd",
+		Configs:         map[string]string{"min.insync.replicas": "2"},
+	})
+
+	assert.Equal(t, []kafkaGo.ConfigEntry{
+		{ConfigName: "retention.ms", ConfigValue: "604800000"},
+		{ConfigName: "compression.type", ConfigValue: "zstd"},
+		{ConfigName: "min.insync.replicas", ConfigValue: "2"},
+	}, entries)
+	assert.Empty(t, TopicConfigEntries(config.KafkaTopicConfig{}))
+}
+
+func TestEnsureTopicWithRetryMisconfigured(t *testing.T) {
+	// No brokers at all should fail at once rather than retry.
+	start := time.Now()
+	err := EnsureTopicWithRetry(context.Background(), config.KafkaConfig{Topic: "t"})
+	assert.ErrorIs(t, err, ErrMisconfigured)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,640 @@
+package lake
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"financial-data-backend-2/internal/models"
+	"fmt"
+	"io"
+	"math/big"
+	"math/bits"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var start = time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+
+func dec(s string) primitive.Decimal128 {
+	d, _ := primitive.ParseDecimal128(s)
+	return d
+}
+
+func trade(symbol string, after time.Duration, price string) models.TradeRecord {
+	return models.TradeRecord{MessageKey: symbol + "-" + after.String(), Symbol: symbol,
+		Time: start.Add(after), Price: dec(price), Volume: dec("1.5")}
+}
+
+// thriftReader decodes the Thrift compact protocol into maps of field id
+// to value, with lists as []any.
+type thriftReader struct {
+	r *bytes.Reader
+}
+
+func (t thriftReader) varint() int64 {
+	u, _ := binary.ReadUvarint(t.r)
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+func (t thriftReader) value(typ byte) any {
+	switch typ {
+	case thriftI32, thriftI64:
+		return t.varint()
+	case thriftBinary:
+		n, _ := binary.ReadUvarint(t.r)
+		b := make([]byte, n)
+		t.r.Read(b)
+		return string(b)
+	case thriftList:
+		h, _ := t.r.ReadByte()
+		n := int(h >> 4)
+		if n == 15 {
+			u, _ := binary.ReadUvarint(t.r)
+			n = int(u)
+		}
+		list := make([]any, n)
+		for i := range list {
+			list[i] = t.value(h & 0x0F)
+		}
+		return list
+	case thriftStruct:
+		return t.structure()
+	}
+	panic("unexpected thrift type")
+}
+
+func (t thriftReader) structure() map[int16]any {
+	fields := make(map[int16]any)
+	var id int16
+	for {
+		b, _ := t.r.ReadByte()
+		if b == 0 {
+			return fields
+		}
+		if delta := int16(b >> 4); delta > 0 {
+			id += delta
+		} else {
+			id = int16(t.varint())
+		}
+		fields[id] = t.value(b & 0x0F)
+	}
+}
+
+// Values of the Parquet format, from its Thrift definitions, kept apart
+// from the writer's so that the reader below checks them
+const (
+	specOptional = 1
+	specRepeated = 2
+
+	specInt64             = 2
+	specByteArray         = 6
+	specFixedLenByteArray = 7
+
+	specUncompressed = 0
+	specSnappy       = 1
+
+	specPlain    = 0
+	specRLE      = 3
+	specDataPage = 0
+)
+
+// leaf is a column of a Parquet file, as its schema describes it.
+type leaf struct {
+	path   []any
+	typ    int64
+	length int   // of a fixed-length byte array
+	scale  int64 // of a decimal
+	// Number of optional or repeated fields on its path, and of repeated
+	// ones
+	maxDef, maxRep int
+}
+
+// leaves returns the columns of a flattened schema, depth first.
+func leaves(elements []any) []leaf {
+	var out []leaf
+	next := 1 // after the root
+	var walk func(path []any, def, rep int)
+	walk = func(path []any, def, rep int) {
+		e := elements[next].(map[int16]any)
+		next++
+		path = append(append([]any{}, path...), e[4])
+		switch e[3] {
+		case int64(specOptional):
+			def++
+		case int64(specRepeated):
+			def++
+			rep++
+		}
+		if children, ok := e[5].(int64); ok {
+			for range children {
+				walk(path, def, rep)
+			}
+			return
+		}
+		l := leaf{path: path, typ: e[1].(int64), maxDef: def, maxRep: rep}
+		if n, ok := e[2].(int64); ok {
+			l.length = int(n)
+		}
+		if scale, ok := e[7].(int64); ok {
+			l.scale = scale
+		}
+		out = append(out, l)
+	}
+	for range elements[0].(map[int16]any)[5].(int64) {
+		walk(nil, 0, 0)
+	}
+	return out
+}
+
+// readLevels reads n levels of at most max from the front of page: a
+// length and runs in the RLE/bit-packing hybrid encoding.
+func readLevels(t *testing.T, page *[]byte, max, n int) []int {
+	if max == 0 {
+		return make([]int, n)
+	}
+	width := bits.Len(uint(max))
+	size := binary.LittleEndian.Uint32(*page)
+	r := bytes.NewReader((*page)[4 : 4+size])
+	*page = (*page)[4+size:]
+	var out []int
+	for len(out) < n {
+		header, err := binary.ReadUvarint(r)
+		require.NoError(t, err)
+		if header&1 == 1 {
+			// Groups of 8 values, packed from the least significant bit
+			packed := make([]byte, int(header>>1)*width)
+			_, err := io.ReadFull(r, packed)
+			require.NoError(t, err)
+			for i := 0; i < len(packed)*8/width; i++ {
+				v := 0
+				for b := 0; b < width; b++ {
+					bit := i*width + b
+					v |= int(packed[bit/8]>>(bit%8)&1) << b
+				}
+				out = append(out, v)
+			}
+			continue
+		}
+		value := make([]byte, (width+7)/8)
+		_, err = io.ReadFull(r, value)
+		require.NoError(t, err)
+		v := 0
+		for i, b := range value {
+			v |= int(b) << (8 * i)
+		}
+		for range header >> 1 {
+			out = append(out, v)
+		}
+	}
+	assert.Zero(t, r.Len())
+	return out[:n]
+}
+
+// readValue reads a PLAIN encoded value from the front of page.
+func readValue(l leaf, page *[]byte) any {
+	p := *page
+	switch l.typ {
+	case specInt64:
+		*page = p[8:]
+		return int64(binary.LittleEndian.Uint64(p))
+	case specByteArray:
+		n := binary.LittleEndian.Uint32(p)
+		*page = p[4+n:]
+		return string(p[4 : 4+n])
+	case specFixedLenByteArray:
+		// A decimal: big-endian two's complement, unscaled
+		v := new(big.Int).SetBytes(p[:l.length])
+		if p[0]&0x80 != 0 {
+			v.Sub(v, new(big.Int).Lsh(big.NewInt(1), uint(8*l.length)))
+		}
+		*page = p[l.length:]
+		s := new(big.Rat).SetFrac(v, new(big.Int).Exp(big.NewInt(10), big.NewInt(l.scale), nil)).
+			FloatString(int(l.scale))
+		return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+	}
+	panic(fmt.Sprintf("unexpected physical type %d", l.typ))
+}
+
+// readParquet reads a file the way a Parquet reader would, from its
+// footer and schema alone, and returns the footer and the rows, as JSON
+// would give them: column name to value, nil for nulls.
+func readParquet(t *testing.T, data []byte) (map[int16]any, []map[string]any) {
+	require.Equal(t, "PAR1", string(data[:4]))
+	require.Equal(t, "PAR1", string(data[len(data)-4:]))
+	n := binary.LittleEndian.Uint32(data[len(data)-8:])
+	footer := data[len(data)-8-int(n) : len(data)-8]
+	meta := thriftReader{bytes.NewReader(footer)}.structure()
+	columns := leaves(meta[2].([]any))
+
+	var rows []map[string]any
+	for _, g := range meta[4].([]any) {
+		group := g.(map[int16]any)
+		numRows := int(group[3].(int64))
+		first := len(rows)
+		for range numRows {
+			rows = append(rows, make(map[string]any))
+		}
+		chunks := group[1].([]any)
+		require.Len(t, chunks, len(columns))
+		for i, c := range chunks {
+			l := columns[i]
+			chunk := c.(map[int16]any)[3].(map[int16]any)
+			require.Equal(t, l.typ, chunk[1], "type of %v", l.path)
+			require.Equal(t, l.path, chunk[3], "path of column %d", i)
+
+			r := bytes.NewReader(data[chunk[9].(int64):])
+			header := thriftReader{r}.structure()
+			require.Equal(t, int64(specDataPage), header[1])
+			page := make([]byte, header[3].(int64))
+			_, err := io.ReadFull(r, page)
+			require.NoError(t, err)
+			switch chunk[4] {
+			case int64(specSnappy):
+				page, err = snappy.Decode(nil, page)
+				require.NoError(t, err)
+			case int64(specUncompressed):
+			default:
+				t.Fatalf("unexpected codec %v", chunk[4])
+			}
+			require.Len(t, page, int(header[2].(int64)))
+			dataPage := header[5].(map[int16]any)
+			require.Equal(t, int64(specPlain), dataPage[2])
+			require.Equal(t, int64(specRLE), dataPage[3])
+			require.Equal(t, int64(specRLE), dataPage[4])
+			values := int(dataPage[1].(int64))
+			require.Equal(t, chunk[5], int64(values))
+
+			// Repetition levels come first, then definition levels
+			rep := readLevels(t, &page, l.maxRep, values)
+			def := readLevels(t, &page, l.maxDef, values)
+			row := first - 1
+			name := l.path[0].(string)
+			for j := 0; j < values; j++ {
+				var v any
+				if def[j] == l.maxDef {
+					v = readValue(l, &page)
+				}
+				if rep[j] == 0 {
+					row++
+					require.Less(t, row, len(rows), "more rows than num_rows in %s", name)
+					if l.maxRep == 0 {
+						rows[row][name] = v
+						continue
+					}
+					rows[row][name] = []any{}
+				}
+				if v != nil {
+					rows[row][name] = append(rows[row][name].([]any), v)
+				}
+			}
+			assert.Equal(t, len(rows)-1, row, "rows of %s", name)
+			assert.Empty(t, page)
+		}
+	}
+
+	// Compare as JSON, as pyarrow's rows are
+	out, err := json.Marshal(rows)
+	require.NoError(t, err)
+	var decoded []map[string]any
+	require.NoError(t, json.Unmarshal(out, &decoded))
+	return meta, decoded
+}
+
+func TestParquetWriter(t *testing.T) {
+	late := trade("AAPL", time.Second, "189.125")
+	late.Conditions = []string{"1", "12"}
+	late.Flags = []string{models.FlagPriceOutlier}
+	late.OriginalTime = start.Add(time.Hour)
+	odd := trade("AAPL", 2*time.Second, "190")
+	odd.Volume = dec("0.0000000000000000005") // rounded to 18 decimals
+	odd.Price = dec("NaN")
+	trades := []models.TradeRecord{trade("AAPL", 0, "189.5"), late, odd}
+
+	for _, codec := range []int32{CodecNone, CodecSnappy} {
+		var buf bytes.Buffer
+		pw, err := newParquetWriter(&buf, codec)
+		require.NoError(t, err)
+		for _, tr := range trades {
+			require.NoError(t, pw.Write(tr))
+		}
+		require.NoError(t, pw.Close())
+		assert.Equal(t, int64(buf.Len()), pw.offset)
+
+		meta, rows := readParquet(t, buf.Bytes())
+		assert.Equal(t, int64(3), meta[3], "num_rows")
+		elements := meta[2].([]any)
+		require.Len(t, elements, len(schema))
+		var names []any
+		for _, e := range elements {
+			names = append(names, e.(map[int16]any)[4])
+		}
+		assert.Equal(t, []any{"trade", "message_key", "time", "price", "volume",
+			"conditions", "list", "element", "flags", "list", "element", "original_time"}, names)
+		price := elements[3].(map[int16]any)
+		assert.Equal(t, int64(decimalScale), price[7], "scale")
+		assert.Equal(t, int64(decimalPrecision), price[8], "precision")
+
+		ms := float64(start.UnixMilli())
+		assert.Equal(t, []map[string]any{
+			{"message_key": "AAPL-0s", "time": ms, "price": "189.5", "volume": "1.5",
+				"conditions": []any{}, "flags": []any{}, "original_time": nil},
+			{"message_key": "AAPL-1s", "time": ms + 1000, "price": "189.125", "volume": "1.5",
+				"conditions": []any{"1", "12"}, "flags": []any{models.FlagPriceOutlier},
+				"original_time": float64(start.Add(time.Hour).UnixMilli())},
+			{"message_key": "AAPL-2s", "time": ms + 2000, "price": nil, "volume": "0.000000000000000001",
+				"conditions": []any{}, "flags": []any{}, "original_time": nil},
+		}, rows)
+	}
+}
+
+// arrowScript prints the rows of a Parquet file as JSON, as pyarrow reads
+// them, with times in ms and decimals as strings.
+const arrowScript = `
+import json, sys
+import pyarrow as pa, pyarrow.parquet as pq
+t = pq.read_table(sys.argv[1])
+for name in ("time", "original_time"):
+    t = t.set_column(t.schema.get_field_index(name), name, t.column(name).cast(pa.int64()))
+rows = t.to_pylist()
+for r in rows:
+    for k in ("price", "volume"):
+        if r[k] is not None:
+            r[k] = format(r[k].normalize(), "f")
+print(json.dumps(rows))
+`
+
+// TestParquetReadable checks files with readParquet and, if it is
+// installed (pip install pyarrow), with pyarrow. CI installs it, so there
+// the test fails without it.
+func TestParquetReadable(t *testing.T) {
+	arrow := exec.Command("python3", "-c", "import pyarrow.parquet").Run() == nil
+	if !arrow && os.Getenv("CI") != "" {
+		t.Fatal("pyarrow is not installed")
+	}
+	late := trade("AAPL", time.Second, "-0.5")
+	late.Conditions = []string{"1", "12"}
+	late.Flags = []string{models.FlagPriceOutlier}
+	late.OriginalTime = start.Add(time.Hour)
+
+	for _, codec := range []int32{CodecNone, CodecSnappy} {
+		path := filepath.Join(t.TempDir(), "trades.parquet")
+		f, err := os.Create(path)
+		require.NoError(t, err)
+		pw, err := newParquetWriter(f, codec)
+		require.NoError(t, err)
+		require.NoError(t, pw.Write(trade("AAPL", 0, "189.5")))
+		require.NoError(t, pw.Write(late))
+		require.NoError(t, pw.Close())
+		require.NoError(t, f.Close())
+
+		ms := float64(start.UnixMilli())
+		expected := []map[string]any{
+			{"message_key": "AAPL-0s", "time": ms, "price": "189.5", "volume": "1.5",
+				"conditions": []any{}, "flags": []any{}, "original_time": nil},
+			{"message_key": "AAPL-1s", "time": ms + 1000, "price": "-0.5", "volume": "1.5",
+				"conditions": []any{"1", "12"}, "flags": []any{models.FlagPriceOutlier},
+				"original_time": float64(start.Add(time.Hour).UnixMilli())},
+		}
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		_, rows := readParquet(t, data)
+		assert.Equal(t, expected, rows)
+
+		if !arrow {
+			t.Log("pyarrow is not installed, only checked with readParquet")
+			continue
+		}
+		out, err := exec.Command("python3", "-c", arrowScript, path).CombinedOutput()
+		require.NoError(t, err, string(out))
+		require.NoError(t, json.Unmarshal(out, &rows), string(out))
+		assert.Equal(t, expected, rows)
+	}
+}
+
+func TestUnscaled(t *testing.T) {
+	v, ok := unscaled(dec("-2.5"))
+	assert.True(t, ok)
+	assert.Equal(t, "-2500000000000000000", v.String())
+	v, ok = unscaled(dec("-0.0000000000000000015"))
+	assert.True(t, ok)
+	assert.Equal(t, "-2", v.String(), "halves are rounded away from zero")
+	_, ok = unscaled(dec("1E+20"))
+	assert.False(t, ok, "more than 38 digits")
+	_, ok = unscaled(dec("Infinity"))
+	assert.False(t, ok)
+}
+
+func TestParquetWriterRowGroups(t *testing.T) {
+	var buf bytes.Buffer
+	pw, err := newParquetWriter(&buf, CodecSnappy)
+	require.NoError(t, err)
+	// Enough trades, with long keys, for several row groups
+	n := 3 * rowGroupSize / 1000
+	for i := 0; i < n; i++ {
+		tr := trade("AAPL", time.Duration(i)*time.Millisecond, "1")
+		tr.MessageKey = strings.Repeat("k", 1000)
+		require.NoError(t, pw.Write(tr))
+	}
+	require.NoError(t, pw.Close())
+
+	meta, rows := readParquet(t, buf.Bytes())
+	assert.Greater(t, len(meta[4].([]any)), 1)
+	assert.Equal(t, int64(n), meta[3])
+	require.Len(t, rows, n)
+	assert.Equal(t, float64(start.Add(time.Duration(n-1)*time.Millisecond).UnixMilli()), rows[n-1]["time"])
+}
+
+func TestEscapePathName(t *testing.T) {
+	assert.Equal(t, "AAPL", EscapePathName("AAPL"))
+	assert.Equal(t, "BINANCE%3ABTCUSDT", EscapePathName("BINANCE:BTCUSDT"))
+	assert.Equal(t, "OANDA%3AEUR_USD", EscapePathName("OANDA:EUR_USD"))
+	assert.Equal(t, "a%2Fb%3Dc%25", EscapePathName("a/b=c%"))
+}
+
+// failingStore fails every Put while failing is set.
+type failingStore struct {
+	Store
+	failing bool
+}
+
+func (s *failingStore) Put(ctx context.Context, path, key string) error {
+	if s.failing {
+		return errors.New("store unavailable")
+	}
+	return s.Store.Put(ctx, path, key)
+}
+
+func newSink(t *testing.T, cfg SinkConfig) (*Sink, *failingStore, string) {
+	root := t.TempDir()
+	store := &failingStore{Store: NewLocalStore(root)}
+	sink, err := NewSink(cfg, filepath.Join(root, "_tmp"), store)
+	require.NoError(t, err)
+	return sink, store, root
+}
+
+// files returns the slash-separated paths of the files under root.
+func files(t *testing.T, root, dir string) []string {
+	var paths []string
+	err := filepath.WalkDir(filepath.Join(root, dir), func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(root, path)
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if !errors.Is(err, os.ErrNotExist) {
+		require.NoError(t, err)
+	}
+	return paths
+}
+
+func TestSink(t *testing.T) {
+	ctx := context.Background()
+	sink, _, root := newSink(t, SinkConfig{RollSize: 1 << 20, RollInterval: time.Hour, Codec: CodecSnappy})
+
+	require.NoError(t, sink.Add(ctx, []models.TradeRecord{
+		trade("AAPL", 0, "189.5"), trade("BINANCE:BTCUSDT", 0, "91000"),
+	}, 0, 10, start))
+	require.NoError(t, sink.Add(ctx, []models.TradeRecord{trade("AAPL", time.Hour, "190")}, 1, 5, start))
+	require.NoError(t, sink.Add(ctx, []models.TradeRecord{trade("AAPL", time.Second, "189.75")}, 0, 11, start))
+	// Nothing is visible, or committable, until the files are finished
+	assert.Empty(t, files(t, root, TradesDir))
+	assert.Equal(t, map[int]int64{0: 10, 1: 5}, sink.Committable())
+
+	require.NoError(t, sink.Close(ctx))
+	assert.Equal(t, map[int]int64{0: 12, 1: 6}, sink.Committable())
+	assert.Empty(t, files(t, root, "_tmp"))
+
+	paths := files(t, root, TradesDir)
+	require.Len(t, paths, 3)
+	dirs := make([]string, len(paths))
+	for i, p := range paths {
+		dirs[i] = filepath.ToSlash(filepath.Dir(p))
+		assert.True(t, strings.HasSuffix(p, ".parquet"))
+	}
+	assert.ElementsMatch(t, []string{
+		"trades/date=2025-11-20/hour=14/symbol=AAPL",
+		"trades/date=2025-11-20/hour=15/symbol=AAPL",
+		"trades/date=2025-11-20/hour=14/symbol=BINANCE%3ABTCUSDT",
+	}, dirs)
+
+	// Each file is listed in the manifest
+	manifest := files(t, root, ManifestDir)
+	require.Len(t, manifest, 3)
+	for _, m := range manifest {
+		data, err := os.ReadFile(filepath.Join(root, m))
+		require.NoError(t, err)
+		var entry ManifestEntry
+		require.NoError(t, json.Unmarshal(data, &entry))
+		assert.Equal(t, ManifestDir+"/"+strings.TrimSuffix(entry.Path, ".parquet")+".json", m)
+		stat, err := os.Stat(filepath.Join(root, entry.Path))
+		require.NoError(t, err)
+		assert.Equal(t, stat.Size(), entry.Bytes)
+		if entry.Symbol == "AAPL" && entry.Hour.Equal(start.Truncate(time.Hour)) {
+			assert.Equal(t, int64(2), entry.Rows)
+			assert.Equal(t, start, entry.MinTime)
+			assert.Equal(t, start.Add(time.Second), entry.MaxTime)
+
+			data, err := os.ReadFile(filepath.Join(root, entry.Path))
+			require.NoError(t, err)
+			_, rows := readParquet(t, data)
+			require.Len(t, rows, 2)
+			assert.Equal(t, "189.5", rows[0]["price"])
+			assert.Equal(t, "189.75", rows[1]["price"])
+		}
+	}
+}
+
+func TestSinkRoll(t *testing.T) {
+	ctx := context.Background()
+	sink, _, root := newSink(t, SinkConfig{RollSize: 1 << 20, RollInterval: time.Minute, Codec: CodecNone})
+
+	require.NoError(t, sink.Add(ctx, []models.TradeRecord{trade("AAPL", 0, "1")}, 0, 0, start))
+	require.NoError(t, sink.Roll(ctx, start.Add(59*time.Second)))
+	assert.Empty(t, files(t, root, TradesDir))
+	require.NoError(t, sink.Roll(ctx, start.Add(time.Minute)))
+	assert.Len(t, files(t, root, TradesDir), 1)
+
+	// The next trades of the partition go to a new file
+	require.NoError(t, sink.Add(ctx, []models.TradeRecord{trade("AAPL", time.Second, "1")}, 0, 1, start))
+	require.NoError(t, sink.Close(ctx))
+	assert.Len(t, files(t, root, TradesDir), 2)
+}
+
+func TestSinkRollSize(t *testing.T) {
+	ctx := context.Background()
+	sink, _, root := newSink(t, SinkConfig{RollSize: 1000, RollInterval: time.Hour, Codec: CodecNone})
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, sink.Add(ctx, []models.TradeRecord{trade("AAPL", time.Duration(i)*time.Second, "1")},
+			0, int64(i), start))
+	}
+	require.NoError(t, sink.Close(ctx))
+	paths := files(t, root, TradesDir)
+	assert.Greater(t, len(paths), 1)
+	for _, p := range paths {
+		stat, err := os.Stat(filepath.Join(root, p))
+		require.NoError(t, err)
+		// A file is finished as soon as it reaches the size, plus its footer
+		assert.Less(t, stat.Size(), int64(2000))
+	}
+}
+
+func TestSinkStoreFailure(t *testing.T) {
+	ctx := context.Background()
+	sink, store, root := newSink(t, SinkConfig{RollSize: 1 << 20, RollInterval: time.Minute, Codec: CodecSnappy})
+
+	store.failing = true
+	require.NoError(t, sink.Add(ctx, []models.TradeRecord{trade("AAPL", 0, "1")}, 0, 7, start))
+	assert.Error(t, sink.Roll(ctx, start.Add(time.Minute)))
+	assert.Equal(t, 1, sink.Pending())
+	require.NoError(t, sink.Add(ctx, []models.TradeRecord{trade("MSFT", 0, "1")}, 0, 8, start))
+	// The unstored file holds back the commits
+	assert.Equal(t, map[int]int64{0: 7}, sink.Committable())
+
+	store.failing = false
+	require.NoError(t, sink.Roll(ctx, start.Add(time.Minute)))
+	assert.Zero(t, sink.Pending())
+	assert.Equal(t, map[int]int64{0: 9}, sink.Committable())
+	assert.Len(t, files(t, root, TradesDir), 2)
+	assert.Len(t, files(t, root, ManifestDir), 2)
+}
+
+func TestSinkWriteFailure(t *testing.T) {
+	ctx := context.Background()
+	sink, _, root := newSink(t, SinkConfig{RollSize: 1 << 20, RollInterval: time.Hour, Codec: CodecSnappy})
+	require.NoError(t, sink.Add(ctx, []models.TradeRecord{trade("AAPL", 0, "189.5")}, 0, 10, start))
+	require.NoError(t, sink.Add(ctx, []models.TradeRecord{trade("AAPL", time.Second, "189.75")}, 1, 20, start))
+	require.NoError(t, sink.Roll(ctx, start.Add(time.Hour)))
+
+	// New files cannot be created anymore
+	require.NoError(t, os.RemoveAll(filepath.Join(root, "_tmp")))
+	err := sink.Add(ctx, []models.TradeRecord{trade("MSFT", 0, "410")}, 0, 11, start)
+	assert.ErrorIs(t, err, ErrWrite)
+	// Messages after the one that failed do not make it committable
+	require.NoError(t, sink.Add(ctx, nil, 0, 12, start))
+	assert.Equal(t, map[int]int64{0: 11, 1: 21}, sink.Committable())
+}
+
+func TestSinkDeletesLeftovers(t *testing.T) {
+	root := t.TempDir()
+	staging := filepath.Join(root, "_tmp")
+	require.NoError(t, os.MkdirAll(staging, 0o755))
+	leftover := filepath.Join(staging, "part-20251120T143000Z-0123456789abcdef.parquet.tmp")
+	require.NoError(t, os.WriteFile(leftover, []byte(parquetMagic), 0o644))
+
+	_, err := NewSink(SinkConfig{RollSize: 1 << 20, RollInterval: time.Hour}, staging, NewLocalStore(root))
+	require.NoError(t, err)
+	assert.NoFileExists(t, leftover)
+}
@@ -0,0 +1,462 @@
+package lake
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+
+	"financial-data-backend-2/internal/models"
+
+	"github.com/golang/snappy"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// The subset of Parquet written here: required and optional columns, and
+// lists of strings, PLAIN encoded, one data page per column chunk.
+
+// Physical types
+const (
+	typeInt64             int32 = 2
+	typeByteArray         int32 = 6
+	typeFixedLenByteArray int32 = 7
+)
+
+// Converted types, or noConversion
+const (
+	noConversion             int32 = -1
+	convertedUTF8            int32 = 0
+	convertedList            int32 = 3
+	convertedDecimal         int32 = 5
+	convertedTimestampMillis int32 = 9
+)
+
+const (
+	encodingPlain int32 = 0
+	encodingRLE   int32 = 3
+
+	repetitionRequired int32 = 0
+	repetitionOptional int32 = 1
+	repetitionRepeated int32 = 2
+
+	pageTypeData int32 = 0
+)
+
+// Prices and volumes are DECIMAL(38, 18), in 16 bytes.
+const (
+	decimalPrecision = 38
+	decimalScale     = 18
+	decimalLength    = 16
+)
+
+// Compression codecs
+const (
+	CodecNone   int32 = 0
+	CodecSnappy int32 = 1
+)
+
+const parquetMagic = "PAR1"
+
+// Uncompressed bytes buffered before they are written as a row group
+const rowGroupSize = 4 << 20
+
+type column struct {
+	name      string
+	typ       int32
+	converted int32
+	optional  bool
+	// A list of values per row, possibly empty, rather than one value
+	list bool
+}
+
+// tradeColumns are the columns of a trades file. The symbol, date and
+// hour are in its path, as Hive-style partitions.
+var tradeColumns = []column{
+	{name: "message_key", typ: typeByteArray, converted: convertedUTF8},
+	{name: "time", typ: typeInt64, converted: convertedTimestampMillis},
+	// Null if the value does not fit, e.g. NaN
+	{name: "price", typ: typeFixedLenByteArray, converted: convertedDecimal, optional: true},
+	{name: "volume", typ: typeFixedLenByteArray, converted: convertedDecimal, optional: true},
+	{name: "conditions", typ: typeByteArray, converted: convertedUTF8, list: true},
+	{name: "flags", typ: typeByteArray, converted: convertedUTF8, list: true},
+	{name: "original_time", typ: typeInt64, converted: convertedTimestampMillis, optional: true},
+}
+
+// path is where the column's values are in the schema: a list's values
+// are the elements of its repeated group, as the LIST annotation asks.
+func (c column) path() []string {
+	if c.list {
+		return []string{c.name, "list", "element"}
+	}
+	return []string{c.name}
+}
+
+// columnBuffer holds a column's values of the current row group.
+type columnBuffer struct {
+	values bytes.Buffer
+	// Whether each value is present (its definition level), for optional
+	// columns, or whether each row's list has a value there, for lists
+	present []bool
+	// Whether each value continues its row's list (its repetition level)
+	repeated []bool
+}
+
+func (b *columnBuffer) int64(v int64) {
+	b.values.Write(binary.LittleEndian.AppendUint64(nil, uint64(v)))
+	b.present = append(b.present, true)
+}
+
+// decimal adds d, or a null if it does not fit the column.
+func (b *columnBuffer) decimal(d primitive.Decimal128) {
+	v, ok := unscaled(d)
+	if !ok {
+		b.null()
+		return
+	}
+	// Big-endian two's complement
+	if v.Sign() < 0 {
+		v.Add(v, new(big.Int).Lsh(big.NewInt(1), 8*decimalLength))
+	}
+	b.values.Write(v.FillBytes(make([]byte, decimalLength)))
+	b.present = append(b.present, true)
+}
+
+func (b *columnBuffer) string(s string) {
+	b.values.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(s))))
+	b.values.WriteString(s)
+	b.present = append(b.present, true)
+}
+
+// strings adds a row's list. An empty list still takes up a level.
+func (b *columnBuffer) strings(list []string) {
+	if len(list) == 0 {
+		b.present = append(b.present, false)
+		b.repeated = append(b.repeated, false)
+		return
+	}
+	for i, s := range list {
+		b.string(s)
+		b.repeated = append(b.repeated, i > 0)
+	}
+}
+
+func (b *columnBuffer) null() {
+	b.present = append(b.present, false)
+}
+
+func (b *columnBuffer) reset() {
+	b.values.Reset()
+	b.present = b.present[:0]
+	b.repeated = b.repeated[:0]
+}
+
+var maxUnscaled = new(big.Int).Exp(big.NewInt(10), big.NewInt(decimalPrecision), nil)
+
+// unscaled returns d times 10^decimalScale, rounded half away from zero,
+// or false if d is not a number or does not fit decimalPrecision digits.
+func unscaled(d primitive.Decimal128) (*big.Int, bool) {
+	v, exp, err := d.BigInt()
+	if err != nil {
+		return nil, false
+	}
+	ten := big.NewInt(10)
+	if shift := exp + decimalScale; shift >= 0 {
+		v.Mul(v, new(big.Int).Exp(ten, big.NewInt(int64(shift)), nil))
+	} else {
+		div := new(big.Int).Exp(ten, big.NewInt(int64(-shift)), nil)
+		sign := int64(v.Sign())
+		rem := new(big.Int)
+		v.QuoRem(v, div, rem)
+		if rem.Abs(rem).Lsh(rem, 1).Cmp(div) >= 0 {
+			v.Add(v, big.NewInt(sign))
+		}
+	}
+	if new(big.Int).Abs(v).Cmp(maxUnscaled) >= 0 {
+		return nil, false
+	}
+	return v, true
+}
+
+type columnChunk struct {
+	offset       int64
+	values       int64
+	uncompressed int64
+	compressed   int64
+}
+
+type rowGroup struct {
+	columns []columnChunk
+	rows    int64
+	size    int64
+}
+
+// parquetWriter writes trades to a Parquet file, a row group at a time.
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	codec     int32
+	buffers   []columnBuffer
+	rows      int64
+	rowGroups []rowGroup
+	total     int64
+}
+
+func newParquetWriter(w io.Writer, codec int32) (*parquetWriter, error) {
+	pw := &parquetWriter{w: w, codec: codec, buffers: make([]columnBuffer, len(tradeColumns))}
+	if err := pw.write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *parquetWriter) write(p []byte) error {
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	return err
+}
+
+// Write adds a trade, writing out the row group once it is big enough.
+func (pw *parquetWriter) Write(t models.TradeRecord) error {
+	b := pw.buffers
+	b[0].string(t.MessageKey)
+	b[1].int64(t.Time.UnixMilli())
+	b[2].decimal(t.Price)
+	b[3].decimal(t.Volume)
+	b[4].strings(t.Conditions)
+	b[5].strings(t.Flags)
+	if t.OriginalTime.IsZero() {
+		b[6].null()
+	} else {
+		b[6].int64(t.OriginalTime.UnixMilli())
+	}
+	pw.rows++
+	if pw.buffered() >= rowGroupSize {
+		return pw.flush()
+	}
+	return nil
+}
+
+// Size returns about how big the file will be, if closed now.
+func (pw *parquetWriter) Size() int64 {
+	return pw.offset + int64(pw.buffered())
+}
+
+func (pw *parquetWriter) buffered() int {
+	n := 0
+	for i := range pw.buffers {
+		n += pw.buffers[i].values.Len()
+	}
+	return n
+}
+
+// flush writes the buffered trades as a row group.
+func (pw *parquetWriter) flush() error {
+	if pw.rows == 0 {
+		return nil
+	}
+	group := rowGroup{rows: pw.rows}
+	for i, col := range tradeColumns {
+		chunk, err := pw.writePage(col, &pw.buffers[i])
+		if err != nil {
+			return err
+		}
+		group.columns = append(group.columns, chunk)
+		group.size += chunk.uncompressed
+		pw.buffers[i].reset()
+	}
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.total += pw.rows
+	pw.rows = 0
+	return nil
+}
+
+func (pw *parquetWriter) writePage(col column, b *columnBuffer) (columnChunk, error) {
+	var data []byte
+	if col.list {
+		data = appendLevels(data, b.repeated)
+	}
+	if col.optional || col.list {
+		data = appendLevels(data, b.present)
+	}
+	data = append(data, b.values.Bytes()...)
+	page := data
+	if pw.codec == CodecSnappy {
+		page = snappy.Encode(nil, data)
+	}
+
+	var h thriftWriter
+	h.begin()
+	h.i32(1, pageTypeData)
+	h.i32(2, int32(len(data)))
+	h.i32(3, int32(len(page)))
+	h.struct_(5)
+	h.i32(1, int32(len(b.present)))
+	h.i32(2, encodingPlain)
+	h.i32(3, encodingRLE)
+	h.i32(4, encodingRLE)
+	h.end()
+	h.end()
+
+	chunk := columnChunk{
+		offset:       pw.offset,
+		values:       int64(len(b.present)),
+		uncompressed: int64(h.buf.Len() + len(data)),
+		compressed:   int64(h.buf.Len() + len(page)),
+	}
+	if err := pw.write(h.buf.Bytes()); err != nil {
+		return chunk, err
+	}
+	return chunk, pw.write(page)
+}
+
+// appendLevels appends repetition or definition levels, which are at most
+// 1 here, length-prefixed: runs of a bit width of 1, in Parquet's
+// RLE/bit-packing hybrid.
+func appendLevels(data []byte, levels []bool) []byte {
+	var out []byte
+	for i := 0; i < len(levels); {
+		j := i
+		for j < len(levels) && levels[j] == levels[i] {
+			j++
+		}
+		out = binary.AppendUvarint(out, uint64(j-i)<<1)
+		if levels[i] {
+			out = append(out, 1)
+		} else {
+			out = append(out, 0)
+		}
+		i = j
+	}
+	data = binary.LittleEndian.AppendUint32(data, uint32(len(out)))
+	return append(data, out...)
+}
+
+// Close writes the last row group and the footer. It does not close the
+// underlying writer.
+func (pw *parquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+
+	var m thriftWriter
+	m.begin()
+	m.i32(1, 1) // version
+	m.list(2, thriftStruct, len(schema))
+	for _, e := range schema {
+		m.begin()
+		if e.typ != noType {
+			m.i32(1, e.typ)
+		}
+		if e.typ == typeFixedLenByteArray {
+			m.i32(2, decimalLength)
+		}
+		if e.repetition != noRepetition {
+			m.i32(3, e.repetition)
+		}
+		m.string(4, e.name)
+		if e.children > 0 {
+			m.i32(5, e.children)
+		}
+		if e.converted != noConversion {
+			m.i32(6, e.converted)
+		}
+		if e.converted == convertedDecimal {
+			m.i32(7, decimalScale)
+			m.i32(8, decimalPrecision)
+		}
+		m.end()
+	}
+	m.i64(3, pw.total)
+	m.list(4, thriftStruct, len(pw.rowGroups))
+	for _, g := range pw.rowGroups {
+		m.begin()
+		m.list(1, thriftStruct, len(g.columns))
+		for i, c := range g.columns {
+			col := tradeColumns[i]
+			m.begin()
+			m.i64(2, c.offset)
+			m.struct_(3)
+			m.i32(1, col.typ)
+			if col.optional || col.list {
+				m.i32List(2, encodingPlain, encodingRLE)
+			} else {
+				m.i32List(2, encodingPlain)
+			}
+			m.stringList(3, col.path()...)
+			m.i32(4, pw.codec)
+			m.i64(5, c.values)
+			m.i64(6, c.uncompressed)
+			m.i64(7, c.compressed)
+			m.i64(9, c.offset)
+			m.end()
+			m.end()
+		}
+		m.i64(2, g.size)
+		m.i64(3, g.rows)
+		m.end()
+	}
+	m.string(6, "financial-data-backend-2 go-lake")
+	m.end()
+
+	footer := m.buf.Bytes()
+	if err := pw.write(footer); err != nil {
+		return err
+	}
+	if err := pw.write(binary.LittleEndian.AppendUint32(nil, uint32(len(footer)))); err != nil {
+		return err
+	}
+	return pw.write([]byte(parquetMagic))
+}
+
+// Marks a schema element without a physical type or repetition: a group,
+// or the root
+const (
+	noType       int32 = -1
+	noRepetition int32 = -1
+)
+
+type schemaElement struct {
+	name       string
+	typ        int32
+	repetition int32
+	converted  int32
+	children   int32
+}
+
+// schema is the flattened schema of a trades file, depth first. A list is
+// a group with a repeated group of its elements.
+var schema = func() []schemaElement {
+	out := []schemaElement{{name: "trade", typ: noType, repetition: noRepetition,
+		converted: noConversion, children: int32(len(tradeColumns))}}
+	for _, col := range tradeColumns {
+		repetition := repetitionRequired
+		if col.optional {
+			repetition = repetitionOptional
+		}
+		if col.list {
+			out = append(out,
+				schemaElement{name: col.name, typ: noType, repetition: repetitionRequired,
+					converted: convertedList, children: 1},
+				schemaElement{name: "list", typ: noType, repetition: repetitionRepeated,
+					converted: noConversion, children: 1})
+			out = append(out, schemaElement{name: "element", typ: col.typ, repetition: repetitionRequired,
+				converted: col.converted})
+			continue
+		}
+		out = append(out, schemaElement{name: col.name, typ: col.typ, repetition: repetition,
+			converted: col.converted})
+	}
+	return out
+}()
+
+// ParseCodec returns the codec named by lake.compression.
+func ParseCodec(name string) (int32, error) {
+	switch name {
+	case "", "snappy":
+		return CodecSnappy, nil
+	case "none":
+		return CodecNone, nil
+	}
+	return 0, fmt.Errorf("unknown compression %q", name)
+}
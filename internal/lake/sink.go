@@ -0,0 +1,368 @@
+// Package lake writes trades into Parquet files for offline research, in
+// hourly, symbol-partitioned directories that Spark and DuckDB read as
+// Hive-style partitions:
+//
+//	trades/date=2025-11-20/hour=14/symbol=BINANCE%3ABTCUSDT/part-<...>.parquet
+//
+// Files are written to a staging directory and only moved into place (or
+// uploaded) once complete, so readers never see a partial file. Each
+// finished file is listed in a manifest entry, under _manifest/ at the same
+// path with a .json extension.
+package lake
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"financial-data-backend-2/internal/models"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Directories of the trade files and of the manifest, in the store
+const (
+	TradesDir   = "trades"
+	ManifestDir = "_manifest"
+)
+
+// ErrWrite is returned by Add when trades could not be written. Their
+// message, and the ones after it in its Kafka partition, are never
+// committable, so the writer should stop and read them again.
+var ErrWrite = errors.New("failed to write trades")
+
+// Partition is where a trade goes: the hour of its time and its symbol.
+type Partition struct {
+	Symbol string
+	Hour   time.Time
+}
+
+func partitionOf(t models.TradeRecord) Partition {
+	return Partition{Symbol: t.Symbol, Hour: t.Time.UTC().Truncate(time.Hour)}
+}
+
+// Dir is the partition's directory in the store.
+func (p Partition) Dir() string {
+	return fmt.Sprintf("%s/date=%s/hour=%02d/symbol=%s", TradesDir, p.Hour.Format(time.DateOnly),
+		p.Hour.Hour(), EscapePathName(p.Symbol))
+}
+
+// ManifestEntry describes a finished file.
+type ManifestEntry struct {
+	// Key of the file in the store, e.g. "trades/date=.../part-....parquet"
+	Path      string    `json:"path"`
+	Symbol    string    `json:"symbol"`
+	Hour      time.Time `json:"hour"`
+	Rows      int64     `json:"rows"`
+	Bytes     int64     `json:"bytes"`
+	MinTime   time.Time `json:"min_time"`
+	MaxTime   time.Time `json:"max_time"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// SinkConfig controls when files are finished.
+type SinkConfig struct {
+	// A file is finished once it is about this many bytes...
+	RollSize int64
+	// ...or has been open this long.
+	RollInterval time.Duration
+	// CodecSnappy or CodecNone
+	Codec int32
+}
+
+// file is a Parquet file being written, or finished but not yet stored.
+type file struct {
+	partition Partition
+	name      string
+	tmp       string
+	f         *os.File
+	writer    *parquetWriter
+	opened    time.Time
+	entry     ManifestEntry
+	// The lowest offset, per Kafka partition, of the messages with
+	// trades in the file
+	offsets map[int]int64
+	// Progress of finishing: closed, then stored, then listed in the
+	// manifest
+	closed, stored bool
+}
+
+// Sink writes trades into files, finishes them by size and age, and
+// tracks up to which offsets the messages read are safely stored. It is
+// not safe for concurrent use.
+type Sink struct {
+	cfg     SinkConfig
+	staging string
+	store   Store
+	open    map[Partition]*file
+	// Finished files that could not be stored yet
+	pending []*file
+	// Offset after the last message added, per Kafka partition
+	next map[int]int64
+	// Lowest offset, per Kafka partition, of the messages whose trades
+	// could not be written
+	failed map[int]int64
+}
+
+// NewSink writes files in staging, a local directory of its own, before
+// putting them in store. Files left in staging by a previous run are
+// deleted: their messages were not committed, and are read again.
+func NewSink(cfg SinkConfig, staging string, store Store) (*Sink, error) {
+	if err := os.MkdirAll(staging, 0o755); err != nil {
+		return nil, err
+	}
+	leftovers, err := filepath.Glob(filepath.Join(staging, "*.tmp"))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range leftovers {
+		if err := os.Remove(name); err != nil {
+			return nil, err
+		}
+	}
+	if len(leftovers) > 0 {
+		log.Printf("Deleted %d unfinished file(s) left in %s", len(leftovers), staging)
+	}
+	return &Sink{cfg: cfg, staging: staging, store: store,
+		open: make(map[Partition]*file), next: make(map[int]int64), failed: make(map[int]int64)}, nil
+}
+
+// Add writes the trades of the message at offset of a Kafka partition,
+// and finishes the files that have grown big enough. If a trade cannot be
+// written, it returns ErrWrite, and drops the file, as it may be broken.
+func (s *Sink) Add(ctx context.Context, trades []models.TradeRecord, partition int, offset int64, now time.Time) error {
+	for _, t := range trades {
+		f, err := s.file(partitionOf(t), now)
+		if err != nil {
+			s.fail(partition, offset)
+			return fmt.Errorf("%w: %v", ErrWrite, err)
+		}
+		if err := f.writer.Write(t); err != nil {
+			s.fail(partition, offset)
+			s.drop(f)
+			return fmt.Errorf("%w to %s: %v", ErrWrite, f.entry.Path, err)
+		}
+		if _, ok := f.offsets[partition]; !ok {
+			f.offsets[partition] = offset
+		}
+		e := &f.entry
+		e.Rows++
+		if e.MinTime.IsZero() || t.Time.Before(e.MinTime) {
+			e.MinTime = t.Time
+		}
+		if t.Time.After(e.MaxTime) {
+			e.MaxTime = t.Time
+		}
+	}
+	s.next[partition] = offset + 1
+
+	var errs []error
+	for p, f := range s.open {
+		if f.writer.Size() >= s.cfg.RollSize {
+			errs = append(errs, s.finish(ctx, p, f))
+		}
+	}
+	return joinErrors(errs)
+}
+
+// fail records that the message at offset of a Kafka partition could not
+// be written.
+func (s *Sink) fail(partition int, offset int64) {
+	if failed, ok := s.failed[partition]; !ok || offset < failed {
+		s.failed[partition] = offset
+	}
+}
+
+// drop deletes an open file. Its messages are not committable anymore.
+func (s *Sink) drop(f *file) {
+	delete(s.open, f.partition)
+	for partition, offset := range f.offsets {
+		s.fail(partition, offset)
+	}
+	f.f.Close()
+	os.Remove(f.tmp)
+}
+
+// file returns the open file of the partition, opening one if needed.
+func (s *Sink) file(p Partition, now time.Time) (*file, error) {
+	if f, ok := s.open[p]; ok {
+		return f, nil
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("part-%s-%s.parquet", now.UTC().Format("20060102T150405Z"), hex.EncodeToString(id))
+	tmp := filepath.Join(s.staging, name+".tmp")
+	osFile, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := newParquetWriter(osFile, s.cfg.Codec)
+	if err != nil {
+		osFile.Close()
+		return nil, err
+	}
+	f := &file{
+		partition: p, name: name, tmp: tmp, f: osFile, writer: writer, opened: now,
+		entry:   ManifestEntry{Path: p.Dir() + "/" + name, Symbol: p.Symbol, Hour: p.Hour},
+		offsets: make(map[int]int64),
+	}
+	s.open[p] = f
+	return f, nil
+}
+
+// Roll finishes the files open for RollInterval, and retries storing the
+// finished files that could not be stored before.
+func (s *Sink) Roll(ctx context.Context, now time.Time) error {
+	var errs []error
+	for p, f := range s.open {
+		if now.Sub(f.opened) >= s.cfg.RollInterval {
+			errs = append(errs, s.finish(ctx, p, f))
+		}
+	}
+	errs = append(errs, s.retry(ctx))
+	return joinErrors(errs)
+}
+
+// Close finishes every open file.
+func (s *Sink) Close(ctx context.Context) error {
+	var errs []error
+	for p, f := range s.open {
+		errs = append(errs, s.finish(ctx, p, f))
+	}
+	return joinErrors(errs)
+}
+
+// Pending returns how many finished files are waiting to be stored.
+func (s *Sink) Pending() int {
+	return len(s.pending)
+}
+
+// Committable returns, per Kafka partition, the offset up to which (not
+// included) every message read has its trades stored.
+func (s *Sink) Committable() map[int]int64 {
+	offsets := make(map[int]int64, len(s.next))
+	for p, next := range s.next {
+		offsets[p] = next
+	}
+	lower := func(f *file) {
+		for p, offset := range f.offsets {
+			if offset < offsets[p] {
+				offsets[p] = offset
+			}
+		}
+	}
+	for _, f := range s.open {
+		lower(f)
+	}
+	for _, f := range s.pending {
+		lower(f)
+	}
+	for p, offset := range s.failed {
+		if offset < offsets[p] {
+			offsets[p] = offset
+		}
+	}
+	return offsets
+}
+
+// finish closes the file and stores it. A file that cannot be stored is
+// kept, and retried by Roll; new trades of its partition go to a new file.
+func (s *Sink) finish(ctx context.Context, p Partition, f *file) error {
+	delete(s.open, p)
+	if err := s.store_(ctx, f); err != nil {
+		s.pending = append(s.pending, f)
+		return fmt.Errorf("failed to finish %s: %w", f.entry.Path, err)
+	}
+	return nil
+}
+
+func (s *Sink) retry(ctx context.Context) error {
+	var errs []error
+	pending := s.pending[:0]
+	for _, f := range s.pending {
+		if err := s.store_(ctx, f); err != nil {
+			pending = append(pending, f)
+			errs = append(errs, fmt.Errorf("failed to finish %s: %w", f.entry.Path, err))
+		}
+	}
+	s.pending = pending
+	return joinErrors(errs)
+}
+
+// store_ closes the file, puts it in the store and then lists it in the
+// manifest, resuming where a previous attempt failed.
+func (s *Sink) store_(ctx context.Context, f *file) error {
+	if !f.closed {
+		if err := f.writer.Close(); err != nil {
+			return err
+		}
+		if err := f.f.Sync(); err != nil {
+			return err
+		}
+		if err := f.f.Close(); err != nil {
+			return err
+		}
+		f.entry.Bytes = f.writer.offset
+		f.closed = true
+	}
+	if !f.stored {
+		if err := s.store.Put(ctx, f.tmp, f.entry.Path); err != nil {
+			return err
+		}
+		f.stored = true
+	}
+
+	f.entry.CreatedAt = time.Now().UTC()
+	data, err := json.Marshal(f.entry)
+	if err != nil {
+		return err
+	}
+	manifest := filepath.Join(s.staging, f.name+".json.tmp")
+	if err := os.WriteFile(manifest, data, 0o644); err != nil {
+		return err
+	}
+	key := path.Join(ManifestDir, strings.TrimSuffix(f.entry.Path, ".parquet")+".json")
+	if err := s.store.Put(ctx, manifest, key); err != nil {
+		return err
+	}
+	log.Printf("Finished %s: %d trade(s), %d bytes", f.entry.Path, f.entry.Rows, f.entry.Bytes)
+	return nil
+}
+
+// EscapePathName escapes the characters Hive, and so Spark, escape in
+// partition values, e.g. ':' as "%3A".
+func EscapePathName(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if c < 0x20 || c == 0x7F || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func joinErrors(errs []error) error {
+	var kept []error
+	for _, err := range errs {
+		if err != nil {
+			kept = append(kept, err)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	if len(kept) == 1 {
+		return kept[0]
+	}
+	return fmt.Errorf("%d errors, the first: %w", len(kept), kept[0])
+}
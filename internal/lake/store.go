@@ -0,0 +1,75 @@
+package lake
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Store is where finished files go: a local directory or a bucket.
+type Store interface {
+	// Put moves the finished local file at path to key, atomically:
+	// readers see either all of it or nothing.
+	Put(ctx context.Context, path, key string) error
+}
+
+// LocalStore keeps files under a local directory, which must be on the
+// same filesystem as the files put, so that they can be renamed.
+type LocalStore struct {
+	root string
+}
+
+func NewLocalStore(root string) *LocalStore {
+	return &LocalStore{root: root}
+}
+
+func (s *LocalStore) Put(ctx context.Context, path, key string) error {
+	dest := filepath.Join(s.root, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(path, dest)
+}
+
+// S3Store uploads files to an S3-compatible bucket, e.g. MinIO, under a
+// prefix. An object only becomes visible once it is completely uploaded.
+type S3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3Store connects to the bucket at endpoint (host and port), over TLS
+// unless insecure.
+func NewS3Store(endpoint, region, accessKey, secretKey, bucket, prefix string, insecure bool) (*S3Store, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: !insecure,
+		Region: region,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3Store{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+// Put uploads the file, and removes it once uploaded.
+func (s *S3Store) Put(ctx context.Context, path, key string) error {
+	contentType := "application/octet-stream"
+	if strings.HasSuffix(key, ".json") {
+		contentType = "application/json"
+	}
+	_, err := s.client.FPutObject(ctx, s.bucket, s.prefix+key, path,
+		minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
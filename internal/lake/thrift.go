@@ -0,0 +1,99 @@
+package lake
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Types of the Thrift compact protocol, which Parquet encodes its page
+// headers and file footer in
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes Thrift structs in the compact protocol. Fields must
+// be written in increasing id order, and each struct ended with end.
+type thriftWriter struct {
+	buf bytes.Buffer
+	// Last field id of each open struct
+	last []int16
+}
+
+func (w *thriftWriter) field(id int16, typ byte) {
+	last := &w.last[len(w.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.varint(int64(id))
+	}
+	*last = id
+}
+
+// begin opens a struct: the message itself, a field (after field) or a
+// list element.
+func (w *thriftWriter) begin() {
+	w.last = append(w.last, 0)
+}
+
+func (w *thriftWriter) end() {
+	w.buf.WriteByte(0) // stop field
+	w.last = w.last[:len(w.last)-1]
+}
+
+// varint writes a zigzag-encoded variable-length integer.
+func (w *thriftWriter) varint(v int64) {
+	w.buf.Write(binary.AppendUvarint(nil, uint64(v<<1^v>>63)))
+}
+
+func (w *thriftWriter) i32(id int16, v int32) {
+	w.field(id, thriftI32)
+	w.varint(int64(v))
+}
+
+func (w *thriftWriter) i64(id int16, v int64) {
+	w.field(id, thriftI64)
+	w.varint(v)
+}
+
+func (w *thriftWriter) string(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	w.buf.WriteString(s)
+}
+
+// list writes a list header for n elements of typ, which must follow.
+func (w *thriftWriter) list(id int16, typ byte, n int) {
+	w.field(id, thriftList)
+	if n < 15 {
+		w.buf.WriteByte(byte(n)<<4 | typ)
+		return
+	}
+	w.buf.WriteByte(0xF0 | typ)
+	w.buf.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+// struct_ opens a struct field, to be ended with end.
+func (w *thriftWriter) struct_(id int16) {
+	w.field(id, thriftStruct)
+	w.begin()
+}
+
+func (w *thriftWriter) i32List(id int16, values ...int32) {
+	w.list(id, thriftI32, len(values))
+	for _, v := range values {
+		w.varint(int64(v))
+	}
+}
+
+func (w *thriftWriter) stringList(id int16, values ...string) {
+	w.list(id, thriftBinary, len(values))
+	for _, s := range values {
+		w.buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+		w.buf.WriteString(s)
+	}
+}
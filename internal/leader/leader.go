@@ -0,0 +1,143 @@
+// Package leader elects one of several replicas to do work that must not
+// be done twice, such as publishing the Finnhub feed, by holding a lease.
+package leader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// Lease is held by one holder at a time, until it expires.
+type Lease interface {
+	// Acquire takes the lease for holder, or extends it if holder already
+	// has it, so that it lasts ttl from now. It reports whether holder
+	// has the lease.
+	Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error)
+	// Release gives the lease up, if holder has it.
+	Release(ctx context.Context, holder string) error
+}
+
+// Elector campaigns for a lease on behalf of this replica.
+type Elector struct {
+	lease  Lease
+	holder string
+	ttl    time.Duration
+	// How often the lease is renewed by the leader, and tried by the
+	// others
+	interval time.Duration
+}
+
+// NewElector returns an elector for holder. The leader renews the lease
+// three times per ttl, so a standby takes over within about 4/3 ttl of
+// the leader dying.
+func NewElector(lease Lease, holder string, ttl time.Duration) *Elector {
+	return &Elector{lease: lease, holder: holder, ttl: ttl, interval: ttl / 3}
+}
+
+// NewHolderID returns a name for this replica that is unique among its
+// peers and says where it runs.
+func NewHolderID() string {
+	host, _ := os.Hostname()
+	b := make([]byte, 4)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(b))
+}
+
+// Lead blocks until this replica holds the lease, or ctx is done. It
+// returns a context that lasts as long as the leadership, and a function
+// to step down, which cancels it and releases the lease.
+//
+// Leadership is lost when a renewal is refused, or when renewals have
+// failed (e.g. MongoDB is down) for as long as the lease lasts, so the
+// leader stops before a standby can take over.
+func (e *Elector) Lead(ctx context.Context) (context.Context, func(), error) {
+	for {
+		start := time.Now()
+		ok, err := e.acquire(ctx, start.Add(e.ttl))
+		if err != nil {
+			log.Printf("Failed to acquire lease: %v", err)
+		}
+		if ok {
+			log.Printf("Acquired lease as %s", e.holder)
+			leadCtx, cancel := context.WithCancel(ctx)
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				e.renew(leadCtx, cancel, start)
+			}()
+			stepDown := func() {
+				cancel()
+				<-done
+				e.release()
+			}
+			return leadCtx, stepDown, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(e.interval):
+		}
+	}
+}
+
+// renew extends the lease until ctx is done or the lease is lost, then
+// cancels. acquired is when the last successful attempt started.
+func (e *Elector) renew(ctx context.Context, cancel context.CancelFunc, acquired time.Time) {
+	defer cancel()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		// The lease lasts ttl from the start of the last attempt that got
+		// it, at the latest.
+		expires := acquired.Add(e.ttl)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(expires)):
+			log.Printf("Lost lease: could not renew it before it expired")
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		ok, err := e.acquire(ctx, expires)
+		switch {
+		case ok:
+			acquired = start
+		case err == nil:
+			log.Printf("Lost lease: another replica holds it")
+			return
+		case ctx.Err() != nil:
+			return
+		case !time.Now().Before(expires):
+			log.Printf("Lost lease: could not renew it before it expired: %v", err)
+			return
+		default:
+			log.Printf("Failed to renew lease, retrying: %v", err)
+		}
+	}
+}
+
+// acquire tries the lease once, giving up at deadline.
+func (e *Elector) acquire(ctx context.Context, deadline time.Time) (bool, error) {
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+	return e.lease.Acquire(ctx, e.holder, e.ttl)
+}
+
+func (e *Elector) release() {
+	// The leadership context is done, so use a fresh one.
+	ctx, cancel := context.WithTimeout(context.Background(), e.interval)
+	defer cancel()
+	if err := e.lease.Release(ctx, e.holder); err != nil {
+		log.Printf("Failed to release lease, it expires in %v: %v", e.ttl, err)
+		return
+	}
+	log.Printf("Released lease")
+}
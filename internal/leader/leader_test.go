@@ -0,0 +1,229 @@
+package leader
+
+import (
+	"context"
+	"errors"
+	mongoGo "financial-data-backend-2/internal/mongo"
+	"log"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryLease is a Lease shared by electors in one process.
+type memoryLease struct {
+	mu      sync.Mutex
+	holder  string
+	expires time.Time
+	// Fails every call while set, as if the database were down
+	err error
+}
+
+func (l *memoryLease) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return false, l.err
+	}
+	now := time.Now()
+	if l.holder != "" && l.holder != holder && now.Before(l.expires) {
+		return false, nil
+	}
+	l.holder, l.expires = holder, now.Add(ttl)
+	return true, nil
+}
+
+func (l *memoryLease) Release(ctx context.Context, holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.holder == holder {
+		l.holder = ""
+	}
+	return nil
+}
+
+func (l *memoryLease) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = err
+}
+
+// campaign runs Lead in the background and reports when it leads.
+func campaign(ctx context.Context, e *Elector) <-chan context.Context {
+	led := make(chan context.Context, 1)
+	go func() {
+		leadCtx, _, err := e.Lead(ctx)
+		if err == nil {
+			led <- leadCtx
+		}
+	}()
+	return led
+}
+
+func TestElector(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+
+	t.Run("a standby takes over when the leader is killed", func(t *testing.T) {
+		lease := &memoryLease{}
+		// Killing the leader's process stops its renewals, without a release.
+		leaderProcess, kill := context.WithCancel(context.Background())
+		defer kill()
+		leadCtx, _, err := NewElector(lease, "a", ttl).Lead(leaderProcess)
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		standbyProcess, stop := context.WithCancel(context.Background())
+		defer stop()
+		led := campaign(standbyProcess, NewElector(lease, "b", ttl))
+
+		// The leader keeps the lease for as long as it renews it.
+		select {
+		case <-led:
+			t.Fatal("the standby should not lead while the leader is alive")
+		case <-time.After(2 * ttl):
+		}
+		assert.NoError(t, leadCtx.Err())
+
+		killed := time.Now()
+		kill()
+		select {
+		case <-led:
+			assert.Less(t, time.Since(killed), ttl+ttl/3+100*time.Millisecond,
+				"the standby should take over once the lease expires")
+		case <-time.After(3 * ttl):
+			t.Fatal("the standby should have taken over")
+		}
+	})
+
+	t.Run("stepping down hands over at once", func(t *testing.T) {
+		lease := &memoryLease{}
+		_, stepDown, err := NewElector(lease, "a", ttl).Lead(context.Background())
+		if !assert.NoError(t, err) {
+			return
+		}
+		ctx, stop := context.WithCancel(context.Background())
+		defer stop()
+		led := campaign(ctx, NewElector(lease, "b", ttl))
+
+		stepDown()
+		select {
+		case <-led:
+		case <-time.After(ttl):
+			t.Fatal("the standby should take over within one retry")
+		}
+	})
+
+	t.Run("the leader stops before its lease can expire", func(t *testing.T) {
+		lease := &memoryLease{}
+		leadCtx, _, err := NewElector(lease, "a", ttl).Lead(context.Background())
+		if !assert.NoError(t, err) {
+			return
+		}
+		lease.mu.Lock()
+		expires := lease.expires
+		lease.mu.Unlock()
+
+		lease.fail(errors.New("database down"))
+		select {
+		case <-leadCtx.Done():
+			assert.False(t, time.Now().After(expires.Add(10*time.Millisecond)),
+				"the leader should stop by the time a standby could take over")
+		case <-time.After(3 * ttl):
+			t.Fatal("the leader should stop once it cannot renew the lease")
+		}
+	})
+
+	t.Run("the leader stops when another replica took the lease", func(t *testing.T) {
+		lease := &memoryLease{}
+		leadCtx, _, err := NewElector(lease, "a", ttl).Lead(context.Background())
+		if !assert.NoError(t, err) {
+			return
+		}
+		lease.mu.Lock()
+		lease.holder, lease.expires = "b", time.Now().Add(time.Hour)
+		lease.mu.Unlock()
+
+		select {
+		case <-leadCtx.Done():
+		case <-time.After(ttl):
+			t.Fatal("the leader should stop at its next renewal")
+		}
+	})
+
+	t.Run("campaigning ends with its context", func(t *testing.T) {
+		lease := &memoryLease{holder: "a", expires: time.Now().Add(time.Hour)}
+		ctx, cancel := context.WithTimeout(context.Background(), ttl)
+		defer cancel()
+		_, _, err := NewElector(lease, "b", ttl).Lead(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+func TestMongoLease(t *testing.T) {
+	_ = godotenv.Load("../../.env")
+	mongoUrl := os.Getenv("MONGO_URL_TEST")
+	if mongoUrl == "" {
+		log.Fatal("FATAL: MONGO_URL_TEST is not set. Aborting lease integration tests.")
+	}
+	testDbClient, err := mongoGo.ConnectDB(mongoUrl, 15*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	db := testDbClient.Database("financialDataLeaderTest")
+	defer func() {
+		db.Drop(ctx)
+		testDbClient.Disconnect(ctx)
+	}()
+	leases := db.Collection("leases")
+
+	const ttl = time.Second
+	a := NewMongoLease(leases, "ingestor")
+	b := NewMongoLease(leases, "ingestor")
+
+	ok, err := a.Acquire(ctx, "a", ttl)
+	assert.NoError(t, err)
+	assert.True(t, ok, "a free lease should be acquired")
+	ok, err = a.Acquire(ctx, "a", ttl)
+	assert.NoError(t, err)
+	assert.True(t, ok, "the holder should renew its lease")
+	ok, err = b.Acquire(ctx, "b", ttl)
+	assert.NoError(t, err)
+	assert.False(t, ok, "a held lease should not be acquired")
+
+	ok, err = NewMongoLease(leases, "retention").Acquire(ctx, "b", ttl)
+	assert.NoError(t, err)
+	assert.True(t, ok, "leases with other names should be independent")
+
+	// Kill the leader: it stops renewing, and a standby takes over once
+	// the lease expires.
+	leader, kill := context.WithCancel(ctx)
+	defer kill()
+	leadCtx, _, err := NewElector(a, "a", ttl).Lead(leader)
+	if !assert.NoError(t, err) {
+		return
+	}
+	standby := campaign(ctx, NewElector(b, "b", ttl))
+	time.Sleep(2 * ttl)
+	assert.NoError(t, leadCtx.Err(), "the leader should keep its lease while renewing it")
+
+	killed := time.Now()
+	kill()
+	select {
+	case <-standby:
+		assert.Less(t, time.Since(killed), 2*ttl)
+	case <-time.After(5 * ttl):
+		t.Fatal("the standby should have taken over")
+	}
+
+	assert.NoError(t, b.Release(ctx, "b"))
+	ok, err = a.Acquire(ctx, "a", ttl)
+	assert.NoError(t, err)
+	assert.True(t, ok, "a released lease should be free")
+}
@@ -0,0 +1,47 @@
+package leader
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoLease is a lease kept as a document of a MongoDB collection, named
+// by its _id. Expiry is decided by the server's clock, so the replicas'
+// clocks need not agree. A TTL index on expires_at removes abandoned
+// leases.
+type MongoLease struct {
+	leases *mongo.Collection
+	name   string
+}
+
+func NewMongoLease(leases *mongo.Collection, name string) *MongoLease {
+	return &MongoLease{leases: leases, name: name}
+}
+
+func (l *MongoLease) Acquire(ctx context.Context, holder string, ttl time.Duration) (bool, error) {
+	// Matches if holder has the lease or it has expired. Otherwise the
+	// upsert collides with the other holder's document.
+	filter := bson.M{"_id": l.name, "$or": bson.A{
+		bson.M{"holder": holder},
+		bson.M{"$expr": bson.M{"$lt": bson.A{"$expires_at", "$$NOW"}}},
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"holder":     holder,
+		"renewed_at": "$$NOW",
+		"expires_at": bson.M{"$add": bson.A{"$$NOW", ttl.Milliseconds()}},
+	}}}}
+	_, err := l.leases.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (l *MongoLease) Release(ctx context.Context, holder string) error {
+	_, err := l.leases.DeleteOne(ctx, bson.M{"_id": l.name, "holder": holder})
+	return err
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+)
+
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var level atomic.Int32
+
+func init() {
+	level.Store(int32(LevelInfo))
+}
+
+// ParseLevel converts a config value such as "debug" into a Level.
+// An empty string means the default, LevelInfo.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// SetLevel changes the minimum level that gets logged. It is safe to
+// call while other goroutines are logging.
+func SetLevel(l Level) {
+	level.Store(int32(l))
+}
+
+// Apply parses s and sets it as the current level, keeping the previous
+// level (and logging why) if s is invalid.
+func Apply(s string) {
+	l, err := ParseLevel(s)
+	if err != nil {
+		log.Printf("Ignoring log level: %v", err)
+		return
+	}
+	SetLevel(l)
+}
+
+func Enabled(l Level) bool {
+	return l >= Level(level.Load())
+}
+
+// Debugf is for per-message logs that are too noisy for production.
+func Debugf(format string, args ...any) {
+	if Enabled(LevelDebug) {
+		log.Printf(format, args...)
+	}
+}
+
+func Infof(format string, args ...any) {
+	if Enabled(LevelInfo) {
+		log.Printf(format, args...)
+	}
+}
+
+func Warnf(format string, args ...any) {
+	if Enabled(LevelWarn) {
+		log.Printf(format, args...)
+	}
+}
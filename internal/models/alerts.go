@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Alert rule types
+const (
+	// The price crosses Price.
+	AlertPriceCross = "price_cross"
+	// The price moves Percent% within WindowMinutes.
+	AlertPercentMove = "percent_move"
+	// The volume traded within WindowMinutes reaches Multiplier times its
+	// average over the preceding windows.
+	AlertVolumeSpike = "volume_spike"
+)
+
+// Directions of an alert rule
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+	DirectionAny  = "any"
+)
+
+// Statuses of an alert delivery
+const (
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// AlertRule notifies a webhook when trades of a symbol meet a condition.
+type AlertRule struct {
+	Id     primitive.ObjectID `bson:"_id,omitempty"`
+	Symbol string             `bson:"symbol"`
+	Type   string             `bson:"type"`
+	// For price_cross and percent_move; defaults to "any".
+	Direction     string               `bson:"direction,omitempty"`
+	Price         primitive.Decimal128 `bson:"price,omitempty"`
+	Percent       float64              `bson:"percent,omitempty"`
+	Multiplier    float64              `bson:"multiplier,omitempty"`
+	WindowMinutes int                  `bson:"window_minutes,omitempty"`
+	WebhookURL    string               `bson:"webhook_url"`
+	// Key of the HMAC-SHA256 signature of every webhook request
+	Secret    string    `bson:"secret"`
+	Enabled   bool      `bson:"enabled"`
+	CreatedAt time.Time `bson:"created_at"`
+	UpdatedAt time.Time `bson:"updated_at"`
+}
+
+// AlertDelivery records the webhook delivery of a triggered rule.
+type AlertDelivery struct {
+	Id       primitive.ObjectID `bson:"_id"`
+	RuleId   primitive.ObjectID `bson:"rule_id"`
+	Symbol   string             `bson:"symbol"`
+	Status   string             `bson:"status"`
+	Attempts int                `bson:"attempts"`
+	// HTTP status of the last attempt; zero if there was no response.
+	ResponseStatus int       `bson:"response_status,omitempty"`
+	Error          string    `bson:"error,omitempty"`
+	Payload        string    `bson:"payload"`
+	TriggeredAt    time.Time `bson:"triggered_at"`
+	CompletedAt    time.Time `bson:"completed_at"`
+}
@@ -6,11 +6,55 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Asset classes of a symbol
+const (
+	AssetClassStock  = "stock"
+	AssetClassCrypto = "crypto"
+	AssetClassForex  = "forex"
+)
+
+// Flags of a bad tick
+const (
+	FlagNonPositivePrice = "non_positive_price"
+	// The price is too far from the symbol's rolling median.
+	FlagPriceOutlier = "price_outlier"
+	// The trade time is too far ahead of the processor's clock.
+	FlagFutureTime = "future_timestamp"
+	// The trade time is too far behind the processor's clock.
+	FlagStaleTime = "stale_timestamp"
+)
+
 type SymbolDocument struct {
-	Id          primitive.ObjectID `bson:"_id,omitempty"`
-	Symbol      string             `bson:"symbol"`
-	TradeCount  int64              `bson:"tradeCount"`
-	LastTradeAt time.Time          `bson:"lastTradeAt"`
+	Id           primitive.ObjectID   `bson:"_id,omitempty"`
+	Symbol       string               `bson:"symbol"`
+	Exchange     string               `bson:"exchange,omitempty"`
+	AssetClass   string               `bson:"assetClass,omitempty"`
+	TradeCount   int64                `bson:"tradeCount"`
+	FirstTradeAt time.Time            `bson:"firstTradeAt,omitempty"`
+	LastTradeAt  time.Time            `bson:"lastTradeAt"`
+	LastPrice    primitive.Decimal128 `bson:"lastPrice,omitempty"`
+	LastVolume   primitive.Decimal128 `bson:"lastVolume,omitempty"`
+	Day          *DaySummary          `bson:"day,omitempty"`
+}
+
+// DaySummary covers the trades of the latest (UTC) day a symbol traded.
+type DaySummary struct {
+	Date   string               `bson:"date"` // e.g. "2025-11-20"
+	OpenAt time.Time            `bson:"openAt"`
+	Open   primitive.Decimal128 `bson:"open"`
+	High   primitive.Decimal128 `bson:"high"`
+	Low    primitive.Decimal128 `bson:"low"`
+	Volume primitive.Decimal128 `bson:"volume"`
+	// The last price of the previous day the symbol traded, if known.
+	PrevClose primitive.Decimal128 `bson:"prevClose,omitempty"`
+}
+
+// SymbolQuery filters and sorts symbols. Empty fields do not filter.
+type SymbolQuery struct {
+	AssetClass string
+	Exchange   string
+	SortBy     string // A SymbolDocument bson field; defaults to "symbol"
+	Descending bool
 }
 
 type TradeRecord struct {
@@ -20,4 +64,74 @@ type TradeRecord struct {
 	Price      primitive.Decimal128 `bson:"price"`
 	Time       time.Time            `bson:"time"`
 	Volume     primitive.Decimal128 `bson:"volume"`
+	Conditions []string             `bson:"conditions,omitempty"`
+	// Why the trade looks like a bad tick, if it does. Flagged trades are
+	// stored but left out of derived figures.
+	Flags []string `bson:"flags,omitempty"`
+	// The time the trade was reported with, if it was ahead of the clock
+	// and Time was clamped to when it arrived
+	OriginalTime time.Time `bson:"original_time,omitempty"`
+	// When the trade was received from its source, if known. It is not
+	// stored.
+	ReceivedAt time.Time `bson:"-"`
+}
+
+// Why a trade was set aside by the lateness policy
+const (
+	// The trade is older than its symbol's watermark.
+	LateReasonLate = "late"
+	// The trade is too far ahead of the clock.
+	LateReasonFuture = "future"
+)
+
+// LateTrade is a trade set aside, rather than stored with the others,
+// because it arrived too late or is timed too far in the future.
+type LateTrade struct {
+	// The trade's idempotency key, so that a re-read message does not set
+	// it aside twice
+	Id         string               `bson:"_id"`
+	Symbol     string               `bson:"symbol"`
+	Price      primitive.Decimal128 `bson:"price"`
+	Time       time.Time            `bson:"time"`
+	Volume     primitive.Decimal128 `bson:"volume"`
+	Conditions []string             `bson:"conditions,omitempty"`
+	Flags      []string             `bson:"flags,omitempty"`
+	Reason     string               `bson:"reason"`
+	// The symbol's watermark when a late trade arrived
+	Watermark  time.Time `bson:"watermark,omitempty"`
+	ReceivedAt time.Time `bson:"received_at"`
+}
+
+// Candle summarises the trades of one symbol in one minute, starting at
+// Time.
+type Candle struct {
+	Symbol     string               `bson:"symbol"`
+	Time       time.Time            `bson:"time"`
+	Open       primitive.Decimal128 `bson:"open"`
+	High       primitive.Decimal128 `bson:"high"`
+	Low        primitive.Decimal128 `bson:"low"`
+	Close      primitive.Decimal128 `bson:"close"`
+	Volume     primitive.Decimal128 `bson:"volume"`
+	TradeCount int64                `bson:"trade_count"`
 }
+
+// TradeStats summarises a symbol's trades over a time window. Prices and
+// volumes are exact decimals; averages are rounded to StatsScale places.
+type TradeStats struct {
+	TradeCount   int64                `bson:"trade_count"`
+	FirstTradeAt time.Time            `bson:"first_trade_at"`
+	LastTradeAt  time.Time            `bson:"last_trade_at"`
+	Open         primitive.Decimal128 `bson:"open"`
+	Close        primitive.Decimal128 `bson:"close"`
+	Low          primitive.Decimal128 `bson:"low"`
+	High         primitive.Decimal128 `bson:"high"`
+	Volume       primitive.Decimal128 `bson:"volume"`
+	VWAP         primitive.Decimal128 `bson:"vwap"`
+	TWAP         primitive.Decimal128 `bson:"twap"`
+	// Square root of the summed squared log returns between consecutive
+	// trades, i.e. not annualised. Zero with fewer than two trades.
+	Volatility primitive.Decimal128 `bson:"volatility"`
+}
+
+// Decimal places of the averages and volatility in TradeStats
+const StatsScale = 10
@@ -5,6 +5,9 @@ type FinnhubTradeData struct {
 	Symbol    string  `json:"s"` // Symbol (e.g., "AAPL")
 	Timestamp int64   `json:"t"` // Unix timestamp in milliseconds
 	Volume    float64 `json:"v"` // Volume
+	// Trade conditions, e.g. odd lot or out of sequence. The codes
+	// depend on the exchange.
+	Conditions []string `json:"c"`
 }
 
 type FinnhubTradeMessage struct {
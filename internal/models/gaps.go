@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DataGap is an interval during market hours in which a symbol has no
+// trades, as found by the gap detector.
+type DataGap struct {
+	Id     primitive.ObjectID `bson:"_id,omitempty"`
+	Symbol string             `bson:"symbol"`
+	// The gap is [From, To): From is just after the last trade before it,
+	// or when the market opened, and To is the next trade, or when the
+	// market closed.
+	From time.Time `bson:"from"`
+	To   time.Time `bson:"to"`
+	// No trades had arrived when it was last checked; To is then.
+	Ongoing    bool      `bson:"ongoing"`
+	DetectedAt time.Time `bson:"detected_at"`
+	UpdatedAt  time.Time `bson:"updated_at"`
+}
+
+// GapCursor is where a page of gaps, sorted by start and symbol, ended:
+// the next page starts after the gap of Symbol starting at From.
+type GapCursor struct {
+	From   time.Time
+	Symbol string
+}
@@ -0,0 +1,14 @@
+package models
+
+// TradeEvent is the source-independent trade that the ingestor publishes
+// on Kafka, one per Kafka message.
+type TradeEvent struct {
+	Symbol       string   `json:"symbol"`
+	Price        string   `json:"price"`         // Decimal string, e.g. "196.38"
+	Volume       string   `json:"volume"`        // Decimal string
+	ExchangeTime int64    `json:"exchange_time"` // Unix milliseconds, as reported by the source
+	ReceiveTime  int64    `json:"receive_time"`  // Unix milliseconds, when the ingestor received it
+	Source       string   `json:"source"`        // e.g. "finnhub"
+	Sequence     int      `json:"sequence"`      // Position within the source's message
+	Conditions   []string `json:"conditions,omitempty"`
+}
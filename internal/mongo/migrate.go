@@ -0,0 +1,147 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MigrationsCollectionName records which migrations have been applied.
+const MigrationsCollectionName = "schema_migrations"
+
+// Migration is one versioned change to the database. Up must be safe to
+// run again after a partial failure, since it is only recorded once it
+// has succeeded.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context, db *mongo.Database) error
+}
+
+// MigrationStatus says whether (and when) a migration was applied.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time
+}
+
+type migrationRecord struct {
+	Version     int       `bson:"_id"`
+	Description string    `bson:"description"`
+	AppliedAt   time.Time `bson:"applied_at"`
+}
+
+// Migrator applies migrations in version order and records each one in
+// the MigrationsCollectionName collection.
+type Migrator struct {
+	db         *mongo.Database
+	migrations []Migration
+}
+
+// NewMigrator checks that the migrations are in strictly increasing
+// version order.
+func NewMigrator(db *mongo.Database, migrations []Migration) (*Migrator, error) {
+	for i, m := range migrations {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("migration %q has invalid version %d", m.Description, m.Version)
+		}
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			return nil, fmt.Errorf("migration %d is out of order (after %d)", m.Version, migrations[i-1].Version)
+		}
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Status lists every known migration and when it was applied.
+func (mg *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, len(mg.migrations))
+	for i, m := range mg.migrations {
+		statuses[i] = MigrationStatus{Version: m.Version, Description: m.Description}
+		if at, ok := applied[m.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// Pending lists the migrations that have not been applied yet.
+func (mg *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range mg.migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration, stopping at the first failure.
+// It returns the migrations it applied.
+func (mg *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	pending, err := mg.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records := mg.db.Collection(MigrationsCollectionName)
+	var done []Migration
+	for _, m := range pending {
+		log.Printf("Applying migration %d: %s", m.Version, m.Description)
+		if err := m.Up(ctx, mg.db); err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+		_, err := records.InsertOne(ctx, migrationRecord{
+			Version:     m.Version,
+			Description: m.Description,
+			AppliedAt:   time.Now().UTC(),
+		})
+		// A concurrent run may have recorded it first, which is fine
+		// since migrations are idempotent.
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return done, fmt.Errorf("failed to record migration %d: %w", m.Version, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+func (mg *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	cursor, err := mg.db.Collection(MigrationsCollectionName).Find(ctx, bson.D{},
+		options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []migrationRecord
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]time.Time, len(records))
+	for _, r := range records {
+		applied[r.Version] = r.AppliedAt
+	}
+	return applied, nil
+}
+
+// collectionExists reports whether the database has a collection called name.
+func collectionExists(ctx context.Context, db *mongo.Database, name string) (bool, error) {
+	names, err := db.ListCollectionNames(ctx, bson.M{"name": name})
+	if err != nil {
+		return false, err
+	}
+	return len(names) > 0, nil
+}
@@ -0,0 +1,44 @@
+package mongo
+
+import (
+	"financial-data-backend-2/internal/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMigrator(t *testing.T) {
+	testCases := []struct {
+		name        string
+		versions    []int
+		expectError bool
+	}{
+		{name: "increasing versions", versions: []int{1, 2, 5}},
+		{name: "no migrations", versions: nil},
+		{name: "zero version", versions: []int{0, 1}, expectError: true},
+		{name: "duplicate version", versions: []int{1, 2, 2}, expectError: true},
+		{name: "out of order", versions: []int{1, 3, 2}, expectError: true},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			var migrations []Migration
+			for _, v := range tt.versions {
+				migrations = append(migrations, Migration{Version: v})
+			}
+
+			_, err := NewMigrator(nil, migrations)
+
+			if tt.expectError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMigrationsAreValid(t *testing.T) {
+	_, err := NewMigrator(nil, Migrations(config.MongoConfig{CollectionName: "trades"}))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,244 @@
+package mongo
+
+import (
+	"context"
+	"financial-data-backend-2/internal/config"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How long the alert delivery log is kept
+const alertDeliveriesTTL = 30 * 24 * time.Hour
+
+// How long trades set aside by the lateness policy are kept
+const lateTradesTTL = 30 * 24 * time.Hour
+
+var tradeValidator = bson.M{"$jsonSchema": bson.M{
+	"bsonType": "object",
+	"required": []string{"symbol", "time", "price", "volume"},
+	"properties": bson.M{
+		"message_key": bson.M{"bsonType": "string"},
+		"symbol":      bson.M{"bsonType": "string"},
+		"time":        bson.M{"bsonType": "date"},
+		"price":       bson.M{"bsonType": "decimal"},
+		"volume":      bson.M{"bsonType": "decimal"},
+		"conditions":  bson.M{"bsonType": "array", "items": bson.M{"bsonType": "string"}},
+	},
+}}
+
+var symbolValidator = bson.M{"$jsonSchema": bson.M{
+	"bsonType": "object",
+	"required": []string{"symbol"},
+	"properties": bson.M{
+		"symbol":      bson.M{"bsonType": "string"},
+		"tradeCount":  bson.M{"bsonType": []string{"int", "long"}},
+		"lastTradeAt": bson.M{"bsonType": "date"},
+	},
+}}
+
+var symbolDetailValidator = bson.M{"$jsonSchema": bson.M{
+	"bsonType": "object",
+	"required": []string{"symbol"},
+	"properties": bson.M{
+		"symbol":       bson.M{"bsonType": "string"},
+		"exchange":     bson.M{"bsonType": "string"},
+		"assetClass":   bson.M{"enum": []string{"stock", "crypto", "forex"}},
+		"tradeCount":   bson.M{"bsonType": []string{"int", "long"}},
+		"firstTradeAt": bson.M{"bsonType": "date"},
+		"lastTradeAt":  bson.M{"bsonType": "date"},
+		"lastPrice":    bson.M{"bsonType": "decimal"},
+		"day": bson.M{
+			"bsonType": "object",
+			"required": []string{"date", "openAt", "open", "high", "low", "volume"},
+			"properties": bson.M{
+				"date":   bson.M{"bsonType": "string"},
+				"openAt": bson.M{"bsonType": "date"},
+				"open":   bson.M{"bsonType": "decimal"},
+				"high":   bson.M{"bsonType": "decimal"},
+				"low":    bson.M{"bsonType": "decimal"},
+				"volume": bson.M{"bsonType": "decimal"},
+			},
+		},
+	},
+}}
+
+// Migrations is the schema history of the database, oldest first. Never
+// change a migration once released; add a new one instead.
+func Migrations(cfg config.MongoConfig) []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "create trades time-series collection",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				exists, err := collectionExists(ctx, db, cfg.CollectionName)
+				if err != nil {
+					return err
+				}
+				if exists {
+					// Collections cannot be converted in place; moving the
+					// data over is left to the operator.
+					log.Printf("Collection %q already exists and is left as it is (it may not be a time-series collection)",
+						cfg.CollectionName)
+					return setValidator(ctx, db, cfg.CollectionName, tradeValidator)
+				}
+				return db.CreateCollection(ctx, cfg.CollectionName, options.CreateCollection().
+					SetTimeSeriesOptions(options.TimeSeries().
+						SetTimeField("time").
+						SetMetaField("symbol").
+						SetGranularity("seconds")).
+					SetValidator(tradeValidator).
+					SetValidationLevel("moderate"))
+			},
+		},
+		{
+			Version:     2,
+			Description: "create trade idempotency keys collection",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				// Keys are stored as _id, which is unique.
+				_, err := db.Collection(cfg.KeysCollection()).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.M{"created_at": 1},
+					Options: options.Index().SetExpireAfterSeconds(int32(config.TradeKeysTTL.Seconds())),
+				})
+				return err
+			},
+		},
+		{
+			Version:     3,
+			Description: "index trades by symbol and time",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(cfg.CollectionName).Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{Keys: bson.D{{Key: "symbol", Value: 1}, {Key: "time", Value: -1}}},
+					{Keys: bson.M{"message_key": 1}},
+				})
+				return err
+			},
+		},
+		{
+			Version:     4,
+			Description: "create symbols collection with unique symbol index",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				exists, err := collectionExists(ctx, db, cfg.SymbolsCollectionName)
+				if err != nil {
+					return err
+				}
+				if exists {
+					err = setValidator(ctx, db, cfg.SymbolsCollectionName, symbolValidator)
+				} else {
+					err = db.CreateCollection(ctx, cfg.SymbolsCollectionName, options.CreateCollection().
+						SetValidator(symbolValidator).
+						SetValidationLevel("moderate"))
+				}
+				if err != nil {
+					return err
+				}
+				_, err = db.Collection(cfg.SymbolsCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.M{"symbol": 1},
+					Options: options.Index().SetUnique(true),
+				})
+				return err
+			},
+		},
+		{
+			Version:     5,
+			Description: "create minute candles collection",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				// A regular collection, since candles are upserted when the
+				// downsampler reruns. Its TTL is set by the retention job.
+				_, err := db.Collection(cfg.CandlesCollection()).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.D{{Key: "symbol", Value: 1}, {Key: "time", Value: -1}},
+					Options: options.Index().SetUnique(true),
+				})
+				return err
+			},
+		},
+		{
+			Version:     6,
+			Description: "validate symbol details and index symbols by asset class",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				if err := setValidator(ctx, db, cfg.SymbolsCollectionName, symbolDetailValidator); err != nil {
+					return err
+				}
+				_, err := db.Collection(cfg.SymbolsCollectionName).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys: bson.D{{Key: "assetClass", Value: 1}, {Key: "exchange", Value: 1}},
+				})
+				return err
+			},
+		},
+		{
+			Version:     7,
+			Description: "create alert rules and delivery log collections",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				_, err := db.Collection(cfg.AlertRulesCollection()).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys: bson.D{{Key: "symbol", Value: 1}, {Key: "enabled", Value: 1}},
+				})
+				if err != nil {
+					return err
+				}
+				_, err = db.Collection(cfg.AlertDeliveriesCollection()).Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{Keys: bson.D{{Key: "rule_id", Value: 1}, {Key: "triggered_at", Value: -1}}},
+					{
+						Keys:    bson.M{"completed_at": 1},
+						Options: options.Index().SetExpireAfterSeconds(int32(alertDeliveriesTTL.Seconds())),
+					},
+				})
+				return err
+			},
+		},
+		{
+			Version:     8,
+			Description: "create late trades collection",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				// Message keys are stored as _id, so trades are set aside once.
+				_, err := db.Collection(cfg.LateTradesCollection()).Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{Keys: bson.D{{Key: "symbol", Value: 1}, {Key: "time", Value: -1}}},
+					{
+						Keys:    bson.M{"received_at": 1},
+						Options: options.Index().SetExpireAfterSeconds(int32(lateTradesTTL.Seconds())),
+					},
+				})
+				return err
+			},
+		},
+		{
+			Version:     9,
+			Description: "expire abandoned leader election leases",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				// Expiry is checked on every acquisition; this only cleans up.
+				_, err := db.Collection(cfg.LeasesCollection()).Indexes().CreateOne(ctx, mongo.IndexModel{
+					Keys:    bson.M{"expires_at": 1},
+					Options: options.Index().SetExpireAfterSeconds(0),
+				})
+				return err
+			},
+		},
+		{
+			Version:     10,
+			Description: "create data gaps collection",
+			Up: func(ctx context.Context, db *mongo.Database) error {
+				// A gap is updated in place while it goes on, found by its
+				// start; the API lists gaps by time, of all symbols or one.
+				_, err := db.Collection(cfg.DataGapsCollection()).Indexes().CreateMany(ctx, []mongo.IndexModel{
+					{
+						Keys:    bson.D{{Key: "symbol", Value: 1}, {Key: "from", Value: 1}},
+						Options: options.Index().SetUnique(true),
+					},
+					{Keys: bson.D{{Key: "from", Value: 1}}},
+				})
+				return err
+			},
+		},
+	}
+}
+
+// setValidator replaces the validator of an existing collection.
+func setValidator(ctx context.Context, db *mongo.Database, collection string, validator bson.M) error {
+	return db.RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: collection},
+		{Key: "validator", Value: validator},
+		{Key: "validationLevel", Value: "moderate"},
+	}).Err()
+}
@@ -0,0 +1,145 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// MigrationsTable records which migrations have been applied.
+const MigrationsTable = "schema_migrations"
+
+// Migration is one versioned change to the database. Its SQL runs in a
+// transaction with the record of it, so it is applied once or not at
+// all.
+type Migration struct {
+	Version     int
+	Description string
+	SQL         string
+}
+
+// MigrationStatus says whether (and when) a migration was applied.
+type MigrationStatus struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time
+}
+
+// Migrator applies migrations in version order and records each one in
+// the MigrationsTable table.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator checks that the migrations are in strictly increasing
+// version order.
+func NewMigrator(db *sql.DB, migrations []Migration) (*Migrator, error) {
+	for i, m := range migrations {
+		if m.Version <= 0 {
+			return nil, fmt.Errorf("migration %q has invalid version %d", m.Description, m.Version)
+		}
+		if i > 0 && m.Version <= migrations[i-1].Version {
+			return nil, fmt.Errorf("migration %d is out of order (after %d)", m.Version, migrations[i-1].Version)
+		}
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Status lists every known migration and when it was applied.
+func (mg *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]MigrationStatus, len(mg.migrations))
+	for i, m := range mg.migrations {
+		statuses[i] = MigrationStatus{Version: m.Version, Description: m.Description}
+		if at, ok := applied[m.Version]; ok {
+			statuses[i].AppliedAt = &at
+		}
+	}
+	return statuses, nil
+}
+
+// Pending lists the migrations that have not been applied yet.
+func (mg *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := mg.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, m := range mg.migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration, stopping at the first failure.
+// It returns the migrations it applied.
+func (mg *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	pending, err := mg.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range pending {
+		log.Printf("Applying migration %d: %s", m.Version, m.Description)
+		if err := mg.apply(ctx, m); err != nil {
+			return done, fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Description, err)
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+func (mg *Migrator) apply(ctx context.Context, m Migration) error {
+	tx, err := mg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+	// A concurrent run may have recorded it first; its statements are
+	// idempotent.
+	_, err = tx.ExecContext(ctx, `INSERT INTO `+MigrationsTable+` (version, description, applied_at)
+		VALUES ($1, $2, $3) ON CONFLICT (version) DO NOTHING`, m.Version, m.Description, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (mg *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	_, err := mg.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+MigrationsTable+` (
+		version     integer PRIMARY KEY,
+		description text        NOT NULL,
+		applied_at  timestamptz NOT NULL
+	)`)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := mg.db.QueryContext(ctx, `SELECT version, applied_at FROM `+MigrationsTable+` ORDER BY version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, Time(&at)); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,87 @@
+package postgres
+
+// Migrations returns every migration, in version order. Numbers are
+// never reused: add new migrations at the end.
+func Migrations() []Migration {
+	return []Migration{
+		{
+			Version:     1,
+			Description: "create the trades table, partitioned by month, and its idempotency keys",
+			// Partitions are created as trades arrive, see EnsurePartition.
+			SQL: `
+CREATE TABLE IF NOT EXISTS ` + TradesTable + ` (
+	message_key   text        NOT NULL,
+	symbol        text        NOT NULL,
+	time          timestamptz NOT NULL,
+	price         numeric     NOT NULL,
+	volume        numeric     NOT NULL,
+	conditions    text[],
+	flags         text[],
+	original_time timestamptz
+) PARTITION BY RANGE (time);
+CREATE INDEX IF NOT EXISTS trades_symbol_time ON ` + TradesTable + ` (symbol, time DESC);
+CREATE TABLE IF NOT EXISTS ` + TradeKeysTable + ` (
+	message_key text PRIMARY KEY,
+	created_at  timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS trade_keys_created_at ON ` + TradeKeysTable + ` (created_at);`,
+		},
+		{
+			Version:     2,
+			Description: "create the symbols table",
+			SQL: `
+CREATE TABLE IF NOT EXISTS ` + SymbolsTable + ` (
+	symbol         text PRIMARY KEY,
+	exchange       text,
+	asset_class    text,
+	trade_count    bigint NOT NULL DEFAULT 0,
+	first_trade_at timestamptz,
+	last_trade_at  timestamptz,
+	last_price     numeric,
+	last_volume    numeric,
+	day_date       date,
+	day_open_at    timestamptz,
+	day_open       numeric,
+	day_high       numeric,
+	day_low        numeric,
+	day_volume     numeric,
+	day_prev_close numeric
+);`,
+		},
+		{
+			Version:     3,
+			Description: "create the minute candles table",
+			SQL: `
+CREATE TABLE IF NOT EXISTS ` + CandlesTable + ` (
+	symbol      text        NOT NULL,
+	time        timestamptz NOT NULL,
+	open        numeric     NOT NULL,
+	high        numeric     NOT NULL,
+	low         numeric     NOT NULL,
+	close       numeric     NOT NULL,
+	volume      numeric     NOT NULL,
+	trade_count bigint      NOT NULL,
+	PRIMARY KEY (symbol, time)
+);`,
+		},
+		{
+			Version:     4,
+			Description: "create the late trades table",
+			SQL: `
+CREATE TABLE IF NOT EXISTS ` + LateTradesTable + ` (
+	id          text PRIMARY KEY,
+	symbol      text        NOT NULL,
+	price       numeric     NOT NULL,
+	time        timestamptz NOT NULL,
+	volume      numeric     NOT NULL,
+	conditions  text[],
+	flags       text[],
+	reason      text        NOT NULL,
+	watermark   timestamptz,
+	received_at timestamptz NOT NULL
+);
+CREATE INDEX IF NOT EXISTS late_trades_symbol_time ON ` + LateTradesTable + ` (symbol, time DESC);
+CREATE INDEX IF NOT EXISTS late_trades_received_at ON ` + LateTradesTable + ` (received_at);`,
+		},
+	}
+}
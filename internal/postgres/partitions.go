@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"financial-data-backend-2/internal/config"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// How long trades set aside by the lateness policy are kept, as with
+// MongoDB's TTL index. Idempotency keys are kept for config.TradeKeysTTL.
+const lateTradesTTL = 30 * 24 * time.Hour
+
+// Error codes of a partition created concurrently by another processor
+const (
+	codeDuplicateTable  pq.ErrorCode = "42P07"
+	codeUniqueViolation pq.ErrorCode = "23505"
+)
+
+// PartitionName is the name of the trades partition holding the month
+// of t (in UTC), e.g. "trades_y2025m11".
+func PartitionName(t time.Time) string {
+	t = t.UTC()
+	return fmt.Sprintf("%s_y%04dm%02d", TradesTable, t.Year(), int(t.Month()))
+}
+
+// monthOf returns the start of the month of t, in UTC.
+func monthOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// EnsurePartition creates the trades partition for the month of t, if
+// it does not exist yet.
+func EnsurePartition(ctx context.Context, db *sql.DB, t time.Time) error {
+	start := monthOf(t)
+	end := start.AddDate(0, 1, 0)
+	_, err := db.ExecContext(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')`,
+		PartitionName(start), TradesTable, start.Format(time.RFC3339), end.Format(time.RFC3339)))
+	var e *pq.Error
+	if errors.As(err, &e) && (e.Code == codeDuplicateTable || e.Code == codeUniqueViolation) {
+		return nil
+	}
+	return err
+}
+
+// DropPartitionsBefore drops the trades partitions of the months that
+// ended by t, which expires their trades all at once, and returns their
+// names.
+func DropPartitionsBefore(ctx context.Context, db *sql.DB, t time.Time) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = $1::regclass`, TradesTable)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var dropped []string
+	for _, name := range names {
+		var year, month int
+		if _, err := fmt.Sscanf(name, TradesTable+"_y%04dm%02d", &year, &month); err != nil {
+			continue // not one of ours
+		}
+		end := time.Date(year, time.Month(month)+1, 1, 0, 0, 0, 0, time.UTC)
+		if end.After(t) {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, `DROP TABLE IF EXISTS `+pq.QuoteIdentifier(name)); err != nil {
+			return dropped, err
+		}
+		log.Printf("Dropped partition %s", name)
+		dropped = append(dropped, name)
+	}
+	return dropped, nil
+}
+
+// DeleteExpired removes the idempotency keys and late trades that have
+// been kept long enough.
+func DeleteExpired(ctx context.Context, db *sql.DB, now time.Time) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM `+TradeKeysTable+` WHERE created_at < $1`,
+		now.Add(-config.TradeKeysTTL)); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, `DELETE FROM `+LateTradesTable+` WHERE received_at < $1`,
+		now.Add(-lateTradesTTL))
+	return err
+}
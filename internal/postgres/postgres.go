@@ -0,0 +1,134 @@
+// Package postgres keeps trades and symbol metadata in PostgreSQL, for
+// deployments that use it rather than MongoDB (storage.backend:
+// "postgres"). It sets up the connection and the tables; the processor
+// and the API have their own Postgres implementations of their stores.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	_ "github.com/lib/pq"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Tables
+const (
+	// Trades, partitioned by month of their time
+	TradesTable = "trades"
+	// Idempotency keys of the stored trades, see processor.InsertTrades
+	TradeKeysTable = "trade_keys"
+	// Trades set aside by the lateness policy
+	LateTradesTable = "late_trades"
+	SymbolsTable    = "symbols"
+	// Minute candles, written by go-retention
+	CandlesTable = "candles_1m"
+)
+
+// Unflagged matches trades without flags, i.e. that are not bad ticks.
+const Unflagged = "coalesce(cardinality(flags), 0) = 0"
+
+func ConnectDB(postgresURL string, duration time.Duration) (*sql.DB, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+
+	db, err := sql.Open("postgres", postgresURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ping the database to verify connection
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	fmt.Println("Connected to Postgres")
+	return db, nil
+}
+
+// InSchema returns postgresURL with its search_path set to schema, so
+// that tables are created and found there, e.g. to keep tests apart.
+func InSchema(postgresURL, schema string) (string, error) {
+	u, err := url.Parse(postgresURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Decimal returns a scanner that reads a numeric column into d. NULL
+// leaves d as the zero Decimal128, as a missing field does with MongoDB.
+func Decimal(d *primitive.Decimal128) sql.Scanner {
+	return decimalScanner{d}
+}
+
+type decimalScanner struct {
+	d *primitive.Decimal128
+}
+
+func (s decimalScanner) Scan(src any) error {
+	var text string
+	switch v := src.(type) {
+	case nil:
+		*s.d = primitive.Decimal128{}
+		return nil
+	case []byte:
+		text = string(v)
+	case string:
+		text = v
+	default:
+		return fmt.Errorf("cannot scan %T into a decimal", src)
+	}
+	d, err := primitive.ParseDecimal128(text)
+	if err != nil {
+		return err
+	}
+	*s.d = d
+	return nil
+}
+
+// DecimalArg is the query argument for d. The zero Decimal128, which
+// stands for a missing value, is NULL.
+func DecimalArg(d primitive.Decimal128) any {
+	if d == (primitive.Decimal128{}) {
+		return nil
+	}
+	return d.String()
+}
+
+// Time returns a scanner that reads a timestamptz column into t, in UTC
+// like times read from MongoDB. NULL leaves t zero.
+func Time(t *time.Time) sql.Scanner {
+	return timeScanner{t}
+}
+
+type timeScanner struct {
+	t *time.Time
+}
+
+func (s timeScanner) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*s.t = time.Time{}
+	case time.Time:
+		*s.t = v.UTC()
+	default:
+		return fmt.Errorf("cannot scan %T into a time", src)
+	}
+	return nil
+}
+
+// TimeArg is the query argument for t. The zero time is NULL.
+func TimeArg(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
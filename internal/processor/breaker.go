@@ -0,0 +1,181 @@
+package processor
+
+import (
+	"context"
+	"financial-data-backend-2/internal/config"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// States of a Breaker
+const (
+	// Writes go ahead
+	BreakerClosed = "closed"
+	// The database is considered down, and writes wait
+	BreakerOpen = "open"
+	// One write is trying whether the database is back; the others wait
+	BreakerHalfOpen = "half_open"
+)
+
+// BreakerStats counts what a Breaker did since it was created.
+type BreakerStats struct {
+	State string
+	// Writes tried again after a transient failure
+	Retries int64
+	// Times the database was considered down
+	Opened int64
+}
+
+// Breaker retries writes to the database that fail transiently, and pauses
+// all of them while it is down. A paused write holds up its worker, so
+// the workers' queues fill up and reading from Kafka pauses too, instead
+// of messages being skipped.
+type Breaker struct {
+	mu             sync.Mutex
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	threshold      int
+	cooldown       time.Duration
+
+	state     string
+	failures  int // consecutive transient failures
+	openUntil time.Time
+	stats     BreakerStats
+	now       func() time.Time
+}
+
+func NewBreaker(cfg config.ProcessorConfig) *Breaker {
+	cfg = cfg.WithDefaults()
+	return &Breaker{
+		initialBackoff: cfg.InitialBackoff,
+		maxBackoff:     cfg.MaxBackoff,
+		threshold:      cfg.BreakerThreshold,
+		cooldown:       cfg.BreakerCooldown,
+		state:          BreakerClosed,
+		now:            time.Now,
+	}
+}
+
+// Do calls write until it succeeds or fails with an error that is not
+// transient, which it returns. Transient errors are retried after an
+// exponential backoff, and once there have been too many in a row, every
+// write waits for the cooldown before one tries the database again. Do only
+// gives up when ctx is done; its error then wraps ctx.Err() (and the last
+// transient error, if any), and the write may not have been done.
+func (b *Breaker) Do(ctx context.Context, write func() error) error {
+	backoff := b.initialBackoff
+	var lastErr error
+	for {
+		if err := b.wait(ctx); err != nil {
+			return giveUp(err, lastErr)
+		}
+		err := write()
+		b.record(err)
+		if !IsTransientError(err) {
+			return err
+		}
+		lastErr = err
+
+		b.mu.Lock()
+		b.stats.Retries++
+		b.mu.Unlock()
+		log.Printf("Transient database error, retrying in %v: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return giveUp(ctx.Err(), lastErr)
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, b.maxBackoff)
+	}
+}
+
+func giveUp(ctxErr, lastErr error) error {
+	if lastErr == nil {
+		return ctxErr
+	}
+	return fmt.Errorf("%w: %w", ctxErr, lastErr)
+}
+
+// Stats returns the counts so far, and the current state.
+func (b *Breaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s := b.stats
+	s.State = b.state
+	return s
+}
+
+// wait returns once a write may go ahead, or ctx is done.
+func (b *Breaker) wait(ctx context.Context) error {
+	for {
+		d := b.admit()
+		if d <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(d):
+		}
+	}
+}
+
+// admit returns how long a write should wait before asking again, or 0
+// if it may go ahead. Once the cooldown is over, the first write to ask
+// tries the database.
+func (b *Breaker) admit() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if wait := b.openUntil.Sub(b.now()); wait > 0 {
+			return wait
+		}
+		b.setState(BreakerHalfOpen, nil)
+		return 0
+	case BreakerHalfOpen:
+		return b.initialBackoff
+	default:
+		return 0
+	}
+}
+
+// record updates the state after a write. Any error that is not
+// transient, like a duplicate key, shows that the database is up.
+func (b *Breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !IsTransientError(err) {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.setState(BreakerClosed, nil)
+		}
+		return
+	}
+	b.failures++
+	if b.state == BreakerHalfOpen || (b.state == BreakerClosed && b.failures >= b.threshold) {
+		b.openUntil = b.now().Add(b.cooldown)
+		b.stats.Opened++
+		b.setState(BreakerOpen, err)
+	}
+}
+
+// setState changes the state and logs the change. Called with mu held.
+func (b *Breaker) setState(state string, err error) {
+	previous := b.state
+	b.state = state
+	switch {
+	case state == BreakerOpen && previous == BreakerHalfOpen:
+		log.Printf("Database circuit breaker %s -> %s: still failing, pausing writes for another %v: %v",
+			previous, state, b.cooldown, err)
+	case state == BreakerOpen:
+		log.Printf("Database circuit breaker %s -> %s: %d consecutive failures, pausing writes for %v (opened %d time(s) since start): %v",
+			previous, state, b.failures, b.cooldown, b.stats.Opened, err)
+	case state == BreakerHalfOpen:
+		log.Printf("Database circuit breaker %s -> %s: trying a write", previous, state)
+	default:
+		log.Printf("Database circuit breaker %s -> %s: writes resumed", previous, state)
+	}
+}
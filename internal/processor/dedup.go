@@ -0,0 +1,66 @@
+package processor
+
+import (
+	"container/list"
+	"financial-data-backend-2/internal/models"
+	"sync"
+)
+
+// SeenCache remembers the idempotency keys of the most recently stored
+// trades, so that duplicates can be skipped without asking MongoDB. It is
+// only a shortcut: the keys collection still decides, e.g. for keys that
+// were forgotten or seen by another processor.
+type SeenCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // of keys, most recent first
+	keys  map[string]*list.Element
+}
+
+// NewSeenCache returns a cache of up to size keys.
+func NewSeenCache(size int) *SeenCache {
+	return &SeenCache{size: size, order: list.New(), keys: make(map[string]*list.Element, size)}
+}
+
+// Filter returns the records whose key has not been seen, and how many it
+// left out.
+func (c *SeenCache) Filter(records []interface{}) ([]interface{}, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fresh := make([]interface{}, 0, len(records))
+	for _, r := range records {
+		if e, ok := c.keys[r.(models.TradeRecord).MessageKey]; ok {
+			c.order.MoveToFront(e)
+			continue
+		}
+		fresh = append(fresh, r)
+	}
+	return fresh, len(records) - len(fresh)
+}
+
+// Add remembers the keys of records once they are stored, forgetting the
+// least recently seen keys beyond the cache's size.
+func (c *SeenCache) Add(records []interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range records {
+		key := r.(models.TradeRecord).MessageKey
+		if e, ok := c.keys[key]; ok {
+			c.order.MoveToFront(e)
+			continue
+		}
+		c.keys[key] = c.order.PushFront(key)
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.keys, oldest.Value.(string))
+		}
+	}
+}
+
+// Len returns the number of keys remembered.
+func (c *SeenCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
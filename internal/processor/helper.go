@@ -1,14 +1,22 @@
 package processor
 
 import (
-	"encoding/json"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/hex"
 	"errors"
+	"financial-data-backend-2/internal/events"
+	"financial-data-backend-2/internal/logging"
 	"financial-data-backend-2/internal/models"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	kafkaGo "github.com/segmentio/kafka-go"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
@@ -32,54 +40,182 @@ func IsDuplicateKeyError(err error) bool {
 	return false
 }
 
-func TransformMessage(m kafkaGo.Message) (*ProcessedData, error) {
-	// Unmarshal the raw JSON value from Kafka
-	var finnMsg models.FinnhubTradeMessage
-	if err := json.Unmarshal(m.Value, &finnMsg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal JSON: %w", err)
+// Server error codes of writes that may succeed when retried: the node
+// is not (or no longer) the primary, is shutting down or unreachable, or
+// could not satisfy the write concern in time.
+var transientCodes = []int{
+	6,     // HostUnreachable
+	7,     // HostNotFound
+	64,    // WriteConcernFailed
+	89,    // NetworkTimeout
+	91,    // ShutdownInProgress
+	189,   // PrimarySteppedDown
+	262,   // ExceededTimeLimit
+	9001,  // SocketException
+	10107, // NotWritablePrimary
+	11600, // InterruptedAtShutdown
+	11602, // InterruptedDueToReplStateChange
+	13435, // NotPrimaryNoSecondaryOk
+	13436, // NotPrimaryOrSecondary
+}
+
+// IsTransientError reports whether a failed write is worth retrying: the
+// network or the database failed, rather than the write itself. Duplicate keys
+// and invalid documents are not transient.
+func IsTransientError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return true
+	}
+	// The write was done, or not, on the primary but not replicated as
+	// asked. Which it was is not known, so a retry must find out, as
+	// InsertTrades does with its pending keys.
+	var bwe mongo.BulkWriteException
+	if errors.As(err, &bwe) && bwe.WriteConcernError != nil {
+		return true
+	}
+	var we mongo.WriteException
+	if errors.As(err, &we) && we.WriteConcernError != nil {
+		return true
+	}
+	var se mongo.ServerError
+	if errors.As(err, &se) {
+		if se.HasErrorLabel("RetryableWriteError") || se.HasErrorLabel("TransientTransactionError") {
+			return true
+		}
+		for _, code := range transientCodes {
+			if se.HasErrorCode(code) {
+				return true
+			}
+		}
+	}
+
+	// Postgres: the connection was lost or refused, the server is short
+	// of resources or shutting down, or the transaction lost a conflict
+	// and was rolled back.
+	var pe *pq.Error
+	if errors.As(err, &pe) {
+		switch pe.Code.Class() {
+		case "08", "53", "57":
+			return true
+		}
+		return pe.Code == "40001" || pe.Code == "40P01"
+	}
+	var ne net.Error
+	return errors.As(err, &ne) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// KeyFunc derives the idempotency key of a trade read from m. Trades with
+// the same key are stored once.
+type KeyFunc func(m kafkaGo.Message, trade models.TradeEvent, price, volume primitive.Decimal128) string
+
+// MessageKey keys a trade by the message it was read from, so that only
+// re-reads of a message are duplicates.
+func MessageKey(m kafkaGo.Message, trade models.TradeEvent, _, _ primitive.Decimal128) string {
+	return fmt.Sprintf("%s-%d-%d-%s-%d-%d", m.Topic, m.Partition, m.Offset,
+		trade.Symbol, trade.ExchangeTime, trade.Sequence)
+}
+
+// ContentKey keys a trade by what it is, so that the same trade published
+// twice, e.g. by two ingestors or a replay, is a duplicate too. The
+// position within its Finnhub frame tells identical trades apart.
+func ContentKey(_ kafkaGo.Message, trade models.TradeEvent, price, volume primitive.Decimal128) string {
+	h := sha256.New()
+	for _, field := range []string{
+		trade.Symbol,
+		strconv.FormatInt(trade.ExchangeTime, 10),
+		price.String(),
+		volume.String(),
+		strings.Join(trade.Conditions, ","),
+		strconv.Itoa(trade.Sequence),
+	} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return "content-" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Transformer decodes the trades of a message into trade records.
+type Transformer struct {
+	// Derives each trade's idempotency key. Defaults to MessageKey.
+	Key KeyFunc
+	// Every trade is run through them, in order, and tagged with the
+	// flags they raise; flagged trades are kept.
+	Validators []TickValidator
+}
+
+// TransformMessage transforms a message with the default keys.
+func TransformMessage(m kafkaGo.Message, validators ...TickValidator) (*ProcessedData, error) {
+	return Transformer{Validators: validators}.Transform(m)
+}
+
+func (tf Transformer) Transform(m kafkaGo.Message) (*ProcessedData, error) {
+	// Decode either a trade event or a legacy, raw Finnhub frame
+	trades, err := events.DecodeTrades(m)
+	if err != nil {
+		return nil, err
 	}
-	log.Printf("Unmarshalled Data: %+v", finnMsg)
+	logging.Debugf("Decoded trades: %+v", trades)
 
-	if finnMsg.Type != "trade" || len(finnMsg.Data) == 0 {
+	if len(trades) == 0 {
 		return nil, nil // Not an error, just a message to skip (e.g., a ping)
 	}
+	return tf.Records(m, trades)
+}
+
+// Records transforms trades read from m, or from elsewhere (e.g. a
+// backfill), into trade records. m is only used to key them.
+func (tf Transformer) Records(m kafkaGo.Message, trades []models.TradeEvent) (*ProcessedData, error) {
+	key := tf.Key
+	if key == nil {
+		key = MessageKey
+	}
 
 	// Prepare data for insertion/updates
 	timeSeries := make([]any, 0)
 	symbolTradeCounts := make(map[string]int64)
 	latestTimestamps := make(map[string]time.Time)
-	for i, trade := range finnMsg.Data {
+	for _, trade := range trades {
 		// time
-		t := time.UnixMilli(trade.Timestamp)
+		t := time.UnixMilli(trade.ExchangeTime)
 
 		// price
-		pStr := strconv.FormatFloat(trade.Price, 'f', -1, 64)
-		p, err := primitive.ParseDecimal128(pStr)
+		p, err := primitive.ParseDecimal128(trade.Price)
 		if err != nil {
 			log.Printf("Could not convert price string '%s' for symbol '%s' to Decimal128: %v",
-				pStr, trade.Symbol, err)
+				trade.Price, trade.Symbol, err)
 			continue // Skip this tick if the price is invalid
 		}
 
 		// volume
-		vStr := strconv.FormatFloat(trade.Volume, 'f', -1, 64)
-		v, err := primitive.ParseDecimal128(vStr)
+		v, err := primitive.ParseDecimal128(trade.Volume)
 		if err != nil {
 			log.Printf("Could not convert volume string '%s' for symbol '%s' to Decimal128: %v",
-				vStr, trade.Symbol, err)
+				trade.Volume, trade.Symbol, err)
 			continue // Skip this tick if the volume is invalid
 		}
 		// put trade to batch
-		timeSeries = append(timeSeries, models.TradeRecord{
+		record := models.TradeRecord{
 			Id: primitive.NewObjectID(),
 			// idempotency key to prevent redundant insertion of data from MQ
-			MessageKey: fmt.Sprintf("%s-%d-%d-%s-%d-%d", m.Topic, m.Partition, m.Offset,
-				trade.Symbol, trade.Timestamp, i),
-			Symbol: trade.Symbol,
-			Price:  p,
-			Time:   t,
-			Volume: v,
-		})
+			MessageKey: key(m, trade, p, v),
+			Symbol:     trade.Symbol,
+			Price:      p,
+			Time:       t,
+			Volume:     v,
+			Conditions: trade.Conditions,
+		}
+		if trade.ReceiveTime > 0 {
+			record.ReceivedAt = time.UnixMilli(trade.ReceiveTime)
+		}
+		record.Flags = validate(record, tf.Validators)
+		if len(record.Flags) > 0 {
+			logging.Debugf("Flagged trade of '%s' at %s, price %s: %v",
+				record.Symbol, t.UTC().Format(time.RFC3339Nano), record.Price, record.Flags)
+		}
+		timeSeries = append(timeSeries, record)
 
 		symbolTradeCounts[trade.Symbol]++
 		if t.After(latestTimestamps[trade.Symbol]) {
@@ -0,0 +1,131 @@
+package processor
+
+import (
+	"financial-data-backend-2/internal/config"
+	"financial-data-backend-2/internal/models"
+	"slices"
+	"sync"
+	"time"
+)
+
+// LatenessStats counts what the lateness policy did with trades.
+type LatenessStats struct {
+	// Stored with the time they were reported with
+	OnTime int64
+	// Set aside for being older than their symbol's watermark
+	Late int64
+	// Stored with their time clamped to when they arrived
+	Clamped int64
+	// Set aside for being too far ahead of the clock
+	Rejected int64
+}
+
+// Sub returns the counts since an earlier snapshot.
+func (s LatenessStats) Sub(earlier LatenessStats) LatenessStats {
+	return LatenessStats{
+		OnTime:   s.OnTime - earlier.OnTime,
+		Late:     s.Late - earlier.Late,
+		Clamped:  s.Clamped - earlier.Clamped,
+		Rejected: s.Rejected - earlier.Rejected,
+	}
+}
+
+// LatenessPolicy decides which trades are stored and which are set aside,
+// as configured by config.LatenessConfig. The watermarks are kept in
+// memory, so they start over when the processor restarts. Trades are
+// keyed by symbol on the topic, so each symbol is read by one processor.
+type LatenessPolicy struct {
+	mu      sync.Mutex
+	allowed time.Duration
+	maxSkew time.Duration
+	reject  bool
+	latest  map[string]time.Time
+	stats   LatenessStats
+	now     func() time.Time
+}
+
+func NewLatenessPolicy(cfg config.LatenessConfig) *LatenessPolicy {
+	cfg = cfg.WithDefaults()
+	return &LatenessPolicy{
+		allowed: cfg.AllowedLateness,
+		maxSkew: cfg.MaxFutureSkew,
+		reject:  cfg.FuturePolicy == config.FutureReject,
+		latest:  make(map[string]time.Time),
+		now:     time.Now,
+	}
+}
+
+// Apply splits trade records, in the order they were read, into those to
+// store and those to set aside. Clamped trades are stored with their
+// future_timestamp flag removed, as their time no longer is. Flagged
+// trades do not move the watermark, so that a single bad tick cannot make
+// the trades after it late.
+func (p *LatenessPolicy) Apply(records []interface{}) ([]interface{}, []models.LateTrade) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	accepted := make([]interface{}, 0, len(records))
+	var late []models.LateTrade
+	for _, rec := range records {
+		r := rec.(models.TradeRecord)
+		now := p.now().UTC()
+
+		clamped := false
+		if p.maxSkew > 0 && r.Time.After(now.Add(p.maxSkew)) {
+			if p.reject {
+				p.stats.Rejected++
+				late = append(late, lateTrade(r, models.LateReasonFuture, time.Time{}, now))
+				continue
+			}
+			r.OriginalTime = r.Time
+			r.Time = now.Truncate(time.Millisecond)
+			r.Flags = slices.DeleteFunc(r.Flags, func(f string) bool { return f == models.FlagFutureTime })
+			if len(r.Flags) == 0 {
+				r.Flags = nil
+			}
+			clamped = true
+		}
+
+		if p.allowed > 0 {
+			latest := p.latest[r.Symbol]
+			if watermark := latest.Add(-p.allowed); !latest.IsZero() && r.Time.Before(watermark) {
+				p.stats.Late++
+				late = append(late, lateTrade(r, models.LateReasonLate, watermark, now))
+				continue
+			}
+			if len(r.Flags) == 0 && r.Time.After(latest) {
+				p.latest[r.Symbol] = r.Time
+			}
+		}
+
+		if clamped {
+			p.stats.Clamped++
+		} else {
+			p.stats.OnTime++
+		}
+		accepted = append(accepted, r)
+	}
+	return accepted, late
+}
+
+// Stats returns the counts since the policy was created.
+func (p *LatenessPolicy) Stats() LatenessStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+func lateTrade(r models.TradeRecord, reason string, watermark, now time.Time) models.LateTrade {
+	return models.LateTrade{
+		Id:         r.MessageKey,
+		Symbol:     r.Symbol,
+		Price:      r.Price,
+		Time:       r.Time,
+		Volume:     r.Volume,
+		Conditions: r.Conditions,
+		Flags:      r.Flags,
+		Reason:     reason,
+		Watermark:  watermark,
+		ReceivedAt: now,
+	}
+}
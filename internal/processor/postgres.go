@@ -0,0 +1,236 @@
+package processor
+
+import (
+	"context"
+	"database/sql"
+	"financial-data-backend-2/internal/models"
+	"financial-data-backend-2/internal/postgres"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// Rows per INSERT, well below Postgres's limit of 65535 parameters
+const postgresBatchSize = 1000
+
+// PostgresStore keeps trades in the trades table, partitioned by month,
+// and symbol metadata in the symbols table.
+type PostgresStore struct {
+	db *sql.DB
+
+	mu sync.Mutex
+	// Partitions known to exist, by name
+	partitions map[string]bool
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db, partitions: make(map[string]bool)}
+}
+
+// InsertTrades claims the records' keys, and inserts the records whose
+// key was free, in one transaction: unlike with MongoDB, either every
+// new record is stored or none is.
+func (s *PostgresStore) InsertTrades(ctx context.Context, records []interface{}) ([]models.TradeRecord, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+	trades := make([]models.TradeRecord, len(records))
+	keys := make([]string, len(records))
+	for i, r := range records {
+		trades[i] = r.(models.TradeRecord)
+		keys[i] = trades[i].MessageKey
+	}
+	if err := s.ensurePartitions(ctx, trades); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `INSERT INTO `+postgres.TradeKeysTable+` (message_key)
+		SELECT unnest($1::text[]) ON CONFLICT DO NOTHING RETURNING message_key`, pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	claimed := make(map[string]bool, len(keys))
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		claimed[key] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	fresh := make([]models.TradeRecord, 0, len(claimed))
+	for _, t := range trades {
+		// A key repeated within the batch is claimed once.
+		if claimed[t.MessageKey] {
+			fresh = append(fresh, t)
+			delete(claimed, t.MessageKey)
+		}
+	}
+	for start := 0; start < len(fresh); start += postgresBatchSize {
+		batch := fresh[start:min(start+postgresBatchSize, len(fresh))]
+		args := make([]any, 0, 8*len(batch))
+		for _, t := range batch {
+			args = append(args, t.MessageKey, t.Symbol, t.Time, postgres.DecimalArg(t.Price),
+				postgres.DecimalArg(t.Volume), pq.Array(t.Conditions), pq.Array(t.Flags),
+				postgres.TimeArg(t.OriginalTime))
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO `+postgres.TradesTable+`
+			(message_key, symbol, time, price, volume, conditions, flags, original_time)
+			VALUES `+placeholders(len(batch), 8), args...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	if len(fresh) == 0 {
+		return nil, nil
+	}
+	return fresh, nil
+}
+
+// ensurePartitions creates the partitions the trades go to, unless they
+// are known to exist.
+func (s *PostgresStore) ensurePartitions(ctx context.Context, trades []models.TradeRecord) error {
+	for _, t := range trades {
+		name := postgres.PartitionName(t.Time)
+		s.mu.Lock()
+		known := s.partitions[name]
+		s.mu.Unlock()
+		if known {
+			continue
+		}
+		if err := postgres.EnsurePartition(ctx, s.db, t.Time); err != nil {
+			return fmt.Errorf("failed to create partition %s: %w", name, err)
+		}
+		s.mu.Lock()
+		s.partitions[name] = true
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+func (s *PostgresStore) InsertLateTrades(ctx context.Context, trades []models.LateTrade) error {
+	for start := 0; start < len(trades); start += postgresBatchSize {
+		batch := trades[start:min(start+postgresBatchSize, len(trades))]
+		args := make([]any, 0, 10*len(batch))
+		for _, t := range batch {
+			args = append(args, t.Id, t.Symbol, postgres.DecimalArg(t.Price), t.Time,
+				postgres.DecimalArg(t.Volume), pq.Array(t.Conditions), pq.Array(t.Flags), t.Reason,
+				postgres.TimeArg(t.Watermark), t.ReceivedAt)
+		}
+		_, err := s.db.ExecContext(ctx, `INSERT INTO `+postgres.LateTradesTable+`
+			(id, symbol, price, time, volume, conditions, flags, reason, watermark, received_at)
+			VALUES `+placeholders(len(batch), 10)+` ON CONFLICT (id) DO NOTHING`, args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// symbolUpsert merges a summary into a row of the symbols table the same
+// way SymbolUpdate does into a MongoDB document, so batches may be
+// applied in any order. $16 is the summary's PrevCloseAt.
+const symbolUpsert = `INSERT INTO ` + postgres.SymbolsTable + ` AS s (symbol, exchange, asset_class,
+	trade_count, first_trade_at, last_trade_at, last_price, last_volume,
+	day_date, day_open_at, day_open, day_high, day_low, day_volume, day_prev_close)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+ON CONFLICT (symbol) DO UPDATE SET
+	exchange = EXCLUDED.exchange,
+	asset_class = COALESCE(EXCLUDED.asset_class, s.asset_class),
+	trade_count = s.trade_count + EXCLUDED.trade_count,
+	first_trade_at = LEAST(s.first_trade_at, EXCLUDED.first_trade_at),
+	last_trade_at = GREATEST(s.last_trade_at, EXCLUDED.last_trade_at),
+	last_price = CASE WHEN s.last_trade_at IS NULL OR EXCLUDED.last_trade_at >= s.last_trade_at
+		THEN EXCLUDED.last_price ELSE s.last_price END,
+	last_volume = CASE WHEN s.last_trade_at IS NULL OR EXCLUDED.last_trade_at >= s.last_trade_at
+		THEN EXCLUDED.last_volume ELSE s.last_volume END,
+	-- A later day starts over, and an earlier one no longer matters.
+	day_date = GREATEST(s.day_date, EXCLUDED.day_date),
+	day_open_at = CASE
+		WHEN s.day_date IS NULL OR s.day_date < EXCLUDED.day_date THEN EXCLUDED.day_open_at
+		WHEN s.day_date > EXCLUDED.day_date THEN s.day_open_at
+		ELSE LEAST(s.day_open_at, EXCLUDED.day_open_at) END,
+	day_open = CASE
+		WHEN s.day_date IS NULL OR s.day_date < EXCLUDED.day_date THEN EXCLUDED.day_open
+		WHEN s.day_date > EXCLUDED.day_date THEN s.day_open
+		WHEN EXCLUDED.day_open_at < s.day_open_at THEN EXCLUDED.day_open
+		ELSE s.day_open END,
+	day_high = CASE
+		WHEN s.day_date IS NULL OR s.day_date < EXCLUDED.day_date THEN EXCLUDED.day_high
+		WHEN s.day_date > EXCLUDED.day_date THEN s.day_high
+		ELSE GREATEST(s.day_high, EXCLUDED.day_high) END,
+	day_low = CASE
+		WHEN s.day_date IS NULL OR s.day_date < EXCLUDED.day_date THEN EXCLUDED.day_low
+		WHEN s.day_date > EXCLUDED.day_date THEN s.day_low
+		ELSE LEAST(s.day_low, EXCLUDED.day_low) END,
+	day_volume = CASE
+		WHEN s.day_date IS NULL OR s.day_date < EXCLUDED.day_date THEN EXCLUDED.day_volume
+		WHEN s.day_date > EXCLUDED.day_date THEN s.day_volume
+		ELSE s.day_volume + EXCLUDED.day_volume END,
+	-- On a new day, the stored last price is the previous close, unless
+	-- the batch has a later trade from before that day. A late trade
+	-- from the day before cannot change the close stored when this day
+	-- started.
+	day_prev_close = CASE
+		WHEN s.day_date IS NULL OR s.day_date < EXCLUDED.day_date THEN
+			CASE WHEN $16::timestamptz >= s.last_trade_at THEN EXCLUDED.day_prev_close ELSE s.last_price END
+		WHEN s.day_date > EXCLUDED.day_date THEN s.day_prev_close
+		ELSE COALESCE(s.day_prev_close, EXCLUDED.day_prev_close) END`
+
+func (s *PostgresStore) UpdateSymbol(ctx context.Context, summary SymbolSummary) error {
+	exchange, assetClass := ClassifySymbol(summary.Symbol)
+	var class any
+	if assetClass != "" {
+		class = assetClass
+	}
+	day := summary.Day
+	var prevClose any
+	if !summary.PrevCloseAt.IsZero() {
+		prevClose = postgres.DecimalArg(day.PrevClose)
+	}
+	_, err := s.db.ExecContext(ctx, symbolUpsert,
+		summary.Symbol, exchange, class, summary.TradeCount, summary.FirstTradeAt, summary.LastTradeAt,
+		postgres.DecimalArg(summary.LastPrice), postgres.DecimalArg(summary.LastVolume),
+		day.Date, day.OpenAt, postgres.DecimalArg(day.Open), postgres.DecimalArg(day.High),
+		postgres.DecimalArg(day.Low), postgres.DecimalArg(day.Volume), prevClose,
+		postgres.TimeArg(summary.PrevCloseAt))
+	return err
+}
+
+// placeholders returns the VALUES list of rows rows of cols parameters,
+// e.g. "($1, $2), ($3, $4)".
+func placeholders(rows, cols int) string {
+	var b strings.Builder
+	n := 1
+	for r := 0; r < rows; r++ {
+		if r > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteByte('(')
+		for c := 0; c < cols; c++ {
+			if c > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "$%d", n)
+			n++
+		}
+		b.WriteByte(')')
+	}
+	return b.String()
+}
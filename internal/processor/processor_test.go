@@ -2,14 +2,24 @@ package processor
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"financial-data-backend-2/internal/config"
+	"financial-data-backend-2/internal/events"
 	"financial-data-backend-2/internal/models"
 	mongoGo "financial-data-backend-2/internal/mongo"
+	"financial-data-backend-2/internal/postgres"
+	"fmt"
 	"log"
+	"net"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/lib/pq"
 	kafkaGo "github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
 	"go.mongodb.org/mongo-driver/bson"
@@ -99,6 +109,40 @@ func TestTransformMessage(t *testing.T) {
 				assert.Equal(t, "key-topic-2-101-MSFT-1700000000000-1", record2.MessageKey)
 			},
 		},
+		{
+			name: "should transform a versioned trade event",
+			inputMessage: kafkaGo.Message{
+				Topic:     "test-topic",
+				Partition: 0,
+				Offset:    7,
+				Headers:   []kafkaGo.Header{{Key: events.HeaderSchemaVersion, Value: []byte(events.TradeSchemaVersion)}},
+				Value: []byte(`{"symbol":"BINANCE:BTCUSDT","price":"64250.12","volume":"0.0042",` +
+					`"exchange_time":1700000000123,"receive_time":1700000000200,"source":"finnhub","sequence":3,"conditions":["1","I"]}`),
+			},
+			expectError:   false,
+			expectNilData: false,
+			assertions: func(t *testing.T, data *ProcessedData) {
+				assert.Len(t, data.TradeRecords, 1)
+				record := data.TradeRecords[0].(models.TradeRecord)
+
+				// Same key format as legacy messages, with the event's sequence
+				assert.Equal(t, "test-topic-0-7-BINANCE:BTCUSDT-1700000000123-3", record.MessageKey)
+				assert.Equal(t, "64250.12", record.Price.String())
+				assert.Equal(t, "0.0042", record.Volume.String())
+				assert.Equal(t, int64(1700000000123), record.Time.UnixMilli())
+				assert.Equal(t, []string{"1", "I"}, record.Conditions)
+				assert.Equal(t, int64(1), data.SymbolTradeCounts["BINANCE:BTCUSDT"])
+			},
+		},
+		{
+			name: "should reject an unknown schema version",
+			inputMessage: kafkaGo.Message{
+				Headers: []kafkaGo.Header{{Key: events.HeaderSchemaVersion, Value: []byte("99")}},
+				Value:   []byte(`{}`),
+			},
+			expectError:   true,
+			expectNilData: true,
+		},
 	}
 
 	// --- RUNNER: Loop through all test cases ---
@@ -128,6 +172,506 @@ func TestTransformMessage(t *testing.T) {
 	}
 }
 
+func benchmarkTransformMessage(b *testing.B, codec events.Codec) {
+	trade := models.TradeEvent{
+		Symbol: "AAPL", Price: "196.38", Volume: "265",
+		ExchangeTime: 1700000000123, ReceiveTime: 1700000000200,
+		Source: events.SourceFinnhub,
+	}
+	m, err := events.EncodeTrade(trade, codec)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := TransformMessage(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTransformMessageJSON(b *testing.B)     { benchmarkTransformMessage(b, events.JSON) }
+func BenchmarkTransformMessageProtobuf(b *testing.B) { benchmarkTransformMessage(b, events.Protobuf) }
+
+func TestContentKey(t *testing.T) {
+	frame := []byte(`{"type":"trade","data":[
+		{"s":"MSFT","p":300,"v":10,"t":1700000000000},
+		{"s":"MSFT","p":300,"v":10,"t":1700000000000},
+		{"s":"MSFT","p":300,"v":10,"t":1700000000000,"c":["I"]}
+	]}`)
+	transformer := Transformer{Key: ContentKey}
+	first, err := transformer.Transform(kafkaGo.Message{Topic: "trades", Partition: 0, Offset: 1, Value: frame})
+	if !assert.NoError(t, err) {
+		return
+	}
+	// The same frame, published again by another ingestor
+	second, err := transformer.Transform(kafkaGo.Message{Topic: "trades", Partition: 0, Offset: 9, Value: frame})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	keys := make(map[string]bool)
+	for i := range first.TradeRecords {
+		key := first.TradeRecords[i].(models.TradeRecord).MessageKey
+		assert.Equal(t, key, second.TradeRecords[i].(models.TradeRecord).MessageKey,
+			"the same trade should have the same key, whatever message it came in")
+		assert.Regexp(t, "^content-[0-9a-f]{64}$", key)
+		keys[key] = true
+	}
+	assert.Len(t, keys, 3, "identical trades within a frame, and trades with other conditions, should have their own keys")
+}
+
+func TestSeenCache(t *testing.T) {
+	record := func(key string) interface{} { return models.TradeRecord{MessageKey: key} }
+	cache := NewSeenCache(2)
+	cache.Add([]interface{}{record("a"), record("b")})
+
+	fresh, skipped := cache.Filter([]interface{}{record("a"), record("c")})
+	assert.Equal(t, []interface{}{record("c")}, fresh)
+	assert.Equal(t, 1, skipped)
+
+	// "a" was seen more recently than "b", so "b" is forgotten.
+	cache.Add([]interface{}{record("c")})
+	assert.Equal(t, 2, cache.Len())
+	fresh, skipped = cache.Filter([]interface{}{record("a"), record("b"), record("c")})
+	assert.Equal(t, []interface{}{record("b")}, fresh)
+	assert.Equal(t, 2, skipped)
+}
+
+func TestPartitionPool(t *testing.T) {
+	t.Run("partitions are handled concurrently, each in order", func(t *testing.T) {
+		var mu sync.Mutex
+		handled := map[int][]int64{}
+		// Partition 0 waits for partition 1 to be handled: with one worker
+		// per partition, it does not stall.
+		release := make(chan struct{})
+		pool := NewPartitionPool(2, 10, 1, func(batch []kafkaGo.Message) {
+			m := batch[0]
+			if m.Partition == 0 && m.Offset == 0 {
+				<-release
+			}
+			mu.Lock()
+			handled[m.Partition] = append(handled[m.Partition], m.Offset)
+			mu.Unlock()
+			if m.Partition == 1 && m.Offset == 2 {
+				close(release)
+			}
+		})
+		for offset := int64(0); offset < 3; offset++ {
+			for partition := 0; partition < 2; partition++ {
+				assert.NoError(t, pool.Submit(context.Background(), kafkaGo.Message{Partition: partition, Offset: offset}))
+			}
+		}
+		pool.Close()
+		assert.Equal(t, map[int][]int64{0: {0, 1, 2}, 1: {0, 1, 2}}, handled)
+	})
+
+	t.Run("a full queue blocks until the context is done", func(t *testing.T) {
+		block := make(chan struct{})
+		pool := NewPartitionPool(1, 1, 1, func([]kafkaGo.Message) { <-block })
+		// One message is being handled, and one is queued.
+		assert.NoError(t, pool.Submit(context.Background(), kafkaGo.Message{Offset: 0}))
+		assert.NoError(t, pool.Submit(context.Background(), kafkaGo.Message{Offset: 1}))
+		assert.Eventually(t, func() bool {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			return pool.Submit(ctx, kafkaGo.Message{Offset: 2}) != nil
+		}, time.Second, 20*time.Millisecond)
+		close(block)
+		pool.Close()
+	})
+
+	t.Run("closing finishes the queued messages", func(t *testing.T) {
+		var handled atomic.Int32
+		pool := NewPartitionPool(2, 10, 3, func(batch []kafkaGo.Message) {
+			time.Sleep(time.Millisecond)
+			handled.Add(int32(len(batch)))
+		})
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, pool.Submit(context.Background(), kafkaGo.Message{Partition: i % 3}))
+		}
+		pool.Close()
+		assert.Equal(t, int32(10), handled.Load())
+	})
+
+	t.Run("queued messages are handled together, in order", func(t *testing.T) {
+		var batches [][]int64
+		started, block := make(chan struct{}), make(chan struct{})
+		pool := NewPartitionPool(1, 10, 3, func(batch []kafkaGo.Message) {
+			if batch[0].Offset == 0 {
+				close(started)
+				<-block
+			}
+			var offsets []int64
+			for _, m := range batch {
+				offsets = append(offsets, m.Offset)
+			}
+			batches = append(batches, offsets)
+		})
+		// The first message is being handled while the others queue up.
+		assert.NoError(t, pool.Submit(context.Background(), kafkaGo.Message{Offset: 0}))
+		<-started
+		for offset := int64(1); offset < 6; offset++ {
+			assert.NoError(t, pool.Submit(context.Background(), kafkaGo.Message{Offset: offset}))
+		}
+		close(block)
+		pool.Close()
+		assert.Equal(t, [][]int64{{0}, {1, 2, 3}, {4, 5}}, batches)
+	})
+}
+
+func TestIsTransientError(t *testing.T) {
+	duplicate := mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Code: 11000}}}}
+	testCases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no error", nil, false},
+		{"network error", mongo.CommandError{Code: 9001, Labels: []string{"NetworkError"}}, true},
+		{"timeout", fmt.Errorf("insert: %w", context.DeadlineExceeded), true},
+		{"not primary", mongo.CommandError{Code: 10107, Name: "NotWritablePrimary"}, true},
+		{"stepped down in a bulk write", mongo.BulkWriteException{WriteErrors: []mongo.BulkWriteError{{WriteError: mongo.WriteError{Code: 189}}}}, true},
+		{"retryable write label", mongo.CommandError{Code: 1, Labels: []string{"RetryableWriteError"}}, true},
+		{"write concern", mongo.BulkWriteException{WriteConcernError: &mongo.WriteConcernError{Code: 64}}, true},
+		{"duplicate key", duplicate, false},
+		{"invalid document", mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 121}}}, false},
+		{"postgres shutting down", &pq.Error{Code: "57P01"}, true},
+		{"postgres connection lost", &pq.Error{Code: "08006"}, true},
+		{"postgres serialization failure", &pq.Error{Code: "40001"}, true},
+		{"postgres unique violation", &pq.Error{Code: "23505"}, false},
+		{"connection refused", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"other", errors.New("boom"), false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, IsTransientError(tc.err))
+		})
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	notPrimary := mongo.CommandError{Code: 10107, Name: "NotWritablePrimary"}
+	newBreaker := func() *Breaker {
+		return NewBreaker(config.ProcessorConfig{
+			InitialBackoff:   time.Millisecond,
+			MaxBackoff:       4 * time.Millisecond,
+			BreakerThreshold: 3,
+			BreakerCooldown:  50 * time.Millisecond,
+		})
+	}
+	// failing fails the first n writes.
+	failing := func(n int, err error) (func() error, *int) {
+		calls := 0
+		return func() error {
+			calls++
+			if calls <= n {
+				return err
+			}
+			return nil
+		}, &calls
+	}
+
+	t.Run("transient errors are retried", func(t *testing.T) {
+		b := newBreaker()
+		write, calls := failing(2, notPrimary)
+		assert.NoError(t, b.Do(context.Background(), write))
+		assert.Equal(t, 3, *calls)
+		assert.Equal(t, BreakerStats{State: BreakerClosed, Retries: 2}, b.Stats())
+	})
+
+	t.Run("other errors are returned at once", func(t *testing.T) {
+		b := newBreaker()
+		refused := errors.New("document failed validation")
+		write, calls := failing(1, refused)
+		assert.Equal(t, refused, b.Do(context.Background(), write))
+		assert.Equal(t, 1, *calls)
+	})
+
+	t.Run("writes pause while MongoDB is down", func(t *testing.T) {
+		b := newBreaker()
+		write, calls := failing(4, notPrimary)
+		start := time.Now()
+		assert.NoError(t, b.Do(context.Background(), write))
+		// Three failures open the breaker; after the cooldown, a fourth
+		// reopens it, and the fifth write closes it.
+		assert.Equal(t, 5, *calls)
+		assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+		assert.Equal(t, BreakerStats{State: BreakerClosed, Retries: 4, Opened: 2}, b.Stats())
+	})
+
+	t.Run("other writes wait while it is open", func(t *testing.T) {
+		b := newBreaker()
+		b.record(notPrimary)
+		b.record(notPrimary)
+		b.record(notPrimary)
+		assert.Equal(t, BreakerOpen, b.Stats().State)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		calls := 0
+		err := b.Do(ctx, func() error { calls++; return nil })
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Zero(t, calls, "no write should be tried before the cooldown")
+	})
+
+	t.Run("giving up keeps the last error", func(t *testing.T) {
+		b := newBreaker()
+		ctx, cancel := context.WithCancel(context.Background())
+		err := b.Do(ctx, func() error { cancel(); return notPrimary })
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorAs(t, err, &mongo.CommandError{})
+	})
+}
+
+func TestClassifySymbol(t *testing.T) {
+	testCases := []struct {
+		symbol     string
+		exchange   string
+		assetClass string
+	}{
+		{symbol: "AAPL", exchange: "US", assetClass: models.AssetClassStock},
+		{symbol: "BINANCE:BTCUSDT", exchange: "BINANCE", assetClass: models.AssetClassCrypto},
+		{symbol: "OANDA:EUR_USD", exchange: "OANDA", assetClass: models.AssetClassForex},
+		{symbol: "NEWEX:ABC", exchange: "NEWEX", assetClass: ""},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.symbol, func(t *testing.T) {
+			exchange, assetClass := ClassifySymbol(tt.symbol)
+			assert.Equal(t, tt.exchange, exchange)
+			assert.Equal(t, tt.assetClass, assetClass)
+		})
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	dec := func(s string) primitive.Decimal128 {
+		d, err := primitive.ParseDecimal128(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	day1 := time.Date(2025, 11, 19, 23, 59, 0, 0, time.UTC)
+	day2 := time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+	trade := func(symbol string, at time.Time, price, volume string) models.TradeRecord {
+		return models.TradeRecord{Symbol: symbol, Time: at, Price: dec(price), Volume: dec(volume)}
+	}
+
+	summaries := Summarize([]models.TradeRecord{
+		trade("AAPL", day2.Add(time.Minute), "101.5", "10"),
+		trade("AAPL", day1, "99", "5"),
+		trade("AAPL", day2, "100", "2.5"),
+		trade("AAPL", day2.Add(2*time.Minute), "100.25", "1"),
+		trade("MSFT", day1, "300", "1"),
+	})
+
+	if !assert.Len(t, summaries, 2) {
+		return
+	}
+	aapl := summaries[0]
+	assert.Equal(t, "AAPL", aapl.Symbol)
+	assert.Equal(t, int64(4), aapl.TradeCount)
+	assert.Equal(t, day1, aapl.FirstTradeAt)
+	assert.Equal(t, day2.Add(2*time.Minute), aapl.LastTradeAt)
+	assert.Equal(t, "100.25", aapl.LastPrice.String())
+	assert.Equal(t, "1", aapl.LastVolume.String())
+	// The day only covers the trades of the latest date.
+	assert.Equal(t, "2025-11-20", aapl.Day.Date)
+	assert.Equal(t, day2, aapl.Day.OpenAt)
+	assert.Equal(t, "100", aapl.Day.Open.String())
+	assert.Equal(t, "101.5", aapl.Day.High.String())
+	assert.Equal(t, "100", aapl.Day.Low.String())
+	assert.Equal(t, "13.5", aapl.Day.Volume.String())
+	// The last trade of the day before is the previous close.
+	assert.Equal(t, day1, aapl.PrevCloseAt)
+	assert.Equal(t, "99", aapl.Day.PrevClose.String())
+
+	msft := summaries[1]
+	assert.Equal(t, "MSFT", msft.Symbol)
+	assert.Equal(t, int64(1), msft.TradeCount)
+	assert.Equal(t, "2025-11-19", msft.Day.Date)
+	assert.True(t, msft.PrevCloseAt.IsZero())
+
+	assert.Empty(t, Summarize(nil))
+
+	// Bad ticks do not count.
+	badTick := trade("AAPL", day2.Add(3*time.Minute), "1000", "1")
+	badTick.Flags = []string{models.FlagPriceOutlier}
+	summaries = Summarize([]models.TradeRecord{trade("AAPL", day2, "100", "2.5"), badTick})
+	if assert.Len(t, summaries, 1) {
+		assert.Equal(t, int64(1), summaries[0].TradeCount)
+		assert.Equal(t, day2, summaries[0].LastTradeAt)
+		assert.Equal(t, "100", summaries[0].Day.High.String())
+	}
+	assert.Empty(t, Summarize([]models.TradeRecord{badTick}))
+}
+
+func TestTickValidators(t *testing.T) {
+	now := time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+	trade := func(symbol, price string, at time.Time) models.TradeRecord {
+		p, err := primitive.ParseDecimal128(price)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return models.TradeRecord{Symbol: symbol, Price: p, Time: at}
+	}
+
+	t.Run("positive price", func(t *testing.T) {
+		v := PositivePrice{}
+		assert.Empty(t, v.Validate(trade("AAPL", "0.0001", now)))
+		assert.Equal(t, []string{models.FlagNonPositivePrice}, v.Validate(trade("AAPL", "0", now)))
+		assert.Equal(t, []string{models.FlagNonPositivePrice}, v.Validate(trade("AAPL", "-1.5", now)))
+	})
+
+	t.Run("time window", func(t *testing.T) {
+		v := NewTimeWindow(time.Minute, time.Hour)
+		v.now = func() time.Time { return now }
+		assert.Empty(t, v.Validate(trade("AAPL", "100", now.Add(time.Minute))))
+		assert.Empty(t, v.Validate(trade("AAPL", "100", now.Add(-time.Hour))))
+		assert.Equal(t, []string{models.FlagFutureTime}, v.Validate(trade("AAPL", "100", now.Add(2*time.Minute))))
+		assert.Equal(t, []string{models.FlagStaleTime}, v.Validate(trade("AAPL", "100", now.Add(-2*time.Hour))))
+
+		// A backlog is judged by when it was received, not when it is read
+		received := trade("AAPL", "100", now.Add(-2*time.Hour))
+		received.ReceivedAt = now.Add(-2 * time.Hour)
+		assert.Empty(t, v.Validate(received))
+		received.ReceivedAt = now.Add(-4 * time.Hour)
+		assert.Equal(t, []string{models.FlagFutureTime}, v.Validate(received))
+
+		unbounded := NewTimeWindow(0, 0)
+		assert.Empty(t, unbounded.Validate(trade("AAPL", "100", now.AddDate(-1, 0, 0))))
+	})
+
+	t.Run("median band", func(t *testing.T) {
+		v := NewMedianBand(0.1, 5)
+		// Not enough history to judge yet
+		assert.Empty(t, v.Validate(trade("AAPL", "100", now)))
+		assert.Empty(t, v.Validate(trade("AAPL", "150", now)))
+		for _, p := range []string{"101", "99", "100"} {
+			assert.Empty(t, v.Validate(trade("AAPL", p, now)))
+		}
+		// Median of 100, 150, 101, 99, 100 is 100
+		assert.Empty(t, v.Validate(trade("AAPL", "109.9", now)))
+		assert.Equal(t, []string{models.FlagPriceOutlier}, v.Validate(trade("AAPL", "1000", now)), "fat finger")
+		assert.Equal(t, []string{models.FlagPriceOutlier}, v.Validate(trade("AAPL", "10", now)), "fat finger")
+		// Other symbols have their own median
+		assert.Empty(t, v.Validate(trade("MSFT", "1000", now)))
+		// Prices that are not positive are left to PositivePrice.
+		assert.Empty(t, v.Validate(trade("AAPL", "0", now)))
+
+		// A lasting move is accepted once it fills half of the window.
+		var flagged int
+		for i := 0; i < 5; i++ {
+			if len(v.Validate(trade("AAPL", "1000", now))) > 0 {
+				flagged++
+			}
+		}
+		assert.Equal(t, 2, flagged)
+	})
+
+	t.Run("transform message", func(t *testing.T) {
+		m := kafkaGo.Message{Value: []byte(`{"type":"trade","data":[{"s":"AAPL","p":0,"v":1,"t":1678886400123},{"s":"AAPL","p":150.75,"v":1,"t":1678886400124}]}`)}
+		data, err := TransformMessage(m, NewTickValidators(config.ValidationConfig{MaxPast: -1})...)
+		if assert.NoError(t, err) && assert.Len(t, data.TradeRecords, 2) {
+			assert.Equal(t, []string{models.FlagNonPositivePrice}, data.TradeRecords[0].(models.TradeRecord).Flags)
+			assert.Empty(t, data.TradeRecords[1].(models.TradeRecord).Flags)
+		}
+
+		// The trades are from 2023, long before now.
+		data, err = TransformMessage(m, NewTickValidators(config.ValidationConfig{})...)
+		if assert.NoError(t, err) && assert.Len(t, data.TradeRecords, 2) {
+			assert.Equal(t, []string{models.FlagNonPositivePrice, models.FlagStaleTime},
+				data.TradeRecords[0].(models.TradeRecord).Flags)
+			assert.Equal(t, []string{models.FlagStaleTime}, data.TradeRecords[1].(models.TradeRecord).Flags)
+		}
+	})
+}
+
+func TestLatenessPolicy(t *testing.T) {
+	now := time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+	trade := func(key, symbol string, at time.Time, flags ...string) interface{} {
+		return models.TradeRecord{MessageKey: key, Symbol: symbol, Time: at, Flags: flags}
+	}
+	keys := func(records []interface{}) []string {
+		var out []string
+		for _, r := range records {
+			out = append(out, r.(models.TradeRecord).MessageKey)
+		}
+		return out
+	}
+
+	t.Run("late trades are set aside", func(t *testing.T) {
+		p := NewLatenessPolicy(config.LatenessConfig{AllowedLateness: 5 * time.Second})
+		p.now = func() time.Time { return now }
+		accepted, late := p.Apply([]interface{}{
+			trade("a", "AAPL", now.Add(-10*time.Second)),
+			trade("b", "AAPL", now.Add(-20*time.Second)),                          // behind the watermark
+			trade("c", "AAPL", now.Add(-14*time.Second)),                          // late, but allowed
+			trade("d", "MSFT", now.Add(-time.Hour)),                               // its own watermark
+			trade("e", "AAPL", now, models.FlagPriceOutlier),                      // flagged, does not move it
+			trade("f", "AAPL", now.Add(-12*time.Second), models.FlagPriceOutlier), // within it still
+		})
+		assert.Equal(t, []string{"a", "c", "d", "e", "f"}, keys(accepted))
+		if assert.Len(t, late, 1) {
+			assert.Equal(t, "b", late[0].Id)
+			assert.Equal(t, models.LateReasonLate, late[0].Reason)
+			assert.Equal(t, now.Add(-15*time.Second), late[0].Watermark)
+			assert.Equal(t, now, late[0].ReceivedAt)
+		}
+		assert.Equal(t, LatenessStats{OnTime: 5, Late: 1}, p.Stats())
+	})
+
+	t.Run("future trades are clamped", func(t *testing.T) {
+		p := NewLatenessPolicy(config.LatenessConfig{MaxFutureSkew: time.Second})
+		p.now = func() time.Time { return now }
+		future := now.Add(time.Minute)
+		accepted, late := p.Apply([]interface{}{
+			trade("a", "AAPL", now.Add(time.Second)),
+			trade("b", "AAPL", future, models.FlagFutureTime),
+			trade("c", "AAPL", future, models.FlagFutureTime, models.FlagPriceOutlier),
+		})
+		assert.Empty(t, late)
+		if assert.Len(t, accepted, 3) {
+			b := accepted[1].(models.TradeRecord)
+			assert.Equal(t, now, b.Time)
+			assert.Equal(t, future, b.OriginalTime)
+			assert.Nil(t, b.Flags)
+			assert.Equal(t, []string{models.FlagPriceOutlier}, accepted[2].(models.TradeRecord).Flags)
+		}
+		assert.Equal(t, LatenessStats{OnTime: 1, Clamped: 2}, p.Stats())
+	})
+
+	t.Run("future trades are rejected", func(t *testing.T) {
+		p := NewLatenessPolicy(config.LatenessConfig{
+			AllowedLateness: time.Second, MaxFutureSkew: time.Second, FuturePolicy: config.FutureReject,
+		})
+		p.now = func() time.Time { return now }
+		accepted, late := p.Apply([]interface{}{
+			trade("a", "AAPL", now.Add(time.Hour)),
+			trade("b", "AAPL", now), // the rejected trade did not move the watermark
+		})
+		assert.Equal(t, []string{"b"}, keys(accepted))
+		if assert.Len(t, late, 1) {
+			assert.Equal(t, models.LateReasonFuture, late[0].Reason)
+			assert.True(t, late[0].Watermark.IsZero())
+		}
+		before := p.Stats()
+		p.Apply([]interface{}{trade("c", "AAPL", now.Add(-time.Minute))})
+		assert.Equal(t, LatenessStats{Late: 1}, p.Stats().Sub(before))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		p := NewLatenessPolicy(config.LatenessConfig{})
+		records := []interface{}{trade("a", "AAPL", now.AddDate(1, 0, 0)), trade("b", "AAPL", now.AddDate(-1, 0, 0))}
+		accepted, late := p.Apply(records)
+		assert.Equal(t, records, accepted)
+		assert.Empty(t, late)
+	})
+}
+
 var (
 	databaseName         string = "financialDataProcessorTest"
 	tradesCollectionName string = "finnhub_trades"
@@ -218,3 +762,273 @@ func TestInsertMany_Idempotency(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, int64(2), finalCount, "The document count should still be 2 after the failed second insert")
 }
+
+func TestInsertTrades_Idempotency(t *testing.T) {
+	// --- ARRANGE ---
+	// 1. Setup MongoDB for use, provisioned by the migrations
+	_ = godotenv.Load("../../.env")
+	mongoUrl := os.Getenv("MONGO_URL_TEST")
+	if mongoUrl == "" {
+		log.Fatal("FATAL: MONGO_URL_TEST is not set. Aborting repo integration tests.")
+	}
+	testDbClient, err := mongoGo.ConnectDB(mongoUrl, 15*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	db := testDbClient.Database(databaseName + "Migrated")
+	assert.NoError(t, db.Drop(ctx))
+	defer db.Drop(context.Background())
+	mongoCfg := config.MongoConfig{CollectionName: tradesCollectionName, SymbolsCollectionName: "symbols"}
+	migrator, err := mongoGo.NewMigrator(db, mongoGo.Migrations(mongoCfg))
+	assert.NoError(t, err)
+	_, err = migrator.Up(ctx)
+	assert.NoError(t, err)
+	keys := db.Collection(mongoCfg.KeysCollection())
+	trades := db.Collection(mongoCfg.CollectionName)
+
+	price, _ := primitive.ParseDecimal128("100")
+	volume, _ := primitive.ParseDecimal128("10")
+	record := func(key string) models.TradeRecord {
+		return models.TradeRecord{
+			Id:         primitive.NewObjectID(),
+			MessageKey: key,
+			Symbol:     "TEST",
+			Price:      price,
+			Time:       time.Now().UTC(),
+			Volume:     volume,
+		}
+	}
+
+	// --- ACT & ASSERT ---
+	inserted, err := InsertTrades(ctx, keys, trades, []interface{}{record("k-0"), record("k-1")})
+	assert.NoError(t, err)
+	assert.Len(t, inserted, 2)
+
+	// A redelivered batch with one new trade only stores the new one.
+	inserted, err = InsertTrades(ctx, keys, trades, []interface{}{record("k-0"), record("k-1"), record("k-2")})
+	assert.NoError(t, err)
+	if assert.Len(t, inserted, 1) {
+		assert.Equal(t, "k-2", inserted[0].MessageKey)
+	}
+
+	count, err := trades.CountDocuments(ctx, bson.M{"symbol": "TEST"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	// Keys left pending, e.g. by a crash: k-3 before its trade was written,
+	// k-4 after. Only k-3 is stored when they come again, and both keys
+	// end up confirmed.
+	_, err = keys.InsertMany(ctx, []interface{}{
+		bson.M{"_id": "k-3", "created_at": time.Now().UTC(), "confirmed": false},
+		bson.M{"_id": "k-4", "created_at": time.Now().UTC(), "confirmed": false},
+	})
+	assert.NoError(t, err)
+	_, err = trades.InsertOne(ctx, record("k-4"))
+	assert.NoError(t, err)
+	inserted, err = InsertTrades(ctx, keys, trades, []interface{}{record("k-3"), record("k-4"), record("k-3")})
+	assert.NoError(t, err)
+	if assert.Len(t, inserted, 1) {
+		assert.Equal(t, "k-3", inserted[0].MessageKey)
+	}
+	count, err = trades.CountDocuments(ctx, bson.M{"symbol": "TEST"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), count)
+	pending, err := keys.CountDocuments(ctx, bson.M{"confirmed": false})
+	assert.NoError(t, err)
+	assert.Zero(t, pending)
+
+	// Running the migrations again is a no-op.
+	applied, err := migrator.Up(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+func TestSymbolUpdate_OutOfOrder(t *testing.T) {
+	// --- ARRANGE ---
+	_ = godotenv.Load("../../.env")
+	mongoUrl := os.Getenv("MONGO_URL_TEST")
+	if mongoUrl == "" {
+		log.Fatal("FATAL: MONGO_URL_TEST is not set. Aborting repo integration tests.")
+	}
+	testDbClient, err := mongoGo.ConnectDB(mongoUrl, 15*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	symbols := testDbClient.Database(databaseName).Collection("symbols")
+	assert.NoError(t, symbols.Drop(ctx))
+	defer symbols.Drop(context.Background())
+
+	dec := func(s string) primitive.Decimal128 {
+		d, _ := primitive.ParseDecimal128(s)
+		return d
+	}
+	day := time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+	trade := func(at time.Time, price, volume string) models.TradeRecord {
+		return models.TradeRecord{Symbol: "BINANCE:BTCUSDT", Time: at, Price: dec(price), Volume: dec(volume)}
+	}
+	apply := func(batches ...[]models.TradeRecord) models.SymbolDocument {
+		for _, batch := range batches {
+			for _, s := range Summarize(batch) {
+				filter, update := SymbolUpdate(s)
+				_, err := symbols.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+				assert.NoError(t, err)
+			}
+		}
+		var doc models.SymbolDocument
+		assert.NoError(t, symbols.FindOne(ctx, bson.M{"symbol": "BINANCE:BTCUSDT"}).Decode(&doc))
+		return doc
+	}
+
+	// --- ACT ---
+	doc := apply(
+		[]models.TradeRecord{trade(day.Add(time.Minute), "101", "1")},
+		// Arrives late: an earlier open and a lower low
+		[]models.TradeRecord{trade(day, "99", "2")},
+		// The previous day no longer changes the day summary
+		[]models.TradeRecord{trade(day.Add(-24*time.Hour), "50", "100")},
+	)
+
+	// --- ASSERT ---
+	assert.Equal(t, "BINANCE", doc.Exchange)
+	assert.Equal(t, models.AssetClassCrypto, doc.AssetClass)
+	assert.Equal(t, int64(3), doc.TradeCount)
+	assert.True(t, doc.FirstTradeAt.Equal(day.Add(-24*time.Hour)))
+	assert.True(t, doc.LastTradeAt.Equal(day.Add(time.Minute)))
+	assert.Equal(t, "101", doc.LastPrice.String())
+	assert.Equal(t, "1", doc.LastVolume.String())
+	if assert.NotNil(t, doc.Day) {
+		assert.Equal(t, "2025-11-20", doc.Day.Date)
+		assert.True(t, doc.Day.OpenAt.Equal(day))
+		assert.Equal(t, "99", doc.Day.Open.String())
+		assert.Equal(t, "101", doc.Day.High.String())
+		assert.Equal(t, "99", doc.Day.Low.String())
+		assert.Equal(t, "3", doc.Day.Volume.String())
+	}
+
+	// The next day starts over, closing the day before at 101.
+	doc = apply([]models.TradeRecord{trade(day.Add(24*time.Hour), "102", "4")})
+	assert.Equal(t, int64(4), doc.TradeCount)
+	assert.Equal(t, "102", doc.LastPrice.String())
+	if assert.NotNil(t, doc.Day) {
+		assert.Equal(t, "2025-11-21", doc.Day.Date)
+		assert.Equal(t, "102", doc.Day.Open.String())
+		assert.Equal(t, "4", doc.Day.Volume.String())
+		assert.Equal(t, "101", doc.Day.PrevClose.String())
+	}
+}
+
+func TestPostgresStore(t *testing.T) {
+	// --- ARRANGE ---
+	// 1. Setup a Postgres schema for use, provisioned by the migrations
+	_ = godotenv.Load("../../.env")
+	postgresUrl := os.Getenv("POSTGRES_URL_TEST")
+	if postgresUrl == "" {
+		t.Skip("POSTGRES_URL_TEST is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	const schema = "financial_data_processor_test"
+	admin, err := postgres.ConnectDB(postgresUrl, 15*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to connect to Postgres: %v", err)
+	}
+	defer admin.Close()
+	_, err = admin.ExecContext(ctx, `DROP SCHEMA IF EXISTS `+schema+` CASCADE; CREATE SCHEMA `+schema)
+	assert.NoError(t, err)
+	defer admin.ExecContext(context.Background(), `DROP SCHEMA `+schema+` CASCADE`)
+
+	schemaUrl, err := postgres.InSchema(postgresUrl, schema)
+	assert.NoError(t, err)
+	db, err := postgres.ConnectDB(schemaUrl, 15*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to connect to Postgres: %v", err)
+	}
+	defer db.Close()
+	migrator, err := postgres.NewMigrator(db, postgres.Migrations())
+	assert.NoError(t, err)
+	_, err = migrator.Up(ctx)
+	assert.NoError(t, err)
+	store := NewPostgresStore(db)
+
+	dec := func(s string) primitive.Decimal128 {
+		d, _ := primitive.ParseDecimal128(s)
+		return d
+	}
+	day := time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+	record := func(key string, at time.Time, price, volume string) interface{} {
+		return models.TradeRecord{MessageKey: key, Symbol: "BINANCE:BTCUSDT", Time: at,
+			Price: dec(price), Volume: dec(volume)}
+	}
+
+	t.Run("trades are stored once, across partitions", func(t *testing.T) {
+		// A trade from the month before goes to another partition.
+		inserted, err := store.InsertTrades(ctx, []interface{}{
+			record("k-0", day, "100", "1"), record("k-1", day.AddDate(0, -1, 0), "100", "1"),
+		})
+		assert.NoError(t, err)
+		assert.Len(t, inserted, 2)
+
+		// A redelivered batch with one new trade only stores the new one.
+		inserted, err = store.InsertTrades(ctx, []interface{}{
+			record("k-0", day, "100", "1"), record("k-1", day.AddDate(0, -1, 0), "100", "1"),
+			record("k-2", day, "100", "1"), record("k-2", day, "100", "1"),
+		})
+		assert.NoError(t, err)
+		if assert.Len(t, inserted, 1) {
+			assert.Equal(t, "k-2", inserted[0].MessageKey)
+		}
+
+		var count int
+		assert.NoError(t, db.QueryRowContext(ctx, `SELECT count(*) FROM `+postgres.TradesTable).Scan(&count))
+		assert.Equal(t, 3, count)
+	})
+
+	t.Run("symbol updates merge out of order", func(t *testing.T) {
+		apply := func(batches ...[]models.TradeRecord) {
+			for _, batch := range batches {
+				for _, s := range Summarize(batch) {
+					assert.NoError(t, store.UpdateSymbol(ctx, s))
+				}
+			}
+		}
+		trade := func(at time.Time, price, volume string) models.TradeRecord {
+			return record("", at, price, volume).(models.TradeRecord)
+		}
+		var tradeCount int64
+		var lastPrice, open, low, volume, prevClose sql.NullString
+		read := func() {
+			err := db.QueryRowContext(ctx, `SELECT trade_count, last_price, day_open, day_low, day_volume,
+				day_prev_close FROM `+postgres.SymbolsTable+` WHERE symbol = 'BINANCE:BTCUSDT'`).
+				Scan(&tradeCount, &lastPrice, &open, &low, &volume, &prevClose)
+			assert.NoError(t, err)
+		}
+
+		apply(
+			[]models.TradeRecord{trade(day.Add(time.Minute), "101", "1")},
+			// Arrives late: an earlier open and a lower low
+			[]models.TradeRecord{trade(day, "99", "2")},
+			// The previous day no longer changes the day summary
+			[]models.TradeRecord{trade(day.Add(-24*time.Hour), "50", "100")},
+		)
+		read()
+		assert.Equal(t, int64(3), tradeCount)
+		assert.Equal(t, "101", lastPrice.String)
+		assert.Equal(t, "99", open.String)
+		assert.Equal(t, "99", low.String)
+		assert.Equal(t, "3", volume.String)
+
+		// The next day starts over, closing the day before at 101.
+		apply([]models.TradeRecord{trade(day.Add(24*time.Hour), "102", "4")})
+		read()
+		assert.Equal(t, int64(4), tradeCount)
+		assert.Equal(t, "102", open.String)
+		assert.Equal(t, "4", volume.String)
+		assert.Equal(t, "101", prevClose.String)
+	})
+}
@@ -0,0 +1,266 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"financial-data-backend-2/internal/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Store is where the processor writes trades and symbol metadata:
+// MongoDB or Postgres, as configured by config.StorageConfig.
+type Store interface {
+	// InsertTrades stores trade records that have not been stored
+	// before and returns the ones it inserted. It may insert some
+	// records and still return an error.
+	InsertTrades(ctx context.Context, records []interface{}) ([]models.TradeRecord, error)
+	// InsertLateTrades stores the trades set aside by the lateness
+	// policy, skipping those set aside before.
+	InsertLateTrades(ctx context.Context, trades []models.LateTrade) error
+	// UpdateSymbol merges a summary into its symbol's metadata.
+	UpdateSymbol(ctx context.Context, s SymbolSummary) error
+}
+
+// MongoStore keeps trades in a time-series collection and symbol
+// metadata in the symbols collection.
+type MongoStore struct {
+	keys    *mongo.Collection
+	trades  *mongo.Collection
+	late    *mongo.Collection
+	symbols *mongo.Collection
+}
+
+func NewMongoStore(keyCollection, tradeCollection, lateCollection, symbolCollection *mongo.Collection) *MongoStore {
+	return &MongoStore{keys: keyCollection, trades: tradeCollection, late: lateCollection, symbols: symbolCollection}
+}
+
+func (s *MongoStore) InsertTrades(ctx context.Context, records []interface{}) ([]models.TradeRecord, error) {
+	return InsertTrades(ctx, s.keys, s.trades, records)
+}
+
+func (s *MongoStore) InsertLateTrades(ctx context.Context, trades []models.LateTrade) error {
+	return InsertLateTrades(ctx, s.late, trades)
+}
+
+// UpdateSymbol merges the count, first/last trade, last price and the
+// day's open/high/low/volume (or creates the document).
+func (s *MongoStore) UpdateSymbol(ctx context.Context, summary SymbolSummary) error {
+	filter, update := SymbolUpdate(summary)
+	_, err := s.symbols.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// InsertTrades stores trade records that have not been stored before and
+// returns the ones it inserted. It may insert some records and still
+// return an error.
+//
+// The trades collection is a time-series collection, which cannot have a
+// unique index, so each record's MessageKey is first claimed in the keys
+// collection (as its _id), as pending, and confirmed once its trade is
+// written. Records whose key is confirmed are duplicates and are skipped.
+// A key left pending, by a crash or a failed or unacknowledged write, is
+// checked against the trades collection when its record comes again: the
+// record is skipped if its trade is there and written otherwise. Keys are
+// never released, so a write that failed but was applied after all is not
+// stored twice.
+//
+// Two processors that claim the same key at once, e.g. with content keys,
+// may both find it pending without a trade and both store the trade.
+func InsertTrades(ctx context.Context, keys, trades *mongo.Collection, records []interface{}) ([]models.TradeRecord, error) {
+	now := time.Now().UTC()
+	keyDocs := make([]interface{}, len(records))
+	for i, r := range records {
+		keyDocs[i] = bson.M{"_id": r.(models.TradeRecord).MessageKey, "created_at": now, "confirmed": false}
+	}
+
+	// Claim keys; with ordered=false every key is tried. If the claim
+	// fails, the keys it took are left pending.
+	taken := make(map[int]bool)
+	_, err := keys.InsertMany(ctx, keyDocs, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		var e mongo.BulkWriteException
+		if !errors.As(err, &e) || e.WriteConcernError != nil {
+			return nil, err
+		}
+		for _, we := range e.WriteErrors {
+			if we.Code != 11000 {
+				return nil, err
+			}
+			taken[we.Index] = true
+		}
+	}
+	if len(taken) > 0 {
+		unstored, err := unstoredKeys(ctx, keys, trades, records, taken)
+		if err != nil {
+			return nil, err
+		}
+		for i := range unstored {
+			delete(taken, i)
+		}
+	}
+
+	fresh := make([]interface{}, 0, len(records))
+	for i, r := range records {
+		if !taken[i] {
+			fresh = append(fresh, r)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil, nil
+	}
+
+	_, err = trades.InsertMany(ctx, fresh, options.InsertMany().SetOrdered(false))
+	if err != nil {
+		// Which trades were written is only known from the write errors;
+		// otherwise the retry finds out from the pending keys.
+		var e mongo.BulkWriteException
+		if !errors.As(err, &e) || e.WriteConcernError != nil {
+			return nil, err
+		}
+		failed := make(map[int]bool, len(e.WriteErrors))
+		for _, we := range e.WriteErrors {
+			failed[we.Index] = true
+		}
+		stored := written(fresh, failed)
+		confirm(keys, stored)
+		return stored, err
+	}
+	stored := written(fresh, nil)
+	confirm(keys, stored)
+	return stored, nil
+}
+
+// unstoredKeys returns, of the records whose key was taken, by index, those
+// whose key is still pending and whose trade is not in the trades
+// collection, and confirms the keys of those whose trade is. A key taken by
+// an earlier record of the same batch is not pending from before, and its
+// record is a duplicate.
+func unstoredKeys(ctx context.Context, keys, trades *mongo.Collection, records []interface{}, taken map[int]bool) (map[int]bool, error) {
+	claimedNow := make(map[string]bool, len(records))
+	for i, r := range records {
+		if !taken[i] {
+			claimedNow[r.(models.TradeRecord).MessageKey] = true
+		}
+	}
+	var ids []string
+	for i := range taken {
+		if key := records[i].(models.TradeRecord).MessageKey; !claimedNow[key] {
+			ids = append(ids, key)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	// Keys claimed before pending keys were introduced have no confirmed
+	// field, and count as confirmed.
+	pending, err := distinctStrings(ctx, keys, "_id", bson.M{"_id": bson.M{"$in": ids}, "confirmed": false})
+	if err != nil {
+		return nil, err
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	stored, err := distinctStrings(ctx, trades, "message_key", bson.M{"message_key": bson.M{"$in": pending}})
+	if err != nil {
+		return nil, err
+	}
+	if len(stored) > 0 {
+		if _, err := keys.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": stored}},
+			bson.M{"$set": bson.M{"confirmed": true}}); err != nil {
+			return nil, err
+		}
+	}
+
+	unstored := make(map[string]bool, len(pending))
+	for _, key := range pending {
+		unstored[key] = true
+	}
+	for _, key := range stored {
+		delete(unstored, key)
+	}
+	out := make(map[int]bool, len(unstored))
+	for i := range taken {
+		key := records[i].(models.TradeRecord).MessageKey
+		if unstored[key] {
+			out[i] = true
+			// Written once, if the batch repeats it
+			delete(unstored, key)
+		}
+	}
+	return out, nil
+}
+
+func distinctStrings(ctx context.Context, c *mongo.Collection, field string, filter bson.M) ([]string, error) {
+	values, err := c.Distinct(ctx, field, filter)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+// written returns the records that are not in failed, by index.
+func written(records []interface{}, failed map[int]bool) []models.TradeRecord {
+	out := make([]models.TradeRecord, 0, len(records)-len(failed))
+	for i, r := range records {
+		if !failed[i] {
+			out = append(out, r.(models.TradeRecord))
+		}
+	}
+	return out
+}
+
+// confirm marks the keys of the stored records as confirmed. If it fails,
+// they stay pending, and are checked against the trades collection when
+// their records come again.
+func confirm(keys *mongo.Collection, stored []models.TradeRecord) {
+	if len(stored) == 0 {
+		return
+	}
+	ids := make([]string, len(stored))
+	for i, r := range stored {
+		ids[i] = r.MessageKey
+	}
+	// The caller's context may be what failed, so use a fresh one.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := keys.UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}},
+		bson.M{"$set": bson.M{"confirmed": true}}); err != nil {
+		log.Printf("Failed to confirm %d idempotency key(s), they are checked again if their trades come again: %v",
+			len(ids), err)
+	}
+}
+
+// InsertLateTrades stores the trades set aside by the lateness policy.
+// Trades set aside before, e.g. when a message is read again, are skipped.
+func InsertLateTrades(ctx context.Context, late *mongo.Collection, trades []models.LateTrade) error {
+	if len(trades) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(trades))
+	for i, t := range trades {
+		docs[i] = t
+	}
+	_, err := late.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	var e mongo.BulkWriteException
+	if errors.As(err, &e) && e.WriteConcernError == nil {
+		for _, we := range e.WriteErrors {
+			if we.Code != 11000 {
+				return err
+			}
+		}
+		return nil
+	}
+	return err
+}
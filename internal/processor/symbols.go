@@ -0,0 +1,271 @@
+package processor
+
+import (
+	"financial-data-backend-2/internal/models"
+	"math/big"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Exchange of symbols without an "EXCHANGE:" prefix, which Finnhub uses
+// for US stocks.
+const defaultExchange = "US"
+
+// Asset classes of the exchanges Finnhub prefixes symbols with.
+var exchangeAssetClasses = map[string]string{
+	"BINANCE":     models.AssetClassCrypto,
+	"BITFINEX":    models.AssetClassCrypto,
+	"BITSTAMP":    models.AssetClassCrypto,
+	"BITTREX":     models.AssetClassCrypto,
+	"COINBASE":    models.AssetClassCrypto,
+	"GEMINI":      models.AssetClassCrypto,
+	"HITBTC":      models.AssetClassCrypto,
+	"HUOBI":       models.AssetClassCrypto,
+	"KRAKEN":      models.AssetClassCrypto,
+	"KUCOIN":      models.AssetClassCrypto,
+	"OKEX":        models.AssetClassCrypto,
+	"POLONIEX":    models.AssetClassCrypto,
+	"FOREXCOM":    models.AssetClassForex,
+	"FXCM":        models.AssetClassForex,
+	"FXPRO":       models.AssetClassForex,
+	"ICMTRADER":   models.AssetClassForex,
+	"OANDA":       models.AssetClassForex,
+	"OCTAFX":      models.AssetClassForex,
+	"PEPPERSTONE": models.AssetClassForex,
+	"SAXO":        models.AssetClassForex,
+}
+
+// ClassifySymbol derives the exchange and asset class of a Finnhub symbol,
+// e.g. "BINANCE:BTCUSDT" is crypto on BINANCE and "AAPL" a US stock. The
+// asset class is empty for exchanges it does not know.
+func ClassifySymbol(symbol string) (exchange, assetClass string) {
+	prefix, _, found := strings.Cut(symbol, ":")
+	if !found {
+		return defaultExchange, models.AssetClassStock
+	}
+	exchange = strings.ToUpper(prefix)
+	return exchange, exchangeAssetClasses[exchange]
+}
+
+// SymbolSummary is what a batch of trades adds to a symbol's metadata.
+type SymbolSummary struct {
+	Symbol       string
+	TradeCount   int64
+	FirstTradeAt time.Time
+	LastTradeAt  time.Time
+	LastPrice    primitive.Decimal128
+	LastVolume   primitive.Decimal128
+	// Day covers the batch's trades on the latest day it has trades on.
+	Day models.DaySummary
+	// The batch's last trade before Day, if any. Its price is the
+	// previous close unless an even later trade is already stored.
+	PrevCloseAt time.Time
+}
+
+// Summarize groups trade records by symbol, sorted by symbol. Flagged
+// trades are left out, as they are of candles.
+func Summarize(records []models.TradeRecord) []SymbolSummary {
+	bySymbol := make(map[string]*SymbolSummary)
+	for _, r := range records {
+		if len(r.Flags) > 0 {
+			continue
+		}
+		s, ok := bySymbol[r.Symbol]
+		if !ok {
+			bySymbol[r.Symbol] = &SymbolSummary{
+				Symbol:       r.Symbol,
+				TradeCount:   1,
+				FirstTradeAt: r.Time,
+				LastTradeAt:  r.Time,
+				LastPrice:    r.Price,
+				LastVolume:   r.Volume,
+				Day:          newDay(r),
+			}
+			continue
+		}
+
+		s.TradeCount++
+		if r.Time.Before(s.FirstTradeAt) {
+			s.FirstTradeAt = r.Time
+		}
+		if !r.Time.Before(s.LastTradeAt) {
+			s.LastTradeAt = r.Time
+			s.LastPrice = r.Price
+			s.LastVolume = r.Volume
+		}
+
+		switch date := dayOf(r.Time); {
+		case date > s.Day.Date:
+			s.Day = newDay(r)
+		case date == s.Day.Date:
+			if r.Time.Before(s.Day.OpenAt) {
+				s.Day.OpenAt = r.Time
+				s.Day.Open = r.Price
+			}
+			if compareDecimal(r.Price, s.Day.High) > 0 {
+				s.Day.High = r.Price
+			}
+			if compareDecimal(r.Price, s.Day.Low) < 0 {
+				s.Day.Low = r.Price
+			}
+			s.Day.Volume = addDecimal(s.Day.Volume, r.Volume)
+		}
+	}
+
+	// The previous close is only known once the latest day is.
+	for _, r := range records {
+		if len(r.Flags) > 0 {
+			continue
+		}
+		s := bySymbol[r.Symbol]
+		if dayOf(r.Time) < s.Day.Date && !r.Time.Before(s.PrevCloseAt) {
+			s.PrevCloseAt = r.Time
+			s.Day.PrevClose = r.Price
+		}
+	}
+
+	summaries := make([]SymbolSummary, 0, len(bySymbol))
+	for _, s := range bySymbol {
+		summaries = append(summaries, *s)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Symbol < summaries[j].Symbol })
+	return summaries
+}
+
+func newDay(r models.TradeRecord) models.DaySummary {
+	return models.DaySummary{
+		Date:   dayOf(r.Time),
+		OpenAt: r.Time,
+		Open:   r.Price,
+		High:   r.Price,
+		Low:    r.Price,
+		Volume: r.Volume,
+	}
+}
+
+// dayOf is the UTC date of t, which sorts as a string.
+func dayOf(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
+}
+
+// SymbolUpdate is the filter and update pipeline that merge a summary into
+// the symbol's document, creating it if needed. The merge is commutative,
+// so batches may be applied in any order, e.g. when a trade arrives late.
+func SymbolUpdate(s SymbolSummary) (bson.M, mongo.Pipeline) {
+	exchange, assetClass := ClassifySymbol(s.Symbol)
+	day := s.Day
+	// On a new day, the stored last price is the previous close, unless
+	// the batch has a later trade from before that day.
+	var prevClose any = "$lastPrice"
+	if !s.PrevCloseAt.IsZero() {
+		prevClose = bson.M{"$cond": bson.A{
+			bson.M{"$gte": bson.A{s.PrevCloseAt, "$lastTradeAt"}}, day.PrevClose, "$lastPrice",
+		}}
+	}
+	newDay := bson.M{
+		"date":      day.Date,
+		"openAt":    day.OpenAt,
+		"open":      day.Open,
+		"high":      day.High,
+		"low":       day.Low,
+		"volume":    day.Volume,
+		"prevClose": prevClose,
+	}
+	// Stages see the document as it was before the update, so every field
+	// is computed in one $set. A missing field sorts before any value.
+	set := bson.M{
+		"exchange":     bson.M{"$literal": exchange},
+		"tradeCount":   bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$tradeCount", 0}}, s.TradeCount}},
+		"firstTradeAt": bson.M{"$min": bson.A{"$firstTradeAt", s.FirstTradeAt}},
+		"lastTradeAt":  bson.M{"$max": bson.A{"$lastTradeAt", s.LastTradeAt}},
+		"lastPrice": bson.M{"$cond": bson.A{
+			bson.M{"$gte": bson.A{s.LastTradeAt, "$lastTradeAt"}}, s.LastPrice, "$lastPrice",
+		}},
+		"lastVolume": bson.M{"$cond": bson.A{
+			bson.M{"$gte": bson.A{s.LastTradeAt, "$lastTradeAt"}}, s.LastVolume, "$lastVolume",
+		}},
+		"day": bson.M{"$switch": bson.M{
+			"branches": bson.A{
+				// A later day starts over.
+				bson.M{"case": bson.M{"$lt": bson.A{"$day.date", day.Date}}, "then": newDay},
+				// An earlier day no longer matters.
+				bson.M{"case": bson.M{"$gt": bson.A{"$day.date", day.Date}}, "then": "$day"},
+			},
+			"default": bson.M{
+				"date":   day.Date,
+				"openAt": bson.M{"$min": bson.A{"$day.openAt", day.OpenAt}},
+				"open": bson.M{"$cond": bson.A{
+					bson.M{"$lt": bson.A{day.OpenAt, "$day.openAt"}}, day.Open, "$day.open",
+				}},
+				"high":   bson.M{"$max": bson.A{"$day.high", day.High}},
+				"low":    bson.M{"$min": bson.A{"$day.low", day.Low}},
+				"volume": bson.M{"$add": bson.A{"$day.volume", day.Volume}},
+				// A late trade from the day before cannot change the close
+				// stored when this day started.
+				"prevClose": bson.M{"$ifNull": bson.A{"$day.prevClose", prevCloseOrRemove(s)}},
+			},
+		}},
+	}
+	if assetClass != "" {
+		set["assetClass"] = bson.M{"$literal": assetClass}
+	}
+	return bson.M{"symbol": s.Symbol}, mongo.Pipeline{{{Key: "$set", Value: set}}}
+}
+
+// prevCloseOrRemove is the batch's previous close, or leaves the field
+// out if it has none.
+func prevCloseOrRemove(s SymbolSummary) any {
+	if s.PrevCloseAt.IsZero() {
+		return "$$REMOVE"
+	}
+	return s.Day.PrevClose
+}
+
+// compareDecimal compares two finite decimals like big.Int.Cmp.
+func compareDecimal(a, b primitive.Decimal128) int {
+	x, y := alignDecimals(a, b)
+	return x.Cmp(y)
+}
+
+// addDecimal adds two finite decimals exactly, as long as the result fits.
+func addDecimal(a, b primitive.Decimal128) primitive.Decimal128 {
+	x, y := alignDecimals(a, b)
+	exp := min(exponent(a), exponent(b))
+	sum, ok := primitive.ParseDecimal128FromBigInt(x.Add(x, y), exp)
+	if !ok {
+		return a
+	}
+	return sum
+}
+
+// alignDecimals returns the coefficients of a and b scaled to the smaller
+// of their exponents. NaN and infinity count as zero.
+func alignDecimals(a, b primitive.Decimal128) (*big.Int, *big.Int) {
+	x, xExp := coefficient(a)
+	y, yExp := coefficient(b)
+	ten := big.NewInt(10)
+	if xExp > yExp {
+		x.Mul(x, new(big.Int).Exp(ten, big.NewInt(int64(xExp-yExp)), nil))
+	} else if yExp > xExp {
+		y.Mul(y, new(big.Int).Exp(ten, big.NewInt(int64(yExp-xExp)), nil))
+	}
+	return x, y
+}
+
+func coefficient(d primitive.Decimal128) (*big.Int, int) {
+	bi, exp, err := d.BigInt()
+	if err != nil {
+		return new(big.Int), 0
+	}
+	return bi, exp
+}
+
+func exponent(d primitive.Decimal128) int {
+	_, exp := coefficient(d)
+	return exp
+}
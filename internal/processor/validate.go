@@ -0,0 +1,142 @@
+package processor
+
+import (
+	"financial-data-backend-2/internal/config"
+	"financial-data-backend-2/internal/models"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TickValidator checks a trade for signs of a bad tick, e.g. a fat-finger
+// price, and returns the flags to tag it with (see models.Flag*), if any.
+// Validators may keep state, such as recent prices, and are called with
+// the trades in the order they are read.
+type TickValidator interface {
+	Validate(trade models.TradeRecord) []string
+}
+
+// NewTickValidators returns the validators configured by cfg.
+func NewTickValidators(cfg config.ValidationConfig) []TickValidator {
+	cfg = cfg.WithDefaults()
+	validators := []TickValidator{PositivePrice{}}
+	if cfg.MaxFuture > 0 || cfg.MaxPast > 0 {
+		validators = append(validators, NewTimeWindow(cfg.MaxFuture, cfg.MaxPast))
+	}
+	if cfg.MedianBand > 0 {
+		validators = append(validators, NewMedianBand(cfg.MedianBand, cfg.MedianWindow))
+	}
+	return validators
+}
+
+// PositivePrice flags trades whose price is zero, negative or not a
+// number.
+type PositivePrice struct{}
+
+func (PositivePrice) Validate(trade models.TradeRecord) []string {
+	if !(toFloat(trade.Price) > 0) {
+		return []string{models.FlagNonPositivePrice}
+	}
+	return nil
+}
+
+// TimeWindow flags trades timed too far from when they were received, or
+// from the clock if that is not known, so that a backlog read late, e.g.
+// after an outage, is not flagged. A zero or negative bound is not
+// checked.
+type TimeWindow struct {
+	maxFuture time.Duration
+	maxPast   time.Duration
+	now       func() time.Time
+}
+
+func NewTimeWindow(maxFuture, maxPast time.Duration) *TimeWindow {
+	return &TimeWindow{maxFuture: maxFuture, maxPast: maxPast, now: time.Now}
+}
+
+func (v *TimeWindow) Validate(trade models.TradeRecord) []string {
+	now := trade.ReceivedAt
+	if now.IsZero() {
+		now = v.now()
+	}
+	switch {
+	case v.maxFuture > 0 && trade.Time.After(now.Add(v.maxFuture)):
+		return []string{models.FlagFutureTime}
+	case v.maxPast > 0 && trade.Time.Before(now.Add(-v.maxPast)):
+		return []string{models.FlagStaleTime}
+	}
+	return nil
+}
+
+// Prices a symbol needs before its median is trusted
+const minMedianSamples = 5
+
+// MedianBand flags trades priced more than band (a fraction) away from
+// the median of the symbol's last window prices. Outliers count towards
+// the median too, so that a lasting move is accepted once it makes up
+// half of the window, while a single bad print never shifts it.
+type MedianBand struct {
+	mu       sync.Mutex
+	band     float64
+	window   int
+	bySymbol map[string][]float64
+}
+
+func NewMedianBand(band float64, window int) *MedianBand {
+	return &MedianBand{band: band, window: window, bySymbol: make(map[string][]float64)}
+}
+
+func (v *MedianBand) Validate(trade models.TradeRecord) []string {
+	price := toFloat(trade.Price)
+	if !(price > 0) || math.IsInf(price, 0) {
+		// Left to PositivePrice, and kept out of the median
+		return nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	prices := v.bySymbol[trade.Symbol]
+	var flags []string
+	if len(prices) >= minMedianSamples {
+		if median := medianOf(prices); math.Abs(price-median) > v.band*median {
+			flags = []string{models.FlagPriceOutlier}
+		}
+	}
+	if len(prices) == v.window {
+		prices = prices[1:]
+	}
+	v.bySymbol[trade.Symbol] = append(prices, price)
+	return flags
+}
+
+func medianOf(prices []float64) float64 {
+	sorted := slices.Clone(prices)
+	slices.Sort(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// validate runs a trade through every validator and returns all the flags
+// they raise.
+func validate(trade models.TradeRecord, validators []TickValidator) []string {
+	var flags []string
+	for _, v := range validators {
+		flags = append(flags, v.Validate(trade)...)
+	}
+	return flags
+}
+
+func toFloat(d primitive.Decimal128) float64 {
+	f, err := strconv.ParseFloat(d.String(), 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
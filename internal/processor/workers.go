@@ -0,0 +1,77 @@
+package processor
+
+import (
+	"context"
+	"sync"
+
+	kafkaGo "github.com/segmentio/kafka-go"
+)
+
+// PartitionPool handles messages on a fixed number of workers. All the
+// messages of a partition go to the same worker, so they are handled in
+// the order they were read, while partitions on different workers are
+// handled concurrently. With at least as many workers as partitions, each
+// partition has a worker of its own.
+type PartitionPool struct {
+	queues []chan kafkaGo.Message
+	wg     sync.WaitGroup
+}
+
+// NewPartitionPool starts workers goroutines calling handle. Each worker
+// queues up to queueSize messages; beyond that, Submit blocks, so a slow
+// database slows down reading instead of piling up messages in memory.
+// A worker hands the messages queued by the time it is free to handle, in
+// order, up to batchSize at a time, so that their trades are written
+// together; it does not wait for more.
+func NewPartitionPool(workers, queueSize, batchSize int, handle func([]kafkaGo.Message)) *PartitionPool {
+	p := &PartitionPool{queues: make([]chan kafkaGo.Message, workers)}
+	batchSize = max(batchSize, 1)
+	for i := range p.queues {
+		queue := make(chan kafkaGo.Message, queueSize)
+		p.queues[i] = queue
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for m := range queue {
+				handle(take(queue, []kafkaGo.Message{m}, batchSize))
+			}
+		}()
+	}
+	return p
+}
+
+// take appends the messages already in queue to batch, up to size.
+func take(queue chan kafkaGo.Message, batch []kafkaGo.Message, size int) []kafkaGo.Message {
+	for len(batch) < size {
+		select {
+		case m, ok := <-queue:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, m)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// Submit queues a message for its partition's worker, waiting for room
+// until ctx is done.
+func (p *PartitionPool) Submit(ctx context.Context, m kafkaGo.Message) error {
+	select {
+	case p.queues[m.Partition%len(p.queues)] <- m:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops the workers once they have handled every queued message,
+// and waits for them. Submit must not be called afterwards.
+func (p *PartitionPool) Close() {
+	for _, queue := range p.queues {
+		close(queue)
+	}
+	p.wg.Wait()
+}
@@ -0,0 +1,200 @@
+package reconcile
+
+import (
+	"context"
+	"database/sql"
+	"financial-data-backend-2/internal/models"
+	"financial-data-backend-2/internal/postgres"
+	"financial-data-backend-2/internal/processor"
+	"fmt"
+	"time"
+)
+
+// PostgresReconciler is the Reconciler of storage.backend "postgres":
+// it recomputes the symbols table from the trades and candles_1m tables.
+type PostgresReconciler struct {
+	db *sql.DB
+}
+
+func NewPostgresReconciler(db *sql.DB) *PostgresReconciler {
+	return &PostgresReconciler{db: db}
+}
+
+// Compute is Reconciler.Compute, over the trades and candles tables.
+func (r *PostgresReconciler) Compute(ctx context.Context) (map[string]SymbolStats, error) {
+	stats, err := r.aggregateTrades(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT DISTINCT symbol FROM `+postgres.CandlesTable)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list candle symbols: %w", err)
+	}
+	var symbols []string
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		symbols = append(symbols, symbol)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, symbol := range symbols {
+		if err := r.addExpired(ctx, stats, symbol); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// aggregateTrades groups the unflagged trades by symbol, of one symbol
+// unless it is empty.
+func (r *PostgresReconciler) aggregateTrades(ctx context.Context, symbol string) (map[string]SymbolStats, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT symbol, count(*), min(time), max(time) FROM `+postgres.TradesTable+`
+		WHERE `+postgres.Unflagged+` AND ($1 = '' OR symbol = $1) GROUP BY symbol`, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate trades: %w", err)
+	}
+	defer rows.Close()
+	stats := make(map[string]SymbolStats)
+	for rows.Next() {
+		var s SymbolStats
+		if err := rows.Scan(&s.Symbol, &s.TradeCount, postgres.Time(&s.FirstTradeAt),
+			postgres.Time(&s.LastTradeAt)); err != nil {
+			return nil, fmt.Errorf("failed to aggregate trades: %w", err)
+		}
+		stats[s.Symbol] = s
+	}
+	return stats, rows.Err()
+}
+
+// addExpired is Reconciler.addExpired, over the candles table.
+func (r *PostgresReconciler) addExpired(ctx context.Context, stats map[string]SymbolStats, symbol string) error {
+	s, hasTrades := stats[symbol]
+	var before any
+	if hasTrades {
+		before = s.FirstTradeAt.Truncate(time.Minute)
+	}
+	e := SymbolStats{Symbol: symbol}
+	err := r.db.QueryRowContext(ctx, `SELECT coalesce(sum(trade_count), 0), min(time), max(time)
+		FROM `+postgres.CandlesTable+` WHERE symbol = $1 AND ($2::timestamptz IS NULL OR time < $2)`,
+		symbol, before).Scan(&e.TradeCount, postgres.Time(&e.FirstTradeAt), postgres.Time(&e.LastTradeAt))
+	if err != nil {
+		return fmt.Errorf("failed to aggregate candles of %s: %w", symbol, err)
+	}
+	if e.FirstTradeAt.IsZero() {
+		return nil
+	}
+	if !hasTrades {
+		stats[symbol] = e
+		return nil
+	}
+	s.TradeCount += e.TradeCount
+	s.FirstTradeAt = e.FirstTradeAt
+	stats[symbol] = s
+	return nil
+}
+
+// symbolDocs reads the metadata of every symbol, or of one unless it is
+// empty.
+func (r *PostgresReconciler) symbolDocs(ctx context.Context, symbol string) ([]models.SymbolDocument, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT symbol, trade_count, first_trade_at, last_trade_at
+		FROM `+postgres.SymbolsTable+` WHERE $1 = '' OR symbol = $1`, symbol)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var docs []models.SymbolDocument
+	for rows.Next() {
+		var doc models.SymbolDocument
+		if err := rows.Scan(&doc.Symbol, &doc.TradeCount, postgres.Time(&doc.FirstTradeAt),
+			postgres.Time(&doc.LastTradeAt)); err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, rows.Err()
+}
+
+// Diffs is Reconciler.Diffs, over the symbols table.
+func (r *PostgresReconciler) Diffs(ctx context.Context) ([]Diff, error) {
+	docs, err := r.symbolDocs(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	stats, err := r.Compute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Compare(docs, stats), nil
+}
+
+// Repair is Reconciler.Repair, over the symbols table.
+func (r *PostgresReconciler) Repair(ctx context.Context, diffs []Diff) (int, error) {
+	repaired := 0
+	for _, d := range diffs {
+		if d.Actual == nil {
+			continue
+		}
+		ok, err := r.repairSymbol(ctx, d.Symbol)
+		if err != nil {
+			return repaired, fmt.Errorf("failed to repair %s: %w", d.Symbol, err)
+		}
+		if ok {
+			repaired++
+		}
+	}
+	return repaired, nil
+}
+
+func (r *PostgresReconciler) repairSymbol(ctx context.Context, symbol string) (bool, error) {
+	docs, err := r.symbolDocs(ctx, symbol)
+	if err != nil {
+		return false, err
+	}
+	stats, err := r.aggregateTrades(ctx, symbol)
+	if err != nil {
+		return false, err
+	}
+	if err := r.addExpired(ctx, stats, symbol); err != nil {
+		return false, err
+	}
+	actual, ok := stats[symbol]
+	if !ok {
+		return false, nil
+	}
+
+	var res sql.Result
+	if len(docs) == 0 {
+		// The last price and day summary are left to the processor. If it
+		// has created the row meanwhile, it is left alone.
+		exchange, assetClass := processor.ClassifySymbol(symbol)
+		var class any
+		if assetClass != "" {
+			class = assetClass
+		}
+		res, err = r.db.ExecContext(ctx, `INSERT INTO `+postgres.SymbolsTable+`
+			(symbol, exchange, asset_class, trade_count, first_trade_at, last_trade_at)
+			VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (symbol) DO NOTHING`,
+			symbol, exchange, class, actual.TradeCount, actual.FirstTradeAt, actual.LastTradeAt)
+	} else {
+		if len(Compare(docs, stats)) == 0 {
+			return false, nil
+		}
+		res, err = r.db.ExecContext(ctx, `UPDATE `+postgres.SymbolsTable+` SET
+			trade_count = trade_count + $2,
+			first_trade_at = LEAST(first_trade_at, $3),
+			last_trade_at = GREATEST(last_trade_at, $4)
+			WHERE symbol = $1 AND trade_count = $5`,
+			symbol, actual.TradeCount-docs[0].TradeCount, actual.FirstTradeAt, actual.LastTradeAt, docs[0].TradeCount)
+	}
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
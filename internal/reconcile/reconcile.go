@@ -0,0 +1,284 @@
+package reconcile
+
+import (
+	"context"
+	"financial-data-backend-2/internal/models"
+	"financial-data-backend-2/internal/processor"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SymbolStats is what a symbol's metadata should be, according to the
+// trades collection.
+type SymbolStats struct {
+	Symbol       string    `bson:"_id"`
+	TradeCount   int64     `bson:"tradeCount"`
+	FirstTradeAt time.Time `bson:"firstTradeAt"`
+	LastTradeAt  time.Time `bson:"lastTradeAt"`
+}
+
+// Diff is a symbol whose stored metadata differs from its trades.
+type Diff struct {
+	Symbol string
+	// Stored is nil if the symbol has trades but no metadata document.
+	Stored *models.SymbolDocument
+	// Actual is nil if the symbol has metadata but no trades, e.g.
+	// because they expired. Such symbols are reported but not repaired.
+	Actual *SymbolStats
+}
+
+func (d Diff) String() string {
+	switch {
+	case d.Stored == nil:
+		return fmt.Sprintf("%s: missing metadata, has %d trade(s) from %s to %s", d.Symbol,
+			d.Actual.TradeCount, formatTime(d.Actual.FirstTradeAt), formatTime(d.Actual.LastTradeAt))
+	case d.Actual == nil:
+		return fmt.Sprintf("%s: metadata says %d trade(s), but none were found", d.Symbol, d.Stored.TradeCount)
+	}
+	return fmt.Sprintf("%s: tradeCount %d -> %d, firstTradeAt %s -> %s, lastTradeAt %s -> %s", d.Symbol,
+		d.Stored.TradeCount, d.Actual.TradeCount,
+		formatTime(d.Stored.FirstTradeAt), formatTime(d.Actual.FirstTradeAt),
+		formatTime(d.Stored.LastTradeAt), formatTime(d.Actual.LastTradeAt))
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// Reconciler recomputes symbol metadata from the trades.
+type Reconciler struct {
+	symbols *mongo.Collection
+	trades  *mongo.Collection
+	candles *mongo.Collection
+}
+
+func NewReconciler(symbols, trades, candles *mongo.Collection) *Reconciler {
+	return &Reconciler{symbols: symbols, trades: trades, candles: candles}
+}
+
+// Compute aggregates the trades of every symbol, apart from flagged ones,
+// which the processor does not count either. Trades that have expired
+// after being downsampled are counted from their candles.
+func (r *Reconciler) Compute(ctx context.Context) (map[string]SymbolStats, error) {
+	stats, err := r.aggregateTrades(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	symbols, err := r.candles.Distinct(ctx, "symbol", bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list candle symbols: %w", err)
+	}
+	for _, v := range symbols {
+		symbol, ok := v.(string)
+		if !ok {
+			continue
+		}
+		if err := r.addExpired(ctx, stats, symbol); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
+
+// computeSymbol is Compute for one symbol. ok is false if it has neither
+// trades nor candles.
+func (r *Reconciler) computeSymbol(ctx context.Context, symbol string) (s SymbolStats, ok bool, err error) {
+	stats, err := r.aggregateTrades(ctx, bson.M{"symbol": symbol})
+	if err != nil {
+		return SymbolStats{}, false, err
+	}
+	if err := r.addExpired(ctx, stats, symbol); err != nil {
+		return SymbolStats{}, false, err
+	}
+	s, ok = stats[symbol]
+	return s, ok, nil
+}
+
+// aggregateTrades groups the unflagged trades matching match by symbol.
+func (r *Reconciler) aggregateTrades(ctx context.Context, match bson.M) (map[string]SymbolStats, error) {
+	match["flags"] = bson.M{"$exists": false}
+	cursor, err := r.trades.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$symbol"},
+			{Key: "tradeCount", Value: bson.M{"$sum": 1}},
+			{Key: "firstTradeAt", Value: bson.M{"$min": "$time"}},
+			{Key: "lastTradeAt", Value: bson.M{"$max": "$time"}},
+		}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate trades: %w", err)
+	}
+	var raw []SymbolStats
+	if err := cursor.All(ctx, &raw); err != nil {
+		return nil, fmt.Errorf("failed to aggregate trades: %w", err)
+	}
+	stats := make(map[string]SymbolStats, len(raw))
+	for _, s := range raw {
+		stats[s.Symbol] = s
+	}
+	return stats, nil
+}
+
+// addExpired adds the candles older than the symbol's first raw trade.
+// Times taken from candles are only accurate to the minute, and trades
+// left out of candles (see aggregates.exclude_conditions) are not counted.
+func (r *Reconciler) addExpired(ctx context.Context, stats map[string]SymbolStats, symbol string) error {
+	s, hasTrades := stats[symbol]
+	match := bson.M{"symbol": symbol}
+	if hasTrades {
+		match["time"] = bson.M{"$lt": s.FirstTradeAt.Truncate(time.Minute)}
+	}
+	cursor, err := r.candles.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$symbol"},
+			{Key: "tradeCount", Value: bson.M{"$sum": "$trade_count"}},
+			{Key: "firstTradeAt", Value: bson.M{"$min": "$time"}},
+			{Key: "lastTradeAt", Value: bson.M{"$max": "$time"}},
+		}}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to aggregate candles of %s: %w", symbol, err)
+	}
+	var expired []SymbolStats
+	if err := cursor.All(ctx, &expired); err != nil {
+		return fmt.Errorf("failed to aggregate candles of %s: %w", symbol, err)
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	e := expired[0]
+	if !hasTrades {
+		stats[symbol] = e
+		return nil
+	}
+	s.TradeCount += e.TradeCount
+	s.FirstTradeAt = e.FirstTradeAt
+	stats[symbol] = s
+	return nil
+}
+
+// Diffs compares the stored metadata with the trades. The metadata is
+// read first, so that trades stored meanwhile show up as too few in the
+// metadata rather than hiding drift; Repair checks each diff again.
+func (r *Reconciler) Diffs(ctx context.Context) ([]Diff, error) {
+	cursor, err := r.symbols.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	var docs []models.SymbolDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	stats, err := r.Compute(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return Compare(docs, stats), nil
+}
+
+// Compare lists the symbols whose documents do not match their stats,
+// sorted by symbol.
+func Compare(docs []models.SymbolDocument, stats map[string]SymbolStats) []Diff {
+	var diffs []Diff
+	seen := make(map[string]bool, len(docs))
+	for i := range docs {
+		doc := &docs[i]
+		seen[doc.Symbol] = true
+		s, ok := stats[doc.Symbol]
+		if !ok {
+			diffs = append(diffs, Diff{Symbol: doc.Symbol, Stored: doc})
+			continue
+		}
+		if doc.TradeCount != s.TradeCount || !doc.FirstTradeAt.Equal(s.FirstTradeAt) ||
+			!doc.LastTradeAt.Equal(s.LastTradeAt) {
+			diffs = append(diffs, Diff{Symbol: doc.Symbol, Stored: doc, Actual: &s})
+		}
+	}
+	for symbol, s := range stats {
+		if !seen[symbol] {
+			diffs = append(diffs, Diff{Symbol: symbol, Actual: &s})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Symbol < diffs[j].Symbol })
+	return diffs
+}
+
+// Repair brings the documents of diffs in line with the trades and
+// returns how many it changed. Each symbol is checked again first: its
+// document is read, then its trades counted, and the document is only
+// updated if its count has not changed since it was read. A symbol the
+// processor stores trades for meanwhile is left for the next run, since
+// those trades may or may not have been counted. The times only move
+// outwards, so they never undo a newer trade.
+func (r *Reconciler) Repair(ctx context.Context, diffs []Diff) (int, error) {
+	repaired := 0
+	for _, d := range diffs {
+		if d.Actual == nil {
+			continue
+		}
+		ok, err := r.repairSymbol(ctx, d.Symbol)
+		if err != nil {
+			return repaired, fmt.Errorf("failed to repair %s: %w", d.Symbol, err)
+		}
+		if ok {
+			repaired++
+		}
+	}
+	return repaired, nil
+}
+
+func (r *Reconciler) repairSymbol(ctx context.Context, symbol string) (bool, error) {
+	var doc models.SymbolDocument
+	err := r.symbols.FindOne(ctx, bson.M{"symbol": symbol}).Decode(&doc)
+	stored := err == nil
+	if err != nil && err != mongo.ErrNoDocuments {
+		return false, err
+	}
+	actual, ok, err := r.computeSymbol(ctx, symbol)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	if !stored {
+		// The last price and day summary are left to the processor. If it
+		// has created the document meanwhile, it is left alone.
+		exchange, assetClass := processor.ClassifySymbol(symbol)
+		onInsert := bson.M{"symbol": symbol, "exchange": exchange, "tradeCount": actual.TradeCount,
+			"firstTradeAt": actual.FirstTradeAt, "lastTradeAt": actual.LastTradeAt}
+		if assetClass != "" {
+			onInsert["assetClass"] = assetClass
+		}
+		res, err := r.symbols.UpdateOne(ctx, bson.M{"symbol": symbol}, bson.M{"$setOnInsert": onInsert},
+			options.Update().SetUpsert(true))
+		if err != nil {
+			return false, err
+		}
+		return res.UpsertedCount > 0, nil
+	}
+
+	if len(Compare([]models.SymbolDocument{doc}, map[string]SymbolStats{symbol: actual})) == 0 {
+		return false, nil
+	}
+	update := bson.M{
+		"$inc": bson.M{"tradeCount": actual.TradeCount - doc.TradeCount},
+		"$min": bson.M{"firstTradeAt": actual.FirstTradeAt},
+		"$max": bson.M{"lastTradeAt": actual.LastTradeAt},
+	}
+	res, err := r.symbols.UpdateOne(ctx, bson.M{"symbol": symbol, "tradeCount": doc.TradeCount}, update)
+	if err != nil {
+		return false, err
+	}
+	return res.ModifiedCount > 0, nil
+}
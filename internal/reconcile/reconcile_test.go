@@ -0,0 +1,200 @@
+package reconcile
+
+import (
+	"context"
+	"financial-data-backend-2/internal/models"
+	mongoGo "financial-data-backend-2/internal/mongo"
+	"financial-data-backend-2/internal/postgres"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCompare(t *testing.T) {
+	t1 := time.UnixMilli(1700000000000).UTC()
+	t2 := t1.Add(time.Hour)
+	stats := map[string]SymbolStats{
+		"AAPL": {Symbol: "AAPL", TradeCount: 10, FirstTradeAt: t1, LastTradeAt: t2},
+		"MSFT": {Symbol: "MSFT", TradeCount: 3, FirstTradeAt: t1, LastTradeAt: t2},
+		"NVDA": {Symbol: "NVDA", TradeCount: 1, FirstTradeAt: t2, LastTradeAt: t2},
+	}
+	docs := []models.SymbolDocument{
+		{Symbol: "MSFT", TradeCount: 3, FirstTradeAt: t1, LastTradeAt: t2}, // in sync
+		{Symbol: "AAPL", TradeCount: 12, LastTradeAt: t2},                  // drifted
+		{Symbol: "TSLA", TradeCount: 5, LastTradeAt: t1},                   // trades gone
+	}
+
+	diffs := Compare(docs, stats)
+
+	assert.Len(t, diffs, 3)
+	assert.Equal(t, "AAPL", diffs[0].Symbol)
+	assert.Equal(t, int64(12), diffs[0].Stored.TradeCount)
+	assert.Equal(t, int64(10), diffs[0].Actual.TradeCount)
+	assert.Contains(t, diffs[0].String(), "tradeCount 12 -> 10")
+
+	assert.Equal(t, "NVDA", diffs[1].Symbol)
+	assert.Nil(t, diffs[1].Stored, "symbols without metadata should be reported")
+	assert.Contains(t, diffs[1].String(), "missing metadata")
+
+	assert.Equal(t, "TSLA", diffs[2].Symbol)
+	assert.Nil(t, diffs[2].Actual, "symbols without trades should be reported")
+
+	assert.Empty(t, Compare(docs[:1], map[string]SymbolStats{"MSFT": stats["MSFT"]}))
+}
+
+func TestReconciler(t *testing.T) {
+	_ = godotenv.Load("../../.env")
+	mongoUrl := os.Getenv("MONGO_URL_TEST")
+	if mongoUrl == "" {
+		log.Fatal("FATAL: MONGO_URL_TEST is not set. Aborting reconciler integration tests.")
+	}
+	testDbClient, err := mongoGo.ConnectDB(mongoUrl, 15*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	db := testDbClient.Database("financialDataReconcileTest")
+	assert.NoError(t, db.Drop(ctx))
+	defer func() {
+		db.Drop(context.Background())
+		testDbClient.Disconnect(context.Background())
+	}()
+	symbols, trades, candles := db.Collection("symbols"), db.Collection("trades"), db.Collection("candles")
+	r := NewReconciler(symbols, trades, candles)
+
+	t0 := time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+	trade := func(symbol string, at time.Duration, flags ...string) any {
+		return models.TradeRecord{Id: primitive.NewObjectID(), Symbol: symbol, Time: t0.Add(at), Flags: flags}
+	}
+	_, err = trades.InsertMany(ctx, []any{
+		trade("AAPL", 0), trade("AAPL", time.Second), trade("AAPL", time.Minute),
+		trade("AAPL", 2*time.Minute, models.FlagPriceOutlier), // not counted
+		trade("MSFT", time.Hour),
+	})
+	assert.NoError(t, err)
+	// AAPL's trades before t0 have expired, leaving their candles
+	_, err = candles.InsertMany(ctx, []any{
+		bson.M{"symbol": "AAPL", "time": t0.Add(-2 * time.Minute), "trade_count": 4},
+		bson.M{"symbol": "AAPL", "time": t0.Add(-time.Minute), "trade_count": 6},
+		bson.M{"symbol": "AAPL", "time": t0, "trade_count": 3}, // still has its trades
+	})
+	assert.NoError(t, err)
+
+	// --- Compute ---
+	stats, err := r.Compute(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]SymbolStats{
+		"AAPL": {Symbol: "AAPL", TradeCount: 13, FirstTradeAt: t0.Add(-2 * time.Minute), LastTradeAt: t0.Add(time.Minute)},
+		"MSFT": {Symbol: "MSFT", TradeCount: 1, FirstTradeAt: t0.Add(time.Hour), LastTradeAt: t0.Add(time.Hour)},
+	}, stats)
+
+	// --- Repair ---
+	// AAPL's count drifted, and the processor has since stored a newer
+	// trade than the trades collection shows; MSFT has no metadata.
+	_, err = symbols.InsertOne(ctx, models.SymbolDocument{Symbol: "AAPL", TradeCount: 20,
+		FirstTradeAt: t0, LastTradeAt: t0.Add(time.Hour)})
+	assert.NoError(t, err)
+	diffs, err := r.Diffs(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, diffs, 2)
+
+	n, err := r.Repair(ctx, diffs)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	var aapl, msft models.SymbolDocument
+	assert.NoError(t, symbols.FindOne(ctx, bson.M{"symbol": "AAPL"}).Decode(&aapl))
+	assert.Equal(t, int64(13), aapl.TradeCount)
+	assert.True(t, aapl.FirstTradeAt.Equal(t0.Add(-2*time.Minute)))
+	assert.True(t, aapl.LastTradeAt.Equal(t0.Add(time.Hour)), "lastTradeAt should not move backwards")
+	assert.NoError(t, symbols.FindOne(ctx, bson.M{"symbol": "MSFT"}).Decode(&msft))
+	assert.Equal(t, int64(1), msft.TradeCount)
+	assert.Equal(t, "US", msft.Exchange)
+
+	// A symbol whose trades come in while it is repaired is left alone:
+	// the stale diff is checked again, and found in sync
+	_, err = trades.InsertOne(ctx, trade("MSFT", 2*time.Hour))
+	assert.NoError(t, err)
+	_, err = symbols.UpdateOne(ctx, bson.M{"symbol": "MSFT"},
+		bson.M{"$inc": bson.M{"tradeCount": 1}, "$max": bson.M{"lastTradeAt": t0.Add(2 * time.Hour)}})
+	assert.NoError(t, err)
+	n, err = r.Repair(ctx, diffs)
+	assert.NoError(t, err)
+	assert.Zero(t, n)
+	assert.NoError(t, symbols.FindOne(ctx, bson.M{"symbol": "MSFT"}).Decode(&msft))
+	assert.Equal(t, int64(2), msft.TradeCount)
+}
+
+func TestPostgresReconciler(t *testing.T) {
+	_ = godotenv.Load("../../.env")
+	postgresUrl := os.Getenv("POSTGRES_URL_TEST")
+	if postgresUrl == "" {
+		t.Skip("POSTGRES_URL_TEST is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	const schema = "financial_data_reconcile_test"
+	admin, err := postgres.ConnectDB(postgresUrl, 15*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to connect to Postgres: %v", err)
+	}
+	defer admin.Close()
+	_, err = admin.ExecContext(ctx, `DROP SCHEMA IF EXISTS `+schema+` CASCADE; CREATE SCHEMA `+schema)
+	assert.NoError(t, err)
+	defer admin.ExecContext(context.Background(), `DROP SCHEMA `+schema+` CASCADE`)
+	schemaUrl, err := postgres.InSchema(postgresUrl, schema)
+	assert.NoError(t, err)
+	db, err := postgres.ConnectDB(schemaUrl, 15*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to connect to Postgres: %v", err)
+	}
+	defer db.Close()
+	migrator, err := postgres.NewMigrator(db, postgres.Migrations())
+	assert.NoError(t, err)
+	_, err = migrator.Up(ctx)
+	assert.NoError(t, err)
+	assert.NoError(t, postgres.EnsurePartition(ctx, db, time.Date(2025, 11, 1, 0, 0, 0, 0, time.UTC)))
+	r := NewPostgresReconciler(db)
+
+	t0 := time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+	_, err = db.ExecContext(ctx, `INSERT INTO trades (message_key, symbol, time, price, volume, flags) VALUES
+		('k-0', 'AAPL', $1, 1, 1, NULL), ('k-1', 'AAPL', $2, 1, 1, NULL),
+		('k-2', 'AAPL', $3, 1, 1, '{price_outlier}'), ('k-3', 'MSFT', $3, 1, 1, NULL)`,
+		t0, t0.Add(time.Minute), t0.Add(time.Hour))
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO candles_1m (symbol, time, open, high, low, close, volume, trade_count)
+		VALUES ('AAPL', $1, 1, 1, 1, 1, 1, 4), ('AAPL', $2, 1, 1, 1, 1, 1, 2)`, t0.Add(-time.Minute), t0)
+	assert.NoError(t, err)
+	_, err = db.ExecContext(ctx, `INSERT INTO symbols (symbol, exchange, trade_count, first_trade_at, last_trade_at)
+		VALUES ('AAPL', 'US', 9, $1, $2)`, t0, t0.Add(2*time.Hour))
+	assert.NoError(t, err)
+
+	stats, err := r.Compute(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, SymbolStats{Symbol: "AAPL", TradeCount: 6, FirstTradeAt: t0.Add(-time.Minute),
+		LastTradeAt: t0.Add(time.Minute)}, stats["AAPL"])
+	assert.Equal(t, int64(1), stats["MSFT"].TradeCount)
+
+	diffs, err := r.Diffs(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, diffs, 2)
+	n, err := r.Repair(ctx, diffs)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	docs, err := r.symbolDocs(ctx, "")
+	assert.NoError(t, err)
+	if assert.Len(t, docs, 2) {
+		bySymbol := map[string]models.SymbolDocument{docs[0].Symbol: docs[0], docs[1].Symbol: docs[1]}
+		assert.Equal(t, int64(6), bySymbol["AAPL"].TradeCount)
+		assert.True(t, bySymbol["AAPL"].FirstTradeAt.Equal(t0.Add(-time.Minute)))
+		assert.True(t, bySymbol["AAPL"].LastTradeAt.Equal(t0.Add(2*time.Hour)), "last_trade_at should not move backwards")
+		assert.Equal(t, int64(1), bySymbol["MSFT"].TradeCount)
+	}
+}
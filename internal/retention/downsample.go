@@ -0,0 +1,196 @@
+package retention
+
+import (
+	"context"
+	"financial-data-backend-2/internal/models"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// How much trade history one aggregation covers, to keep each query small.
+const chunk = 24 * time.Hour
+
+// Downsampler rolls up raw trades into minute candles.
+type Downsampler struct {
+	trades            *mongo.Collection
+	candles           *mongo.Collection
+	symbols           *mongo.Collection
+	after             time.Duration
+	lookback          time.Duration
+	excludeConditions []string
+}
+
+// NewDownsampler rolls up trades once they are older than after, leaving
+// out trades with any of excludeConditions. Each run rolls up again the
+// trades of lookback before the last candle, in case some arrived late.
+func NewDownsampler(trades, candles, symbols *mongo.Collection, after, lookback time.Duration, excludeConditions []string) *Downsampler {
+	return &Downsampler{
+		trades:            trades,
+		candles:           candles,
+		symbols:           symbols,
+		after:             after,
+		lookback:          lookback,
+		excludeConditions: excludeConditions,
+	}
+}
+
+// Run writes the candles of every complete minute older than the
+// downsampling age, continuing per symbol from lookback before its last
+// candle, so that the candles of trades that arrived late are recomputed.
+// It returns how many candles were written.
+func (d *Downsampler) Run(ctx context.Context, now time.Time) (int, error) {
+	end := now.Add(-d.after).Truncate(time.Minute)
+
+	// Every symbol with trades has metadata, or soon will: go-reconciler
+	// adds what the processor missed.
+	symbols, err := d.symbols.Distinct(ctx, "symbol", bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list symbols: %w", err)
+	}
+
+	written := 0
+	for _, s := range symbols {
+		symbol, ok := s.(string)
+		if !ok {
+			continue
+		}
+		start, found, err := d.resumeFrom(ctx, symbol)
+		if err != nil {
+			return written, err
+		}
+		if !found {
+			continue
+		}
+		for _, w := range Windows(start, end, chunk) {
+			n, err := d.downsample(ctx, symbol, w[0], w[1])
+			written += n
+			if err != nil {
+				return written, fmt.Errorf("failed to downsample %s from %v: %w", symbol, w[0], err)
+			}
+		}
+	}
+	return written, nil
+}
+
+// resumeFrom returns lookback before the time of the symbol's last candle,
+// or the time of its first trade if it has no candles yet. found is false
+// if it has neither.
+func (d *Downsampler) resumeFrom(ctx context.Context, symbol string) (time.Time, bool, error) {
+	var doc struct {
+		Time time.Time `bson:"time"`
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "time", Value: -1}}).SetProjection(bson.M{"time": 1})
+	err := d.candles.FindOne(ctx, bson.M{"symbol": symbol}, opts).Decode(&doc)
+	if err == nil {
+		return doc.Time.Add(-d.lookback).Truncate(time.Minute), true, nil
+	}
+	if err != mongo.ErrNoDocuments {
+		return time.Time{}, false, err
+	}
+
+	opts.SetSort(bson.D{{Key: "time", Value: 1}})
+	err = d.trades.FindOne(ctx, bson.M{"symbol": symbol}, opts).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return doc.Time.Truncate(time.Minute), true, nil
+}
+
+func (d *Downsampler) downsample(ctx context.Context, symbol string, from, to time.Time) (int, error) {
+	cursor, err := d.trades.Aggregate(ctx, CandlePipeline(symbol, from, to, d.excludeConditions))
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var candles []models.Candle
+	if err := cursor.All(ctx, &candles); err != nil {
+		return 0, err
+	}
+	if len(candles) == 0 {
+		return 0, nil
+	}
+
+	writes := make([]mongo.WriteModel, len(candles))
+	for i, c := range candles {
+		writes[i] = mongo.NewReplaceOneModel().
+			SetFilter(bson.M{"symbol": c.Symbol, "time": c.Time}).
+			SetReplacement(c).
+			SetUpsert(true)
+	}
+	if _, err := d.candles.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+		return 0, err
+	}
+	log.Printf("Downsampled %s: %d candle(s) from %v to %v", symbol, len(candles),
+		from.Format(time.RFC3339), to.Format(time.RFC3339))
+	return len(candles), nil
+}
+
+// CandlePipeline aggregates a symbol's trades in [from, to) into minute
+// candles, decoded as models.Candle.
+func CandlePipeline(symbol string, from, to time.Time, excludeConditions []string) mongo.Pipeline {
+	return BucketPipeline(symbol, from, to, time.Minute, excludeConditions)
+}
+
+// BucketPipeline aggregates a symbol's trades in [from, to) into candles
+// of size, a whole number of minutes, decoded as models.Candle. Buckets
+// start at multiples of size since 2000-01-01 UTC, so sizes that divide a
+// day start at midnight. Flagged trades (bad ticks) are left out.
+func BucketPipeline(symbol string, from, to time.Time, size time.Duration, excludeConditions []string) mongo.Pipeline {
+	match := bson.M{
+		"symbol": symbol,
+		"time":   bson.M{"$gte": from, "$lt": to},
+		"flags":  bson.M{"$exists": false},
+	}
+	if len(excludeConditions) > 0 {
+		match["conditions"] = bson.M{"$nin": excludeConditions}
+	}
+	return mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$sort", Value: bson.D{{Key: "time", Value: 1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: bson.M{"$dateTrunc": bson.M{
+				"date": "$time", "unit": "minute", "binSize": int64(size / time.Minute),
+			}}},
+			{Key: "open", Value: bson.M{"$first": "$price"}},
+			{Key: "high", Value: bson.M{"$max": "$price"}},
+			{Key: "low", Value: bson.M{"$min": "$price"}},
+			{Key: "close", Value: bson.M{"$last": "$price"}},
+			{Key: "volume", Value: bson.M{"$sum": "$volume"}},
+			{Key: "trade_count", Value: bson.M{"$sum": 1}},
+		}}},
+		{{Key: "$project", Value: bson.D{
+			{Key: "_id", Value: 0},
+			{Key: "symbol", Value: bson.M{"$literal": symbol}},
+			{Key: "time", Value: "$_id"},
+			{Key: "open", Value: 1},
+			{Key: "high", Value: 1},
+			{Key: "low", Value: 1},
+			{Key: "close", Value: 1},
+			{Key: "volume", Value: 1},
+			{Key: "trade_count", Value: 1},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "time", Value: 1}}}},
+	}
+}
+
+// Windows splits [start, end) into consecutive windows of at most size.
+func Windows(start, end time.Time, size time.Duration) [][2]time.Time {
+	var windows [][2]time.Time
+	for from := start; from.Before(end); from = from.Add(size) {
+		to := from.Add(size)
+		if to.After(end) {
+			to = end
+		}
+		windows = append(windows, [2]time.Time{from, to})
+	}
+	return windows
+}
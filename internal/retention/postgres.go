@@ -0,0 +1,152 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"financial-data-backend-2/internal/postgres"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresDownsampler rolls up raw trades into minute candles, like
+// Downsampler, for storage.backend "postgres".
+type PostgresDownsampler struct {
+	db                *sql.DB
+	after             time.Duration
+	lookback          time.Duration
+	excludeConditions []string
+}
+
+func NewPostgresDownsampler(db *sql.DB, after, lookback time.Duration, excludeConditions []string) *PostgresDownsampler {
+	return &PostgresDownsampler{db: db, after: after, lookback: lookback, excludeConditions: excludeConditions}
+}
+
+// Run works like Downsampler.Run.
+func (d *PostgresDownsampler) Run(ctx context.Context, now time.Time) (int, error) {
+	end := now.Add(-d.after).Truncate(time.Minute)
+
+	symbols, err := d.symbols(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list symbols: %w", err)
+	}
+
+	written := 0
+	for _, symbol := range symbols {
+		start, found, err := d.resumeFrom(ctx, symbol)
+		if err != nil {
+			return written, err
+		}
+		if !found {
+			continue
+		}
+		for _, w := range Windows(start, end, chunk) {
+			n, err := d.downsample(ctx, symbol, w[0], w[1])
+			written += n
+			if err != nil {
+				return written, fmt.Errorf("failed to downsample %s from %v: %w", symbol, w[0], err)
+			}
+		}
+	}
+	return written, nil
+}
+
+// symbols lists the symbols that have metadata, which every symbol with
+// trades has.
+func (d *PostgresDownsampler) symbols(ctx context.Context) ([]string, error) {
+	rows, err := d.db.QueryContext(ctx, `SELECT symbol FROM `+postgres.SymbolsTable+` ORDER BY symbol`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var symbols []string
+	for rows.Next() {
+		var symbol string
+		if err := rows.Scan(&symbol); err != nil {
+			return nil, err
+		}
+		symbols = append(symbols, symbol)
+	}
+	return symbols, rows.Err()
+}
+
+// resumeFrom returns lookback before the time of the symbol's last candle,
+// or the time of its first trade if it has no candles yet. found is false
+// if it has neither.
+func (d *PostgresDownsampler) resumeFrom(ctx context.Context, symbol string) (time.Time, bool, error) {
+	var last time.Time
+	err := d.db.QueryRowContext(ctx, `SELECT max(time) FROM `+postgres.CandlesTable+
+		` WHERE symbol = $1`, symbol).Scan(postgres.Time(&last))
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if !last.IsZero() {
+		return last.Add(-d.lookback).Truncate(time.Minute), true, nil
+	}
+
+	var first time.Time
+	err = d.db.QueryRowContext(ctx, `SELECT min(time) FROM `+postgres.TradesTable+
+		` WHERE symbol = $1`, symbol).Scan(postgres.Time(&first))
+	if err != nil || first.IsZero() {
+		return time.Time{}, false, err
+	}
+	return first.Truncate(time.Minute), true, nil
+}
+
+// candleUpsert aggregates a symbol's trades in [$2, $3) into minute
+// candles, as CandlePipeline does, and replaces the stored ones.
+const candleUpsert = `INSERT INTO ` + postgres.CandlesTable + `
+	(symbol, time, open, high, low, close, volume, trade_count)
+SELECT $1, date_bin('1 minute', time, TIMESTAMPTZ '2000-01-01 00:00:00Z') AS minute,
+	(array_agg(price ORDER BY time))[1], max(price), min(price),
+	(array_agg(price ORDER BY time DESC))[1], sum(volume), count(*)
+FROM ` + postgres.TradesTable + `
+WHERE symbol = $1 AND time >= $2 AND time < $3 AND ` + postgres.Unflagged + `
+	AND NOT coalesce(conditions && $4, false)
+GROUP BY minute
+ON CONFLICT (symbol, time) DO UPDATE SET
+	open = EXCLUDED.open, high = EXCLUDED.high, low = EXCLUDED.low, close = EXCLUDED.close,
+	volume = EXCLUDED.volume, trade_count = EXCLUDED.trade_count`
+
+func (d *PostgresDownsampler) downsample(ctx context.Context, symbol string, from, to time.Time) (int, error) {
+	result, err := d.db.ExecContext(ctx, candleUpsert, symbol, from, to, pq.Array(d.excludeConditions))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	log.Printf("Downsampled %s: %d candle(s) from %v to %v", symbol, n,
+		from.Format(time.RFC3339), to.Format(time.RFC3339))
+	return int(n), nil
+}
+
+// ApplyPostgresRetention drops the trades partitions of the months older
+// than trades, deletes the candles older than candles, and the expired
+// idempotency keys and late trades. A zero duration keeps everything.
+// Unlike MongoDB's TTLs, trades expire a whole month at a time.
+func ApplyPostgresRetention(ctx context.Context, db *sql.DB, now time.Time, trades, candles time.Duration) error {
+	if trades > 0 {
+		if _, err := postgres.DropPartitionsBefore(ctx, db, now.Add(-trades)); err != nil {
+			return fmt.Errorf("failed to drop trades partitions: %w", err)
+		}
+	}
+	if candles > 0 {
+		result, err := db.ExecContext(ctx, `DELETE FROM `+postgres.CandlesTable+` WHERE time < $1`,
+			now.Add(-candles))
+		if err != nil {
+			return fmt.Errorf("failed to delete candles: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Printf("Deleted %d expired candle(s)", n)
+		}
+	}
+	if err := postgres.DeleteExpired(ctx, db, now); err != nil {
+		return fmt.Errorf("failed to delete expired keys and late trades: %w", err)
+	}
+	return nil
+}
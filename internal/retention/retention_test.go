@@ -0,0 +1,155 @@
+package retention
+
+import (
+	"context"
+	"financial-data-backend-2/internal/models"
+	mongoGo "financial-data-backend-2/internal/mongo"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestWindows(t *testing.T) {
+	start := time.Date(2025, 11, 20, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name     string
+		end      time.Time
+		expected [][2]time.Time
+	}{
+		{name: "empty range", end: start},
+		{name: "end before start", end: start.Add(-time.Hour)},
+		{
+			name:     "shorter than a window",
+			end:      start.Add(time.Hour),
+			expected: [][2]time.Time{{start, start.Add(time.Hour)}},
+		},
+		{
+			name: "last window is cut short",
+			end:  start.Add(60 * time.Hour),
+			expected: [][2]time.Time{
+				{start, start.Add(24 * time.Hour)},
+				{start.Add(24 * time.Hour), start.Add(48 * time.Hour)},
+				{start.Add(48 * time.Hour), start.Add(60 * time.Hour)},
+			},
+		},
+	}
+
+	for _, tt := range testCases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Windows(start, tt.end, 24*time.Hour))
+		})
+	}
+}
+
+func TestCandlePipeline(t *testing.T) {
+	from := time.UnixMilli(1700000000000)
+	to := from.Add(time.Hour)
+
+	match := CandlePipeline("AAPL", from, to, nil)[0][0].Value.(bson.M)
+	assert.Equal(t, bson.M{
+		"symbol": "AAPL",
+		"time":   bson.M{"$gte": from, "$lt": to},
+		"flags":  bson.M{"$exists": false},
+	}, match, "flagged trades should be left out of candles")
+
+	match = CandlePipeline("AAPL", from, to, []string{"I"})[0][0].Value.(bson.M)
+	assert.Equal(t, bson.M{"$nin": []string{"I"}}, match["conditions"],
+		"excluded conditions should be left out of candles")
+
+	group := BucketPipeline("AAPL", from, to, 5*time.Minute, nil)[2][0].Value.(bson.D)
+	assert.Equal(t, bson.M{"$dateTrunc": bson.M{"date": "$time", "unit": "minute", "binSize": int64(5)}},
+		group[0].Value, "trades should be grouped into 5 minute buckets")
+}
+
+func TestDownsampler(t *testing.T) {
+	_ = godotenv.Load("../../.env")
+	mongoUrl := os.Getenv("MONGO_URL_TEST")
+	if mongoUrl == "" {
+		log.Fatal("FATAL: MONGO_URL_TEST is not set. Aborting downsampler integration tests.")
+	}
+	testDbClient, err := mongoGo.ConnectDB(mongoUrl, 15*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	db := testDbClient.Database("financialDataDownsampleTest")
+	assert.NoError(t, db.Drop(ctx))
+	defer func() {
+		db.Drop(context.Background())
+		testDbClient.Disconnect(context.Background())
+	}()
+	symbols, trades, candles := db.Collection("symbols"), db.Collection("trades"), db.Collection("candles")
+	after := 24 * time.Hour
+	d := NewDownsampler(trades, candles, symbols, after, 10*time.Minute, nil)
+
+	t0 := time.Date(2025, 11, 20, 14, 30, 0, 0, time.UTC)
+	trade := func(symbol string, at time.Duration, price string) any {
+		p, _ := primitive.ParseDecimal128(price)
+		v, _ := primitive.ParseDecimal128("1")
+		return models.TradeRecord{Id: primitive.NewObjectID(), Symbol: symbol, Time: t0.Add(at), Price: p, Volume: v}
+	}
+	_, err = symbols.InsertMany(ctx, []any{
+		models.SymbolDocument{Symbol: "AAPL"},
+		models.SymbolDocument{Symbol: "MSFT"}, // no trades
+	})
+	assert.NoError(t, err)
+	_, err = trades.InsertMany(ctx, []any{
+		trade("AAPL", 0, "10"), trade("AAPL", 10*time.Second, "12"),
+		trade("AAPL", time.Minute, "11"), trade("AAPL", 20*time.Minute, "13"),
+		trade("TSLA", 0, "1"), // no metadata, so not listed
+	})
+	assert.NoError(t, err)
+
+	candle := func(symbol string, at time.Duration) models.Candle {
+		var c models.Candle
+		assert.NoError(t, candles.FindOne(ctx, bson.M{"symbol": symbol, "time": t0.Add(at)}).Decode(&c))
+		return c
+	}
+	count := func() int64 {
+		n, err := candles.CountDocuments(ctx, bson.M{})
+		assert.NoError(t, err)
+		return n
+	}
+
+	// --- First run: every complete minute older than after ---
+	now := t0.Add(after + 30*time.Minute)
+	n, err := d.Run(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, int64(3), count(), "only the symbols in the symbols collection should be downsampled")
+	first := candle("AAPL", 0)
+	assert.Equal(t, int64(2), first.TradeCount)
+	assert.Equal(t, "10", first.Open.String())
+	assert.Equal(t, "12", first.Close.String())
+
+	// --- Late trades ---
+	// One lands within the lookback before the last candle, at t0+20m, and
+	// is rolled up; one lands before it, and is not.
+	_, err = trades.InsertMany(ctx, []any{
+		trade("AAPL", 15*time.Minute, "9"),
+		trade("AAPL", 30*time.Second, "8"),
+	})
+	assert.NoError(t, err)
+	n, err = d.Run(ctx, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n, "the candles of the lookback should be recomputed")
+	assert.Equal(t, int64(1), candle("AAPL", 15*time.Minute).TradeCount)
+	assert.Equal(t, int64(2), candle("AAPL", 0).TradeCount)
+	assert.Equal(t, int64(1), candle("AAPL", 20*time.Minute).TradeCount)
+
+	// One candle per minute with trades rolled up
+	opts := options.Find().SetSort(bson.D{{Key: "time", Value: 1}})
+	cursor, err := candles.Find(ctx, bson.M{"symbol": "AAPL"}, opts)
+	assert.NoError(t, err)
+	var all []models.Candle
+	assert.NoError(t, cursor.All(ctx, &all))
+	assert.Len(t, all, 4)
+}
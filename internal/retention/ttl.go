@@ -0,0 +1,83 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Name of the TTL index on collections that are not time-series.
+const ttlIndexName = "time_ttl"
+
+// Server error codes
+const (
+	codeIndexNotFound       = 27
+	codeNamespaceNotFound   = 26
+	codeIndexOptionConflict = 85
+)
+
+// ApplyTTL makes documents of the collection expire once their "time" is
+// older than ttl, or never if ttl is zero. Time-series collections use
+// their expireAfterSeconds option, others a TTL index on "time".
+func ApplyTTL(ctx context.Context, db *mongo.Database, collection string, ttl time.Duration) error {
+	specs, err := db.ListCollectionSpecifications(ctx, bson.M{"name": collection})
+	if err != nil {
+		return err
+	}
+	if len(specs) == 0 {
+		return fmt.Errorf("collection %q does not exist, run `migrate up` first", collection)
+	}
+
+	if specs[0].Type == "timeseries" {
+		var expire any = "off"
+		if ttl > 0 {
+			expire = int64(ttl.Seconds())
+		}
+		return db.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection},
+			{Key: "expireAfterSeconds", Value: expire},
+		}).Err()
+	}
+
+	indexes := db.Collection(collection).Indexes()
+	if ttl <= 0 {
+		_, err := indexes.DropOne(ctx, ttlIndexName)
+		if hasCode(err, codeIndexNotFound, codeNamespaceNotFound) {
+			return nil
+		}
+		return err
+	}
+	seconds := int32(ttl.Seconds())
+	_, err = indexes.CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"time": 1},
+		Options: options.Index().SetName(ttlIndexName).SetExpireAfterSeconds(seconds),
+	})
+	if hasCode(err, codeIndexOptionConflict) {
+		// The index exists with another TTL.
+		log.Printf("Changing TTL of %q to %v", collection, ttl)
+		return db.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection},
+			{Key: "index", Value: bson.M{"name": ttlIndexName, "expireAfterSeconds": seconds}},
+		}).Err()
+	}
+	return err
+}
+
+func hasCode(err error, codes ...int) bool {
+	var se mongo.ServerError
+	if !errors.As(err, &se) {
+		return false
+	}
+	for _, code := range codes {
+		if se.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
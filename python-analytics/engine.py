@@ -6,6 +6,7 @@ import json
 from collections import deque, defaultdict
 import yaml
 from aiokafka import AIOKafkaConsumer, errors
+import trade_event_pb2
 
 logging.basicConfig(level=logging.INFO, format='%(levelname)s: %(message)s')
 
@@ -31,6 +32,48 @@ class MarketMetrics:
             return None
         return self.cum_pv/self.cum_vol
 
+def excluded(trade, conditions):
+    '''Whether a trade carries any of the given condition codes'''
+    return not conditions.isdisjoint(trade.get('c') or [])
+
+def decode_trade_event(raw):
+    '''Decode a TradeEvent (internal/events/trade_event.proto)'''
+    event = trade_event_pb2.TradeEvent.FromString(raw)
+    return {
+        'symbol': event.symbol,
+        'price': event.price,
+        'volume': event.volume,
+        'exchange_time': event.exchange_time,
+        'receive_time': event.receive_time,
+        'source': event.source,
+        'sequence': event.sequence,
+        'conditions': list(event.conditions),
+    }
+
+def trades_from(headers, raw):
+    '''Return the trades in a Kafka message in Finnhub's p/s/t/v form.
+
+    Messages with a schema-version header carry one internal trade event,
+    encoded as JSON or protobuf (see the content-type header); those
+    without are legacy, raw Finnhub frames.'''
+    headers = dict(headers or [])
+    version = headers.get('schema-version')
+    if version is None:
+        return json.loads(raw.decode('utf-8')).get('data') or []
+    if version == b'1':
+        if headers.get('content-type') == b'application/x-protobuf':
+            value = decode_trade_event(raw)
+        else:
+            value = json.loads(raw.decode('utf-8'))
+        return [{
+            's': value['symbol'],
+            'p': float(value['price']),
+            'v': float(value['volume']),
+            't': value['exchange_time'],
+            'c': value.get('conditions') or [],
+        }]
+    raise ValueError(f'unsupported trade schema version {version!r}')
+
 class AnalyticsEngine:
     def __init__(self):
         # Set of active TCP writers
@@ -90,13 +133,15 @@ class AnalyticsEngine:
         # Start TCP Server
         host = config['analytics_engine']['tcp_host']
         port = config['analytics_engine']['tcp_port']
+        exclude_conditions = set(
+            (config.get('aggregates') or {}).get('exclude_conditions') or [])
         await asyncio.start_server(self.handle_client, host, port)
         print(f'TCP Server listening on port {port}')
 
         # Setup Kafka Consumer, then wait for Kafka to be ready
         consumer = AIOKafkaConsumer(
             config['kafka']['topic'],
-            bootstrap_servers=config['kafka']['broker_url'],
+            bootstrap_servers=config['kafka'].get('brokers') or config['kafka']['broker_url'],
             group_id='analytics-engine-group'
         )
         while True:
@@ -115,10 +160,13 @@ class AnalyticsEngine:
                     print('Message received | Topic: %s | Partition: %d | Offset: %d',
                         msg.topic, msg.partition, msg.offset)
 
-                    value = json.loads(msg.value.decode('utf-8'))
-                    print('Message value:', value)
+                    trades = trades_from(msg.headers, msg.value)
+                    print('Message value:', trades)
 
-                    for data in value['data']:
+                    for data in trades:
+                        if excluded(data, exclude_conditions):
+                            print(f"Skipping {data['s']} trade with conditions {data['c']}")
+                            continue
                         vwap = self.metrics[data['s']].update(data)
                         await self.broadcast(data['t'], data['s'], data['p'], vwap)
                         print(f"Processed {data['s']}: ${data['p']} at time {data['t']} | VWAP: ${vwap}")
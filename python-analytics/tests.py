@@ -1,5 +1,6 @@
+import json
 import unittest
-from engine import MarketMetrics
+from engine import MarketMetrics, excluded, trades_from
 
 class TestVWAP(unittest.TestCase):
     def test_basic_vwap(self):
@@ -18,5 +19,39 @@ class TestVWAP(unittest.TestCase):
         result = metrics.update({'p': 100, 'v': 0})
         self.assertIsNone(result)
 
+class TestTradesFrom(unittest.TestCase):
+    def test_legacy_frame(self):
+        value = {'type': 'trade', 'data': [{'s': 'AAPL', 'p': 1.5, 'v': 2, 't': 3}]}
+        self.assertEqual(trades_from([], json.dumps(value).encode()), value['data'])
+
+    def test_legacy_ping(self):
+        self.assertEqual(trades_from(None, b'{"type": "ping"}'), [])
+
+    def test_trade_event(self):
+        value = {'symbol': 'AAPL', 'price': '1.5', 'volume': '2',
+                 'exchange_time': 3, 'receive_time': 4, 'source': 'finnhub', 'sequence': 0}
+        self.assertEqual(trades_from([('schema-version', b'1')], json.dumps(value).encode()),
+                         [{'s': 'AAPL', 'p': 1.5, 'v': 2.0, 't': 3, 'c': []}])
+
+    def test_protobuf_trade_event(self):
+        # Same bytes as the Go codec produces (see internal/events tests)
+        raw = bytes([0x0a, 0x01]) + b'A' + bytes([0x12, 0x03]) + b'1.5' + \
+            bytes([0x1a, 0x01]) + b'2' + bytes([0x20, 0xac, 0x02])
+        headers = [('schema-version', b'1'), ('content-type', b'application/x-protobuf')]
+        self.assertEqual(trades_from(headers, raw), [{'s': 'A', 'p': 1.5, 'v': 2.0, 't': 300, 'c': []}])
+
+    def test_trade_event_conditions(self):
+        value = {'symbol': 'AAPL', 'price': '1.5', 'volume': '2',
+                 'exchange_time': 3, 'conditions': ['1', 'I']}
+        trades = trades_from([('schema-version', b'1')], json.dumps(value).encode())
+        self.assertEqual(trades[0]['c'], ['1', 'I'])
+        self.assertTrue(excluded(trades[0], {'I', 'Z'}))
+        self.assertFalse(excluded(trades[0], {'Z'}))
+        self.assertFalse(excluded({'s': 'A', 'p': 1, 'v': 1, 't': 1}, {'Z'}))
+
+    def test_unknown_version(self):
+        with self.assertRaises(ValueError):
+            trades_from([('schema-version', b'99')], b'{}')
+
 if __name__ == '__main__':
     unittest.main()
\ No newline at end of file